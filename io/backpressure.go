@@ -0,0 +1,169 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultBackpressureMaxDelay = 60 * time.Second
+
+	// The least pause between flushes once throttled, and below which a
+	// recovering sink is flushed to as fast as before.
+	minBackpressureDelay = 100 * time.Millisecond
+)
+
+var flushRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "k8stream",
+	Name:      "sink_flush_rate",
+	Help:      "Flushes per second that a throttling sink is held to, by the name of the sink (empty for the primary). 0 is unthrottled.",
+}, []string{"sink"})
+
+func init() {
+	prometheus.MustRegister(flushRate)
+}
+
+// BackpressureConfig slows flushes down while the sink throttles them.
+type BackpressureConfig struct {
+	Adaptive bool `json:"adaptive"`
+
+	// Most seconds between flushes, however long the sink asks for.
+	MaxDelay int `json:"max_delay"`
+}
+
+// adaptiveFlusher spaces flushes at the sink apart by delay. Each flush
+// that the sink throttles doubles the delay, or sets it to the RetryAfter
+// that the sink asked for when that is longer, and each one that succeeds
+// halves it, so that the rate recovers gradually once throttling stops.
+type adaptiveFlusher struct {
+	Flusher
+	max   time.Duration
+	gauge prometheus.Gauge
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu    sync.Mutex
+	delay time.Duration
+	last  time.Time
+}
+
+// adaptiveRecordFlusher is an adaptiveFlusher for a sink that reports per
+// record results.
+type adaptiveRecordFlusher struct {
+	*adaptiveFlusher
+	records RecordFlusher
+}
+
+// WithBackpressure wraps f in the adaptive rate controller, when
+// conf.Backpressure asks for it.
+func WithBackpressure(f Flusher, conf *Config) Flusher {
+	if !conf.Backpressure.Adaptive {
+		return f
+	}
+
+	a := newAdaptiveFlusher(f, conf)
+	if rf, ok := f.(RecordFlusher); ok {
+		return &adaptiveRecordFlusher{a, rf}
+	}
+	return a
+}
+
+func newAdaptiveFlusher(f Flusher, conf *Config) *adaptiveFlusher {
+	max := time.Duration(conf.Backpressure.MaxDelay) * time.Second
+	if max == 0 {
+		max = defaultBackpressureMaxDelay
+	}
+
+	g := flushRate.WithLabelValues(conf.name)
+	g.Set(0)
+
+	return &adaptiveFlusher{Flusher: f, max: max, gauge: g, now: time.Now, sleep: time.Sleep}
+}
+
+func (a *adaptiveFlusher) LoadConfig(b json.RawMessage) error {
+	return a.Flusher.LoadConfig(b)
+}
+
+func (a *adaptiveFlusher) Flush(uuid, ident string, d []byte) error {
+	a.wait()
+	err := a.Flusher.Flush(uuid, ident, d)
+	a.observe(err, throttledFor(err))
+	return err
+}
+
+func (a *adaptiveRecordFlusher) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	a.wait()
+	results, err := a.records.FlushRecords(uuid, ident, records)
+
+	retryAfter := throttledFor(err)
+	for _, r := range results {
+		if d := throttledFor(r.Err); d > retryAfter {
+			retryAfter = d
+		}
+	}
+
+	a.observe(err, retryAfter)
+	return results, err
+}
+
+// wait holds a flush back until delay passed since the one before.
+func (a *adaptiveFlusher) wait() {
+	a.mu.Lock()
+	now := a.now()
+	at := a.last.Add(a.delay)
+	if at.Before(now) {
+		at = now
+	}
+	a.last = at
+	a.mu.Unlock()
+
+	a.sleep(at.Sub(now))
+}
+
+// observe adapts the delay to how a flush went, retryAfter being what the
+// sink asked for, and -1 when it did not throttle. Other failures leave the
+// delay as it is.
+func (a *adaptiveFlusher) observe(err error, retryAfter time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case retryAfter >= 0:
+		d := 2 * a.delay
+		if retryAfter > d {
+			d = retryAfter
+		}
+		if d < minBackpressureDelay {
+			d = minBackpressureDelay
+		}
+		if d > a.max {
+			d = a.max
+		}
+		a.delay = d
+	case err == nil:
+		a.delay /= 2
+		if a.delay < minBackpressureDelay {
+			a.delay = 0
+		}
+	}
+
+	if a.delay == 0 {
+		a.gauge.Set(0)
+		return
+	}
+	a.gauge.Set(1 / a.delay.Seconds())
+}
+
+// throttledFor is the RetryAfter of a throttled err, and -1 when err is not
+// a throttle.
+func throttledFor(err error) time.Duration {
+	var t *ErrThrottled
+	if !errors.As(err, &t) {
+		return -1
+	}
+	return t.RetryAfter
+}
@@ -0,0 +1,67 @@
+package io
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// withClock puts a on a clock that its pauses move, and returns the pauses.
+func withClock(a *adaptiveFlusher) *[]time.Duration {
+	clock := time.Unix(0, 0)
+	sleeps := []time.Duration{}
+
+	a.now = func() time.Time { return clock }
+	a.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		clock = clock.Add(d)
+	}
+	return &sleeps
+}
+
+func TestWithBackpressure(t *testing.T) {
+	assert.Equal(t, Flusher(&failingSink{}), WithBackpressure(&failingSink{}, &Config{}))
+
+	throttled := func(retryAfter time.Duration) error {
+		return &ErrThrottled{Err: errors.New("429 Too Many Requests"), RetryAfter: retryAfter}
+	}
+
+	conf := &Config{Backpressure: BackpressureConfig{Adaptive: true}, name: "throttling"}
+	a := newAdaptiveFlusher(&failingSink{fails: 3, err: throttled(0)}, conf)
+	sleeps := withClock(a)
+	gauge := flushRate.WithLabelValues("throttling")
+
+	rates := []float64{}
+	for ix := 0; ix < 7; ix++ {
+		a.Flush("uid", "batch", []byte("{}\n"))
+		rates = append(rates, testutil.ToFloat64(gauge))
+	}
+
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{0, 100 * ms, 200 * ms, 400 * ms, 200 * ms, 100 * ms, 0}, *sleeps)
+	assert.Equal(t, []float64{10, 5, 2.5, 5, 10, 0, 0}, rates)
+
+	t.Run("Waits as long as the sink asks", func(t *testing.T) {
+		conf := &Config{Backpressure: BackpressureConfig{Adaptive: true, MaxDelay: 10}}
+		a := newAdaptiveFlusher(&failingSink{fails: 2, err: throttled(8 * time.Second)}, conf)
+		sleeps := withClock(a)
+
+		for ix := 0; ix < 3; ix++ {
+			a.Flush("uid", "batch", []byte("{}\n"))
+		}
+		assert.Equal(t, []time.Duration{0, 8 * time.Second, 10 * time.Second}, *sleeps)
+	})
+
+	t.Run("Other failures leave the rate be", func(t *testing.T) {
+		a := newAdaptiveFlusher(&failingSink{fails: -1}, conf)
+		sleeps := withClock(a)
+
+		for ix := 0; ix < 3; ix++ {
+			a.Flush("uid", "batch", []byte("{}\n"))
+		}
+		assert.Equal(t, []time.Duration{0, 0, 0}, *sleeps)
+	})
+}
@@ -2,18 +2,43 @@ package io
 
 import (
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+// Batches numbered so far, so that batches cut at the same instant by
+// different workers still get idents of their own.
+var batchSeq uint64
+
 func BatchNumber() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10)
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" +
+		strconv.FormatUint(atomic.AddUint64(&batchSeq, 1), 10)
 }
 
 // Listen to an Interface channel and return a buffer batch on
 // Either a timeout happens
 // OR buffer is filled to a size.
 func Batch(ch <-chan interface{}, c *Config) (batch []interface{}, ident string) {
+	return BatchUntil(ch, c, nil)
+}
+
+// BatchUntil is Batch that also cuts the batch short as soon as an item
+// for which urgent returns true arrives, carrying whatever was buffered
+// before it.
+func BatchUntil(
+	ch <-chan interface{}, c *Config, urgent func(interface{}) bool,
+) (batch []interface{}, ident string) {
+	batch, ident, _ = NextBatch(ch, c, urgent)
+	return
+}
+
+// NextBatch is BatchUntil that also cuts the batch short when ch is
+// closed, and then reports that no batches follow.
+func NextBatch(
+	ch <-chan interface{}, c *Config, urgent func(interface{}) bool,
+) (batch []interface{}, ident string, open bool) {
 	batch = make([]interface{}, c.BatchSize)
+	open = true
 
 	var ix int
 
@@ -28,8 +53,19 @@ func Batch(ch <-chan interface{}, c *Config) (batch []interface{}, ident string)
 		case <-time.After(time.Duration(c.BatchInterval) * time.Second):
 			c.Log("Flushing batch for Timeout %v", c.BatchInterval)
 			return
-		case x := <-ch:
+		case x, ok := <-ch:
+			if !ok {
+				c.Log("Flushing the last batch")
+				open = false
+				return
+			}
+
 			batch[ix] = x
+			if urgent != nil && urgent(x) {
+				c.Log("Flushing batch for an urgent item")
+				ix++
+				return
+			}
 		}
 	}
 
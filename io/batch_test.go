@@ -3,6 +3,7 @@ package io
 import (
 	"log"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,7 +28,7 @@ func TestBatch(t *testing.T) {
 		t.Run("Send and Receive Events", func(t *testing.T) {
 			go func() {
 				for i := 0; i <= 13; i++ {
-					ch <- &Event{ID: string(i)}
+					ch <- &Event{ID: strconv.Itoa(i)}
 				}
 			}()
 
@@ -54,3 +55,31 @@ func TestMain(m *testing.M) {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	os.Exit(m.Run())
 }
+
+func TestBatchUntil(t *testing.T) {
+	c := &Config{BatchSize: 5, BatchInterval: 5}
+	ch := make(chan interface{}, 5)
+	for _, id := range []string{"a", "b", "urgent", "c"} {
+		ch <- &Event{ID: id}
+	}
+
+	b, _ := BatchUntil(ch, c, func(x interface{}) bool {
+		return x.(*Event).ID == "urgent"
+	})
+
+	assert.Equal(t, 3, len(b))
+	assert.Equal(t, "urgent", b[2].(*Event).ID)
+	assert.Equal(t, 1, len(ch))
+}
+
+func TestNextBatchClosed(t *testing.T) {
+	c := &Config{BatchSize: 5, BatchInterval: 5}
+	ch := make(chan interface{}, 5)
+	ch <- &Event{ID: "a"}
+	ch <- &Event{ID: "b"}
+	close(ch)
+
+	b, _, open := NextBatch(ch, c, nil)
+	assert.Equal(t, 2, len(b))
+	assert.False(t, open)
+}
@@ -0,0 +1,52 @@
+package io
+
+import (
+	"encoding/json"
+)
+
+// limitedFlusher lets no more than cap(slots) flushes at the sink run at
+// once. Flushes past the limit wait for a slot.
+type limitedFlusher struct {
+	Flusher
+	slots chan struct{}
+}
+
+// limitedRecordFlusher is a limitedFlusher for a sink that reports per
+// record results.
+type limitedRecordFlusher struct {
+	*limitedFlusher
+	records RecordFlusher
+}
+
+// WithConcurrencyLimit bounds the flushes running at once at f by
+// conf.MaxConcurrentFlushes, so that a fragile sink is not flushed to by
+// every flush worker at once. A limit of 0 leaves f unbounded.
+func WithConcurrencyLimit(f Flusher, conf *Config) Flusher {
+	if conf.MaxConcurrentFlushes <= 0 {
+		return f
+	}
+
+	l := &limitedFlusher{f, make(chan struct{}, conf.MaxConcurrentFlushes)}
+	if rf, ok := f.(RecordFlusher); ok {
+		return &limitedRecordFlusher{l, rf}
+	}
+	return l
+}
+
+func (l *limitedFlusher) LoadConfig(b json.RawMessage) error {
+	return l.Flusher.LoadConfig(b)
+}
+
+func (l *limitedFlusher) Flush(uuid, ident string, d []byte) error {
+	l.slots <- struct{}{}
+	defer func() { <-l.slots }()
+
+	return l.Flusher.Flush(uuid, ident, d)
+}
+
+func (l *limitedRecordFlusher) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	l.slots <- struct{}{}
+	defer func() { <-l.slots }()
+
+	return l.records.FlushRecords(uuid, ident, records)
+}
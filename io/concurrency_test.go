@@ -0,0 +1,67 @@
+package io
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// busySink notes the most flushes it has seen running at once.
+type busySink struct {
+	sync.Mutex
+	running, peak int
+}
+
+func (b *busySink) LoadConfig(_ json.RawMessage) error { return nil }
+
+func (b *busySink) Flush(uuid, ident string, d []byte) error {
+	b.Lock()
+	b.running++
+	if b.running > b.peak {
+		b.peak = b.running
+	}
+	b.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	b.Lock()
+	b.running--
+	b.Unlock()
+	return nil
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	assert.Equal(t, Flusher(&busySink{}), WithConcurrencyLimit(&busySink{}, &Config{}))
+
+	fragile, robust := &busySink{}, &busySink{}
+	sinks := map[*busySink]Flusher{
+		fragile: WithConcurrencyLimit(fragile, &Config{MaxConcurrentFlushes: 1}),
+		robust:  WithConcurrencyLimit(robust, &Config{MaxConcurrentFlushes: 4}),
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range sinks {
+		for ix := 0; ix < 16; ix++ {
+			wg.Add(1)
+			go func(f Flusher, ix int) {
+				defer wg.Done()
+				assert.Nil(t, f.Flush("uid", strconv.Itoa(ix), []byte("{}\n")))
+			}(f, ix)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, fragile.peak)
+	assert.True(t, robust.peak > 1 && robust.peak <= 4, "peak of %v", robust.peak)
+
+	t.Run("Per record results are kept", func(t *testing.T) {
+		_, ok := WithConcurrencyLimit(&UnixSink{}, &Config{MaxConcurrentFlushes: 1}).(RecordFlusher)
+		assert.True(t, ok)
+		_, ok = WithConcurrencyLimit(&busySink{}, &Config{MaxConcurrentFlushes: 1}).(RecordFlusher)
+		assert.False(t, ok)
+	})
+}
@@ -29,6 +29,42 @@ type Config struct {
 	HeartbeatHook     string          `json:"heartbeat_hook"`
 	HeartbeatInterval int             `json:"heartbeat_interval"`
 	HeartbeatTimeout  int             `json:"heartbeat_timeout_ms"`
+	SinkWarmUp        bool            `json:"sink_warm_up"`
+	SinkKeepAlive     int             `json:"sink_keep_alive_interval"`
+	DeadLetterDir     string          `json:"dead_letter_dir"`
+	RetryAttempts     int             `json:"retry_attempts"`
+	RetryBudget       int             `json:"retry_budget_per_minute"`
+
+	// Pause before the first retry, doubled on each retry up to RetryMax.
+	// RetryMax defaults to RetryInitial, a pause that does not grow.
+	RetryInitial int `json:"retry_initial_ms"`
+	RetryMax     int `json:"retry_max_ms"`
+
+	// Flushes at the sink that may run at once. 0 is unlimited.
+	MaxConcurrentFlushes int `json:"max_concurrent_flushes"`
+
+	// Version of the event schema, that the HTTP sinks send as a header.
+	// Set from the output settings rather than read from this block.
+	SchemaVersion string `json:"-"`
+
+	// tz database location of the dates in sink paths. UTC when empty.
+	// Set from the output settings too.
+	Timezone string `json:"-"`
+
+	// TLS setup shared by every network sink, and by the heartbeat.
+	TLS *TLSConfig `json:"tls"`
+
+	// Spill queue for batches that fail after their retries.
+	Recovery RecoveryConfig `json:"recovery"`
+
+	// Slowing flushes down while the sink throttles them.
+	Backpressure BackpressureConfig `json:"backpressure"`
+
+	// Named sinks, besides the primary one, that events can be routed to.
+	Sinks map[string]json.RawMessage `json:"sinks"`
+
+	// Name of a named sink, and empty for the primary one.
+	name string
 }
 
 func (c Config) Log(msg string, args ...interface{}) {
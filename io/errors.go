@@ -0,0 +1,86 @@
+package io
+
+import (
+	"errors"
+	"time"
+)
+
+// Sinks wrap their errors in one of the following so that the retry logic
+// can tell a transient failure from one that no retry is going to fix.
+// Errors that are not wrapped are treated as retryable.
+
+// ErrRetryable is a transient failure, like a 503 or a dropped connection.
+type ErrRetryable struct {
+	Err error
+}
+
+func (e *ErrRetryable) Error() string { return e.Err.Error() }
+func (e *ErrRetryable) Unwrap() error { return e.Err }
+
+// ErrPermanent is a failure that will recur on every retry, like a 400.
+// The batch is dead-lettered right away.
+type ErrPermanent struct {
+	Err error
+}
+
+func (e *ErrPermanent) Error() string { return e.Err.Error() }
+func (e *ErrPermanent) Unwrap() error { return e.Err }
+
+// ErrThrottled is returned when the sink asks to slow down. The retry waits
+// for RetryAfter, when the sink said how long.
+type ErrThrottled struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ErrThrottled) Error() string { return e.Err.Error() }
+func (e *ErrThrottled) Unwrap() error { return e.Err }
+
+// ErrDeadLettered is returned for a batch that the sink still failed after
+// its retries, once the dead-letter sink took it instead. There is nothing
+// left to retry, but the batch was not delivered.
+type ErrDeadLettered struct {
+	Err error
+}
+
+func (e *ErrDeadLettered) Error() string { return "dead-lettered: " + e.Err.Error() }
+func (e *ErrDeadLettered) Unwrap() error { return e.Err }
+
+// ErrSpilled is returned for a batch that the spill queue holds until the
+// sink recovers. What became of it is sent on Done: nil once the sink took
+// it, or else why it was not delivered.
+type ErrSpilled struct {
+	Done <-chan error
+}
+
+func (e *ErrSpilled) Error() string { return "spilled until the sink recovers" }
+
+func isPermanent(err error) bool {
+	var p *ErrPermanent
+	return errors.As(err, &p)
+}
+
+// retryDelay is how long to wait before retrying after err, when the
+// backoff is to wait for backoff.
+func retryDelay(err error, backoff time.Duration) time.Duration {
+	var t *ErrThrottled
+	if errors.As(err, &t) && t.RetryAfter > 0 {
+		return t.RetryAfter
+	}
+
+	return backoff
+}
+
+// classifyStatus wraps err according to the HTTP status code of a response.
+func classifyStatus(status int, retryAfter time.Duration, err error) error {
+	switch {
+	case status == 429:
+		return &ErrThrottled{Err: err, RetryAfter: retryAfter}
+	case status >= 500:
+		return &ErrRetryable{Err: err}
+	case status >= 400:
+		return &ErrPermanent{Err: err}
+	}
+
+	return err
+}
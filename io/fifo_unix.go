@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package io
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0644)
+}
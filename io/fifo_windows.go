@@ -0,0 +1,7 @@
+package io
+
+import "errors"
+
+func mkfifo(path string) error {
+	return errors.New("the fifo sink is not supported on windows")
+}
@@ -2,30 +2,311 @@ package io
 
 import (
 	"encoding/json"
+	fmt "fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Flusher is a sink. Flush takes a batch encoded in the output format, as
+// newline delimited records, not the events themselves: the events are
+// declared by stream, which imports io, and the retries, the spill queue and
+// the dead-letter sink pass the encoded batch on as it is. stream.FuncFlusher
+// decodes it back into events for sinks that want them.
 type Flusher interface {
 	Flush(uuid, ident string, d []byte) error
 	LoadConfig(json.RawMessage) error
 }
 
-func GetFlusher(conf *Config) (Flusher, error) {
-	var f Flusher
-	switch conf.Sink {
-	case "s3":
-		f = &S3Sink{}
-	case "file":
-		f = &FileSink{}
-	case "memory":
-		f = &MemSink{Records: map[string][]byte{}, OnFetch: func(id string) {
+// Connector is implemented by sinks that hold a connection to their
+// destination, so it can be established before the first Flush and kept
+// warm while the stream is quiet.
+type Connector interface {
+	Connect() error
+	Ping() error
+}
+
+// Closer is implemented by sinks that run something of their own, like a
+// keep-alive, to stop once they are done with.
+type Closer interface {
+	Close() error
+}
+
+// sinkTypes make a new sink of each type that the "sink" key may name.
+var sinkTypes = map[string]func() Flusher{
+	"s3":            func() Flusher { return &S3Sink{} },
+	"file":          func() Flusher { return &FileSink{} },
+	"azblob":        func() Flusher { return &AzBlobSink{} },
+	"fifo":          func() Flusher { return &FifoSink{} },
+	"vector":        func() Flusher { return &VectorSink{} },
+	"http":          func() Flusher { return &HTTPSink{} },
+	"unix":          func() Flusher { return &UnixSink{} },
+	"elasticsearch": func() Flusher { return &ElasticsearchSink{} },
+	"arrow-flight":  func() Flusher { return &ArrowFlightSink{} },
+	"otlp-logs":     func() Flusher { return &OTLPLogsSink{} },
+	"stdout":        func() Flusher { return &StdoutSink{} },
+	"spool":         func() Flusher { return &SpoolSink{} },
+	"memory": func() Flusher {
+		return &MemSink{Records: map[string][]byte{}, OnFetch: func(id string) {
 			log.Println("Flushing", id)
 		}}
+	},
+}
+
+// eventSinks read the fields of the events in the batches they take, and so
+// cannot take the Kubernetes objects that the raw output format writes.
+var eventSinks = map[string]bool{
+	"arrow-flight": true,
+	"otlp-logs":    true,
+}
+
+// TakesObjects reports whether a sink of the type takes records of any
+// shape, like the objects of the raw output format, rather than only events.
+func TakesObjects(sink string) bool {
+	return !eventSinks[sink]
+}
+
+// RegisterSink adds a sink type, for programs that embed the pipeline to
+// flush to a destination of their own by setting "sink" to name. The sink
+// gets the whole configuration in LoadConfig, like the built-in ones. It
+// panics if name is taken, and is to be called before GetFlusher.
+func RegisterSink(name string, fn func() Flusher) {
+	if _, ok := sinkTypes[name]; ok {
+		panic(fmt.Sprintf("sink %q is registered already", name))
 	}
+	sinkTypes[name] = fn
+}
+
+// SinkTypes are the names that "sink" may be set to, sorted.
+func SinkTypes() []string {
+	names := make([]string, 0, len(sinkTypes))
+	for name := range sinkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetFlusher(conf *Config) (Flusher, error) {
+	fn, ok := sinkTypes[conf.Sink]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q, choices are %v", conf.Sink, strings.Join(SinkTypes(), ", "))
+	}
+	f := fn()
 
 	if err := f.LoadConfig(conf.Raw); err != nil {
 		return nil, err
 	}
 
-	return f, nil
+	if s, ok := f.(schemaVersioned); ok {
+		s.setSchemaVersion(conf.SchemaVersion)
+	}
+
+	if l, ok := f.(localized); ok {
+		loc, err := time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %q: %w", conf.Timezone, err)
+		}
+		l.setLocation(loc)
+	}
+
+	k, err := warmUp(f, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return withConnector(WithConcurrencyLimit(WithBackpressure(f, conf), conf), f, k), nil
+}
+
+// connectedFlusher is a wrapper of a sink that is a Connector, made one too.
+// Its flushes keep the keep-alive of the sink from pinging it, and Close
+// stops the keep-alive.
+type connectedFlusher struct {
+	Flusher
+	Connector
+	keepAlive *keepAlive
+}
+
+// connectedRecordFlusher is a connectedFlusher for a sink that reports per
+// record results.
+type connectedRecordFlusher struct {
+	*connectedFlusher
+	records RecordFlusher
+}
+
+// withConnector has w, the sink f as wrapped, connect and ping through to f
+// when f is a Connector, for the wrappers not to hide it.
+func withConnector(w, f Flusher, k *keepAlive) Flusher {
+	c, ok := f.(Connector)
+	if !ok {
+		return w
+	}
+
+	cf := &connectedFlusher{w, c, k}
+	if rf, ok := w.(RecordFlusher); ok {
+		return &connectedRecordFlusher{cf, rf}
+	}
+	return cf
+}
+
+func (c *connectedFlusher) Flush(uuid, ident string, d []byte) error {
+	defer c.keepAlive.touch()
+	return c.Flusher.Flush(uuid, ident, d)
+}
+
+// Close stops the keep-alive.
+func (c *connectedFlusher) Close() error {
+	c.keepAlive.stop()
+	return nil
+}
+
+func (c *connectedRecordFlusher) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	defer c.keepAlive.touch()
+	return c.records.FlushRecords(uuid, ident, records)
+}
+
+// schemaVersioned is implemented by the sinks that tell the destination
+// which version of the event schema they send.
+type schemaVersioned interface {
+	setSchemaVersion(v string)
+}
+
+// localized is implemented by the sinks that name what they write by the
+// date.
+type localized interface {
+	setLocation(loc *time.Location)
+}
+
+// GetSinks builds the named sinks of conf.Sinks, that events can be routed
+// to besides the primary sink. Each one is configured like the primary sink,
+// with its "sink" key naming the type, and retries from the shared budget.
+func GetSinks(conf *Config, deadLetter Flusher, budget *RetryBudget) (map[string]Flusher, error) {
+	sinks := map[string]Flusher{}
+	for name, raw := range conf.Sinks {
+		c := &Config{}
+		if err := json.Unmarshal(raw, c); err != nil {
+			return nil, fmt.Errorf("sink %v: %w", name, err)
+		}
+		c.Raw = raw
+		c.name = name
+		c.SchemaVersion = conf.SchemaVersion
+		c.Timezone = conf.Timezone
+
+		f, err := GetFlusher(c)
+		if err != nil {
+			return nil, fmt.Errorf("sink %v: %w", name, err)
+		}
+
+		sinks[name] = WithRecovery(f, deadLetter, c, budget)
+	}
+
+	return sinks, nil
+}
+
+// GetDeadLetter returns the sink that receives records the primary sink
+// could not take, or nil when no dead_letter_dir is configured.
+// Dead-lettered records are written as files, same as the file sink.
+func GetDeadLetter(conf *Config) Flusher {
+	if conf.DeadLetterDir == "" {
+		return nil
+	}
+
+	return &FileSink{Dir: conf.DeadLetterDir}
+}
+
+// warmUp connects the sink ahead of the first batch, if asked to, and
+// starts its keep-alive, that pings it once it has been idle for
+// SinkKeepAlive seconds so that the first flush after a quiet period does
+// not pay for a reconnect.
+func warmUp(f Flusher, conf *Config) (*keepAlive, error) {
+	c, ok := f.(Connector)
+	if !ok {
+		return nil, nil
+	}
+
+	if conf.SinkWarmUp {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.SinkKeepAlive > 0 {
+		return startKeepAlive(c, time.Duration(conf.SinkKeepAlive)*time.Second), nil
+	}
+
+	return nil, nil
+}
+
+// keepAlive pings a sink that has been idle for interval, and reconnects it
+// when the ping fails, until it is stopped. A nil keepAlive does nothing.
+type keepAlive struct {
+	c        Connector
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+
+	done chan struct{}
+	once sync.Once
+}
+
+func startKeepAlive(c Connector, interval time.Duration) *keepAlive {
+	k := &keepAlive{c: c, interval: interval, last: time.Now(), done: make(chan struct{})}
+	go k.run()
+	return k
+}
+
+// touch notes that the sink was just used, and needs no ping for interval.
+func (k *keepAlive) touch() {
+	if k == nil {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.last = time.Now()
+}
+
+func (k *keepAlive) idle() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return time.Since(k.last)
+}
+
+func (k *keepAlive) stop() {
+	if k == nil {
+		return
+	}
+
+	k.once.Do(func() { close(k.done) })
+}
+
+func (k *keepAlive) run() {
+	t := time.NewTimer(k.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-t.C:
+		}
+
+		if idle := k.idle(); idle < k.interval {
+			t.Reset(k.interval - idle)
+			continue
+		}
+
+		if err := k.c.Ping(); err != nil {
+			log.Println("sink keep-alive failed, reconnecting:", err)
+			if err := k.c.Connect(); err != nil {
+				log.Println("sink reconnect failed:", err)
+			}
+		}
+
+		k.touch()
+		t.Reset(k.interval)
+	}
 }
@@ -0,0 +1,285 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	fmt "fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/flight"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const defaultFlightTimeout = 30
+
+// flightColumns are the columns of the record batches, by the JSON name
+// of the event field. Fields that are objects or lists (labels, pod, diff,
+// metrics...) are columns of their JSON, as strings.
+var flightColumns = []arrow.Field{
+	{Name: "id", Type: arrow.BinaryTypes.String},
+	{Name: "timestamp", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "time", Type: arrow.BinaryTypes.String},
+	{Name: "component", Type: arrow.BinaryTypes.String},
+	{Name: "host", Type: arrow.BinaryTypes.String},
+	{Name: "message", Type: arrow.BinaryTypes.String},
+	{Name: "namespace", Type: arrow.BinaryTypes.String},
+	{Name: "reason", Type: arrow.BinaryTypes.String},
+	{Name: "type", Type: arrow.BinaryTypes.String},
+	{Name: "severity", Type: arrow.BinaryTypes.String},
+	{Name: "priority", Type: arrow.BinaryTypes.String},
+	{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "first_timestamp", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "last_timestamp", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "reference_uid", Type: arrow.BinaryTypes.String},
+	{Name: "reference_namespace", Type: arrow.BinaryTypes.String},
+	{Name: "reference_name", Type: arrow.BinaryTypes.String},
+	{Name: "reference_kind", Type: arrow.BinaryTypes.String},
+	{Name: "reference_version", Type: arrow.BinaryTypes.String},
+	{Name: "reference_api_version", Type: arrow.BinaryTypes.String},
+	{Name: "object_uid", Type: arrow.BinaryTypes.String},
+	{Name: "labels", Type: arrow.BinaryTypes.String},
+	{Name: "annotations", Type: arrow.BinaryTypes.String},
+	{Name: "address", Type: arrow.BinaryTypes.String},
+	{Name: "pod", Type: arrow.BinaryTypes.String},
+	{Name: "version", Type: arrow.BinaryTypes.String},
+	{Name: "container", Type: arrow.BinaryTypes.String},
+	{Name: "total_pods", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "pods_truncated", Type: arrow.FixedWidthTypes.Boolean},
+	{Name: "producer_version", Type: arrow.BinaryTypes.String},
+	{Name: "schema_version", Type: arrow.BinaryTypes.String},
+	{Name: "enrichment_error", Type: arrow.BinaryTypes.String},
+	{Name: "producer", Type: arrow.BinaryTypes.String},
+	{Name: "original_message", Type: arrow.BinaryTypes.String},
+	{Name: "workload_kind", Type: arrow.BinaryTypes.String},
+	{Name: "workload_name", Type: arrow.BinaryTypes.String},
+	{Name: "edge", Type: arrow.BinaryTypes.String},
+	{Name: "quota", Type: arrow.BinaryTypes.String},
+	{Name: "image", Type: arrow.BinaryTypes.String},
+	{Name: "pull_duration_ms", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "diff", Type: arrow.BinaryTypes.String},
+	{Name: "diff_truncated", Type: arrow.FixedWidthTypes.Boolean},
+	{Name: "scheduling_latency_ms", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "metrics", Type: arrow.BinaryTypes.String},
+	{Name: "storm_count", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "affected_pods", Type: arrow.BinaryTypes.String},
+	{Name: "correlation_id", Type: arrow.BinaryTypes.String},
+	{Name: "pdb", Type: arrow.BinaryTypes.String},
+	{Name: "self_metrics", Type: arrow.BinaryTypes.String},
+	{Name: "datacenter", Type: arrow.BinaryTypes.String},
+	{Name: "rack", Type: arrow.BinaryTypes.String},
+	{Name: "namespace_labels", Type: arrow.BinaryTypes.String},
+	{Name: "security", Type: arrow.BinaryTypes.String},
+	{Name: "pod_uid", Type: arrow.BinaryTypes.String},
+	{Name: "pod_name", Type: arrow.BinaryTypes.String},
+	{Name: "pod_ip", Type: arrow.BinaryTypes.String},
+	{Name: "pod_host_ip", Type: arrow.BinaryTypes.String},
+	{Name: "pod_start_time", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "lease", Type: arrow.BinaryTypes.String},
+	{Name: "certificate", Type: arrow.BinaryTypes.String},
+	{Name: "sequence", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "epoch", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "resource_version", Type: arrow.BinaryTypes.String},
+}
+
+// FlightSchema is the schema of the record batches that the arrow-flight
+// sink puts. Every column is nullable, for the fields events leave out.
+var FlightSchema = arrow.NewSchema(nullable(flightColumns), nil)
+
+func nullable(fields []arrow.Field) []arrow.Field {
+	for i := range fields {
+		fields[i].Nullable = true
+	}
+	return fields
+}
+
+// ArrowFlightSink puts each batch to an Arrow Flight service, as a record
+// batch of FlightSchema, with a DoPut of the descriptor path. Fields that
+// an event lacks are null.
+type ArrowFlightSink struct {
+	// grpc://host:port, or grpc+tls://host:port.
+	Location string   `json:"flight_location" validate:"required"`
+	Path     []string `json:"flight_path"`
+
+	// Basic auth, with a handshake that trades it for a token, or a
+	// bearer token sent as is.
+	Username string `json:"flight_username"`
+	Password string `json:"flight_password"`
+	Token    string `json:"flight_token"`
+
+	// Seconds a DoPut may take.
+	Timeout int `json:"flight_timeout"`
+
+	TLS *TLSConfig `json:"tls"`
+
+	client flight.Client
+}
+
+func (s *ArrowFlightSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	if len(s.Path) == 0 {
+		s.Path = []string{"k8stream"}
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = defaultFlightTimeout
+	}
+
+	u, err := url.Parse(s.Location)
+	if err != nil {
+		return fmt.Errorf("flight_location: %w", err)
+	}
+
+	var creds grpc.DialOption
+	switch u.Scheme {
+	case "grpc", "grpc+tcp":
+		creds = grpc.WithInsecure()
+	case "grpc+tls":
+		t := s.TLS
+		if t == nil {
+			t = &TLSConfig{}
+		}
+
+		c, err := t.config()
+		if err != nil {
+			return err
+		}
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(c))
+	default:
+		return fmt.Errorf("flight_location %q: unknown scheme %q", s.Location, u.Scheme)
+	}
+
+	// Dialing does not wait for the connection, which is made on the first
+	// DoPut and remade after failures.
+	s.client, err = flight.NewClientWithMiddleware(u.Host, nil, nil, creds)
+	return err
+}
+
+// authorize adds the credentials to ctx.
+func (s *ArrowFlightSink) authorize(ctx context.Context) (context.Context, error) {
+	switch {
+	case s.Token != "":
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+s.Token), nil
+	case s.Username != "":
+		return s.client.AuthenticateBasicToken(ctx, s.Username, s.Password)
+	}
+	return ctx, nil
+}
+
+func (s *ArrowFlightSink) Flush(uuid, ident string, d []byte) error {
+	rec, err := flightRecord(memory.NewGoAllocator(), splitRecords(d))
+	if err != nil {
+		return err
+	}
+	defer rec.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Timeout)*time.Second)
+	defer cancel()
+
+	ctx, err = s.authorize(ctx)
+	if err != nil {
+		return fmt.Errorf("flight auth: %w", err)
+	}
+
+	stream, err := s.client.DoPut(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(FlightSchema))
+	w.SetFlightDescriptor(&flight.FlightDescriptor{
+		Type: flight.FlightDescriptor_PATH,
+		Path: s.Path,
+	})
+
+	if err := w.WriteWithAppMetadata(rec, []byte(uuid+"-"+ident)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	// The put is done once the service ends the stream, or has failed.
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// flightRecord builds the record batch of events, records of their JSON.
+func flightRecord(mem memory.Allocator, records [][]byte) (array.Record, error) {
+	b := array.NewRecordBuilder(mem, FlightSchema)
+	defer b.Release()
+
+	for _, r := range records {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(r, &fields); err != nil {
+			return nil, fmt.Errorf("flight record: %w", err)
+		}
+
+		for i, c := range flightColumns {
+			if err := appendFlightValue(b.Field(i), fields[c.Name]); err != nil {
+				return nil, fmt.Errorf("flight column %v: %w", c.Name, err)
+			}
+		}
+	}
+
+	return b.NewRecord(), nil
+}
+
+func appendFlightValue(b array.Builder, v json.RawMessage) error {
+	if len(v) == 0 || string(v) == "null" {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		var n int64
+		if err := json.Unmarshal(v, &n); err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.BooleanBuilder:
+		var t bool
+		if err := json.Unmarshal(v, &t); err != nil {
+			return err
+		}
+		b.Append(t)
+	case *array.StringBuilder:
+		// Strings are the column's value, anything else its JSON.
+		var s string
+		if strings.HasPrefix(string(v), `"`) {
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+		} else {
+			s = string(v)
+		}
+		b.Append(s)
+	default:
+		return fmt.Errorf("unexpected builder %T", b)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package io
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/flight"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestArrowFlightSink(t *testing.T) {
+	var path []string
+	var auth []string
+	var rows []map[string]interface{}
+	var fail error
+
+	s := flight.NewServerWithMiddleware(nil, nil)
+	assert.Nil(t, s.Init("localhost:0"))
+	s.RegisterFlightService(&flight.FlightServiceService{
+		DoPut: func(stream flight.FlightService_DoPutServer) error {
+			if fail != nil {
+				return fail
+			}
+
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			auth = md.Get("authorization")
+
+			r, err := flight.NewRecordReader(stream)
+			if err != nil {
+				return err
+			}
+			defer r.Release()
+
+			if !r.Schema().Equal(FlightSchema) {
+				t.Errorf("schema %v", r.Schema())
+			}
+			path = r.LatestFlightDescriptor().Path
+
+			for r.Next() {
+				rec := r.Record()
+				for i := 0; i < int(rec.NumRows()); i++ {
+					row := map[string]interface{}{}
+					for j, c := range rec.Columns() {
+						if c.IsNull(i) {
+							continue
+						}
+
+						name := rec.ColumnName(j)
+						switch c := c.(type) {
+						case *array.String:
+							row[name] = c.Value(i)
+						case *array.Int64:
+							row[name] = c.Value(i)
+						case *array.Boolean:
+							row[name] = c.Value(i)
+						}
+					}
+					rows = append(rows, row)
+				}
+			}
+
+			return stream.Send(&flight.PutResult{})
+		},
+	})
+	go s.Serve()
+	defer s.Shutdown()
+
+	sink := &ArrowFlightSink{}
+	assert.Nil(t, sink.LoadConfig([]byte(`{
+		"flight_location": "grpc://`+s.Addr().String()+`",
+		"flight_path": ["events", "k8s"],
+		"flight_token": "secret"
+	}`)))
+
+	d := []byte(`{"id":"a","timestamp":1586340721,"reason":"Evicted","count":3,"labels":{"app":"web"},"pods_truncated":true}
+{"id":"b","message":"Pulled","address":["10.0.0.1"],"diff":[{"path":"/spec/type","old":"ClusterIP","new":"NodePort"}]}
+`)
+
+	t.Run("Events round trip as a record batch", func(t *testing.T) {
+		assert.Nil(t, sink.Flush("uid", "1", d))
+		assert.Equal(t, []string{"events", "k8s"}, path)
+		assert.Equal(t, []string{"Bearer secret"}, auth)
+		assert.Equal(t, []map[string]interface{}{
+			{
+				"id": "a", "timestamp": int64(1586340721), "reason": "Evicted",
+				"count": int64(3), "labels": `{"app":"web"}`, "pods_truncated": true,
+			},
+			{
+				"id": "b", "message": "Pulled", "address": `["10.0.0.1"]`,
+				"diff": `[{"path":"/spec/type","old":"ClusterIP","new":"NodePort"}]`,
+			},
+		}, rows)
+	})
+
+	t.Run("Stream failures are returned for retry", func(t *testing.T) {
+		fail = errors.New("ingest is down")
+		assert.NotNil(t, sink.Flush("uid", "2", d))
+	})
+
+	t.Run("Unknown schemes are rejected", func(t *testing.T) {
+		assert.NotNil(t, (&ArrowFlightSink{}).LoadConfig([]byte(`{"flight_location": "http://flight:8815"}`)))
+	})
+}
+
+func TestFlightSchemaNullable(t *testing.T) {
+	for _, f := range FlightSchema.Fields() {
+		assert.True(t, f.Nullable, f.Name)
+	}
+}
@@ -0,0 +1,431 @@
+package io
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	azblobAPIVersion = "2019-12-12"
+
+	// An append block cannot be larger than 4 MiB.
+	azblobMaxBlock = 4 << 20
+
+	defaultAzblobPath     = "{{.UID}}/{{.Date}}/{{.Ident}}.log"
+	defaultAzblobMaxBytes = 64 << 20
+	defaultAzblobRoll     = 3600
+
+	azureIMDS = "http://169.254.169.254/metadata/identity/oauth2/token" +
+		"?api-version=2018-02-01&resource=https%3A%2F%2Fstorage.azure.com%2F"
+)
+
+// AzBlobSink appends each batch to an append blob, rolling over to a new
+// blob when the current one is too large, too old or the date changes.
+type AzBlobSink struct {
+	Account          string `json:"azblob_account"`
+	Container        string `json:"azblob_container" validate:"required"`
+	ConnectionString string `json:"azblob_connection_string"`
+	ManagedIdentity  bool   `json:"azblob_managed_identity"`
+	BlobPath         string `json:"azblob_blob_path"`
+	MaxBlobBytes     int    `json:"azblob_max_blob_bytes"`
+	RollInterval     int    `json:"azblob_roll_interval"`
+
+	TLS *TLSConfig `json:"tls"`
+
+	// Only the proxy of the HTTP options applies to azblob. With managed
+	// identity, the metadata endpoint must be in no_proxy.
+	HTTP *HTTPOptions `json:"http_sink"`
+
+	sync.Mutex
+	client  appendBlobClient
+	path    *template.Template
+	now     func() time.Time
+	loc     *time.Location
+	current string
+	date    string
+	size    int
+	created time.Time
+
+	// A batch that failed partway through, to resume rather than append
+	// again from its first block when the same batch is retried.
+	partial *azblobPartial
+}
+
+type azblobPartial struct {
+	uuid, ident string
+	blob        string
+	start       int
+}
+
+// appendBlobClient is the part of the Blob service API used by the sink.
+type appendBlobClient interface {
+	CreateAppendBlob(path string) error
+	AppendBlock(path string, b []byte) error
+	BlobSize(path string) (int, error)
+}
+
+// Values available to the azblob_blob_path template.
+type azblobPathData struct {
+	UID   string
+	Ident string
+	Date  string
+	Hour  string
+}
+
+func (a *AzBlobSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, a); err != nil {
+		return err
+	}
+
+	if a.BlobPath == "" {
+		a.BlobPath = defaultAzblobPath
+	}
+	if a.MaxBlobBytes == 0 {
+		a.MaxBlobBytes = defaultAzblobMaxBytes
+	}
+	if a.RollInterval == 0 {
+		a.RollInterval = defaultAzblobRoll
+	}
+
+	t, err := template.New("azblob").Parse(a.BlobPath)
+	if err != nil {
+		return fmt.Errorf("invalid azblob_blob_path: %w", err)
+	}
+
+	a.path = t
+	a.now = time.Now
+	a.loc = time.UTC
+
+	a.client, err = newAzblobRESTClient(a)
+	return err
+}
+
+// setLocation has the dates of blob paths be the dates in loc.
+func (a *AzBlobSink) setLocation(loc *time.Location) {
+	a.loc = loc
+}
+
+func (a *AzBlobSink) Flush(uuid, ident string, d []byte) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if p := a.partial; p != nil && p.uuid == uuid && p.ident == ident && p.blob == a.current {
+		// Skip what the blob has of this batch already, as told by the
+		// service: a block may have been committed without an answer.
+		committed, err := a.client.BlobSize(a.current)
+		if err != nil {
+			return err
+		}
+
+		if skip := committed - p.start; skip >= 0 && skip <= len(d) {
+			a.size = committed
+			return a.append(uuid, ident, p.start, d[skip:])
+		}
+	}
+	a.partial = nil
+
+	now := a.now().In(a.loc)
+	date := now.Format("2006/01/02")
+	if a.current == "" || a.date != date ||
+		a.size+len(d) > a.MaxBlobBytes ||
+		now.Sub(a.created) >= time.Duration(a.RollInterval)*time.Second {
+		if err := a.roll(uuid, ident, now); err != nil {
+			return err
+		}
+	}
+
+	return a.append(uuid, ident, a.size, d)
+}
+
+// append writes d to the current blob in blocks. start is where the batch
+// begins in the blob, to resume from if a block fails.
+func (a *AzBlobSink) append(uuid, ident string, start int, d []byte) error {
+	// Each flush is whole NDJSON lines, so appended blocks concatenate into
+	// a valid NDJSON blob.
+	for len(d) > 0 {
+		n := len(d)
+		if n > azblobMaxBlock {
+			n = azblobMaxBlock
+		}
+
+		if err := a.client.AppendBlock(a.current, d[:n]); err != nil {
+			a.partial = &azblobPartial{uuid: uuid, ident: ident, blob: a.current, start: start}
+			return err
+		}
+
+		a.size += n
+		d = d[n:]
+	}
+
+	a.partial = nil
+	return nil
+}
+
+// roll starts a new append blob, named by the path template.
+func (a *AzBlobSink) roll(uuid, ident string, now time.Time) error {
+	var buf bytes.Buffer
+	if err := a.path.Execute(&buf, azblobPathData{
+		UID:   uuid,
+		Ident: ident,
+		Date:  now.Format("2006/01/02"),
+		Hour:  now.Format("15"),
+	}); err != nil {
+		return err
+	}
+
+	path := buf.String()
+	log.Println("Creating append blob", path)
+	if err := a.client.CreateAppendBlob(path); err != nil {
+		return err
+	}
+
+	a.current = path
+	a.date = now.Format("2006/01/02")
+	a.size = 0
+	a.created = now
+	return nil
+}
+
+// azblobRESTClient talks to the Blob service REST API, authorizing with
+// a shared key or SAS from a connection string, or with a managed identity.
+type azblobRESTClient struct {
+	endpoint  string
+	container string
+	sas       string
+	account   string
+	key       []byte
+	msi       bool
+
+	http     *http.Client
+	tokenMu  sync.Mutex
+	token    string
+	tokenExp time.Time
+}
+
+func newAzblobRESTClient(a *AzBlobSink) (*azblobRESTClient, error) {
+	hc, err := newHTTPClient(a.TLS, a.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &azblobRESTClient{
+		container: a.Container,
+		account:   a.Account,
+		msi:       a.ManagedIdentity,
+		http:      hc,
+	}
+
+	suffix := "core.windows.net"
+	if a.ConnectionString != "" {
+		kv := map[string]string{}
+		for _, part := range strings.Split(a.ConnectionString, ";") {
+			if ix := strings.Index(part, "="); ix > 0 {
+				kv[part[:ix]] = part[ix+1:]
+			}
+		}
+
+		if v, ok := kv["AccountName"]; ok {
+			c.account = v
+		}
+		if v, ok := kv["EndpointSuffix"]; ok {
+			suffix = v
+		}
+		if v, ok := kv["BlobEndpoint"]; ok {
+			c.endpoint = strings.TrimSuffix(v, "/")
+		}
+		c.sas = strings.TrimPrefix(kv["SharedAccessSignature"], "?")
+
+		if v, ok := kv["AccountKey"]; ok {
+			key, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AccountKey in azblob_connection_string: %w", err)
+			}
+			c.key = key
+		}
+	}
+
+	if c.endpoint == "" {
+		if c.account == "" {
+			return nil, fmt.Errorf("azblob sink needs an azblob_account or a connection string")
+		}
+		c.endpoint = fmt.Sprintf("https://%s.blob.%s", c.account, suffix)
+	}
+
+	if c.key == nil && c.sas == "" && !c.msi {
+		return nil, fmt.Errorf("azblob sink needs a connection string or azblob_managed_identity")
+	}
+
+	return c, nil
+}
+
+func (c *azblobRESTClient) CreateAppendBlob(path string) error {
+	_, err := c.do(http.MethodPut, path, nil, nil, map[string]string{
+		"x-ms-blob-type": "AppendBlob",
+	})
+	return err
+}
+
+func (c *azblobRESTClient) AppendBlock(path string, b []byte) error {
+	_, err := c.do(http.MethodPut, path, url.Values{"comp": {"appendblock"}}, b, nil)
+	return err
+}
+
+func (c *azblobRESTClient) BlobSize(path string) (int, error) {
+	h, err := c.do(http.MethodHead, path, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(h.Get("Content-Length"))
+}
+
+func (c *azblobRESTClient) do(method, path string, q url.Values, body []byte, headers map[string]string) (http.Header, error) {
+	u := fmt.Sprintf("%s/%s/%s", c.endpoint, c.container, path)
+	if q == nil {
+		q = url.Values{}
+	}
+
+	rawQuery := q.Encode()
+	if c.sas != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += c.sas
+	}
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-ms-version", azblobAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case c.key != nil:
+		req.Header.Set("Authorization", c.sharedKey(req, path, q, len(body)))
+	case c.msi:
+		token, err := c.managedIdentityToken()
+		if err != nil {
+			return nil, &ErrRetryable{Err: err}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &ErrRetryable{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, classifyStatus(
+			resp.StatusCode, time.Duration(retryAfter)*time.Second,
+			fmt.Errorf("azblob %s %s: %s", method, path, resp.Status),
+		)
+	}
+
+	return resp.Header, nil
+}
+
+// sharedKey signs a request as described in
+// https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (c *azblobRESTClient) sharedKey(req *http.Request, path string, q url.Values, length int) string {
+	contentLength := ""
+	if length > 0 {
+		contentLength = strconv.Itoa(length)
+	}
+
+	msHeaders := []string{}
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			msHeaders = append(msHeaders, lk+":"+req.Header.Get(k))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := fmt.Sprintf("/%s/%s/%s", c.account, c.container, path)
+	params := []string{}
+	for k, v := range q {
+		params = append(params, strings.ToLower(k)+":"+strings.Join(v, ","))
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		resource += "\n" + p
+	}
+
+	toSign := strings.Join([]string{
+		req.Method,
+		"", "", contentLength, "", req.Header.Get("Content-Type"),
+		"", "", "", "", "", "",
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(toSign))
+	return fmt.Sprintf(
+		"SharedKey %s:%s", c.account,
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	)
+}
+
+// managedIdentityToken fetches a token for storage from the instance
+// metadata service, reusing it until shortly before it expires.
+func (c *azblobRESTClient) managedIdentityToken() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExp) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, azureIMDS, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("managed identity token: %s", resp.Status)
+	}
+
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+
+	exp, _ := strconv.ParseInt(t.ExpiresOn, 10, 64)
+	c.token = t.AccessToken
+	c.tokenExp = time.Unix(exp, 0).Add(-5 * time.Minute)
+	return c.token, nil
+}
@@ -0,0 +1,148 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAppendBlobs struct {
+	created []string
+	blocks  map[string][][]byte
+
+	// Appends that fail, counting from 1, once each.
+	failOn  map[int]bool
+	appends int
+}
+
+func (f *fakeAppendBlobs) CreateAppendBlob(path string) error {
+	f.created = append(f.created, path)
+	return nil
+}
+
+func (f *fakeAppendBlobs) AppendBlock(path string, b []byte) error {
+	f.appends++
+	if f.failOn[f.appends] {
+		return &ErrRetryable{Err: errors.New("connection reset")}
+	}
+
+	f.blocks[path] = append(f.blocks[path], append([]byte{}, b...))
+	return nil
+}
+
+func (f *fakeAppendBlobs) BlobSize(path string) (int, error) {
+	return len(bytes.Join(f.blocks[path], nil)), nil
+}
+
+func TestAzBlobSink(t *testing.T) {
+	cfg := []byte(`{
+		"azblob_container": "events",
+		"azblob_connection_string": "BlobEndpoint=https://acct.blob.core.windows.net;SharedAccessSignature=sv=2019&sig=abc",
+		"azblob_blob_path": "k8s/{{.UID}}/{{.Date}}/{{.Ident}}.ndjson",
+		"azblob_max_blob_bytes": 64
+	}`)
+
+	a := &AzBlobSink{}
+	if err := a.LoadConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAppendBlobs{blocks: map[string][][]byte{}}
+	now := time.Date(2020, 4, 17, 10, 0, 0, 0, time.UTC)
+	a.client = fake
+	a.now = func() time.Time { return now }
+
+	line := []byte(`{"id":"1"}` + "\n")
+
+	t.Run("Appends NDJSON to a templated blob", func(t *testing.T) {
+		assert.Nil(t, a.Flush("uid", "100", line))
+		assert.Nil(t, a.Flush("uid", "101", line))
+
+		assert.Equal(t, []string{"k8s/uid/2020/04/17/100.ndjson"}, fake.created)
+		blob := bytes.Join(fake.blocks["k8s/uid/2020/04/17/100.ndjson"], nil)
+		assert.Equal(t, strings.Repeat(string(line), 2), string(blob))
+	})
+
+	t.Run("Rolls over on size", func(t *testing.T) {
+		assert.Nil(t, a.Flush("uid", "102", bytes.Repeat(line, 4)))
+		assert.Equal(t, "k8s/uid/2020/04/17/102.ndjson", fake.created[1])
+	})
+
+	t.Run("Rolls over on date", func(t *testing.T) {
+		now = now.Add(24 * time.Hour)
+		assert.Nil(t, a.Flush("uid", "103", line))
+		assert.Equal(t, "k8s/uid/2020/04/18/103.ndjson", fake.created[2])
+	})
+
+	t.Run("Chunks blocks at the 4MiB limit", func(t *testing.T) {
+		a.MaxBlobBytes = defaultAzblobMaxBytes
+		big := bytes.Repeat([]byte("x"), azblobMaxBlock+10)
+		now = now.Add(24 * time.Hour)
+
+		assert.Nil(t, a.Flush("uid", "104", big))
+		blocks := fake.blocks["k8s/uid/2020/04/19/104.ndjson"]
+		assert.Len(t, blocks, 2)
+		assert.Len(t, blocks[0], azblobMaxBlock)
+		assert.Len(t, blocks[1], 10)
+	})
+
+	t.Run("A retried batch resumes after its committed blocks", func(t *testing.T) {
+		big := bytes.Repeat([]byte("y"), azblobMaxBlock+10)
+		fake.failOn = map[int]bool{fake.appends + 2: true}
+
+		assert.NotNil(t, a.Flush("uid", "105", big))
+		assert.Nil(t, a.Flush("uid", "105", big))
+
+		blob := bytes.Join(fake.blocks["k8s/uid/2020/04/19/104.ndjson"], nil)
+		assert.Equal(t, len(big)+azblobMaxBlock+10, len(blob))
+		assert.Equal(t, big, blob[azblobMaxBlock+10:])
+	})
+
+	t.Run("Dates are the dates of the timezone", func(t *testing.T) {
+		loc, err := time.LoadLocation("Asia/Kolkata")
+		assert.Nil(t, err)
+		a.setLocation(loc)
+		defer a.setLocation(time.UTC)
+
+		// 20:00 UTC is already the next day in India.
+		now = time.Date(2020, 4, 20, 20, 0, 0, 0, time.UTC)
+		assert.Nil(t, a.Flush("uid", "106", line))
+		assert.Equal(t, "k8s/uid/2020/04/21/106.ndjson", fake.created[len(fake.created)-1])
+	})
+}
+
+func TestAzblobRESTClient(t *testing.T) {
+	var mu sync.Mutex
+	reqs := []*http.Request{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reqs = append(reqs, r)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	a := &AzBlobSink{}
+	b, _ := json.Marshal(map[string]string{
+		"azblob_container":         "events",
+		"azblob_connection_string": "AccountName=acct;AccountKey=a2V5;BlobEndpoint=" + s.URL,
+	})
+	if err := a.LoadConfig(b); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, a.Flush("uid", "1", []byte("{}\n")))
+	assert.Len(t, reqs, 2)
+
+	assert.Equal(t, "AppendBlob", reqs[0].Header.Get("x-ms-blob-type"))
+	assert.Equal(t, "appendblock", reqs[1].URL.Query().Get("comp"))
+	assert.True(t, strings.HasPrefix(reqs[1].Header.Get("Authorization"), "SharedKey acct:"))
+}
@@ -0,0 +1,154 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	fmt "fmt"
+	"net/http"
+	"strings"
+)
+
+// ElasticsearchSink writes each batch with the bulk API, as create actions,
+// so that it can append to data streams. Events go to the data stream of
+// their type ("Warning", "Normal"), or the default one, so that each can
+// have a retention of its own.
+type ElasticsearchSink struct {
+	Address     string            `json:"es_address" validate:"required"`
+	Index       string            `json:"es_index" validate:"required"`
+	IndexByType map[string]string `json:"es_index_by_type"`
+	TLS         *TLSConfig        `json:"tls"`
+	HTTP        *HTTPOptions      `json:"http_sink"`
+
+	client        *http.Client
+	transforms    []RequestTransform
+	schemaVersion string
+}
+
+func (s *ElasticsearchSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	c, err := newHTTPClient(s.TLS, s.HTTP)
+	if err != nil {
+		return err
+	}
+
+	s.client = c
+	s.transforms, err = s.HTTP.transforms()
+	return err
+}
+
+func (s *ElasticsearchSink) setSchemaVersion(v string) {
+	s.schemaVersion = v
+}
+
+// indexOf returns the data stream a record goes to, by its type.
+func (s *ElasticsearchSink) indexOf(record []byte) string {
+	var e struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(record, &e); err == nil {
+		if index, ok := s.IndexByType[e.Type]; ok {
+			return index
+		}
+	}
+
+	return s.Index
+}
+
+// bulkBody is the NDJSON body of a bulk request creating the records.
+func (s *ElasticsearchSink) bulkBody(records [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, r := range records {
+		action, err := json.Marshal(map[string]interface{}{
+			"create": map[string]string{"_index": s.indexOf(r)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(bytes.TrimRight(r, "\n"))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (s *ElasticsearchSink) Flush(uuid, ident string, d []byte) error {
+	results, err := s.FlushRecords(uuid, ident, splitRecords(d))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// FlushRecords sends the records in one bulk request, and reports the
+// outcome of each from the response. A record that exists already, with a
+// 409, was created by an earlier attempt and counts as flushed.
+func (s *ElasticsearchSink) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	body, err := s.bulkBody(records)
+	if err != nil {
+		return nil, &ErrPermanent{Err: err}
+	}
+
+	headers := map[string]string{
+		"X-K8stream-Uid":   uuid,
+		"X-K8stream-Batch": ident,
+	}
+	if s.schemaVersion != "" {
+		headers["X-K8stream-Schema-Version"] = s.schemaVersion
+	}
+
+	url := strings.TrimRight(s.Address, "/") + "/_bulk"
+	b, err := postForResponse(s.client, s.transforms, url, "application/x-ndjson", body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]FlushResult, len(records))
+
+	var resp bulkResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, &ErrRetryable{Err: fmt.Errorf("bulk response: %w", err)}
+	}
+
+	if !resp.Errors {
+		return results, nil
+	}
+
+	if len(resp.Items) != len(records) {
+		return nil, &ErrRetryable{Err: fmt.Errorf(
+			"bulk response has %v items for %v records", len(resp.Items), len(records),
+		)}
+	}
+
+	for ix, item := range resp.Items {
+		r := item["create"]
+		if r.Status < 300 || r.Status == http.StatusConflict {
+			continue
+		}
+
+		results[ix].Err = classifyStatus(r.Status, 0, fmt.Errorf(
+			"creating record %v of %v: %s", ix, ident, r.Error,
+		))
+	}
+
+	return results, nil
+}
@@ -0,0 +1,72 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElasticsearchSink(t *testing.T) {
+	var path string
+	var lines []string
+	response := `{"errors": false, "items": []}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+
+	s := &ElasticsearchSink{}
+	assert.Nil(t, s.LoadConfig([]byte(`{
+		"es_address": "`+ts.URL+`",
+		"es_index": "k8s-events",
+		"es_index_by_type": {"Warning": "k8s-warnings"}
+	}`)))
+
+	records := [][]byte{
+		[]byte(`{"id":"a","type":"Warning"}`),
+		[]byte(`{"id":"b","type":"Normal"}`),
+	}
+
+	t.Run("Events are created in the data stream of their type", func(t *testing.T) {
+		results, err := s.FlushRecords("uid", "1", records)
+		assert.Nil(t, err)
+		assert.Equal(t, []FlushResult{{}, {}}, results)
+		assert.Equal(t, "/_bulk", path)
+
+		assert.Equal(t, []string{
+			`{"create":{"_index":"k8s-warnings"}}`, `{"id":"a","type":"Warning"}`,
+			`{"create":{"_index":"k8s-events"}}`, `{"id":"b","type":"Normal"}`,
+		}, lines)
+	})
+
+	t.Run("Failed items are reported per record", func(t *testing.T) {
+		items, _ := json.Marshal(map[string]interface{}{
+			"errors": true,
+			"items": []interface{}{
+				map[string]interface{}{"create": map[string]interface{}{"status": 409}},
+				map[string]interface{}{"create": map[string]interface{}{
+					"status": 429, "error": map[string]string{"type": "es_rejected_execution_exception"},
+				}},
+			},
+		})
+		response = string(items)
+
+		results, err := s.FlushRecords("uid", "2", records)
+		assert.Nil(t, err)
+		assert.Nil(t, results[0].Err)
+
+		var throttled *ErrThrottled
+		assert.True(t, errors.As(results[1].Err, &throttled))
+
+		assert.NotNil(t, s.Flush("uid", "3", joinRecords(records)))
+	})
+}
@@ -0,0 +1,115 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	fmt "fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	fifoBuffer = "buffer"
+	fifoDrop   = "drop"
+
+	defaultFifoBufferBytes = 16 << 20
+)
+
+// FifoSink writes NDJSON to a named pipe read by a local log shipper. The
+// pipe is created if it does not exist. While no reader has the pipe open,
+// records are buffered, up to fifo_buffer_bytes, or dropped; the pipe is
+// reopened on the next Flush once a reader is back.
+type FifoSink struct {
+	Path        string `json:"fifo_path" validate:"required"`
+	NoReader    string `json:"fifo_no_reader_policy"`
+	BufferBytes int    `json:"fifo_buffer_bytes"`
+
+	sync.Mutex
+	f       *os.File
+	pending []byte
+}
+
+func (s *FifoSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	switch s.NoReader {
+	case "":
+		s.NoReader = fifoBuffer
+	case fifoBuffer, fifoDrop:
+	default:
+		return fmt.Errorf("unknown fifo_no_reader_policy %q", s.NoReader)
+	}
+
+	if s.BufferBytes == 0 {
+		s.BufferBytes = defaultFifoBufferBytes
+	}
+
+	fi, err := os.Stat(s.Path)
+	switch {
+	case os.IsNotExist(err):
+		return mkfifo(s.Path)
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeNamedPipe == 0:
+		return fmt.Errorf("fifo_path %v is not a named pipe", s.Path)
+	}
+
+	return nil
+}
+
+func (s *FifoSink) Flush(uuid, ident string, d []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.f == nil {
+		// Opening for writing without blocking fails with ENXIO while
+		// there is no reader.
+		f, err := os.OpenFile(s.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			if errors.Is(err, syscall.ENXIO) {
+				return s.noReader(ident, d)
+			}
+			return &ErrRetryable{Err: err}
+		}
+		s.f = f
+	}
+
+	b := append(s.pending, d...)
+	s.pending = nil
+
+	n, err := s.f.Write(b)
+	if err != nil {
+		s.f.Close()
+		s.f = nil
+
+		// The reader went away.
+		if errors.Is(err, syscall.EPIPE) {
+			return s.noReader(ident, b[n:])
+		}
+		s.pending = b[n:]
+		return &ErrRetryable{Err: err}
+	}
+
+	return nil
+}
+
+// noReader holds on to, or drops, records written while nobody reads
+// the pipe.
+func (s *FifoSink) noReader(ident string, d []byte) error {
+	if s.NoReader == fifoDrop {
+		log.Printf("No reader on %v, dropping %v", s.Path, ident)
+		return nil
+	}
+
+	if len(s.pending)+len(d) > s.BufferBytes {
+		log.Printf("No reader on %v and the buffer is full, dropping %v", s.Path, ident)
+		return nil
+	}
+
+	s.pending = append(s.pending, d...)
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build !windows
+// +build !windows
+
+package io
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fifoReader stands in for the log shipper. It opens the pipe read-write,
+// which does not wait for a writer and still counts as a reader.
+type fifoReader struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func newFifoReader(t *testing.T, path string) *fifoReader {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fifoReader{f, bufio.NewReader(f)}
+}
+
+func (r *fifoReader) lines(t *testing.T, n int) []string {
+	r.f.SetReadDeadline(time.Now().Add(time.Second))
+	lines := []string{}
+	for len(lines) < n {
+		l, err := r.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestFifoSink(t *testing.T) {
+	newSink := func(t *testing.T, policy string) *FifoSink {
+		dir, err := ioutil.TempDir("", "fifo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := &FifoSink{}
+		path := filepath.Join(dir, "events.fifo")
+		if err := s.LoadConfig([]byte(`{
+			"fifo_path": "` + path + `",
+			"fifo_no_reader_policy": "` + policy + `"
+		}`)); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.NotZero(t, fi.Mode()&os.ModeNamedPipe)
+		return s
+	}
+
+	t.Run("Writes survive the reader reconnecting", func(t *testing.T) {
+		s := newSink(t, fifoBuffer)
+		defer os.RemoveAll(filepath.Dir(s.Path))
+
+		// Nobody is reading yet.
+		assert.Nil(t, s.Flush("uid", "1", []byte("a\n")))
+
+		r := newFifoReader(t, s.Path)
+		assert.Nil(t, s.Flush("uid", "2", []byte("b\n")))
+		assert.Equal(t, []string{"a\n", "b\n"}, r.lines(t, 2))
+
+		r.f.Close()
+		assert.Nil(t, s.Flush("uid", "3", []byte("c\n")))
+
+		r = newFifoReader(t, s.Path)
+		defer r.f.Close()
+		assert.Nil(t, s.Flush("uid", "4", []byte("d\n")))
+		assert.Equal(t, []string{"c\n", "d\n"}, r.lines(t, 2))
+	})
+
+	t.Run("Drop policy discards writes without a reader", func(t *testing.T) {
+		s := newSink(t, fifoDrop)
+		defer os.RemoveAll(filepath.Dir(s.Path))
+		assert.Nil(t, s.Flush("uid", "1", []byte("a\n")))
+
+		r := newFifoReader(t, s.Path)
+		defer r.f.Close()
+		assert.Nil(t, s.Flush("uid", "2", []byte("b\n")))
+		assert.Equal(t, []string{"b\n"}, r.lines(t, 1))
+	})
+}
@@ -4,19 +4,121 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
+// FileSink writes each batch to a file of its own in Dir, or, with Path,
+// appends the batches to that one file as newline delimited JSON. The file
+// is rotated, renamed with the time as a suffix, before it grows past
+// MaxBytes. With PathTemplate, the batch files in Dir are partitioned into
+// the subdirectories that the events render to.
 type FileSink struct {
-	Dir string `json:"file_sink_dir" validate:"required"`
+	Dir          string `json:"file_sink_dir" validate:"required_without=Path"`
+	Path         string `json:"file_sink_path"`
+	MaxBytes     int64  `json:"file_sink_max_bytes"`
+	PathTemplate string `json:"file_sink_path_template"`
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	now       func() time.Time
+	partition *pathTemplate
 }
 
 func (f *FileSink) LoadConfig(b json.RawMessage) error {
 	// TODO: Check if Dir actually exists and is writable.
-	return LoadConfig(b, f)
+	if err := LoadConfig(b, f); err != nil {
+		return err
+	}
+
+	if f.PathTemplate == "" {
+		return nil
+	}
+
+	t, err := newPathTemplate("file_sink_path_template", f.PathTemplate)
+	f.partition = t
+	return err
+}
+
+// setLocation has the dates of the paths of PathTemplate be the dates in
+// loc.
+func (f *FileSink) setLocation(loc *time.Location) {
+	if f.partition != nil {
+		f.partition.loc = loc
+	}
 }
 
 func (f *FileSink) Flush(uuid, filename string, d []byte) error {
-	fname := filepath.Join(f.Dir, fmt.Sprintf("%v_%v.log", uuid, filename))
-	return ioutil.WriteFile(fname, d, 0644)
+	if f.Path != "" {
+		return f.append(d)
+	}
+
+	if f.partition == nil {
+		fname := filepath.Join(f.Dir, fmt.Sprintf("%v_%v.log", uuid, filename))
+		return ioutil.WriteFile(fname, d, 0644)
+	}
+
+	paths, groups, err := f.partition.partition(d)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		dir := filepath.Join(f.Dir, path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		fname := filepath.Join(dir, fmt.Sprintf("%v_%v.log", uuid, filename))
+		if err := ioutil.WriteFile(fname, joinRecords(groups[path]), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSink) append(d []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil && f.MaxBytes > 0 && f.size > 0 && f.size+int64(len(d)) > f.MaxBytes {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if f.file == nil {
+		file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		f.file, f.size = file, info.Size()
+	}
+
+	n, err := f.file.Write(d)
+	f.size += int64(n)
+	return err
+}
+
+// rotate moves the file aside, for the next batch to start a new one.
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	now := time.Now
+	if f.now != nil {
+		now = f.now
+	}
+	return os.Rename(f.Path, f.Path+"."+now().UTC().Format("20060102T150405.000000000"))
 }
@@ -0,0 +1,98 @@
+package io
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("A file per batch", func(t *testing.T) {
+		f := &FileSink{}
+		assert.Nil(t, f.LoadConfig([]byte(`{"file_sink_dir": "`+dir+`"}`)))
+		assert.Nil(t, f.Flush("uid", "1", []byte("{}\n")))
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "uid_1.log"))
+		assert.Nil(t, err)
+		assert.Equal(t, "{}\n", string(b))
+	})
+
+	t.Run("Appended and rotated", func(t *testing.T) {
+		path := filepath.Join(dir, "events.ndjson")
+		f := &FileSink{}
+		assert.Nil(t, f.LoadConfig([]byte(`{"file_sink_path": "`+path+`", "file_sink_max_bytes": 24}`)))
+		f.now = func() time.Time { return time.Date(2020, 4, 8, 10, 12, 1, 0, time.UTC) }
+
+		line := []byte("{\"id\":\"a\"}\n") // 11 bytes
+		assert.Nil(t, f.Flush("uid", "1", line))
+		assert.Nil(t, f.Flush("uid", "2", line))
+		assert.Nil(t, f.Flush("uid", "3", line))
+
+		rotated, err := ioutil.ReadFile(path + ".20200408T101201.000000000")
+		assert.Nil(t, err)
+		assert.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"a\"}\n", string(rotated))
+
+		current, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "{\"id\":\"a\"}\n", string(current))
+	})
+
+	t.Run("Partitioned by the path template", func(t *testing.T) {
+		f := &FileSink{}
+		assert.Nil(t, f.LoadConfig([]byte(`{"file_sink_dir": "`+dir+`", "file_sink_path_template": "{{.Namespace}}/{{.ReferenceKind}}/{{date}}"}`)))
+		f.partition.now = func() time.Time { return time.Date(2020, 4, 8, 10, 12, 1, 0, time.UTC) }
+
+		web := `{"namespace":"web","reference_kind":"Pod","reason":"BackOff"}` + "\n"
+		deploy := `{"namespace":"web","reference_kind":"Deployment","reason":"ScalingReplicaSet"}` + "\n"
+		db := `{"namespace":"db","reference_kind":"Pod","reason":"Killing"}` + "\n"
+		node := `{"namespace":"","reference_kind":"../../Node","reason":"NodeNotReady"}` + "\n"
+		assert.Nil(t, f.Flush("uid", "1", []byte(web+deploy+db+web+node)))
+
+		for path, want := range map[string]string{
+			"web/Pod/2020-04-08":        web + web,
+			"web/Deployment/2020-04-08": deploy,
+			"db/Pod/2020-04-08":         db,
+			"Node/2020-04-08":           node,
+		} {
+			b, err := ioutil.ReadFile(filepath.Join(dir, path, "uid_1.log"))
+			assert.Nil(t, err, path)
+			assert.Equal(t, want, string(b), path)
+		}
+	})
+
+	t.Run("Dated in the timezone", func(t *testing.T) {
+		conf := &Config{
+			Sink:     "file",
+			Raw:      []byte(`{"file_sink_dir": "` + dir + `", "file_sink_path_template": "{{date}}/{{hour}}"}`),
+			Timezone: "Asia/Kolkata",
+		}
+		sink, err := GetFlusher(conf)
+		assert.Nil(t, err)
+
+		f := sink.(*FileSink)
+		f.partition.now = func() time.Time { return time.Date(2020, 4, 8, 20, 12, 1, 0, time.UTC) }
+		assert.Nil(t, f.Flush("uid", "1", []byte(`{"namespace":"web"}`+"\n")))
+
+		_, err = os.Stat(filepath.Join(dir, "2020-04-09", "01", "uid_1.log"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("A path template that does not parse", func(t *testing.T) {
+		f := &FileSink{}
+		assert.Error(t, f.LoadConfig([]byte(`{"file_sink_dir": "`+dir+`", "file_sink_path_template": "{{.Namespace"}`)))
+	})
+
+	t.Run("A directory or a path is required", func(t *testing.T) {
+		assert.Error(t, (&FileSink{}).LoadConfig([]byte(`{}`)))
+	})
+}
@@ -0,0 +1,49 @@
+package io
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HTTPSink posts each batch to http_address as newline delimited JSON, the
+// same payload as the vector sink, for any collector that takes it.
+type HTTPSink struct {
+	Address string       `json:"http_address" validate:"required"`
+	HTTP    *HTTPOptions `json:"http_sink"`
+	TLS     *TLSConfig   `json:"tls"`
+
+	client        *http.Client
+	transforms    []RequestTransform
+	schemaVersion string
+}
+
+func (s *HTTPSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	c, err := newHTTPClient(s.TLS, s.HTTP)
+	if err != nil {
+		return err
+	}
+
+	s.client = c
+	s.transforms, err = s.HTTP.transforms()
+	return err
+}
+
+func (s *HTTPSink) setSchemaVersion(v string) {
+	s.schemaVersion = v
+}
+
+func (s *HTTPSink) Flush(uuid, ident string, d []byte) error {
+	headers := map[string]string{
+		"X-K8stream-Uid":   uuid,
+		"X-K8stream-Batch": ident,
+	}
+	if s.schemaVersion != "" {
+		headers["X-K8stream-Schema-Version"] = s.schemaVersion
+	}
+
+	return post(s.client, s.transforms, s.Address, "application/x-ndjson", d, headers)
+}
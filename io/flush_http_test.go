@@ -0,0 +1,50 @@
+package io
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSink(t *testing.T) {
+	var posted []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "2", r.Header.Get("X-K8stream-Batch"))
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	batch := []byte("{\"id\":\"a\"}\n{\"id\":\"b\"}\n")
+
+	s := &HTTPSink{}
+	assert.Nil(t, s.LoadConfig([]byte(`{"http_address": "`+ts.URL+`"}`)))
+	assert.Nil(t, s.Flush("uid", "2", batch))
+	assert.Equal(t, batch, posted)
+
+	t.Run("The file sink writes the same payloads", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "events.ndjson")
+		f := &FileSink{}
+		assert.Nil(t, f.LoadConfig([]byte(`{"file_sink_path": "`+path+`"}`)))
+		assert.Nil(t, f.Flush("uid", "2", batch))
+
+		b, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, posted, b)
+	})
+
+	t.Run("An address is required", func(t *testing.T) {
+		assert.Error(t, (&HTTPSink{}).LoadConfig([]byte(`{}`)))
+	})
+}
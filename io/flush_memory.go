@@ -3,9 +3,13 @@ package io
 import (
 	"encoding/json"
 	"log"
+	"sync"
 )
 
+// MemSink keeps the records flushed to it by their batch ident. It is safe
+// for use by several flush workers at once.
 type MemSink struct {
+	mu      sync.Mutex
 	uuid    string
 	Records map[string][]byte
 	OnFetch func(string)
@@ -18,9 +22,20 @@ func (m *MemSink) LoadConfig(_ json.RawMessage) error {
 
 func (m *MemSink) Flush(uuid, ident string, d []byte) error {
 	defer m.OnFetch(uuid + "/" + ident)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.batch = ident
 	m.uuid = uuid
 	m.Records[ident] = d
 	log.Println(string(d))
 	return nil
 }
+
+// Len returns the number of batches flushed to the sink.
+func (m *MemSink) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.Records)
+}
@@ -0,0 +1,359 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultOTLPTimeout = 10
+
+	otlpLogsExport = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+)
+
+// SeverityNumber of OTLP log records, by the severity of the event.
+var otlpSeverities = map[string]uint64{
+	"info":     9,  // INFO
+	"warning":  13, // WARN
+	"critical": 17, // ERROR
+}
+
+// OTLPLogsSink exports each batch to the OTLP/gRPC logs service of a
+// collector, with an Export of a request holding a LogRecord per event: the
+// message as the body, the severity as the severity, and the other fields
+// of the event as attributes by their JSON names.
+type OTLPLogsSink struct {
+	// host:port of the collector, 4317 by convention.
+	Endpoint string            `json:"otlp_endpoint" validate:"required"`
+	Headers  map[string]string `json:"otlp_headers"`
+
+	// Plaintext instead of TLS, for a collector on the node.
+	Insecure bool `json:"otlp_insecure"`
+
+	// Seconds an Export may take.
+	Timeout int `json:"otlp_timeout"`
+
+	TLS *TLSConfig `json:"tls"`
+
+	conn *grpc.ClientConn
+	now  func() time.Time
+}
+
+func (s *OTLPLogsSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = defaultOTLPTimeout
+	}
+
+	creds := grpc.WithInsecure()
+	if !s.Insecure {
+		t := s.TLS
+		if t == nil {
+			t = &TLSConfig{}
+		}
+
+		c, err := t.config()
+		if err != nil {
+			return err
+		}
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(c))
+	}
+
+	// Dialing does not wait for the connection, which is made on the first
+	// Export and remade after failures.
+	conn, err := grpc.Dial(s.Endpoint, creds)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	s.now = time.Now
+	return nil
+}
+
+func (s *OTLPLogsSink) Flush(uuid, ident string, d []byte) error {
+	req, err := otlpLogsRequest(uuid, splitRecords(d), s.now())
+	if err != nil {
+		return &ErrPermanent{Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Timeout)*time.Second)
+	defer cancel()
+
+	for k, v := range s.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+
+	var resp []byte
+	if err := s.conn.Invoke(ctx, otlpLogsExport, &req, &resp, grpc.ForceCodec(rawCodec{})); err != nil {
+		return classifyOTLPError(err)
+	}
+
+	// Records the collector rejected must not be retried.
+	if rejected, msg := otlpPartialSuccess(resp); rejected > 0 {
+		log.Printf("OTLP collector rejected %v of the log records of %v: %v", rejected, ident, msg)
+	}
+	return nil
+}
+
+// classifyOTLPError maps the status of a failed Export to the sink error
+// types, by the codes that OTLP has clients retry.
+func classifyOTLPError(err error) error {
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted,
+		codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return &ErrRetryable{Err: err}
+	case codes.ResourceExhausted:
+		return &ErrThrottled{Err: err}
+	}
+	return &ErrPermanent{Err: err}
+}
+
+// rawCodec passes messages encoded already through gRPC as they are.
+type rawCodec struct{}
+
+var _ encoding.Codec = rawCodec{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec cannot marshal %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec cannot unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+// otlpLogsRequest encodes an ExportLogsServiceRequest of a ResourceLogs of
+// k8stream, with a LogRecord of each of the records.
+func otlpLogsRequest(uuid string, records [][]byte, observed time.Time) ([]byte, error) {
+	var logs []byte
+	for _, r := range records {
+		lr, err := otlpLogRecord(r, observed)
+		if err != nil {
+			return nil, err
+		}
+		logs = pbMessage(logs, 2, lr) // ScopeLogs.log_records
+	}
+
+	var scope []byte
+	scope = pbString(scope, 1, "k8stream") // InstrumentationScope.name
+	scopeLogs := pbMessage(nil, 1, scope)  // ScopeLogs.scope
+	scopeLogs = append(scopeLogs, logs...)
+
+	var resource []byte
+	resource = pbMessage(resource, 1, pbKeyValue("service.name", pbStringValue("k8stream")))
+	resource = pbMessage(resource, 1, pbKeyValue("k8stream.uid", pbStringValue(uuid)))
+
+	resourceLogs := pbMessage(nil, 1, resource)          // ResourceLogs.resource
+	resourceLogs = pbMessage(resourceLogs, 2, scopeLogs) // ResourceLogs.scope_logs
+	return pbMessage(nil, 1, resourceLogs), nil          // ExportLogsServiceRequest.resource_logs
+}
+
+func otlpLogRecord(r []byte, observed time.Time) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(r, &fields); err != nil {
+		return nil, fmt.Errorf("otlp log record: %w", err)
+	}
+
+	var ts int64
+	json.Unmarshal(fields["timestamp"], &ts)
+
+	var severity, typ, message string
+	json.Unmarshal(fields["severity"], &severity)
+	json.Unmarshal(fields["type"], &typ)
+	json.Unmarshal(fields["message"], &message)
+
+	number, ok := otlpSeverities[severity]
+	if !ok {
+		number = otlpSeverities["info"]
+		if typ == "Warning" {
+			number = otlpSeverities["warning"]
+		}
+	}
+	text := severity
+	if text == "" {
+		text = typ
+	}
+
+	var lr []byte
+	if ts > 0 {
+		lr = pbFixed64(lr, 1, uint64(ts)*uint64(time.Second)) // time_unix_nano
+	}
+	lr = pbVarint(lr, 2, number)                  // severity_number
+	lr = pbString(lr, 3, text)                    // severity_text
+	lr = pbMessage(lr, 5, pbStringValue(message)) // body
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "message" || name == "timestamp" {
+			continue
+		}
+
+		v, ok := pbAnyValue(fields[name])
+		if !ok {
+			continue
+		}
+		lr = pbMessage(lr, 6, pbKeyValue(name, v)) // attributes
+	}
+
+	lr = pbFixed64(lr, 11, uint64(observed.UnixNano())) // observed_time_unix_nano
+	return lr, nil
+}
+
+// otlpPartialSuccess reads the rejected log records, and why, off an
+// ExportLogsServiceResponse.
+func otlpPartialSuccess(resp []byte) (int64, string) {
+	var rejected int64
+	var msg string
+	pbEach(resp, func(num protowire.Number, v []byte, n uint64) {
+		if num != 1 {
+			return
+		}
+		pbEach(v, func(num protowire.Number, v []byte, n uint64) {
+			switch num {
+			case 1:
+				rejected = int64(n)
+			case 2:
+				msg = string(v)
+			}
+		})
+	})
+	return rejected, msg
+}
+
+// pbAnyValue encodes a JSON value as an AnyValue: strings, bools and
+// numbers as themselves, objects and lists as their JSON. Nulls and empty
+// strings are left out.
+func pbAnyValue(raw json.RawMessage) ([]byte, bool) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil || v == nil || v == "" {
+		return nil, false
+	}
+
+	switch v := v.(type) {
+	case string:
+		return pbStringValue(v), true
+	case bool:
+		var n uint64
+		if v {
+			n = 1
+		}
+		return pbVarint(nil, 2, n), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return pbVarint(nil, 3, uint64(i)), true
+		}
+		f, _ := v.Float64()
+		return pbFixed64(nil, 4, math.Float64bits(f)), true
+	}
+	return pbStringValue(string(raw)), true
+}
+
+func pbStringValue(s string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func pbKeyValue(key string, value []byte) []byte {
+	return pbMessage(pbString(nil, 1, key), 2, value)
+}
+
+func pbString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func pbMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func pbVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func pbFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+// pbEach calls fn with each field of a message: its bytes, for the length
+// delimited ones, or else its number. It stops at what does not parse.
+func pbEach(b []byte, fn func(num protowire.Number, v []byte, n uint64)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return
+			}
+			fn(num, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return
+			}
+			fn(num, nil, v)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return
+			}
+			fn(num, nil, v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return
+			}
+			b = b[n:]
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package io
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pbFields decodes a message into its fields, by number: the bytes of the
+// length delimited ones, and the numbers of the others.
+func pbFields(b []byte) (map[protowire.Number][][]byte, map[protowire.Number]uint64) {
+	messages := map[protowire.Number][][]byte{}
+	numbers := map[protowire.Number]uint64{}
+	pbEach(b, func(num protowire.Number, v []byte, n uint64) {
+		if v != nil {
+			messages[num] = append(messages[num], v)
+			return
+		}
+		numbers[num] = n
+	})
+	return messages, numbers
+}
+
+// pbAttributes decodes repeated KeyValues into their keys and AnyValues.
+func pbAttributes(kvs [][]byte) map[string][]byte {
+	attrs := map[string][]byte{}
+	for _, kv := range kvs {
+		f, _ := pbFields(kv)
+		attrs[string(f[1][0])] = f[2][0]
+	}
+	return attrs
+}
+
+func TestOTLPLogsSink(t *testing.T) {
+	var requests [][]byte
+	var auth []string
+	var failures []codes.Code
+
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			assert.Equal(t, otlpLogsExport, method)
+
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			auth = md.Get("authorization")
+
+			var req []byte
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			requests = append(requests, req)
+
+			if len(failures) > 0 {
+				code := failures[0]
+				failures = failures[1:]
+				return status.Error(code, "collector is busy")
+			}
+
+			resp := []byte{}
+			return stream.SendMsg(&resp)
+		}),
+	)
+	l, err := net.Listen("tcp", "localhost:0")
+	assert.Nil(t, err)
+	go s.Serve(l)
+	defer s.Stop()
+
+	sink := &OTLPLogsSink{}
+	assert.Nil(t, sink.LoadConfig([]byte(`{
+		"otlp_endpoint": "`+l.Addr().String()+`",
+		"otlp_headers": {"authorization": "Bearer secret"},
+		"otlp_insecure": true
+	}`)))
+	sink.now = func() time.Time { return time.Unix(1586340800, 0) }
+
+	d := []byte(`{"id":"a","timestamp":1586340721,"message":"Back-off restarting failed container","reason":"BackOff","type":"Warning","severity":"warning","count":3,"labels":{"app":"web"},"pods_truncated":true,"host":""}
+{"id":"b","message":"Pulled","type":"Normal"}
+`)
+
+	t.Run("Batches export as a request of log records", func(t *testing.T) {
+		requests = nil
+		assert.Nil(t, sink.Flush("uid", "1", d))
+		assert.Equal(t, []string{"Bearer secret"}, auth)
+		assert.Len(t, requests, 1)
+
+		req, _ := pbFields(requests[0])
+		assert.Len(t, req[1], 1)
+		resourceLogs, _ := pbFields(req[1][0])
+
+		resource, _ := pbFields(resourceLogs[1][0])
+		assert.Equal(t, map[string][]byte{
+			"service.name": pbStringValue("k8stream"),
+			"k8stream.uid": pbStringValue("uid"),
+		}, pbAttributes(resource[1]))
+
+		scopeLogs, _ := pbFields(resourceLogs[2][0])
+		scope, _ := pbFields(scopeLogs[1][0])
+		assert.Equal(t, "k8stream", string(scope[1][0]))
+		assert.Len(t, scopeLogs[2], 2)
+
+		first, n := pbFields(scopeLogs[2][0])
+		assert.Equal(t, uint64(1586340721*time.Second), n[1])
+		assert.Equal(t, uint64(13), n[2])
+		assert.Equal(t, "warning", string(first[3][0]))
+		assert.Equal(t, pbStringValue("Back-off restarting failed container"), first[5][0])
+		assert.Equal(t, uint64(1586340800*time.Second), n[11])
+		assert.Equal(t, map[string][]byte{
+			"id":             pbStringValue("a"),
+			"reason":         pbStringValue("BackOff"),
+			"type":           pbStringValue("Warning"),
+			"severity":       pbStringValue("warning"),
+			"count":          pbVarint(nil, 3, 3),
+			"labels":         pbStringValue(`{"app":"web"}`),
+			"pods_truncated": pbVarint(nil, 2, 1),
+		}, pbAttributes(first[6]))
+
+		second, n := pbFields(scopeLogs[2][1])
+		_, hasTime := n[1]
+		assert.False(t, hasTime)
+		assert.Equal(t, uint64(9), n[2])
+		assert.Equal(t, "Normal", string(second[3][0]))
+		assert.Equal(t, pbStringValue("Pulled"), second[5][0])
+	})
+
+	t.Run("Retryable statuses are retried", func(t *testing.T) {
+		requests = nil
+		failures = []codes.Code{codes.Unavailable}
+
+		r := WithRetry(sink, nil, &Config{RetryAttempts: 3}, NewRetryBudget(0)).(*retryFlusher)
+		r.sleep = func(time.Duration) {}
+		assert.Nil(t, r.Flush("uid", "2", d))
+		assert.Len(t, requests, 2)
+		assert.Equal(t, requests[0], requests[1])
+	})
+
+	t.Run("Other statuses are permanent", func(t *testing.T) {
+		requests = nil
+		failures = []codes.Code{codes.InvalidArgument}
+
+		r := WithRetry(sink, nil, &Config{RetryAttempts: 3}, NewRetryBudget(0)).(*retryFlusher)
+		r.sleep = func(time.Duration) {}
+		err := r.Flush("uid", "3", d)
+		assert.True(t, isPermanent(err))
+		assert.Len(t, requests, 1)
+	})
+
+	t.Run("Exhausted collectors throttle", func(t *testing.T) {
+		failures = []codes.Code{codes.ResourceExhausted}
+		_, ok := sink.Flush("uid", "4", d).(*ErrThrottled)
+		assert.True(t, ok)
+	})
+}
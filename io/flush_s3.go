@@ -3,28 +3,64 @@ package io
 import (
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	fmt "fmt"
 	"io"
 	"log"
+	"net/http"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// S3Sink uploads each batch, gzipped, under Prefix. With PathTemplate, the
+// batch is split into an object per path that its events render to, keyed
+// between Prefix and the uuid, for queries to prune the partitions.
 type S3Sink struct {
-	Prefix  string `json:"prefix" validate:"required"`
-	Region  string `json:"aws_region", validate:"required"`
-	Bucket  string `json:"aws_bucket" validate:"required"`
-	Profile string `json:"aws_profile" validate:"required"`
+	Prefix       string     `json:"prefix" validate:"required"`
+	Region       string     `json:"aws_region" validate:"required"`
+	Bucket       string     `json:"aws_bucket" validate:"required"`
+	Profile      string     `json:"aws_profile" validate:"required"`
+	PathTemplate string     `json:"path_template"`
+	TLS          *TLSConfig `json:"tls"`
+
+	// Only the proxy of the HTTP options applies to S3.
+	HTTP *HTTPOptions `json:"http_sink"`
+
+	client    *http.Client
+	partition *pathTemplate
 }
 
 func (s *S3Sink) LoadConfig(b json.RawMessage) error {
-	return LoadConfig(b, s)
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	if s.PathTemplate != "" {
+		t, err := newPathTemplate("path_template", s.PathTemplate)
+		if err != nil {
+			return err
+		}
+		s.partition = t
+	}
+
+	c, err := newHTTPClient(s.TLS, s.HTTP)
+	s.client = c
+	return err
+}
+
+// setLocation has the dates of the keys of PathTemplate be the dates in loc.
+func (s *S3Sink) setLocation(loc *time.Location) {
+	if s.partition != nil {
+		s.partition.loc = loc
+	}
 }
 
 var s3s *session.Session
@@ -36,12 +72,36 @@ func getSession(s *S3Sink) (*session.Session, error) {
 		s3s, err = session.NewSession(&aws.Config{
 			Region:      aws.String(s.Region),
 			Credentials: credentials.NewSharedCredentials("", s.Profile),
+			HTTPClient:  s.client,
 		})
 	})
 
 	return s3s, err
 }
 
+// Connect establishes the AWS session ahead of the first upload.
+func (s *S3Sink) Connect() error {
+	_, err := getSession(s)
+	return err
+}
+
+// Ping issues a HeadBucket so the underlying connection stays warm.
+func (s *S3Sink) Ping() error {
+	sess, err := getSession(s)
+	if err != nil {
+		return err
+	}
+
+	if sess == nil {
+		return fmt.Errorf("Empty session. There was an error earlier")
+	}
+
+	_, err = s3.New(sess).HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	return err
+}
+
 func (s *S3Sink) Flush(uuid, filename string, d []byte) error {
 	sess, err := getSession(s)
 	if err != nil {
@@ -52,6 +112,26 @@ func (s *S3Sink) Flush(uuid, filename string, d []byte) error {
 		return fmt.Errorf("Empty session. There was an error earlier")
 	}
 
+	if s.partition == nil {
+		return uploadToS3(sess, s.Bucket, filepath.Join(s.Prefix, uuid), filename, gzipped(d))
+	}
+
+	// A retry uploads the same keys again, over the objects that made it.
+	paths, groups, err := s.partition.partition(d)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		prefix := filepath.Join(s.Prefix, path, uuid)
+		if err := uploadToS3(sess, s.Bucket, prefix, filename, gzipped(joinRecords(groups[path]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gzipped(d []byte) io.Reader {
 	reader, writer := io.Pipe()
 	go func() {
 		zw := gzip.NewWriter(writer)
@@ -59,9 +139,7 @@ func (s *S3Sink) Flush(uuid, filename string, d []byte) error {
 		zw.Close()
 		writer.Close()
 	}()
-
-	prefix := filepath.Join(s.Prefix, uuid)
-	return uploadToS3(sess, s.Bucket, prefix, filename, reader)
+	return reader
 }
 
 func uploadToS3(
@@ -81,5 +159,20 @@ func uploadToS3(
 		StorageClass: aws.String(s3.ObjectStorageClassStandardIa),
 	})
 
-	return err
+	return classifyS3Error(err)
+}
+
+// classifyS3Error maps the status code of a failed S3 request to the sink
+// error types. S3 signals throttling with a 503 SlowDown.
+func classifyS3Error(err error) error {
+	var rf awserr.RequestFailure
+	if !errors.As(err, &rf) {
+		return err
+	}
+
+	if rf.Code() == "SlowDown" {
+		return &ErrThrottled{Err: err}
+	}
+
+	return classifyStatus(rf.StatusCode(), 0, err)
 }
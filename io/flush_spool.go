@@ -0,0 +1,194 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultSpoolFileBytes = 64 << 20
+	defaultSpoolMaxFiles  = 10
+
+	// Name of the marker file in the spool directory.
+	spoolCommittedFile = "committed"
+)
+
+// SpoolSink appends batches, as newline delimited JSON, to a bounded set
+// of rotating files, spool-000001.ndjson onwards, for an agent on the node
+// to tail. The agent acks what it shipped by writing the marker file
+// "committed" in the directory:
+//
+//	{"file": "spool-000002.ndjson", "offset": 1024}
+//
+// A file is deleted only once the marker is past it. While MaxFiles are
+// waiting for the agent, flushes fail, to be retried, so that no event is
+// dropped before it was shipped.
+type SpoolSink struct {
+	Dir          string `json:"spool_dir" validate:"required"`
+	MaxFileBytes int64  `json:"spool_file_max_bytes"`
+	MaxFiles     int    `json:"spool_max_files"`
+
+	mu   sync.Mutex
+	file *os.File
+	seq  int
+	size int64
+}
+
+// spoolMarker is what the agent writes to the marker file.
+type spoolMarker struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+func spoolFileName(seq int) string {
+	return fmt.Sprintf("spool-%06d.ndjson", seq)
+}
+
+func (s *SpoolSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, s); err != nil {
+		return err
+	}
+
+	if s.MaxFileBytes == 0 {
+		s.MaxFileBytes = defaultSpoolFileBytes
+	}
+	if s.MaxFiles == 0 {
+		s.MaxFiles = defaultSpoolMaxFiles
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Carry on after the files of a previous run.
+	seqs, err := s.files()
+	if err != nil {
+		return err
+	}
+	if len(seqs) > 0 {
+		s.seq = seqs[len(seqs)-1]
+	}
+	return nil
+}
+
+func (s *SpoolSink) Flush(uuid, ident string, d []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.collect(); err != nil {
+		return err
+	}
+
+	if s.file == nil || s.size > 0 && s.size+int64(len(d)) > s.MaxFileBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(d)
+	s.size += int64(n)
+	return err
+}
+
+// files are the sequence numbers of the spool files, in order.
+func (s *SpoolSink) files() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(s.Dir, "spool-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := []int{}
+	for _, name := range names {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(name), "spool-%06d.ndjson", &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// rotate starts the next file, unless MaxFiles are waiting for the agent.
+// A file that the previous run was writing is not appended to, as the
+// agent may hold an offset into it.
+func (s *SpoolSink) rotate() error {
+	seqs, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	if len(seqs) >= s.MaxFiles {
+		return &ErrRetryable{Err: fmt.Errorf(
+			"spool %v is full, %v files are not committed past yet", s.Dir, len(seqs),
+		)}
+	}
+
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+
+	s.seq++
+	f, err := os.OpenFile(filepath.Join(s.Dir, spoolFileName(s.seq)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.file, s.size = f, 0
+	return nil
+}
+
+// collect deletes the files that the marker is past: those before the
+// file it is in, and that file too once it was read to the end and no
+// longer written to.
+func (s *SpoolSink) collect() error {
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, spoolCommittedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var m spoolMarker
+	var committed int
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("spool marker: %w", err)
+	}
+	if _, err := fmt.Sscanf(m.File, "spool-%06d.ndjson", &committed); err != nil {
+		return fmt.Errorf("spool marker names %q: %w", m.File, err)
+	}
+
+	seqs, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range seqs {
+		if seq > committed || seq == s.seq && s.file != nil {
+			break
+		}
+
+		path := filepath.Join(s.Dir, spoolFileName(seq))
+		if seq == committed {
+			info, err := os.Stat(path)
+			if err != nil || m.Offset < info.Size() {
+				break
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package io
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoolSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	commit := func(marker string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, spoolCommittedFile), []byte(marker), 0644))
+	}
+
+	s := &SpoolSink{}
+	assert.Nil(t, s.LoadConfig([]byte(`{"spool_dir": "`+dir+`", "spool_file_max_bytes": 11, "spool_max_files": 2}`)))
+
+	line := []byte("{\"id\":\"a\"}\n") // 11 bytes, a file each
+	assert.Nil(t, s.Flush("uid", "1", line))
+	assert.Nil(t, s.Flush("uid", "2", line))
+	assert.True(t, exists("spool-000001.ndjson"))
+	assert.True(t, exists("spool-000002.ndjson"))
+
+	t.Run("Nothing is deleted while the agent is behind", func(t *testing.T) {
+		err := s.Flush("uid", "3", line)
+		assert.IsType(t, &ErrRetryable{}, err)
+		assert.True(t, exists("spool-000001.ndjson"))
+
+		// Part way into the first file is not past it.
+		commit(`{"file": "spool-000001.ndjson", "offset": 5}`)
+		assert.IsType(t, &ErrRetryable{}, s.Flush("uid", "3", line))
+		assert.True(t, exists("spool-000001.ndjson"))
+	})
+
+	t.Run("Files the marker is past are deleted", func(t *testing.T) {
+		commit(`{"file": "spool-000001.ndjson", "offset": 11}`)
+		assert.Nil(t, s.Flush("uid", "3", line))
+		assert.False(t, exists("spool-000001.ndjson"))
+		assert.True(t, exists("spool-000002.ndjson"))
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "spool-000003.ndjson"))
+		assert.Nil(t, err)
+		assert.Equal(t, string(line), string(b))
+	})
+
+	t.Run("The file being written is kept", func(t *testing.T) {
+		commit(`{"file": "spool-000003.ndjson", "offset": 11}`)
+		assert.Nil(t, s.Flush("uid", "4", line))
+		assert.False(t, exists("spool-000002.ndjson"))
+		assert.True(t, exists("spool-000003.ndjson"))
+		assert.True(t, exists("spool-000004.ndjson"))
+	})
+
+	t.Run("A restart carries on after the last file", func(t *testing.T) {
+		r := &SpoolSink{}
+		assert.Nil(t, r.LoadConfig([]byte(`{"spool_dir": "`+dir+`", "spool_file_max_bytes": 11, "spool_max_files": 3}`)))
+		assert.Nil(t, r.Flush("uid", "5", line))
+		assert.True(t, exists("spool-000005.ndjson"))
+	})
+
+	t.Run("A directory is required", func(t *testing.T) {
+		assert.Error(t, (&SpoolSink{}).LoadConfig([]byte(`{}`)))
+	})
+}
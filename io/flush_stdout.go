@@ -0,0 +1,30 @@
+package io
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// StdoutSink prints each batch, as newline delimited JSON, for debugging a
+// configuration locally.
+type StdoutSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *StdoutSink) LoadConfig(_ json.RawMessage) error {
+	if s.w == nil {
+		s.w = os.Stdout
+	}
+	return nil
+}
+
+func (s *StdoutSink) Flush(uuid, ident string, d []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(d)
+	return err
+}
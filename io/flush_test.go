@@ -0,0 +1,201 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type connectorSink struct {
+	sync.Mutex
+	calls []string
+}
+
+func (c *connectorSink) record(call string) {
+	c.Lock()
+	defer c.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+func (c *connectorSink) LoadConfig(_ json.RawMessage) error { return nil }
+
+func (c *connectorSink) Flush(uuid, ident string, d []byte) error {
+	c.record("flush")
+	return nil
+}
+
+func (c *connectorSink) Connect() error {
+	c.record("connect")
+	return nil
+}
+
+func (c *connectorSink) Ping() error {
+	c.record("ping")
+	return nil
+}
+
+func (c *connectorSink) pings() int {
+	c.Lock()
+	defer c.Unlock()
+
+	n := 0
+	for _, call := range c.calls {
+		if call == "ping" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWarmUp(t *testing.T) {
+	getFlusher := func(t *testing.T, s *connectorSink, conf Config) Flusher {
+		sinkTypes["connector"] = func() Flusher { return s }
+		defer delete(sinkTypes, "connector")
+
+		conf.Sink = "connector"
+		f, err := GetFlusher(&conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	t.Run("Connect is invoked before any Flush", func(t *testing.T) {
+		s := &connectorSink{}
+		f := getFlusher(t, s, Config{SinkWarmUp: true})
+
+		f.Flush("uuid", "1", []byte("{}"))
+		assert.Equal(t, []string{"connect", "flush"}, s.calls)
+	})
+
+	t.Run("Skipped unless enabled", func(t *testing.T) {
+		s := &connectorSink{}
+		getFlusher(t, s, Config{})
+
+		assert.Empty(t, s.calls)
+	})
+
+	t.Run("Keep-alive pings only idle sinks, until closed", func(t *testing.T) {
+		s := &connectorSink{}
+		f := getFlusher(t, s, Config{SinkKeepAlive: 1})
+
+		// A flush puts the ping off for another interval.
+		time.Sleep(600 * time.Millisecond)
+		assert.Nil(t, f.Flush("uuid", "1", []byte("{}")))
+		time.Sleep(700 * time.Millisecond)
+		assert.Equal(t, 0, s.pings())
+
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, 1, s.pings())
+
+		// Closed through the retries, as the pipeline does on shutdown.
+		assert.Nil(t, WithRecovery(f, nil, &Config{}, nil).(Closer).Close())
+		time.Sleep(1200 * time.Millisecond)
+		assert.Equal(t, 1, s.pings())
+	})
+}
+
+func TestSinkTypes(t *testing.T) {
+	t.Run("Unknown sinks list the choices", func(t *testing.T) {
+		_, err := GetFlusher(&Config{Sink: "kafka"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown sink "kafka"`)
+		assert.Contains(t, err.Error(), "stdout")
+	})
+
+	t.Run("Registered sinks are picked by name", func(t *testing.T) {
+		c := &connectorSink{}
+		RegisterSink("test-connector", func() Flusher { return c })
+		defer delete(sinkTypes, "test-connector")
+
+		f, err := GetFlusher(&Config{Sink: "test-connector", Raw: []byte(`{}`)})
+		assert.NoError(t, err)
+		assert.NoError(t, f.Flush("uid", "1", []byte("{}\n")))
+		assert.Equal(t, []string{"flush"}, c.calls)
+		assert.Contains(t, SinkTypes(), "test-connector")
+
+		assert.Panics(t, func() { RegisterSink("file", func() Flusher { return &FileSink{} }) })
+	})
+
+	t.Run("Stdout prints every batch", func(t *testing.T) {
+		var out bytes.Buffer
+		s := &StdoutSink{w: &out}
+		assert.NoError(t, s.LoadConfig(nil))
+		assert.NoError(t, s.Flush("uid", "1", []byte("{\"id\":\"a\"}\n")))
+		assert.NoError(t, s.Flush("uid", "2", []byte("{\"id\":\"b\"}\n")))
+		assert.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n", out.String())
+	})
+}
+
+// recordConnectorSink is a connectorSink that reports per record results.
+type recordConnectorSink struct {
+	connectorSink
+}
+
+func (c *recordConnectorSink) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	c.record("flush records")
+	return make([]FlushResult, len(records)), nil
+}
+
+func TestGetFlusherKeepsConnector(t *testing.T) {
+	wrapped := &Config{
+		MaxConcurrentFlushes: 1,
+		Backpressure:         BackpressureConfig{Adaptive: true},
+	}
+
+	t.Run("Connectors connect through the wrappers", func(t *testing.T) {
+		s := &connectorSink{}
+		sinkTypes["connector"] = func() Flusher { return s }
+		defer delete(sinkTypes, "connector")
+
+		conf := *wrapped
+		conf.Sink = "connector"
+		f, err := GetFlusher(&conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := f.(*connectedFlusher)
+		assert.True(t, ok)
+
+		c := f.(Connector)
+		assert.Nil(t, c.Connect())
+		assert.Nil(t, c.Ping())
+		assert.Nil(t, f.Flush("uuid", "1", []byte("{}")))
+		assert.Equal(t, []string{"connect", "ping", "flush"}, s.calls)
+	})
+
+	t.Run("Per-record results are kept", func(t *testing.T) {
+		s := &recordConnectorSink{}
+		sinkTypes["connector"] = func() Flusher { return s }
+		defer delete(sinkTypes, "connector")
+
+		conf := *wrapped
+		conf.Sink = "connector"
+		f, err := GetFlusher(&conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := f.(Connector)
+		assert.True(t, ok)
+		assert.Nil(t, FlushRecords(f, "uuid", "1", [][]byte{[]byte("{}")}))
+		assert.Equal(t, []string{"flush records"}, s.calls)
+	})
+
+	t.Run("Other sinks are not made connectors", func(t *testing.T) {
+		conf := *wrapped
+		conf.Sink = "memory"
+		f, err := GetFlusher(&conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := f.(Connector)
+		assert.False(t, ok)
+	})
+}
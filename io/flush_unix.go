@@ -0,0 +1,148 @@
+package io
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	framingNDJSON         = "ndjson"
+	framingLengthPrefixed = "length-prefixed"
+
+	unixDialTimeout = 5 * time.Second
+)
+
+// UnixSink writes records to a UNIX domain socket that a local agent
+// listens on, either as NDJSON or each record prefixed with its length as a
+// 4 byte big-endian integer. While the agent is not listening, Flush fails
+// as retryable, so that the retries and the spill queue hold the batches;
+// the connection is redialled on the next Flush. Records are written one by
+// one, so that a batch cut short by the agent going away reports the
+// records written before that as flushed.
+type UnixSink struct {
+	Path    string `json:"unix_socket_path" validate:"required"`
+	Framing string `json:"unix_socket_framing"`
+
+	sync.Mutex
+	conn net.Conn
+}
+
+func (u *UnixSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, u); err != nil {
+		return err
+	}
+
+	switch u.Framing {
+	case "":
+		u.Framing = framingNDJSON
+	case framingNDJSON, framingLengthPrefixed:
+	default:
+		return fmt.Errorf("unknown unix_socket_framing %q", u.Framing)
+	}
+
+	return nil
+}
+
+func (u *UnixSink) Connect() error {
+	u.Lock()
+	defer u.Unlock()
+	return u.dial()
+}
+
+func (u *UnixSink) Ping() error {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.conn == nil {
+		return u.dial()
+	}
+	return nil
+}
+
+func (u *UnixSink) dial() error {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+
+	c, err := net.DialTimeout("unix", u.Path, unixDialTimeout)
+	if err != nil {
+		return &ErrRetryable{Err: err}
+	}
+
+	u.conn = c
+	return nil
+}
+
+func (u *UnixSink) frame(r []byte) []byte {
+	if u.Framing != framingLengthPrefixed {
+		return append(append(make([]byte, 0, len(r)+1), r...), '\n')
+	}
+
+	b := make([]byte, 4+len(r))
+	binary.BigEndian.PutUint32(b, uint32(len(r)))
+	copy(b[4:], r)
+	return b
+}
+
+func (u *UnixSink) Flush(uuid, ident string, d []byte) error {
+	results, err := u.FlushRecords(uuid, ident, splitRecords(d))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// FlushRecords writes the records in order, and fails the rest of them
+// once a write fails.
+func (u *UnixSink) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	results := make([]FlushResult, len(records))
+	for ix, r := range records {
+		if err := u.write(ident, u.frame(r)); err != nil {
+			for ; ix < len(records); ix++ {
+				results[ix].Err = err
+			}
+			break
+		}
+	}
+
+	return results, nil
+}
+
+func (u *UnixSink) write(ident string, b []byte) error {
+	// A connection to an agent that has since restarted fails on write,
+	// so that is retried once on a fresh connection.
+	for attempt := 0; ; attempt++ {
+		if u.conn == nil {
+			if err := u.dial(); err != nil {
+				return err
+			}
+		}
+
+		_, err := u.conn.Write(b)
+		if err == nil {
+			return nil
+		}
+
+		u.conn.Close()
+		u.conn = nil
+		if attempt > 0 {
+			return &ErrRetryable{Err: err}
+		}
+		log.Printf("Writing %v to %v: %v, reconnecting", ident, u.Path, err)
+	}
+}
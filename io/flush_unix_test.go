@@ -0,0 +1,150 @@
+//go:build !windows
+// +build !windows
+
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// agent accepts a single connection on path and hands over what it reads.
+type agent struct {
+	l    net.Listener
+	conn chan net.Conn
+}
+
+func newAgent(t *testing.T, path string) *agent {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &agent{l: l, conn: make(chan net.Conn, 1)}
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			a.conn <- c
+		}
+	}()
+	return a
+}
+
+func (a *agent) accepted(t *testing.T) net.Conn {
+	select {
+	case c := <-a.conn:
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("No connection from the sink")
+	}
+	return nil
+}
+
+func (a *agent) stop(c net.Conn) {
+	c.Close()
+	a.l.Close()
+}
+
+func TestUnixSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "agent.sock")
+
+	t.Run("Agent not listening", func(t *testing.T) {
+		u := &UnixSink{}
+		assert.Nil(t, u.LoadConfig([]byte(`{"unix_socket_path": "`+path+`"}`)))
+
+		var retryable *ErrRetryable
+		assert.True(t, errors.As(u.Flush("uid", "1", []byte("{}\n")), &retryable))
+	})
+
+	t.Run("NDJSON survives the agent restarting", func(t *testing.T) {
+		u := &UnixSink{}
+		assert.Nil(t, u.LoadConfig([]byte(`{"unix_socket_path": "`+path+`"}`)))
+
+		a := newAgent(t, path)
+		assert.Nil(t, u.Flush("uid", "1", []byte("{\"id\":\"a\"}\n")))
+
+		c := a.accepted(t)
+		l, err := bufio.NewReader(c).ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "{\"id\":\"a\"}\n", l)
+
+		a.stop(c)
+		a = newAgent(t, path)
+		assert.Nil(t, u.Flush("uid", "2", []byte("{\"id\":\"b\"}\n")))
+
+		c = a.accepted(t)
+		defer a.stop(c)
+		l, err = bufio.NewReader(c).ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "{\"id\":\"b\"}\n", l)
+	})
+
+	t.Run("Length-prefixed", func(t *testing.T) {
+		u := &UnixSink{}
+		assert.Nil(t, u.LoadConfig([]byte(`{
+			"unix_socket_path": "`+path+`",
+			"unix_socket_framing": "length-prefixed"
+		}`)))
+
+		a := newAgent(t, path)
+		batch := []byte("{\"id\":\"a\"}\n{\"id\":\"b\"}\n")
+		assert.Nil(t, u.Flush("uid", "1", batch))
+
+		c := a.accepted(t)
+		defer a.stop(c)
+
+		// A frame per record.
+		for _, want := range []string{`{"id":"a"}`, `{"id":"b"}`} {
+			var n uint32
+			assert.Nil(t, binary.Read(c, binary.BigEndian, &n))
+			got := make([]byte, n)
+			_, err := io.ReadFull(c, got)
+			assert.Nil(t, err)
+			assert.Equal(t, want, string(got))
+		}
+	})
+
+	t.Run("Reports per record", func(t *testing.T) {
+		u := &UnixSink{}
+		assert.Nil(t, u.LoadConfig([]byte(`{"unix_socket_path": "`+path+`"}`)))
+
+		records := [][]byte{[]byte(`{"id":"a"}`), []byte(`{"id":"b"}`)}
+		results, err := u.FlushRecords("uid", "1", records)
+		assert.Nil(t, err)
+		assert.Len(t, results, 2)
+		for _, r := range results {
+			assert.NotNil(t, r.Err)
+		}
+
+		a := newAgent(t, path)
+		results, err = u.FlushRecords("uid", "2", records)
+		assert.Nil(t, err)
+		assert.Equal(t, []FlushResult{{}, {}}, results)
+
+		c := a.accepted(t)
+		defer a.stop(c)
+		r := bufio.NewReader(c)
+		for _, want := range records {
+			l, err := r.ReadString('\n')
+			assert.Nil(t, err)
+			assert.Equal(t, string(want)+"\n", l)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package io
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// VectorSink posts each batch to a Vector http source (or http_server in
+// newer releases), as newline delimited JSON. Configure the source with
+//
+//	decoding.codec = "json"
+//	framing.method = "newline_delimited"
+//
+// so that every line becomes an event with the fields of the L9Event.
+type VectorSink struct {
+	Address string       `json:"vector_address" validate:"required"`
+	TLS     *TLSConfig   `json:"vector_tls"`
+	HTTP    *HTTPOptions `json:"http_sink"`
+
+	// The shared "tls" block, for when vector_tls is not given.
+	SharedTLS *TLSConfig `json:"tls"`
+
+	client        *http.Client
+	transforms    []RequestTransform
+	schemaVersion string
+}
+
+func (v *VectorSink) LoadConfig(b json.RawMessage) error {
+	if err := LoadConfig(b, v); err != nil {
+		return err
+	}
+
+	t := v.TLS
+	if t == nil {
+		t = v.SharedTLS
+	}
+
+	c, err := newHTTPClient(t, v.HTTP)
+	if err != nil {
+		return err
+	}
+
+	v.client = c
+	v.transforms, err = v.HTTP.transforms()
+	return err
+}
+
+func (v *VectorSink) setSchemaVersion(s string) {
+	v.schemaVersion = s
+}
+
+func (v *VectorSink) Flush(uuid, ident string, d []byte) error {
+	headers := map[string]string{
+		"X-K8stream-Uid":   uuid,
+		"X-K8stream-Batch": ident,
+	}
+	if v.schemaVersion != "" {
+		headers["X-K8stream-Schema-Version"] = v.schemaVersion
+	}
+
+	return post(v.client, v.transforms, v.Address, "application/x-ndjson", d, headers)
+}
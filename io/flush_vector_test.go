@@ -0,0 +1,90 @@
+package io
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVectorSink(t *testing.T) {
+	var lines []string
+	status := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "uid", r.Header.Get("X-K8stream-Uid"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+		w.WriteHeader(status)
+	})
+
+	t.Run("Events are accepted", func(t *testing.T) {
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+
+		v := &VectorSink{}
+		assert.Nil(t, v.LoadConfig([]byte(`{"vector_address": "`+ts.URL+`"}`)))
+		assert.Nil(t, v.Flush("uid", "1", []byte("{\"id\":\"a\"}\n{\"id\":\"b\"}\n")))
+		assert.Equal(t, []string{`{"id":"a"}`, `{"id":"b"}`}, lines)
+	})
+
+	t.Run("The schema version is sent", func(t *testing.T) {
+		var version string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version = r.Header.Get("X-K8stream-Schema-Version")
+		}))
+		defer ts.Close()
+
+		f, err := GetFlusher(&Config{
+			Sink:          "vector",
+			Raw:           []byte(`{"vector_address": "` + ts.URL + `"}`),
+			SchemaVersion: "2",
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, f.Flush("uid", "1", []byte("{}\n")))
+		assert.Equal(t, "2", version)
+	})
+
+	t.Run("Over TLS", func(t *testing.T) {
+		ts := httptest.NewTLSServer(handler)
+		defer ts.Close()
+
+		ca, err := ioutil.TempFile("", "vector-ca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(ca.Name())
+		pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+		ca.Close()
+
+		v := &VectorSink{}
+		assert.Nil(t, v.LoadConfig([]byte(`{
+			"vector_address": "`+ts.URL+`",
+			"vector_tls": {"ca_file": "`+ca.Name()+`"}
+		}`)))
+		assert.Nil(t, v.Flush("uid", "2", []byte("{\"id\":\"c\"}\n")))
+		assert.Equal(t, []string{`{"id":"c"}`}, lines)
+	})
+
+	t.Run("Failures are retryable", func(t *testing.T) {
+		ts := httptest.NewServer(handler)
+		status = http.StatusServiceUnavailable
+
+		v := &VectorSink{}
+		assert.Nil(t, v.LoadConfig([]byte(`{"vector_address": "`+ts.URL+`"}`)))
+
+		var retryable *ErrRetryable
+		assert.True(t, errors.As(v.Flush("uid", "3", []byte("{}\n")), &retryable))
+
+		// Vector is down altogether.
+		ts.Close()
+		assert.True(t, errors.As(v.Flush("uid", "4", []byte("{}\n")), &retryable))
+	})
+}
@@ -14,7 +14,7 @@ const (
 	defaultHeartbeatTimeout  = 300
 )
 
-func StartHeartbeat(version, uid, hook string, interval, timeout int) error {
+func StartHeartbeat(version, uid, hook string, interval, timeout int, t *TLSConfig) error {
 	if hook == "" {
 		return nil
 	}
@@ -30,6 +30,12 @@ func StartHeartbeat(version, uid, hook string, interval, timeout int) error {
 		timeout = defaultHeartbeatTimeout
 	}
 
+	client, err := newHTTPClient(t, nil)
+	if err != nil {
+		return err
+	}
+	client.Timeout = time.Duration(timeout) * time.Millisecond
+
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
 
 	go func() {
@@ -40,9 +46,6 @@ func StartHeartbeat(version, uid, hook string, interval, timeout int) error {
 			q.Set("version", version)
 			u.RawQuery = q.Encode()
 
-			client := http.Client{
-				Timeout: time.Duration(timeout) * time.Millisecond,
-			}
 			resp, err := client.Get(u.String())
 			if err != nil {
 				log.Print("error while sending heartbeat: %w", err)
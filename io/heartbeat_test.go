@@ -36,7 +36,7 @@ func TestStartHeartbeat(t *testing.T) {
 	version := "0.1"
 
 	t.Run("Server should receive heartbeat in an Interval", func(t *testing.T) {
-		assert.Nil(t, StartHeartbeat(version, uid, s.URL, interval, 0))
+		assert.Nil(t, StartHeartbeat(version, uid, s.URL, interval, 0, nil))
 
 		select {
 		case received := <-uids:
@@ -47,10 +47,10 @@ func TestStartHeartbeat(t *testing.T) {
 	})
 
 	t.Run("upgrade should send SIGQUIT to main process", func(t *testing.T) {
-		sigCh := make(chan os.Signal)
+		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGQUIT)
 
-		if err := StartHeartbeat(version, upgradeUid, s.URL, interval, 0); err != nil {
+		if err := StartHeartbeat(version, upgradeUid, s.URL, interval, 0, nil); err != nil {
 			t.Fatal(err)
 		}
 
@@ -0,0 +1,244 @@
+package io
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	fmt "fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+// TLSConfig is the TLS setup of a sink talking HTTPS. The "tls" block of
+// the config applies to every network sink and to the heartbeat.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	// Lowest version accepted, e.g. "1.2". Go's default when empty.
+	MinVersion string `json:"min_version"`
+
+	// Suites offered, by their Go names, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Go's default when empty.
+	// TLS 1.3 suites are not configurable.
+	CipherSuites []string `json:"cipher_suites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+func (t *TLSConfig) config() (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", t.MinVersion)
+		}
+		c.MinVersion = v
+	}
+
+	for _, name := range t.CipherSuites {
+		s, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, s)
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", t.CAFile)
+		}
+		c.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// RequestTransform changes a request about to be sent, say to sign it. It
+// gets the body, which by then the request can no longer give.
+type RequestTransform func(req *http.Request, body []byte) error
+
+// HTTPOptions are the options of the HTTP based sinks, under "http_sink".
+type HTTPOptions struct {
+	SigV4 *SigV4Config `json:"sigv4"`
+
+	// Proxy that requests go through, with the credentials to it, if any,
+	// as the URL's user info. HTTP_PROXY and HTTPS_PROXY when empty.
+	ProxyURL string `json:"proxy_url"`
+
+	// Hosts reached without the proxy, in the format of NO_PROXY, which
+	// applies when empty.
+	NoProxy string `json:"no_proxy"`
+}
+
+// proxy is the proxy function of the transport of the sink.
+func (o *HTTPOptions) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if o == nil || o.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	if _, err := url.Parse(o.ProxyURL); err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+
+	noProxy := o.NoProxy
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+	}
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+
+	fn := (&httpproxy.Config{
+		HTTPProxy:  o.ProxyURL,
+		HTTPSProxy: o.ProxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}, nil
+}
+
+// transforms returns the transforms the options ask for, in order.
+func (o *HTTPOptions) transforms() ([]RequestTransform, error) {
+	var t []RequestTransform
+	if o == nil {
+		return t, nil
+	}
+
+	if o.SigV4 != nil {
+		if err := Validator().Struct(o.SigV4); err != nil {
+			return nil, err
+		}
+
+		sign, err := o.SigV4.transform()
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, sign)
+	}
+
+	return t, nil
+}
+
+// newHTTPClient is the client of the HTTP based sinks. Without TLS options
+// the system roots are trusted.
+func newHTTPClient(t *TLSConfig, o *HTTPOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy, err := o.proxy()
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	if t != nil {
+		c, err := t.config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = c
+	}
+
+	return &http.Client{Transport: transport, Timeout: defaultHTTPTimeout}, nil
+}
+
+// post sends body, through the transforms, wrapping failures for the
+// retry logic.
+func post(
+	c *http.Client, transforms []RequestTransform,
+	url, contentType string, body []byte, headers map[string]string,
+) error {
+	_, err := postForResponse(c, transforms, url, contentType, body, headers)
+	return err
+}
+
+// postForResponse is post for the sinks that read the response body.
+func postForResponse(
+	c *http.Client, transforms []RequestTransform,
+	url, contentType string, body []byte, headers map[string]string,
+) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &ErrPermanent{Err: err}
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	for _, t := range transforms {
+		if err := t(req, body); err != nil {
+			return nil, &ErrRetryable{Err: err}
+		}
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, &ErrRetryable{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, classifyStatus(
+			resp.StatusCode, time.Duration(retryAfter)*time.Second,
+			fmt.Errorf("POST %s: %s", url, resp.Status),
+		)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ErrRetryable{Err: err}
+	}
+	return b, nil
+}
@@ -0,0 +1,82 @@
+package io
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfig(t *testing.T) {
+	c, err := (&TLSConfig{
+		MinVersion: "1.2",
+		CipherSuites: []string{
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		},
+	}).config()
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), c.MinVersion)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	}, c.CipherSuites)
+
+	t.Run("Unknown names are rejected", func(t *testing.T) {
+		_, err := (&TLSConfig{MinVersion: "1.4"}).config()
+		assert.NotNil(t, err)
+
+		_, err = (&TLSConfig{CipherSuites: []string{"TLS_NULL"}}).config()
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Sinks take the shared block", func(t *testing.T) {
+		v := &VectorSink{}
+		assert.Nil(t, v.LoadConfig([]byte(
+			`{"vector_address": "https://vector:8080", "tls": {"min_version": "1.3"}}`,
+		)))
+		tc := v.client.Transport.(*http.Transport).TLSClientConfig
+		assert.Equal(t, uint16(tls.VersionTLS13), tc.MinVersion)
+
+		assert.NotNil(t, v.LoadConfig([]byte(
+			`{"vector_address": "https://vector:8080", "tls": {"min_version": "ssl3"}}`,
+		)))
+	})
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var proxied *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r
+	}))
+	defer proxy.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("k8stream", "secret")
+
+	v := &VectorSink{}
+	assert.Nil(t, v.LoadConfig([]byte(fmt.Sprintf(
+		`{"vector_address": "http://vector.example:8080", "http_sink": {"proxy_url": %q, "no_proxy": "direct.example"}}`,
+		u.String(),
+	))))
+
+	t.Run("Sink requests go through the proxy", func(t *testing.T) {
+		assert.Nil(t, v.Flush("uid", "batch-1", []byte(`{"id": "1"}`)))
+		if assert.NotNil(t, proxied) {
+			assert.Equal(t, "vector.example:8080", proxied.Host)
+			assert.Equal(t, "http://vector.example:8080/", proxied.URL.String())
+			assert.Equal(t, "Basic azhzdHJlYW06c2VjcmV0", proxied.Header.Get("Proxy-Authorization"))
+		}
+	})
+
+	t.Run("No proxy hosts are reached directly", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "http://direct.example/", nil)
+		p, err := v.client.Transport.(*http.Transport).Proxy(req)
+		assert.Nil(t, err)
+		assert.Nil(t, p)
+	})
+}
@@ -0,0 +1,79 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	fmt "fmt"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// Values of an event available to the path templates of the file and s3
+// sinks, like "{{.Namespace}}/{{.ReferenceKind}}/{{date}}".
+type recordPathData struct {
+	Namespace     string `json:"namespace"`
+	ReferenceKind string `json:"reference_kind"`
+	Reason        string `json:"reason"`
+	Type          string `json:"type"`
+	Component     string `json:"component"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// pathTemplate partitions a batch by the path that each of its records
+// renders to. {{date}} and {{hour}} are those of the flush, in loc, UTC
+// unless the sink is given output.timezone.
+type pathTemplate struct {
+	t   *template.Template
+	now func() time.Time
+	loc *time.Location
+}
+
+func newPathTemplate(name, text string) (*pathTemplate, error) {
+	t, err := template.New(name).Funcs(pathFuncs(time.Time{})).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v: %w", name, err)
+	}
+
+	return &pathTemplate{t: t, now: time.Now, loc: time.UTC}, nil
+}
+
+func pathFuncs(now time.Time) template.FuncMap {
+	return template.FuncMap{
+		"date": func() string { return now.Format("2006-01-02") },
+		"hour": func() string { return now.Format("15") },
+	}
+}
+
+// partition groups the records of d by their rendered path, and returns
+// the paths in the order of their first record. A path cannot climb out of
+// the directory it is joined to.
+func (p *pathTemplate) partition(d []byte) ([]string, map[string][][]byte, error) {
+	t, err := p.t.Clone()
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Funcs(pathFuncs(p.now().In(p.loc)))
+
+	paths := []string{}
+	groups := map[string][][]byte{}
+	for _, r := range splitRecords(d) {
+		var data recordPathData
+		if err := json.Unmarshal(r, &data); err != nil {
+			return nil, nil, &ErrPermanent{Err: fmt.Errorf("rendering %v: %w", t.Name(), err)}
+		}
+
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return nil, nil, &ErrPermanent{Err: fmt.Errorf("rendering %v: %w", t.Name(), err)}
+		}
+
+		path := filepath.Join("/", b.String())[1:]
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], r)
+	}
+
+	return paths, groups, nil
+}
@@ -0,0 +1,98 @@
+package io
+
+import (
+	"bytes"
+)
+
+// FlushResult is the outcome of flushing a single record.
+type FlushResult struct {
+	Err error
+}
+
+// RecordFlusher is implemented by sinks that take a batch as individual
+// records and can report which of them failed, so that only those are
+// retried. Results are in the same order as the records.
+// A sink may instead return a single result that applies to all records.
+type RecordFlusher interface {
+	FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error)
+}
+
+// FlushRecords flushes records through f, as individual records if the sink
+// supports it, or else as one newline delimited payload.
+func FlushRecords(f Flusher, uuid, ident string, records [][]byte) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	rf, ok := f.(RecordFlusher)
+	if !ok {
+		return f.Flush(uuid, ident, joinRecords(records))
+	}
+
+	results, err := rf.FlushRecords(uuid, ident, records)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+
+	return nil
+}
+
+// splitRecords is the inverse of joinRecords.
+func splitRecords(d []byte) [][]byte {
+	records := [][]byte{}
+	for _, r := range bytes.Split(d, []byte{'\n'}) {
+		if len(r) > 0 {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func joinRecords(records [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write(r)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// failedRecords picks the records that failed, split into those worth a retry
+// and those that failed permanently. err is the last failure seen.
+func failedRecords(records [][]byte, results []FlushResult, err error) (retry, permanent [][]byte, last error) {
+	if err != nil {
+		if isPermanent(err) {
+			return nil, records, err
+		}
+		return records, nil, err
+	}
+
+	// A single result stands for the whole batch.
+	if len(results) == 1 && len(records) > 1 {
+		results = append(make([]FlushResult, 0, len(records)), results...)
+		for len(results) < len(records) {
+			results = append(results, results[0])
+		}
+	}
+
+	for ix, r := range results {
+		if ix >= len(records) || r.Err == nil {
+			continue
+		}
+
+		last = r.Err
+		if isPermanent(r.Err) {
+			permanent = append(permanent, records[ix])
+		} else {
+			retry = append(retry, records[ix])
+		}
+	}
+
+	return retry, permanent, last
+}
@@ -0,0 +1,79 @@
+package io
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReplayErrors are the failures of a replay, one per batch.
+type ReplayErrors []error
+
+func (e ReplayErrors) Error() string {
+	msgs := make([]string, len(e))
+	for ix, err := range e {
+		msgs[ix] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ReplayDeadLetter re-flushes the batches dead-lettered into dir through f,
+// oldest first, and removes each one that f takes. f is expected to be the
+// bare sink: a batch that fails again is left in place for the next replay
+// instead of being dead-lettered, or retried, once more.
+// A failed batch does not stop the replay. It returns the number of batches
+// replayed and failed, and the failures as ReplayErrors.
+func ReplayDeadLetter(dir string, f Flusher) (replayed, failed int, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	var errs ReplayErrors
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".log" {
+			continue
+		}
+
+		// Names are <uuid>_<ident>.log, as written by the FileSink.
+		name := strings.TrimSuffix(fi.Name(), ".log")
+		ix := strings.LastIndex(name, "_")
+		if ix < 0 {
+			log.Println("Skipping dead-letter file", fi.Name())
+			continue
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			failed++
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := f.Flush(name[:ix], name[ix+1:], d); err != nil {
+			failed++
+			errs = append(errs, fmt.Errorf("replaying %v: %w", fi.Name(), err))
+			continue
+		}
+
+		// Flushed all the same, though it will be replayed again.
+		replayed++
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return replayed, failed, errs
+	}
+	return replayed, failed, nil
+}
@@ -0,0 +1,73 @@
+package io
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rejectingSink fails the batch with one ident, and takes the rest.
+type rejectingSink struct {
+	*MemSink
+	reject string
+}
+
+func (r *rejectingSink) Flush(uuid, ident string, d []byte) error {
+	if ident == r.reject {
+		return errors.New("rejected " + ident)
+	}
+	return r.MemSink.Flush(uuid, ident, d)
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl := &FileSink{Dir: dir}
+	for _, ident := range []string{"1", "2", "3"} {
+		assert.Nil(t, dl.Flush("k8stream-uid", ident, []byte(`{"id":"`+ident+`"}`)))
+		// Replayed in the order of their modification times.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("Failed batches are kept, and the rest replayed", func(t *testing.T) {
+		sink := &rejectingSink{newTestMemSink(), "2"}
+		n, failed, err := ReplayDeadLetter(dir, sink)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, 1, failed)
+
+		errs, ok := err.(ReplayErrors)
+		assert.True(t, ok)
+		assert.Equal(t, 1, len(errs))
+		assert.Contains(t, err.Error(), "k8stream-uid_2.log")
+
+		assert.Equal(t, map[string][]byte{
+			"1": []byte(`{"id":"1"}`),
+			"3": []byte(`{"id":"3"}`),
+		}, sink.Records)
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Equal(t, 1, len(files))
+	})
+
+	t.Run("Replayed batches are removed", func(t *testing.T) {
+		sink := newTestMemSink()
+		n, failed, err := ReplayDeadLetter(dir, sink)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, 0, failed)
+
+		assert.Equal(t, map[string][]byte{"2": []byte(`{"id":"2"}`)}, sink.Records)
+		assert.Equal(t, "k8stream-uid", sink.uuid)
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Equal(t, 0, len(files))
+	})
+}
@@ -0,0 +1,192 @@
+package io
+
+import (
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultRetryDelay = time.Second
+
+// RetryBudget caps the number of retries made in a minute, across every sink
+// that shares it, so a misbehaving sink cannot keep the pipeline retrying
+// for an unbounded time during a partial outage.
+type RetryBudget struct {
+	sync.Mutex
+	perMinute int
+	window    time.Time
+	used      int
+	now       func() time.Time
+}
+
+// NewRetryBudget returns a budget of perMinute retries. A budget of 0
+// places no limit on retries.
+func NewRetryBudget(perMinute int) *RetryBudget {
+	return &RetryBudget{perMinute: perMinute, now: time.Now}
+}
+
+// Allow takes one retry out of the budget, if any is left.
+func (b *RetryBudget) Allow() bool {
+	if b == nil || b.perMinute <= 0 {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+	if now.Sub(b.window) >= time.Minute {
+		b.window = now
+		b.used = 0
+	}
+
+	if b.used >= b.perMinute {
+		return false
+	}
+
+	b.used++
+	return true
+}
+
+// retryFlusher retries a failed Flush up to attempts times, while the shared
+// budget allows, backing off exponentially from initial to max. Batches that
+// still fail, or fail permanently, go to the dead-letter sink, when there is
+// one, and are then failed with ErrDeadLettered.
+type retryFlusher struct {
+	Flusher
+	deadLetter Flusher
+	budget     *RetryBudget
+	attempts   int
+	initial    time.Duration
+	max        time.Duration
+	sleep      func(time.Duration)
+}
+
+// WithRetry wraps a sink with retries as configured by RetryAttempts,
+// RetryInitial and RetryMax.
+func WithRetry(f, deadLetter Flusher, conf *Config, budget *RetryBudget) Flusher {
+	initial := time.Duration(conf.RetryInitial) * time.Millisecond
+	if initial <= 0 {
+		initial = defaultRetryDelay
+	}
+
+	max := time.Duration(conf.RetryMax) * time.Millisecond
+	if max < initial {
+		max = initial
+	}
+
+	return &retryFlusher{
+		Flusher:    f,
+		deadLetter: deadLetter,
+		budget:     budget,
+		attempts:   conf.RetryAttempts,
+		initial:    initial,
+		max:        max,
+		sleep:      time.Sleep,
+	}
+}
+
+// backoff is the pause before retry attempt, counting from 1.
+func (r *retryFlusher) backoff(attempt int) time.Duration {
+	d := r.initial
+	for i := 1; i < attempt && d < r.max; i++ {
+		d *= 2
+	}
+
+	if d > r.max {
+		return r.max
+	}
+	return d
+}
+
+func (r *retryFlusher) LoadConfig(b json.RawMessage) error {
+	return r.Flusher.LoadConfig(b)
+}
+
+// Close closes the sink, when it is a Closer.
+func (r *retryFlusher) Close() error {
+	if c, ok := r.Flusher.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (r *retryFlusher) Flush(uuid, ident string, d []byte) error {
+	err := r.Flusher.Flush(uuid, ident, d)
+	for attempt := 1; err != nil && attempt <= r.attempts; attempt++ {
+		if isPermanent(err) {
+			break
+		}
+
+		if !r.budget.Allow() {
+			log.Println("Retry budget exhausted, not retrying", ident)
+			break
+		}
+
+		log.Printf("Flush of %v failed, retry %v: %v", ident, attempt, err)
+		r.sleep(retryDelay(err, r.backoff(attempt)))
+		err = r.Flusher.Flush(uuid, ident, d)
+	}
+
+	if err == nil || r.deadLetter == nil {
+		return err
+	}
+
+	log.Printf("Dead-lettering %v: %v", ident, err)
+	return deadLettered(r.deadLetter.Flush(uuid, ident, d), err)
+}
+
+// deadLettered is the outcome of a batch that failed with err, once the
+// dead-letter sink returned dlErr for it.
+func deadLettered(dlErr, err error) error {
+	if dlErr != nil {
+		return dlErr
+	}
+	return &ErrDeadLettered{Err: err}
+}
+
+// FlushRecords retries only the records that the sink reports as failed,
+// each retry as a new batch. Sinks that cannot report per record get the
+// whole batch retried, as with Flush.
+func (r *retryFlusher) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	rf, ok := r.Flusher.(RecordFlusher)
+	if !ok {
+		return []FlushResult{{Err: r.Flush(uuid, ident, joinRecords(records))}}, nil
+	}
+
+	results, err := rf.FlushRecords(uuid, ident, records)
+	retry, dead, last := failedRecords(records, results, err)
+	for attempt := 1; len(retry) > 0 && attempt <= r.attempts; attempt++ {
+		if !r.budget.Allow() {
+			log.Println("Retry budget exhausted, not retrying", ident)
+			break
+		}
+
+		retryIdent := fmt.Sprintf("%v-retry%v", ident, attempt)
+		log.Printf(
+			"%v of %v records of %v failed, retry %v: %v",
+			len(retry), len(records), ident, attempt, last,
+		)
+		r.sleep(retryDelay(last, r.backoff(attempt)))
+
+		results, err := rf.FlushRecords(uuid, retryIdent, retry)
+		var permanent [][]byte
+		retry, permanent, last = failedRecords(retry, results, err)
+		dead = append(dead, permanent...)
+	}
+
+	dead = append(dead, retry...)
+	if len(dead) == 0 {
+		return []FlushResult{{}}, nil
+	}
+
+	if r.deadLetter == nil {
+		return []FlushResult{{Err: last}}, nil
+	}
+
+	log.Printf("Dead-lettering %v records of %v: %v", len(dead), ident, last)
+	err = r.deadLetter.Flush(uuid, ident, joinRecords(dead))
+	return []FlushResult{{Err: deadLettered(err, last)}}, nil
+}
@@ -0,0 +1,211 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSink struct {
+	calls int
+	fails int
+	err   error
+}
+
+func (f *failingSink) LoadConfig(_ json.RawMessage) error { return nil }
+
+func (f *failingSink) Flush(uuid, ident string, d []byte) error {
+	f.calls++
+	if f.fails < 0 || f.calls <= f.fails {
+		if f.err != nil {
+			return f.err
+		}
+		return errors.New("sink unavailable")
+	}
+	return nil
+}
+
+func newTestMemSink() *MemSink {
+	return &MemSink{Records: map[string][]byte{}, OnFetch: func(string) {}}
+}
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Now()
+	b := NewRetryBudget(2)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	t.Run("Refills on the next window", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		assert.True(t, b.Allow())
+	})
+
+	t.Run("Zero budget is unlimited", func(t *testing.T) {
+		u := NewRetryBudget(0)
+		for ix := 0; ix < 100; ix++ {
+			assert.True(t, u.Allow())
+		}
+	})
+}
+
+func TestRetryFlusher(t *testing.T) {
+	newRetry := func(s Flusher, dl Flusher, budget *RetryBudget) *retryFlusher {
+		r := WithRetry(s, dl, &Config{RetryAttempts: 3}, budget).(*retryFlusher)
+		r.sleep = func(time.Duration) {}
+		return r
+	}
+
+	t.Run("Retries until the sink recovers", func(t *testing.T) {
+		s, dl := &failingSink{fails: 2}, newTestMemSink()
+		assert.Nil(t, newRetry(s, dl, NewRetryBudget(0)).Flush("uid", "1", []byte("x")))
+		assert.Equal(t, 3, s.calls)
+		assert.Empty(t, dl.Records)
+	})
+
+	t.Run("Exhausted budget goes straight to dead-letter", func(t *testing.T) {
+		budget := NewRetryBudget(2)
+		s, other, dl := &failingSink{fails: -1}, &failingSink{fails: -1}, newTestMemSink()
+
+		// The first batch uses up the budget: 1 flush and 2 retries.
+		assert.IsType(t, &ErrDeadLettered{}, newRetry(s, dl, budget).Flush("uid", "1", []byte("a")))
+		assert.Equal(t, 3, s.calls)
+
+		// Another sink sharing the budget does not get to retry.
+		assert.IsType(t, &ErrDeadLettered{}, newRetry(other, dl, budget).Flush("uid", "2", []byte("b")))
+		assert.Equal(t, 1, other.calls)
+
+		assert.Equal(t, []byte("a"), dl.Records["1"])
+		assert.Equal(t, []byte("b"), dl.Records["2"])
+	})
+
+	t.Run("Error surfaces without a dead-letter sink", func(t *testing.T) {
+		s := &failingSink{fails: -1}
+		assert.NotNil(t, newRetry(s, nil, NewRetryBudget(0)).Flush("uid", "1", nil))
+		assert.Equal(t, 4, s.calls)
+	})
+}
+
+func TestRetryErrorTypes(t *testing.T) {
+	sleeps := []time.Duration{}
+	newRetry := func(s Flusher, dl Flusher) *retryFlusher {
+		r := WithRetry(s, dl, &Config{RetryAttempts: 3}, NewRetryBudget(0)).(*retryFlusher)
+		r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+		return r
+	}
+
+	cause := errors.New("boom")
+
+	t.Run("Permanent is dead-lettered immediately", func(t *testing.T) {
+		sleeps = sleeps[:0]
+		s := &failingSink{fails: -1, err: &ErrPermanent{Err: cause}}
+		dl := newTestMemSink()
+
+		err := newRetry(s, dl).Flush("uid", "1", []byte("a"))
+		assert.IsType(t, &ErrDeadLettered{}, err)
+		assert.True(t, errors.Is(err, cause))
+		assert.Equal(t, 1, s.calls)
+		assert.Empty(t, sleeps)
+		assert.Equal(t, []byte("a"), dl.Records["1"])
+	})
+
+	t.Run("Throttled waits for RetryAfter", func(t *testing.T) {
+		sleeps = sleeps[:0]
+		s := &failingSink{fails: 1, err: &ErrThrottled{Err: cause, RetryAfter: 7 * time.Second}}
+		dl := newTestMemSink()
+
+		assert.Nil(t, newRetry(s, dl).Flush("uid", "1", []byte("a")))
+		assert.Equal(t, 2, s.calls)
+		assert.Equal(t, []time.Duration{7 * time.Second}, sleeps)
+		assert.Empty(t, dl.Records)
+	})
+
+	t.Run("Retryable is retried with the default delay", func(t *testing.T) {
+		sleeps = sleeps[:0]
+		s := &failingSink{fails: 2, err: &ErrRetryable{Err: cause}}
+
+		assert.Nil(t, newRetry(s, nil).Flush("uid", "1", []byte("a")))
+		assert.Equal(t, 3, s.calls)
+		assert.Equal(t, []time.Duration{defaultRetryDelay, defaultRetryDelay}, sleeps)
+	})
+
+	t.Run("Backs off exponentially up to the max", func(t *testing.T) {
+		sleeps = sleeps[:0]
+		s := &failingSink{fails: -1}
+
+		r := WithRetry(s, nil, &Config{RetryAttempts: 5, RetryInitial: 100, RetryMax: 300}, NewRetryBudget(0)).(*retryFlusher)
+		r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+		assert.NotNil(t, r.Flush("uid", "1", []byte("a")))
+		assert.Equal(t, []time.Duration{
+			100 * time.Millisecond, 200 * time.Millisecond,
+			300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond,
+		}, sleeps)
+	})
+
+	t.Run("HTTP status classification", func(t *testing.T) {
+		var th *ErrThrottled
+		var rt *ErrRetryable
+		var pm *ErrPermanent
+		assert.True(t, errors.As(classifyStatus(429, time.Second, cause), &th))
+		assert.Equal(t, time.Second, th.RetryAfter)
+		assert.True(t, errors.As(classifyStatus(503, 0, cause), &rt))
+		assert.True(t, errors.As(classifyStatus(400, 0, cause), &pm))
+	})
+}
+
+// halfSink fails every other record the first time it sees it.
+type halfSink struct {
+	batches [][]string
+	seen    map[string]bool
+}
+
+func (h *halfSink) LoadConfig(_ json.RawMessage) error { return nil }
+
+func (h *halfSink) Flush(uuid, ident string, d []byte) error { return nil }
+
+func (h *halfSink) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	batch := []string{}
+	results := make([]FlushResult, len(records))
+	for ix, r := range records {
+		batch = append(batch, string(r))
+		if !h.seen[string(r)] && ix%2 == 1 {
+			results[ix].Err = &ErrRetryable{Err: errors.New("rejected")}
+		}
+		h.seen[string(r)] = true
+	}
+
+	h.batches = append(h.batches, batch)
+	return results, nil
+}
+
+func TestRetryFailedRecords(t *testing.T) {
+	s := &halfSink{seen: map[string]bool{}}
+	r := WithRetry(s, nil, &Config{RetryAttempts: 3}, NewRetryBudget(0))
+	r.(*retryFlusher).sleep = func(time.Duration) {}
+
+	records := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	assert.Nil(t, FlushRecords(r, "uid", "1", records))
+
+	assert.Equal(t, [][]string{{"a", "b", "c", "d"}, {"b", "d"}}, s.batches)
+
+	t.Run("Sinks without per-record results get the whole batch", func(t *testing.T) {
+		m := newTestMemSink()
+		assert.Nil(t, FlushRecords(WithRetry(m, nil, &Config{}, nil), "uid", "2", records))
+		assert.Equal(t, []byte("a\nb\nc\nd\n"), m.Records["2"])
+	})
+
+	t.Run("Records still failing are dead-lettered", func(t *testing.T) {
+		s := &halfSink{seen: map[string]bool{}}
+		dl := newTestMemSink()
+		r := WithRetry(s, dl, &Config{}, NewRetryBudget(0))
+
+		assert.IsType(t, &ErrDeadLettered{}, FlushRecords(r, "uid", "3", records))
+		assert.Equal(t, []byte("b\nd\n"), dl.Records["3"])
+	})
+}
@@ -0,0 +1,44 @@
+package io
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigV4(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer ts.Close()
+
+	creds := credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")
+	sign := sigV4(creds, "execute-api", "ap-south-1")
+
+	client, err := newHTTPClient(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, post(
+		client, []RequestTransform{sign}, ts.URL, "application/x-ndjson",
+		[]byte("{}\n"), nil,
+	))
+
+	assert.NotEmpty(t, got.Get("X-Amz-Date"))
+	assert.True(t, strings.HasPrefix(
+		got.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/",
+	), got.Get("Authorization"))
+	assert.Contains(t, got.Get("Authorization"), "/ap-south-1/execute-api/aws4_request")
+
+	t.Run("Configured on a sink", func(t *testing.T) {
+		v := &VectorSink{}
+		assert.NotNil(t, v.LoadConfig([]byte(`{
+			"vector_address": "`+ts.URL+`",
+			"http_sink": {"sigv4": {"region": "ap-south-1"}}
+		}`)), "service is required")
+	})
+}
@@ -0,0 +1,267 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	fmt "fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpillProbeInterval = 5 * time.Second
+
+	// How often Drain checks whether the queue is empty.
+	spillDrainPoll = 100 * time.Millisecond
+)
+
+var (
+	errSpillFull   = errors.New("spill queue is full")
+	errSpillClosed = errors.New("spill queue was drained on shutdown")
+)
+
+// Drainer is a sink that holds batches to flush later. Drain waits for them
+// to be flushed, and dead-letters those it still holds once ctx is done.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// RecoveryConfig sets up a spill queue for batches that a sink still fails
+// after its retries, instead of dead-lettering them right away.
+type RecoveryConfig struct {
+	// Batches held while the sink is down. 0 disables the queue.
+	QueueBatches int `json:"queue_batches"`
+
+	// Cap on batches per second replayed once the sink recovers, so that
+	// the backlog does not knock it over again. 0 replays as fast as it can.
+	MaxCatchUpRate float64 `json:"max_catch_up_rate"`
+
+	// Seconds between attempts at the sink while it is down.
+	ProbeInterval int `json:"probe_interval"`
+}
+
+type spilledBatch struct {
+	uuid, ident string
+	d           []byte
+	done        chan error
+}
+
+// spillQueue holds failed batches in memory and replays them, in order, to
+// the sink once it takes them again. It stands in for the dead-letter sink of
+// the retries; batches that do not fit in the queue go on to deadLetter.
+// Flush fails the batches it queues with ErrSpilled, for the caller to learn
+// of their delivery once they are replayed.
+type spillQueue struct {
+	sink, deadLetter Flusher
+	max              int
+	rate             float64
+	interval         time.Duration
+	sleep            func(time.Duration)
+
+	sync.Mutex
+	queue  []spilledBatch
+	closed bool
+	wakeup chan struct{}
+
+	// Held while a batch is replayed, for Drain not to dead-letter it too.
+	replaying sync.Mutex
+}
+
+// WithSpill returns the Flusher that batches failing on f go to after their
+// retries: the spill queue when conf.Recovery asks for one, otherwise the
+// dead-letter sink.
+func WithSpill(f, deadLetter Flusher, conf *Config) Flusher {
+	r := conf.Recovery
+	if r.QueueBatches <= 0 {
+		return deadLetter
+	}
+
+	interval := time.Duration(r.ProbeInterval) * time.Second
+	if interval == 0 {
+		interval = defaultSpillProbeInterval
+	}
+
+	return newSpillQueue(f, deadLetter, r.QueueBatches, r.MaxCatchUpRate, interval)
+}
+
+// WithRecovery wraps a sink with retries, and with the spill queue when
+// conf.Recovery asks for one. While the queue holds batches, new batches
+// join it rather than go to the sink, so that the queue drains first, in
+// order, and the catch-up rate applies to the new batches too.
+func WithRecovery(f, deadLetter Flusher, conf *Config, budget *RetryBudget) Flusher {
+	spill := WithSpill(f, deadLetter, conf)
+	r := WithRetry(f, spill, conf, budget)
+
+	s, ok := spill.(*spillQueue)
+	if !ok {
+		return r
+	}
+	return &spillFront{r.(*retryFlusher), s}
+}
+
+// spillFront sends batches to the sink through retries, unless the spill
+// queue is still draining, in which case they join the queue.
+type spillFront struct {
+	*retryFlusher
+	spill *spillQueue
+}
+
+func (f *spillFront) Flush(uuid, ident string, d []byte) error {
+	if f.spill.pending() {
+		return f.spill.Flush(uuid, ident, d)
+	}
+	return f.retryFlusher.Flush(uuid, ident, d)
+}
+
+func (f *spillFront) Drain(ctx context.Context) error {
+	return f.spill.Drain(ctx)
+}
+
+func (f *spillFront) FlushRecords(uuid, ident string, records [][]byte) ([]FlushResult, error) {
+	if f.spill.pending() {
+		return []FlushResult{{Err: f.spill.Flush(uuid, ident, joinRecords(records))}}, nil
+	}
+	return f.retryFlusher.FlushRecords(uuid, ident, records)
+}
+
+func newSpillQueue(f, deadLetter Flusher, max int, rate float64, interval time.Duration) *spillQueue {
+	s := &spillQueue{
+		sink:       f,
+		deadLetter: deadLetter,
+		max:        max,
+		rate:       rate,
+		interval:   interval,
+		sleep:      time.Sleep,
+		wakeup:     make(chan struct{}, 1),
+	}
+
+	go s.replay()
+	return s
+}
+
+func (s *spillQueue) LoadConfig(b json.RawMessage) error {
+	return nil
+}
+
+func (s *spillQueue) Flush(uuid, ident string, d []byte) error {
+	s.Lock()
+	if s.closed || len(s.queue) >= s.max {
+		why := errSpillFull
+		if s.closed {
+			why = errSpillClosed
+		}
+		s.Unlock()
+		return s.deadLetterBatch(uuid, ident, d, why)
+	}
+
+	log.Printf("Spilling %v until the sink recovers", ident)
+	done := make(chan error, 1)
+	s.queue = append(s.queue, spilledBatch{uuid, ident, d, done})
+	s.Unlock()
+
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+	return &ErrSpilled{Done: done}
+}
+
+// deadLetterBatch sends a batch that the queue cannot hold to the
+// dead-letter sink.
+func (s *spillQueue) deadLetterBatch(uuid, ident string, d []byte, why error) error {
+	if s.deadLetter == nil {
+		return fmt.Errorf("%v, dropping %v", why, ident)
+	}
+
+	log.Printf("Dead-lettering %v: %v", ident, why)
+	return deadLettered(s.deadLetter.Flush(uuid, ident, d), why)
+}
+
+// Drain waits for the queue to be replayed. Once ctx is done, the batches
+// still queued are dead-lettered, and the queue takes no more.
+func (s *spillQueue) Drain(ctx context.Context) error {
+	for s.pending() {
+		select {
+		case <-ctx.Done():
+			s.replaying.Lock()
+			s.Lock()
+			rest := s.queue
+			s.queue, s.closed = nil, true
+			s.Unlock()
+			s.replaying.Unlock()
+
+			for _, b := range rest {
+				b.done <- s.deadLetterBatch(b.uuid, b.ident, b.d, errSpillClosed)
+			}
+			return ctx.Err()
+		case <-time.After(spillDrainPoll):
+		}
+	}
+
+	s.Lock()
+	s.closed = true
+	s.Unlock()
+	return nil
+}
+
+// pending reports whether batches are waiting to be replayed. A batch
+// leaves the queue only once the sink has taken it.
+func (s *spillQueue) pending() bool {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.queue) > 0
+}
+
+func (s *spillQueue) head() (spilledBatch, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.queue) == 0 {
+		return spilledBatch{}, false
+	}
+	return s.queue[0], true
+}
+
+// flushHead replays the oldest batch, with Drain kept from dead-lettering
+// it meanwhile. It is false when no batch is left.
+func (s *spillQueue) flushHead() (spilledBatch, bool, error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	b, ok := s.head()
+	if !ok {
+		return b, false, nil
+	}
+
+	if err := s.sink.Flush(b.uuid, b.ident, b.d); err != nil {
+		return b, true, err
+	}
+
+	s.Lock()
+	s.queue = s.queue[1:]
+	s.Unlock()
+
+	b.done <- nil
+	return b, true, nil
+}
+
+// replay tries the oldest batch every interval while the sink is down, and
+// drains the queue at no more than rate batches a second once it is up.
+func (s *spillQueue) replay() {
+	for {
+		b, ok, err := s.flushHead()
+		switch {
+		case !ok:
+			<-s.wakeup
+		case err != nil:
+			s.sleep(s.interval)
+		default:
+			log.Printf("Replayed spilled %v", b.ident)
+			if s.rate > 0 {
+				s.sleep(time.Duration(float64(time.Second) / s.rate))
+			}
+		}
+	}
+}
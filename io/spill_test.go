@@ -0,0 +1,179 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakySink fails every Flush while it is down, and notes when each batch
+// was taken once it is up.
+type flakySink struct {
+	sync.Mutex
+	up     bool
+	idents []string
+	acked  []time.Time
+}
+
+func (f *flakySink) LoadConfig(_ json.RawMessage) error { return nil }
+
+func (f *flakySink) Flush(uuid, ident string, d []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if !f.up {
+		return errors.New("sink unavailable")
+	}
+
+	f.idents = append(f.idents, ident)
+	f.acked = append(f.acked, time.Now())
+	return nil
+}
+
+func (f *flakySink) setUp(up bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.up = up
+}
+
+func (f *flakySink) taken() ([]string, []time.Time) {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.idents...), append([]time.Time{}, f.acked...)
+}
+
+func TestSpillQueue(t *testing.T) {
+	const batches, rate = 10, 50
+
+	sink, dl := &flakySink{}, newTestMemSink()
+	s := newSpillQueue(sink, dl, batches, rate, 10*time.Millisecond)
+
+	// The outage: every batch fails its retries and is spilled.
+	var spilled []*ErrSpilled
+	for ix := 0; ix < batches; ix++ {
+		err := s.Flush("uid", strconv.Itoa(ix), []byte("{}\n"))
+		assert.IsType(t, &ErrSpilled{}, err)
+		spilled = append(spilled, err.(*ErrSpilled))
+	}
+
+	t.Run("Batches past the queue are dead-lettered", func(t *testing.T) {
+		assert.IsType(t, &ErrDeadLettered{}, s.Flush("uid", "overflow", []byte("{}\n")))
+		assert.Contains(t, dl.Records, "overflow")
+	})
+
+	idents, _ := sink.taken()
+	assert.Empty(t, idents)
+
+	sink.setUp(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if idents, _ := sink.taken(); len(idents) == batches {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Spilled batches were not replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	idents, acked := sink.taken()
+	for ix, ident := range idents {
+		assert.Equal(t, strconv.Itoa(ix), ident)
+	}
+
+	t.Run("Replayed batches are done", func(t *testing.T) {
+		for _, s := range spilled {
+			assert.Nil(t, <-s.Done)
+		}
+	})
+
+	// batches-1 gaps of at least 1/rate, give or take timer slack.
+	elapsed := acked[len(acked)-1].Sub(acked[0])
+	min := time.Duration(batches-1) * time.Second / rate
+	assert.True(t, elapsed >= min*9/10, "replayed %v batches in %v", batches, elapsed)
+}
+
+func TestWithSpill(t *testing.T) {
+	dl := newTestMemSink()
+	assert.Equal(t, Flusher(dl), WithSpill(&flakySink{}, dl, &Config{}))
+
+	conf := &Config{Recovery: RecoveryConfig{QueueBatches: 1}}
+	_, ok := WithSpill(&flakySink{}, dl, conf).(*spillQueue)
+	assert.True(t, ok)
+
+	_, ok = WithRecovery(&flakySink{}, dl, &Config{}, nil).(*retryFlusher)
+	assert.True(t, ok)
+	_, ok = WithRecovery(&flakySink{}, dl, conf, nil).(*spillFront)
+	assert.True(t, ok)
+}
+
+func TestSpillQueueOrdersLiveBatches(t *testing.T) {
+	const rate = 50
+
+	sink, dl := &flakySink{}, newTestMemSink()
+	s := newSpillQueue(sink, dl, 10, rate, 10*time.Millisecond)
+	front := &spillFront{WithRetry(sink, s, &Config{}, nil).(*retryFlusher), s}
+
+	for ix := 0; ix < 3; ix++ {
+		assert.IsType(t, &ErrSpilled{}, front.Flush("uid", strconv.Itoa(ix), []byte("{}\n")))
+	}
+
+	// The queue is still draining, so the live batch waits its turn.
+	sink.setUp(true)
+	assert.IsType(t, &ErrSpilled{}, front.Flush("uid", "live", []byte("{}\n")))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if idents, _ := sink.taken(); len(idents) == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Batches were not replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	idents, acked := sink.taken()
+	assert.Equal(t, []string{"0", "1", "2", "live"}, idents)
+
+	gap := acked[3].Sub(acked[2])
+	assert.True(t, gap >= time.Second/rate*9/10, "live batch followed in %v", gap)
+
+	t.Run("Batches go straight to the sink once drained", func(t *testing.T) {
+		for s.pending() {
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.Nil(t, front.Flush("uid", "direct", []byte("{}\n")))
+		idents, _ := sink.taken()
+		assert.Equal(t, "direct", idents[len(idents)-1])
+	})
+}
+
+func TestSpillQueueDrain(t *testing.T) {
+	sink, dl := &flakySink{}, newTestMemSink()
+	s := newSpillQueue(sink, dl, 10, 0, 10*time.Millisecond)
+
+	spilled := s.Flush("uid", "held", []byte("{}\n")).(*ErrSpilled)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Drain(ctx))
+
+	err := <-spilled.Done
+	assert.IsType(t, &ErrDeadLettered{}, err)
+	assert.True(t, errors.Is(err, errSpillClosed))
+	assert.Contains(t, dl.Records, "held")
+
+	t.Run("Drained queues take no more", func(t *testing.T) {
+		assert.IsType(t, &ErrDeadLettered{}, s.Flush("uid", "late", []byte("{}\n")))
+		assert.Contains(t, dl.Records, "late")
+		assert.False(t, s.pending())
+	})
+}
@@ -1,32 +1,53 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/last9/k8stream/io"
+	"github.com/last9/k8stream/stream"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 )
 
-const VERSION = "0.0.4"
-
 var (
 	configFile = kingpin.Flag("config", "Config File to Parse").Required().File()
+
+	replayDeadLetter = kingpin.Flag(
+		"replay-deadletter",
+		"Re-flush the batches dead-lettered into this directory through the sink, and exit",
+	).String()
 )
 
-func getFlusher(conf *L9K8streamConfig) (io.Flusher, error) {
+func getFlusher(conf *stream.L9K8streamConfig) (io.Flusher, error) {
 	return io.GetFlusher(&conf.Config)
 }
 
+// replay re-flushes dead-lettered batches through the bare primary sink, so
+// that a batch failing again stays where it is.
+func replay(conf *stream.L9K8streamConfig, dir string) {
+	f, err := getFlusher(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, failed, err := io.ReplayDeadLetter(dir, f)
+	log.Printf("Replayed %v dead-lettered batches from %v, %v failed", n, dir, failed)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
-	kingpin.Version(VERSION)
+	kingpin.Version(stream.VERSION)
 	kingpin.Parse()
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
@@ -35,76 +56,254 @@ func main() {
 		log.Fatal(err)
 	}
 
-	conf := &L9K8streamConfig{}
+	conf := &stream.L9K8streamConfig{}
 	if err := io.LoadConfig(cData, conf); err != nil {
 		log.Fatal(err)
 	}
 
 	if err := io.StartHeartbeat(
-		VERSION,
+		stream.VERSION,
 		conf.UID, conf.HeartbeatHook,
-		conf.HeartbeatInterval, conf.HeartbeatTimeout,
+		conf.HeartbeatInterval, conf.HeartbeatTimeout, conf.TLS,
 	); err != nil {
 		log.Fatal(err)
 	}
 
 	conf.Raw = cData
-	setDefaults(conf)
+	stream.SetDefaults(conf)
+
+	if *replayDeadLetter != "" {
+		replay(conf, *replayDeadLetter)
+		return
+	}
+
+	ready := &stream.Readiness{}
 
 	// Create a k8s client
-	kc, err := newK8sClient(conf.KubeConfig)
+	kc, err := stream.NewK8sClient(conf.KubeConfig, conf.Kube)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create a LRU Cache
-	mcache, err := newCache()
+	// Get Flusher instance from IO
+	f, err := getFlusher(conf)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Get Flusher instance from IO
-	f, err := getFlusher(conf)
+	if c, ok := f.(io.Connector); ok && conf.SinkHealthInterval > 0 {
+		ready.ProbeSink(c.Ping, time.Duration(conf.SinkHealthInterval)*time.Second)
+	}
+
+	var metrics *http.Server
+	if conf.MetricsAddr != "" {
+		metrics = stream.StartMetricsServer(conf.MetricsAddr, ready)
+	}
+
+	// Sink for records that the primary sink should not, or could not, take.
+	dl := io.GetDeadLetter(&conf.Config)
+
+	// Retries of every sink draw from the same budget.
+	budget := io.NewRetryBudget(conf.RetryBudget)
+	f = io.WithRecovery(f, dl, &conf.Config, budget)
+
+	named, err := io.GetSinks(&conf.Config, dl, budget)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Start a batcher, returns a channel.
-	ch := startIngester(f, conf, mcache)
-	h := &Handler{kc, ch, mcache, conf}
+	sinks, err := stream.NewSinkSet(f, named, conf.SeverityRoutes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p, err := stream.NewPipeline(conf, kc, sinks, dl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := p.Handler
+
+	if conf.Enrich.Datacenters != nil {
+		go reloadOnHangup(p)
+	}
 
 	stopCh := make(chan struct{})
 	factory := informers.NewSharedInformerFactory(
 		kc.Clientset,
-		time.Duration(conf.ResyncInterval)*time.Second,
+		time.Duration(*conf.ResyncInterval)*time.Second,
 	)
 
-	// Service Informer to capture service events, since they dont show up
-	// in the defaults events interface.
-	svcInformer := factory.Core().V1().Services().Informer()
-	svcInformer.AddEventHandler(h)
-	go svcInformer.Run(stopCh)
+	// Informers are shared by kind, and run once each.
+	running := map[cache.SharedIndexInformer]bool{}
+	run := func(i cache.SharedIndexInformer) {
+		if !running[i] {
+			running[i] = true
+			go i.Run(stopCh)
+		}
+	}
+
+	// Pods of services are looked up in the pod informer, once it synced,
+	// for the reverse index to be reconciled against the same pods.
+	if conf.PodIndexReconcileInterval > 0 {
+		podInformer := factory.Core().V1().Pods().Informer()
+		run(podInformer)
+		if !cache.WaitForCacheSync(stopCh, podInformer.HasSynced) {
+			runtime.HandleError(fmt.Errorf("timed out waiting for the pod cache to sync"))
+			return
+		}
 
-	informer := factory.Core().V1().Events().Informer()
-	informer.AddEventHandler(h)
-	go informer.Run(stopCh)
-	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		p.StartPodIndexReconciler(
+			podInformer.GetStore(),
+			time.Duration(conf.PodIndexReconcileInterval)*time.Second,
+		)
+	}
+
+	stores := []cache.Store{}
+	synced := []cache.InformerSynced{}
+
+	available, err := kc.AvailableResources(conf.Watch.Resources(), conf.Watch.RequireAllResources)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Each kind of watch.kinds, services included, since their changes do
+	// not show up as core events.
+	for _, kind := range conf.Watch.Kinds {
+		if !available[stream.KindResource(kind)] {
+			continue
+		}
+
+		i := informerOf(factory, kind)
+		i.AddEventHandler(h)
+		run(i)
+		synced = append(synced, i.HasSynced)
+		if kind == stream.KindServices {
+			stores = append(stores, i.GetStore())
+		}
+	}
+
+	if available[stream.NamespacesResource] {
+		nsInformer := factory.Core().V1().Namespaces().Informer()
+		nsInformer.AddEventHandler(h)
+		run(nsInformer)
+		stores = append(stores, nsInformer.GetStore())
+		synced = append(synced, nsInformer.HasSynced)
+	}
+
+	if available[stream.ResourceQuotasResource] {
+		quotaInformer := factory.Core().V1().ResourceQuotas().Informer()
+		quotaInformer.AddEventHandler(h)
+		run(quotaInformer)
+		synced = append(synced, quotaInformer.HasSynced)
+	}
+
+	if available[stream.PDBResource] {
+		pdbInformer := factory.Policy().V1beta1().PodDisruptionBudgets().Informer()
+		pdbInformer.AddEventHandler(h)
+		run(pdbInformer)
+		synced = append(synced, pdbInformer.HasSynced)
+	}
+
+	if available[stream.LeasesResource] {
+		leaseInformer := factory.Coordination().V1().Leases().Informer()
+		leaseInformer.AddEventHandler(h)
+		run(leaseInformer)
+		synced = append(synced, leaseInformer.HasSynced)
+	}
+
+	if available[stream.SecretsResource] {
+		secretInformer := factory.Core().V1().Secrets().Informer()
+		secretInformer.AddEventHandler(h)
+		run(secretInformer)
+		synced = append(synced, secretInformer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
 		runtime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
+	ready.MarkSynced()
+	p.MarkSynced()
 
-	os.Exit(trapSignal(stopCh))
+	if conf.EmitInitialState {
+		p.EmitInitialState(stores)
+	}
+
+	if conf.Snapshot.Interval > 0 {
+		p.StartSnapshots(stores, time.Duration(conf.Snapshot.Interval)*time.Second, stopCh)
+	}
+
+	code := trapSignal(stopCh, p, time.Duration(conf.ShutdownTimeout)*time.Second)
+	if metrics != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), quitFlushTimeout)
+		metrics.Shutdown(ctx)
+		cancel()
+	}
+	os.Exit(code)
 }
 
-func trapSignal(stopCh chan<- struct{}) int {
+// informerOf is the informer of a kind of watch.kinds, that the pipeline
+// checked already.
+func informerOf(factory informers.SharedInformerFactory, kind string) cache.SharedIndexInformer {
+	switch kind {
+	case stream.KindServices:
+		return factory.Core().V1().Services().Informer()
+	case stream.KindPods:
+		return factory.Core().V1().Pods().Informer()
+	case stream.KindDeployments:
+		return factory.Apps().V1().Deployments().Informer()
+	case stream.KindReplicaSets:
+		return factory.Apps().V1().ReplicaSets().Informer()
+	}
+	return factory.Core().V1().Events().Informer()
+}
+
+// reloadOnHangup reads the datacenter mapping again on every SIGHUP.
+func reloadOnHangup(p *stream.Pipeline) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	for range sigCh {
+		if err := p.ReloadDatacenters(); err != nil {
+			log.Println("Reloading the datacenter mapping:", err)
+			continue
+		}
+		log.Println("Reloaded the datacenter mapping")
+	}
+}
+
+// quitFlushTimeout bounds the flush on a SIGQUIT, which asks for a fast
+// exit, as the heartbeat does when the control plane is unreachable.
+const quitFlushTimeout = 300 * time.Millisecond
+
+// trapSignal stops the informers on a signal, or once the pipeline
+// expired, and then the pipeline, once it flushed the events it holds or
+// the timeout passed. On a SIGQUIT the flush is only a best effort, within
+// quitFlushTimeout.
+func trapSignal(stopCh chan<- struct{}, p *stream.Pipeline, timeout time.Duration) int {
 	sigCh := make(chan os.Signal, 0)
 	signal.Notify(sigCh, os.Kill, os.Interrupt, syscall.SIGQUIT)
 
-	s := <-sigCh
+	var s os.Signal
+	select {
+	case s = <-sigCh:
+	case <-p.Expired():
+	}
 	close(stopCh)
 
+	if s == syscall.SIGQUIT && timeout > quitFlushTimeout {
+		timeout = quitFlushTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != nil {
+		log.Println("Shutting down:", err)
+	}
+
 	if s == syscall.SIGQUIT {
-		time.Sleep(300 * time.Millisecond)
 		return 1
 	}
 
@@ -0,0 +1,47 @@
+package stream
+
+import "errors"
+
+// Why an event that reached a batch was not delivered to its sink, as told
+// to OnFailed.
+var (
+	ErrDeadLettered = errors.New("oversized event was dead-lettered")
+	ErrDropped      = errors.New("oversized event was dropped")
+)
+
+// Acker is told, by an embedding application, of what became of each event
+// that reached a batch, by its AckToken. An event is delivered once the
+// batch holding it is flushed to the sinks, and failed once the flush gave
+// up, its retries exhausted, even when the batch went to the dead-letter
+// sink. Batches that the spill queue holds are acked once they are replayed.
+// The calls are made on the flushing goroutine, or on the one settling a
+// spilled batch, so they should not block.
+type Acker interface {
+	OnDelivered(token string)
+	OnFailed(token string, err error)
+}
+
+// SetAcker registers a, before any object is handled.
+func (p *Pipeline) SetAcker(a Acker) {
+	p.Handler.conf.acker = a
+}
+
+// ackBatch tells the acker what became of a batch, the events that were
+// not sent to the sink by their index aside.
+func ackBatch(cfg *L9K8streamConfig, batch []interface{}, err error, skipped map[int]error) {
+	if cfg.acker == nil {
+		return
+	}
+
+	for ix, v := range batch {
+		token := v.(*L9Event).AckToken
+		switch {
+		case err != nil:
+			cfg.acker.OnFailed(token, err)
+		case skipped[ix] != nil:
+			cfg.acker.OnFailed(token, skipped[ix])
+		default:
+			cfg.acker.OnDelivered(token)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+type recordingAcker struct {
+	sync.Mutex
+	delivered []string
+	failed    map[string]error
+}
+
+func (a *recordingAcker) OnDelivered(token string) {
+	a.Lock()
+	defer a.Unlock()
+	a.delivered = append(a.delivered, token)
+}
+
+func (a *recordingAcker) OnFailed(token string, err error) {
+	a.Lock()
+	defer a.Unlock()
+	a.failed[token] = err
+}
+
+func TestAcks(t *testing.T) {
+	batchOf := func(events ...*L9Event) chan interface{} {
+		ch := make(chan interface{}, len(events))
+		h := &Handler{conf: &L9K8streamConfig{}, ch: ch}
+		for _, e := range events {
+			h.emit(e)
+		}
+		return ch
+	}
+
+	t.Run("Delivered once flushed", func(t *testing.T) {
+		cfg := newTestConfig()
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		ch := batchOf(&L9Event{ID: "a"}, &L9Event{ID: "b", AckToken: "token-b"})
+		assert.Equal(t, doBatch(SingleSink(newMemSink()), nil, ch, nil, cfg), nil)
+		assert.Equal(t, acker.delivered, []string{"a", "token-b"})
+		assert.Equal(t, len(acker.failed), 0)
+	})
+
+	t.Run("Failed once the retries are exhausted", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.RetryAttempts = 2
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		attempts := 0
+		down := errors.New("sink down")
+		failing := io.WithRetry(NewFuncFlusher(func([]*L9Event) error {
+			attempts++
+			return &io.ErrThrottled{Err: down, RetryAfter: time.Millisecond}
+		}), nil, &cfg.Config, nil)
+
+		ch := batchOf(&L9Event{ID: "a"}, &L9Event{ID: "b"})
+		assert.NotEqual(t, doBatch(SingleSink(failing), nil, ch, nil, cfg), nil)
+		assert.Equal(t, attempts, 3)
+		assert.Equal(t, len(acker.delivered), 0)
+		assert.Equal(t, len(acker.failed), 2)
+		assert.Equal(t, errors.Is(acker.failed["a"], down), true)
+	})
+
+	t.Run("Failed once dead-lettered", func(t *testing.T) {
+		cfg := newTestConfig()
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		down := errors.New("sink down")
+		dl := newMemSink()
+		failing := io.WithRetry(NewFuncFlusher(func([]*L9Event) error {
+			return &io.ErrPermanent{Err: down}
+		}), dl, &cfg.Config, nil)
+
+		ch := batchOf(&L9Event{ID: "a"}, &L9Event{ID: "b"})
+		assert.Equal(t, doBatch(SingleSink(failing), nil, ch, nil, cfg), nil)
+		assert.Equal(t, len(sinkLines(dl)), 2)
+		assert.Equal(t, len(acker.delivered), 0)
+		assert.Equal(t, len(acker.failed), 2)
+		assert.Equal(t, errors.Is(acker.failed["a"], down), true)
+	})
+
+	t.Run("Delivered once spilled batches are replayed", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.Recovery.QueueBatches = 1
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		var mu sync.Mutex
+		attempts := 0
+		flaky := io.WithRecovery(NewFuncFlusher(func([]*L9Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts++; attempts == 1 {
+				return errors.New("sink down")
+			}
+			return nil
+		}), newMemSink(), &cfg.Config, nil)
+
+		ch := batchOf(&L9Event{ID: "a"}, &L9Event{ID: "b"})
+		assert.Equal(t, doBatch(SingleSink(flaky), nil, ch, nil, cfg), nil)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			acker.Lock()
+			delivered := len(acker.delivered)
+			acker.Unlock()
+			if delivered == 2 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Spilled batch was not acked")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, len(acker.failed), 0)
+	})
+
+	t.Run("Dead-lettered oversized events failed", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.MaxEventBytes = 512
+		cfg.OversizePolicy = oversizeDeadLetter
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		ch := batchOf(&L9Event{ID: "small"}, &L9Event{ID: "big", Message: strings.Repeat("x", 2048)})
+		assert.Equal(t, doBatch(SingleSink(newMemSink()), newMemSink(), ch, nil, cfg), nil)
+		assert.Equal(t, acker.delivered, []string{"small"})
+		assert.Equal(t, acker.failed["big"], ErrDeadLettered)
+	})
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/last9/k8stream/io"
+)
+
+// eventField returns the index of the L9Event field serialized as name.
+func eventField(name string) (int, bool) {
+	t := reflect.TypeOf(L9Event{})
+	for ix := 0; ix < t.NumField(); ix++ {
+		tag := strings.Split(t.Field(ix).Tag.Get("json"), ",")[0]
+		if tag == name {
+			return ix, true
+		}
+	}
+
+	return 0, false
+}
+
+func keyOf(v interface{}, field int) string {
+	return fmt.Sprint(reflect.ValueOf(v.(*L9Event)).Elem().Field(field).Interface())
+}
+
+// accumulator is the batch being filled with one value of the key.
+type accumulator struct {
+	batch    []interface{}
+	deadline time.Time
+}
+
+// keyedBatcher fills a batch for each value of cfg.BatchByKey. Each one is
+// flushed on its own once it holds BatchSize events, or BatchInterval after
+// its first event, so that a rare value neither waits on a busy one nor
+// cuts its batches short.
+func keyedBatcher(
+	sinks *SinkSet, dl io.Flusher, ch <-chan interface{},
+	db Cachier, cfg *L9K8streamConfig,
+) {
+	field, _ := eventField(cfg.BatchByKey)
+	interval := time.Duration(cfg.BatchInterval) * time.Second
+	accs := map[string]*accumulator{}
+
+	flush := func(key string) {
+		acc := accs[key]
+		delete(accs, key)
+
+		batchIdent := io.BatchNumber()
+		cfg.Log("Flushing %v for %v: %v", batchIdent, key, len(acc.batch))
+		if err := shipBatch(sinks, dl, acc.batch, batchIdent, db, cfg); err != nil {
+			log.Println(err)
+		}
+	}
+
+	for {
+		// Wakes up for the accumulator that is due first.
+		var due <-chan time.Time
+		var first time.Time
+		for _, acc := range accs {
+			if first.IsZero() || acc.deadline.Before(first) {
+				first = acc.deadline
+			}
+		}
+		if !first.IsZero() {
+			due = time.After(time.Until(first))
+		}
+
+		select {
+		case x, ok := <-ch:
+			if !ok {
+				for key := range accs {
+					flush(key)
+				}
+				return
+			}
+
+			key := keyOf(x, field)
+			acc, ok := accs[key]
+			if !ok {
+				acc = &accumulator{deadline: time.Now().Add(interval)}
+				accs[key] = acc
+			}
+
+			acc.batch = append(acc.batch, x)
+			if len(acc.batch) >= cfg.BatchSize || urgent(x) {
+				flush(key)
+			}
+		case now := <-due:
+			for key, acc := range accs {
+				if !acc.deadline.After(now) {
+					cfg.Log("Flushing batch for %v for Timeout %v", key, cfg.BatchInterval)
+					flush(key)
+				}
+			}
+		}
+	}
+}
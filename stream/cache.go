@@ -0,0 +1,371 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	fmt "fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Multiple read-only transactions can be opened at the same time but
+// there can only be one read/write transaction at a time.
+// Attempting to open a read/write transactions while another one is
+// in progress will result in blocking until the current read/write
+// transaction is completed.
+// Use sync.Mutex underneath. Will come up with something else later.
+type Cache struct {
+	db  *buntdb.DB
+	now func() time.Time
+}
+
+// Keys of the write timestamps of entries that are set without expiry, for
+// Sweep to find the ones that were left behind.
+const writtenPrefix = "written-"
+
+// Keys naming the tables, as buntdb does not persist indexes, for a cache
+// opened from a file to create their indexes again.
+const tablePrefix = "table/"
+
+// Item that is internally saved to the database.
+// Don't expect the Uid to be generated on Insert.
+type result struct {
+	data json.RawMessage
+}
+
+func (r *result) Exists() bool {
+	return r.data != nil
+}
+
+func (r *result) Unmarshal(obj interface{}) error {
+	if r.data == nil {
+		return errors.New("Empty result set cannot be unmarshalled")
+	}
+
+	return json.Unmarshal(r.data, obj)
+}
+
+func makeKey(table, uid string) string {
+	// Combine to attach a table prefix and convert everything to lowercase
+	// Hello and hELLo are the same tables.
+	return fmt.Sprintf("%s-%s", strings.ToLower(table), uid)
+}
+
+func (c *Cache) Get(table, uid string) (*result, error) {
+	key := makeKey(table, uid)
+	r := &result{}
+
+	return r, c.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(key) //IgnoreExpiredValue
+		if err != nil {
+			// NOTE: Weird syntax by buntDB where it returns a pre-constructed
+			// value rather than an error type.
+			if err == buntdb.ErrNotFound {
+				r.data = nil
+				return nil
+			}
+			return err
+		}
+
+		r.data = []byte(val)
+		return nil
+	})
+}
+
+// Set is just a wrapper around ExpireSet with a no-expiration Intent.
+func (c *Cache) Set(table, uid string, obj interface{}) error {
+	return c.ExpireSet(table, uid, obj, 0)
+}
+
+// Table is just a prefix on which an Index exists.
+// This is a way to emulate buckets in buntDB. In a key-value DB a query
+// like find all keys that are of the same type are challenging.
+// In absence of buckets and partial Indexes, prefix can be exploited
+// to deliver the same feature.
+
+// Set an object in table with Expiry, and create a new Index if none exists
+func (c *Cache) ExpireSet(table, uid string, obj interface{}, expires int) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	opts := &buntdb.SetOptions{}
+	if expires > 0 {
+		opts.Expires = true
+		opts.TTL = time.Duration(expires) * time.Second
+	}
+
+	return c.db.Update(func(tx *buntdb.Tx) error {
+		if err := ensureIndex(tx, table); err != nil {
+			return err
+		}
+
+		key := makeKey(table, uid)
+		tx.Set(key, string(b), opts)
+		if expires == 0 {
+			tx.Set(writtenPrefix+key, strconv.FormatInt(c.now().Unix(), 10), nil)
+		}
+		return nil
+	})
+}
+
+// Len is the number of entries of all the tables.
+func (c *Cache) Len() (int, error) {
+	var n int
+	return n, c.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("*", func(key, value string) bool {
+			if !strings.HasPrefix(key, writtenPrefix) && !strings.HasPrefix(key, tablePrefix) {
+				n++
+			}
+			return true
+		})
+	})
+}
+
+// Sweep deletes the entries set without expiry, in the tables starting
+// with prefix, that were last written more than maxAge ago. It returns the
+// number of entries deleted.
+func (c *Cache) Sweep(prefix string, maxAge time.Duration) (int, error) {
+	cutoff := c.now().Add(-maxAge).Unix()
+
+	var swept int
+	return swept, c.db.Update(func(tx *buntdb.Tx) error {
+		var stale []string
+		if err := tx.AscendKeys(writtenPrefix+strings.ToLower(prefix)+"*", func(key, value string) bool {
+			if written, err := strconv.ParseInt(value, 10, 64); err == nil && written < cutoff {
+				stale = append(stale, key)
+			}
+			return true
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			tx.Delete(k)
+			if _, err := tx.Delete(strings.TrimPrefix(k, writtenPrefix)); err == nil {
+				swept++
+			}
+		}
+		return nil
+	})
+}
+
+func ensureIndex(tx *buntdb.Tx, table string) error {
+	indices, err := tx.Indexes()
+	if err != nil {
+		return err
+	}
+
+	for _, ix := range indices {
+		if table == ix {
+			return nil
+		}
+	}
+
+	if err := tx.CreateIndex(table, makeKey(table, "*"), buntdb.IndexString); err != nil {
+		return err
+	}
+
+	_, _, err = tx.Set(tablePrefix+table, "", nil)
+	return err
+}
+
+// restoreIndexes creates the indexes of the tables of a cache opened from
+// a file.
+func restoreIndexes(db *buntdb.DB) error {
+	return db.Update(func(tx *buntdb.Tx) error {
+		var tables []string
+		if err := tx.AscendKeys(tablePrefix+"*", func(key, _ string) bool {
+			tables = append(tables, strings.TrimPrefix(key, tablePrefix))
+			return true
+		}); err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			if err := tx.CreateIndex(table, makeKey(table, "*"), buntdb.IndexString); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetNX sets the object only if the key does not exist yet, and reports
+// whether it did. The check and the set happen in one transaction, so of
+// many concurrent callers exactly one gets true.
+func (c *Cache) SetNX(table, uid string, obj interface{}, expires int) (bool, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+
+	opts := &buntdb.SetOptions{}
+	if expires > 0 {
+		opts.Expires = true
+		opts.TTL = time.Duration(expires) * time.Second
+	}
+
+	var set bool
+	return set, c.db.Update(func(tx *buntdb.Tx) error {
+		key := makeKey(table, uid)
+		if _, err := tx.Get(key); err != buntdb.ErrNotFound {
+			return err
+		}
+
+		if err := ensureIndex(tx, table); err != nil {
+			return err
+		}
+
+		if _, _, err := tx.Set(key, string(b), opts); err != nil {
+			return err
+		}
+
+		set = true
+		return nil
+	})
+}
+
+// Use the Index to List all the Keys in the Index.
+// If the index doesn't exist. the method raises an IndexNotFound error.
+func (c *Cache) List(table string) ([]string, error) {
+	var values []string
+	index := makeKey(table, "")
+	return values, c.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(table, func(key, value string) bool {
+			values = append(values, strings.SplitN(key, index, 2)[1])
+			return true
+		})
+	})
+}
+
+// Tables lists the tables whose name starts with prefix.
+func (c *Cache) Tables(prefix string) ([]string, error) {
+	var tables []string
+	return tables, c.db.View(func(tx *buntdb.Tx) error {
+		indices, err := tx.Indexes()
+		if err != nil {
+			return err
+		}
+
+		for _, ix := range indices {
+			if strings.HasPrefix(ix, prefix) {
+				tables = append(tables, ix)
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes an entry of a table. Removing one that is not there is not
+// an error.
+func (c *Cache) Delete(table, uid string) error {
+	return c.db.Update(func(tx *buntdb.Tx) error {
+		key := makeKey(table, uid)
+		if _, err := tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		tx.Delete(writtenPrefix + key)
+		return nil
+	})
+}
+
+// DropTable deletes every key of a table, and its Index.
+func (c *Cache) DropTable(table string) error {
+	return c.db.Update(func(tx *buntdb.Tx) error {
+		var keys []string
+		if err := tx.Ascend(table, func(key, _ string) bool {
+			keys = append(keys, key)
+			return true
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if _, err := tx.Delete(k); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+			tx.Delete(writtenPrefix + k)
+		}
+
+		tx.Delete(tablePrefix + table)
+		return tx.DropIndex(table)
+	})
+}
+
+type Cachier interface {
+	Set(table, uid string, obj interface{}) error
+	ExpireSet(table, uid string, obj interface{}, expires int) error
+	SetNX(table, uid string, obj interface{}, expires int) (bool, error)
+	Get(table, uid string) (*result, error)
+	Delete(table, uid string) error
+	List(table string) ([]string, error)
+	Tables(prefix string) ([]string, error)
+	DropTable(table string) error
+	Sweep(prefix string, maxAge time.Duration) (int, error)
+	Len() (int, error)
+}
+
+func newCache() (Cachier, error) {
+	db, err := buntdb.Open(":memory:")
+	return &Cache{db, time.Now}, err
+}
+
+// openCache opens the cache kept in the file at path, for the dedup state
+// to survive restarts, or a cache in memory without a path. A file that
+// cannot be read, say corrupt after a crash, is logged and the cache is kept
+// in memory instead.
+func openCache(path string) (Cachier, error) {
+	if path == "" {
+		return newCache()
+	}
+
+	db, err := buntdb.Open(path)
+	if err == nil {
+		if err = restoreIndexes(db); err == nil {
+			return &Cache{db, time.Now}, nil
+		}
+		db.Close()
+	}
+
+	log.Printf("Opening the cache at %v, keeping it in memory instead: %v", path, err)
+	return newCache()
+}
+
+// Prefixes of the tables of services and pods, whose entries are removed
+// on delete events, but are left behind when one is missed.
+var sweptTables = []string{serviceTable, podServicesTable}
+
+// startCacheSweeper removes the service and pod entries not written for
+// maxAge, as a safety net against missed deletes.
+func startCacheSweeper(db Cachier, maxAge time.Duration) {
+	interval := maxAge / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			sweepCache(db, maxAge)
+		}
+	}()
+}
+
+func sweepCache(db Cachier, maxAge time.Duration) {
+	for _, prefix := range sweptTables {
+		n, err := db.Sweep(prefix, maxAge)
+		if err != nil {
+			log.Println("Sweeping", prefix, err)
+			continue
+		}
+
+		if n > 0 {
+			log.Printf("Swept %v %v entries older than %v", n, prefix, maxAge)
+		}
+	}
+}
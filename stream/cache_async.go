@@ -0,0 +1,50 @@
+package stream
+
+import "log"
+
+const defaultAsyncCacheBuffer = 1024
+
+// Tables whose writes stay synchronous in the async mode: dedup records,
+// and the service state that the next update of a service is diffed with.
+var syncTables = []string{eventCacheTable, serviceStateTable}
+
+type cacheWrite struct {
+	table, uid string
+	obj        interface{}
+}
+
+// asyncCache hands Set off to a background writer, so that the handler is
+// not held up by the cache on denormalization writes. A Get may not see a
+// write that is still queued. The queue is bounded; Set blocks when it is
+// full. ExpireSet and SetNX are always synchronous.
+type asyncCache struct {
+	Cachier
+	writes chan cacheWrite
+}
+
+func withAsyncWrites(c Cachier, buffer int) Cachier {
+	if buffer <= 0 {
+		buffer = defaultAsyncCacheBuffer
+	}
+
+	a := &asyncCache{Cachier: c, writes: make(chan cacheWrite, buffer)}
+	go a.run()
+	return a
+}
+
+func (a *asyncCache) run() {
+	for w := range a.writes {
+		if err := a.Cachier.Set(w.table, w.uid, w.obj); err != nil {
+			log.Printf("Async cache write %v/%v: %v", w.table, w.uid, err)
+		}
+	}
+}
+
+func (a *asyncCache) Set(table, uid string, obj interface{}) error {
+	if contains(table, syncTables) {
+		return a.Cachier.Set(table, uid, obj)
+	}
+
+	a.writes <- cacheWrite{table, uid, obj}
+	return nil
+}
@@ -0,0 +1,49 @@
+package stream
+
+import "time"
+
+// noopCache is the Cachier of cache.disabled. It keeps nothing, so every
+// event is emitted as often as the informers hand it over, and every
+// lookup goes to the API server. The pod to service index is always empty.
+type noopCache struct{}
+
+func (noopCache) Set(table, uid string, obj interface{}) error {
+	return nil
+}
+
+func (noopCache) ExpireSet(table, uid string, obj interface{}, expires int) error {
+	return nil
+}
+
+// SetNX always claims, as nothing was set before.
+func (noopCache) SetNX(table, uid string, obj interface{}, expires int) (bool, error) {
+	return true, nil
+}
+
+func (noopCache) Get(table, uid string) (*result, error) {
+	return &result{}, nil
+}
+
+func (noopCache) Delete(table, uid string) error {
+	return nil
+}
+
+func (noopCache) List(table string) ([]string, error) {
+	return nil, nil
+}
+
+func (noopCache) Tables(prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (noopCache) DropTable(table string) error {
+	return nil
+}
+
+func (noopCache) Sweep(prefix string, maxAge time.Duration) (int, error) {
+	return 0, nil
+}
+
+func (noopCache) Len() (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,459 @@
+package stream
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testItem struct {
+	Foo string
+	Id  int
+}
+
+func TestCache(t *testing.T) {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+
+	c, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Internal tests", func(t *testing.T) {
+		t.Run("Set a key", func(t *testing.T) {
+			var wg sync.WaitGroup
+			for ix := 0; ix < 4; ix++ {
+				wg.Add(1)
+				go func(val int) {
+					defer wg.Done()
+					if err := c.Set("table", strconv.Itoa(val), val); err != nil {
+						t.Error(err)
+					}
+				}(ix)
+			}
+
+			wg.Wait()
+
+			// Should run the next few gets in Parallel
+			t.Parallel()
+
+			for ix := 0; ix < 4; ix++ {
+				t.Run("Get a key", func(t *testing.T) {
+					var val int
+					r, err := c.Get("table", strconv.Itoa(ix))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if !r.Exists() {
+						t.Fatal("Value", ix, "is missing")
+					}
+
+					if err := r.Unmarshal(&val); err != nil {
+						t.Fatal(err)
+					} else {
+						assert.Equal(t, ix, val)
+					}
+
+				})
+			}
+		})
+	})
+
+	t.Run("Insert a random struct", func(t *testing.T) {
+		if err := c.Set("test", "uid1", &testItem{Foo: "foo", Id: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("Fetch the struct", func(t *testing.T) {
+			var ret testItem
+			res, err := c.Get("test", "uid1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := res.Unmarshal(&ret); err != nil {
+				t.Fatal(err)
+			} else {
+				assert.Equal(t, ret.Foo, "foo")
+				assert.Equal(t, ret.Id, 1)
+			}
+		})
+	})
+
+	t.Run("List values for table", func(t *testing.T) {
+		c.Set("test1", "uid1", nil)
+		c.Set("test1", "uid2", nil)
+		c.Set("test2", "uid3", nil)
+		items, err := c.List("test1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(items), 2)
+		expected := []string{"uid1", "uid2"}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i] < items[j]
+		})
+		assert.Equal(t, items, expected)
+	})
+
+	t.Run("List the services of a pod", func(t *testing.T) {
+		pod := "0f8fad5b-d9cb-469f-a165-70867728950e"
+		other := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+		for _, sid := range []string{"svc-1", "svc-2", "svc-3"} {
+			c.Set(makeKey(podServicesTable, pod), sid, true)
+		}
+		c.Set(makeKey(podServicesTable, other), "svc-4", true)
+
+		sids, err := c.List(makeKey(podServicesTable, pod))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(sids)
+		assert.Equal(t, sids, []string{"svc-1", "svc-2", "svc-3"})
+	})
+
+	t.Run("SetNX sets only once", func(t *testing.T) {
+		set, err := c.SetNX("claims", "uid1", 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, set, true)
+
+		set, err = c.SetNX("claims", "uid1", 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, set, false)
+
+		var val int
+		r, _ := c.Get("claims", "uid1")
+		if err := r.Unmarshal(&val); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, val, 1)
+	})
+}
+
+// blockingCache holds every Set until release is closed.
+type blockingCache struct {
+	Cachier
+	release chan struct{}
+}
+
+func (b *blockingCache) Set(table, uid string, obj interface{}) error {
+	<-b.release
+	return b.Cachier.Set(table, uid, obj)
+}
+
+func TestAsyncWrites(t *testing.T) {
+	c, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &blockingCache{Cachier: c, release: make(chan struct{})}
+	a := withAsyncWrites(slow, 4)
+
+	exists := func(table, uid string) bool {
+		r, err := c.Get(table, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Exists()
+	}
+
+	t.Run("Non-dedup writes do not block", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- a.Set(servicePodsTable, "svc", []string{"pod"}) }()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, err, nil)
+		case <-time.After(time.Second):
+			t.Fatal("Set blocked on the cache")
+		}
+
+		assert.Equal(t, exists(servicePodsTable, "svc"), false)
+	})
+
+	t.Run("Dedup writes are synchronous", func(t *testing.T) {
+		claimed, err := a.SetNX(eventCacheTable, "event", true, 0)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, true)
+		assert.Equal(t, exists(eventCacheTable, "event"), true)
+	})
+
+	t.Run("Non-dedup writes complete eventually", func(t *testing.T) {
+		close(slow.release)
+
+		deadline := time.Now().Add(time.Second)
+		for !exists(servicePodsTable, "svc") {
+			if time.Now().After(deadline) {
+				t.Fatal("Async write never landed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestCacheSweep(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := db.(*Cache)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	assert.Equal(t, c.Set(serviceTable, "old", 1), nil)
+	assert.Equal(t, c.Set(podServicesTable, "old-pod", []string{"svc"}), nil)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, c.Set(serviceTable, "fresh", 2), nil)
+
+	exists := func(table, uid string) bool {
+		r, err := c.Get(table, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Exists()
+	}
+
+	n, err := c.Sweep(serviceTable, 30*time.Minute)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+
+	n, err = c.Sweep(podServicesTable, 30*time.Minute)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+
+	assert.Equal(t, exists(serviceTable, "old"), false)
+	assert.Equal(t, exists(podServicesTable, "old-pod"), false)
+	assert.Equal(t, exists(serviceTable, "fresh"), true)
+}
+
+func TestCachePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "k8stream.db")
+	c, err := openCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &L9Event{ID: "seen-uid"}
+	markProcessed(c, []interface{}{e})
+	assert.Equal(t, c.Set(makeKey(podServicesTable, "pod-uid"), "svc-uid", true), nil)
+	assert.Equal(t, c.(*Cache).db.Close(), nil)
+
+	t.Run("Survives a restart", func(t *testing.T) {
+		c, err := openCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.(*Cache).db.Close()
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{&KubernetesClient{}, ch, c, &L9K8streamConfig{}}
+		processed, err := h.processed("seen-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, processed, true)
+
+		sids, err := c.List(makeKey(podServicesTable, "pod-uid"))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, sids, []string{"svc-uid"})
+
+		tables, err := c.Tables(makeKey(podServicesTable, ""))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, tables, []string{makeKey(podServicesTable, "pod-uid")})
+	})
+
+	t.Run("A corrupt file is kept in memory instead", func(t *testing.T) {
+		corrupt := filepath.Join(dir, "corrupt.db")
+		if err := ioutil.WriteFile(corrupt, []byte("*3\r\n$3\r\nset\r\nnot resp"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := openCache(corrupt)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, c.Set("events", "uid", 1), nil)
+	})
+}
+
+func TestDisabledCache(t *testing.T) {
+	f := newMemSink()
+	conf := newTestConfig()
+	conf.BatchSize = 10
+	conf.Cache.Disabled = true
+
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.1", Namespace: "default", UID: "nocache-uid"},
+		Reason:     "Rebooted",
+	}
+	p.Handler.OnAdd(e)
+	p.Handler.OnUpdate(e, e)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, p.Shutdown(ctx), nil)
+
+	assert.Equal(t, len(sinkLines(f)), 2)
+
+	tables, err := p.Handler.db.Tables("")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(tables), 0)
+
+	ids, err := p.Handler.db.List(eventCacheTable)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(ids), 0)
+}
+
+func TestTieredCache(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	tiered := func() *tieredCache {
+		front, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newTieredCache(front, server.Addr().String(), defaultCacheKeyPrefix, 0)
+	}
+
+	gets := func() int {
+		server.Lock()
+		defer server.Unlock()
+		return server.gets
+	}
+
+	a, b := tiered(), tiered()
+	assert.Equal(t, a.ExpireSet(eventCacheTable, "event-uid", true, objectCacheExpiry), nil)
+
+	t.Run("Warm reads hit the front", func(t *testing.T) {
+		r, err := a.Get(eventCacheTable, "event-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), true)
+		assert.Equal(t, gets(), 0)
+	})
+
+	t.Run("Misses fall back to the back", func(t *testing.T) {
+		r, err := b.Get(eventCacheTable, "event-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), true)
+		assert.Equal(t, gets(), 1)
+
+		// And warm the front.
+		r, err = b.Get(eventCacheTable, "event-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), true)
+		assert.Equal(t, gets(), 1)
+
+		r, err = b.Get(eventCacheTable, "other-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), false)
+	})
+
+	t.Run("Claims are shared", func(t *testing.T) {
+		claimed, err := a.SetNX(eventCacheTable, "claim-uid", true, 60)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, true)
+
+		claimed, err = b.SetNX(eventCacheTable, "claim-uid", true, 60)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, false)
+	})
+
+	t.Run("Memory serves while Redis is down", func(t *testing.T) {
+		front, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		down := newTieredCache(front, "127.0.0.1:1", defaultCacheKeyPrefix, 0)
+
+		assert.Equal(t, down.Set(serviceTable, "svc-uid", 1), nil)
+		r, err := down.Get(serviceTable, "svc-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), true)
+
+		claimed, err := down.SetNX(eventCacheTable, "event-uid", true, 60)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, true)
+		assert.Equal(t, down.degraded, true)
+	})
+
+	t.Run("The front evicts the least recently used", func(t *testing.T) {
+		front, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTieredCache(front, server.Addr().String(), "k8stream:evict:", 2)
+
+		inFront := func(uid string) bool {
+			r, err := front.Get(serviceTable, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r.Exists()
+		}
+
+		assert.Equal(t, c.Set(serviceTable, "a", 1), nil)
+		assert.Equal(t, c.Set(serviceTable, "b", 2), nil)
+		if _, err := c.Get(serviceTable, "a"); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.Set(serviceTable, "c", 3), nil)
+
+		assert.Equal(t, c.lru.len(), 2)
+		assert.Equal(t, inFront("a"), true)
+		assert.Equal(t, inFront("b"), false)
+		assert.Equal(t, inFront("c"), true)
+
+		// Evicted entries are read back from Redis.
+		before := gets()
+		r, err := c.Get(serviceTable, "b")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), true)
+		assert.Equal(t, gets(), before+1)
+		assert.Equal(t, c.lru.len(), 2)
+		assert.Equal(t, inFront("b"), true)
+		assert.Equal(t, inFront("a"), false)
+	})
+
+	t.Run("Listed tables are not evicted", func(t *testing.T) {
+		front, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newTieredCache(front, server.Addr().String(), "k8stream:listed:", 1)
+
+		table := makeKey(podServicesTable, "pod-uid")
+		assert.Equal(t, c.Set(table, "svc-a", true), nil)
+		assert.Equal(t, c.Set(table, "svc-b", true), nil)
+
+		sids, err := c.List(table)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, sids, []string{"svc-a", "svc-b"})
+	})
+}
@@ -0,0 +1,250 @@
+package stream
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Choices of cache.type.
+const (
+	cacheMemory = "memory"
+	cacheTiered = "tiered"
+
+	defaultCacheKeyPrefix = "k8stream:cache:"
+
+	// Entries the front holds by default before the least recently used
+	// are evicted.
+	defaultFrontEntries = 100000
+)
+
+// tieredCache is the Cachier of cache.type tiered: the in-memory cache in
+// front of Redis, that replicas share. Writes go through to both, and
+// reads are served from memory while it holds the key, so that hot keys
+// stay local while dedup records are seen cluster wide.
+// Only entries are shared: listing, dropping and sweeping tables work on
+// the front, as the tables that need them are derived by every replica
+// from what it watches.
+// The front holds up to a number of entries, evicting the least recently
+// used, that are read back from Redis when asked for again. The tables of
+// the reverse pod index are listed, so their entries are never evicted;
+// the reconciler bounds them instead.
+// While Redis is unavailable the front serves alone, which dedups only
+// within the replica, and the entries it evicts are gone.
+type tieredCache struct {
+	Cachier
+	back   *redisConn
+	prefix string
+	lru    *frontLRU
+
+	mu       sync.Mutex
+	degraded bool
+}
+
+func newTieredCache(front Cachier, addr, prefix string, entries int) *tieredCache {
+	if entries <= 0 {
+		entries = defaultFrontEntries
+	}
+
+	return &tieredCache{
+		Cachier: front,
+		back:    &redisConn{addr: addr},
+		prefix:  prefix,
+		lru:     newFrontLRU(entries),
+	}
+}
+
+type frontKey struct {
+	table, uid string
+}
+
+// frontLRU is the order in which the entries of the front were last used.
+type frontLRU struct {
+	sync.Mutex
+	max   int
+	order *list.List
+	keys  map[frontKey]*list.Element
+}
+
+func newFrontLRU(max int) *frontLRU {
+	return &frontLRU{max: max, order: list.New(), keys: map[frontKey]*list.Element{}}
+}
+
+// touch marks the entry as the most recently used, and returns the entries
+// that no longer fit.
+func (l *frontLRU) touch(table, uid string) []frontKey {
+	l.Lock()
+	defer l.Unlock()
+
+	k := frontKey{table, uid}
+	if e, ok := l.keys[k]; ok {
+		l.order.MoveToFront(e)
+		return nil
+	}
+	l.keys[k] = l.order.PushFront(k)
+
+	var evicted []frontKey
+	for l.order.Len() > l.max {
+		k := l.order.Remove(l.order.Back()).(frontKey)
+		delete(l.keys, k)
+		evicted = append(evicted, k)
+	}
+	return evicted
+}
+
+func (l *frontLRU) forget(table, uid string) {
+	l.Lock()
+	defer l.Unlock()
+
+	k := frontKey{table, uid}
+	if e, ok := l.keys[k]; ok {
+		l.order.Remove(e)
+		delete(l.keys, k)
+	}
+}
+
+func (l *frontLRU) len() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.order.Len()
+}
+
+// touch records the use of an entry of the front, evicting the least
+// recently used ones past the bound.
+func (c *tieredCache) touch(table, uid string) {
+	if strings.HasPrefix(table, makeKey(podServicesTable, "")) {
+		return
+	}
+
+	for _, k := range c.lru.touch(table, uid) {
+		if err := c.Cachier.Delete(k.table, k.uid); err != nil {
+			log.Println("Evicting", k.table, k.uid, err)
+		}
+	}
+}
+
+// backErr records whether Redis answered, logging only when that changes.
+func (c *tieredCache) backErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case err != nil && !c.degraded:
+		log.Println("Cache degraded to memory only, Redis is unavailable:", err)
+	case err == nil && c.degraded:
+		log.Println("Cache recovered, Redis is back")
+	}
+	c.degraded = err != nil
+}
+
+func (c *tieredCache) Set(table, uid string, obj interface{}) error {
+	return c.ExpireSet(table, uid, obj, 0)
+}
+
+func (c *tieredCache) ExpireSet(table, uid string, obj interface{}, expires int) error {
+	if err := c.Cachier.ExpireSet(table, uid, obj, expires); err != nil {
+		return err
+	}
+	c.touch(table, uid)
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"SET", c.prefix + makeKey(table, uid), string(b)}
+	if expires > 0 {
+		args = append(args, "EX", strconv.Itoa(expires))
+	}
+
+	_, err = c.back.do(args...)
+	c.backErr(err)
+	return nil
+}
+
+// SetNX claims in Redis, so that of all the replicas only one does, and
+// in memory only while Redis is unavailable.
+func (c *tieredCache) SetNX(table, uid string, obj interface{}, expires int) (bool, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+
+	args := []string{"SET", c.prefix + makeKey(table, uid), string(b), "NX"}
+	if expires > 0 {
+		args = append(args, "EX", strconv.Itoa(expires))
+	}
+
+	reply, err := c.back.do(args...)
+	c.backErr(err)
+	if err != nil {
+		claimed, err := c.Cachier.SetNX(table, uid, obj, expires)
+		if claimed {
+			c.touch(table, uid)
+		}
+		return claimed, err
+	}
+
+	// A nil reply means the key was set already.
+	if reply == nil {
+		return false, nil
+	}
+
+	if err := c.Cachier.ExpireSet(table, uid, obj, expires); err != nil {
+		return true, err
+	}
+	c.touch(table, uid)
+	return true, nil
+}
+
+// Get serves from memory, and otherwise from Redis, keeping what it finds
+// there in memory for a while.
+func (c *tieredCache) Get(table, uid string) (*result, error) {
+	r, err := c.Cachier.Get(table, uid)
+	if err != nil {
+		return r, err
+	}
+
+	if r.Exists() {
+		c.touch(table, uid)
+		return r, nil
+	}
+	c.lru.forget(table, uid)
+
+	reply, err := c.back.do("GET", c.prefix+makeKey(table, uid))
+	c.backErr(err)
+
+	s, ok := reply.(string)
+	if err != nil || !ok {
+		return r, nil
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal([]byte(s), &obj); err != nil {
+		return r, nil
+	}
+
+	if err := c.Cachier.ExpireSet(table, uid, obj, objectCacheExpiry); err != nil {
+		log.Println("Caching", table, uid, err)
+	} else {
+		c.touch(table, uid)
+	}
+
+	return &result{json.RawMessage(s)}, nil
+}
+
+// Delete removes the entry from both tiers, for no replica to read it back
+// from Redis.
+func (c *tieredCache) Delete(table, uid string) error {
+	if err := c.Cachier.Delete(table, uid); err != nil {
+		return err
+	}
+	c.lru.forget(table, uid)
+
+	_, err := c.back.do("DEL", c.prefix+makeKey(table, uid))
+	c.backErr(err)
+	return nil
+}
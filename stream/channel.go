@@ -0,0 +1,64 @@
+package stream
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How often the events dropped off a full channel are logged.
+const dropLogInterval = time.Minute
+
+// send hands e to the batchers. With channel_full_policy drop, an event
+// that finds the channel full is dropped rather than hold the informer up.
+// It is not marked processed, so a resync emits it again.
+func (h *Handler) send(e *L9Event) {
+	if h.conf.channelDrops == nil {
+		h.ch <- e
+		bufferedEvents.Inc()
+		return
+	}
+
+	select {
+	case h.ch <- e:
+		bufferedEvents.Inc()
+	default:
+		h.conf.channelDrops.add()
+		h.conf.Log("%v is dropped, the channel is full", e.ID)
+	}
+}
+
+// dropLog counts the events dropped off a full channel, and logs how many
+// were every interval, rather than once for every event.
+type dropLog struct {
+	mu      sync.Mutex
+	dropped int
+}
+
+func (d *dropLog) add() {
+	channelDroppedEvents.Inc()
+
+	d.mu.Lock()
+	d.dropped++
+	d.mu.Unlock()
+}
+
+// take returns the events dropped since it was last called.
+func (d *dropLog) take() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := d.dropped
+	d.dropped = 0
+	return n
+}
+
+func (d *dropLog) start(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if n := d.take(); n > 0 {
+				log.Printf("Dropped %v events in the last %v, the channel to the batchers was full", n, interval)
+			}
+		}
+	}()
+}
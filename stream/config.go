@@ -0,0 +1,451 @@
+package stream
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/last9/k8stream/io"
+)
+
+const VERSION = "0.0.4"
+
+const (
+	DEFAULT_RESYNC_INTERVAL = 120
+)
+
+// Dedup scopes
+const (
+	// Dedup state is private to this instance.
+	dedupInstance = "instance"
+
+	// Replicas claim events in Redis, and share the dedup state there.
+	dedupShared = "shared"
+)
+
+// What to do when an Event is garbage collected at the end of its TTL.
+const (
+	eventDeletesIgnore  = "ignore"
+	eventDeletesExpired = "expired"
+
+	// Reason of the marker emitted for an expired Event.
+	eventExpiredReason = "EventExpired"
+)
+
+// Policies applied to an event whose serialized size exceeds MaxEventBytes.
+const (
+	oversizeTruncate   = "truncate"
+	oversizeDrop       = "drop"
+	oversizeDeadLetter = "dead-letter"
+)
+
+// Policies applied to an event emitted while the channel to the batchers
+// is full.
+const (
+	channelBlock = "block"
+	channelDrop  = "drop"
+)
+
+type L9K8streamConfig struct {
+	io.Config  `json:"config" validate:"required"`
+	KubeConfig string      `json:"kubeconfig"`
+	Kube       KubeOptions `json:"kube"`
+
+	// Seconds between the resyncs of the informers, that hand every object
+	// they hold to the Handler again. 0 disables them, for only the changes
+	// to be handled. DEFAULT_RESYNC_INTERVAL when unset.
+	ResyncInterval *int `json:"resync_interval"`
+
+	Namespaces NamespacesConfig `json:"namespaces"`
+	Events     []string         `json:"events"`
+
+	// A curated ruleset of the events that are emitted, "critical", on top
+	// of events and filter_expression.
+	Preset        string `json:"preset"`
+	presetReasons []string
+
+	// CEL expression over each event, as serialized; events it is false
+	// for are dropped.
+	FilterExpression string `json:"filter_expression"`
+	filter           *eventFilter
+
+	EmitOOMEvents  bool   `json:"emit_oom_events"`
+	MaxEventBytes  int    `json:"max_event_bytes"`
+	OversizePolicy string `json:"oversize_policy"`
+
+	// Events held on their way from the Handler to the batchers, batch_size
+	// unless set. Once they are full the Handler waits, holding the
+	// informer up, or with ChannelFullPolicy drop, drops the event.
+	ChannelBufferSize int    `json:"channel_buffer_size"`
+	ChannelFullPolicy string `json:"channel_full_policy"`
+	channelDrops      *dropLog
+
+	Output      OutputConfig  `json:"output"`
+	Message     MessageConfig `json:"message"`
+	Diff        DiffConfig    `json:"diff"`
+	MetricsAddr string        `json:"metrics_addr"`
+
+	// Seconds between checks of the sink that /readyz reflects. 0 leaves
+	// the sink out of readiness.
+	SinkHealthInterval int `json:"sink_health_interval"`
+
+	// Batches flushed concurrently, and whether the events of an object
+	// are kept in order across them.
+	FlushWorkers  int  `json:"flush_workers"`
+	OrderByObject bool `json:"order_by_object"`
+
+	// Field of the event, by its JSON name (e.g. "reason"), that every
+	// flushed batch holds a single value of.
+	BatchByKey string `json:"batch_by_key"`
+
+	// Flush only the latest of the events of a batch with the same id, such
+	// as the updates of an Event that arrived before it was first flushed.
+	BatchCollapseDuplicates bool `json:"batch_collapse_duplicates"`
+
+	Cache CacheConfig `json:"cache"`
+
+	// Formats of the named sinks, from their "format" key.
+	sinkFormats map[string]string
+
+	// Events are held back after startup until the informers have synced,
+	// for at most this many seconds, and are checked against dedup then.
+	StartupQuietPeriod int `json:"startup_quiet_period"`
+	quiet              *quietPeriod
+
+	// Choices "ignore", "expired" (emit an EventExpired marker).
+	EventDeletes string `json:"event_deletes"`
+
+	// Kinds of objects watched besides events and services.
+	Watch WatchConfig `json:"watch"`
+
+	Snapshot SnapshotConfig `json:"snapshot"`
+
+	// Emit an Initial event for each watched object once the informers
+	// have synced, ahead of the changes to it.
+	EmitInitialState bool `json:"emit_initial_state"`
+
+	// Severity of events by reason, and the named sink for a severity.
+	SeverityRules  map[string]string `json:"severity_rules"`
+	SeverityRoutes map[string]string `json:"severity_routes"`
+
+	// Look the sink of an event up in an external routing service, ahead
+	// of the severity routes.
+	SinkRouter *SinkRouterConfig `json:"sink_router"`
+
+	// Severities whose events are flushed without waiting for the batch.
+	HighPrioritySeverities []string `json:"high_priority_severities"`
+
+	// Drop events about k8stream itself. The namespace and pod default to
+	// the POD_NAMESPACE and POD_NAME env vars set through the downward API.
+	ExcludeSelf   bool   `json:"exclude_self"`
+	SelfNamespace string `json:"self_namespace"`
+	SelfPod       string `json:"self_pod"`
+	selfUIDs      map[string]bool
+	producer      *Producer
+
+	ServiceEnrichment ServiceEnrichmentConfig `json:"service_enrichment"`
+	NodeResolve       NodeResolveConfig       `json:"node_resolve"`
+	Enrich            EnrichConfig            `json:"enrich"`
+	Dedup             DedupConfig             `json:"dedup"`
+	claims            claimStore
+	acker             Acker
+	spilled           *sync.WaitGroup
+	sequence          *sequencer
+
+	// Objects handled at once, each on a goroutine of its own. With more
+	// than one, the events of an object can be emitted out of order.
+	HandlerMaxGoroutines int `json:"handler_max_goroutines"`
+	handlerSlots         chan struct{}
+
+	// Objects are let into the Handler through the gate, until shutdown.
+	gate *gate
+
+	// Seconds that shutdown waits for the events in the pipeline to be
+	// flushed.
+	ShutdownTimeout int `json:"shutdown_timeout_seconds"`
+
+	// Seconds after which the process shuts down as on a signal, and exits
+	// cleanly for its supervisor to restart it, with whatever state built
+	// up. 0 runs it until a signal.
+	MaxProcessLifetime int `json:"max_process_lifetime_seconds"`
+
+	// Emit service events only when the selector, ports or the set of
+	// backing pods change, rather than on every resourceVersion bump.
+	ServiceTransitionsOnly bool `json:"service_transitions_only"`
+
+	// Name the Deployment, StatefulSet, Job... at the top of the owners of
+	// the pod of a Pod event.
+	ResolveWorkloads bool `json:"resolve_workloads"`
+
+	// Set scheduling_latency_ms of Scheduled events, from the creation of
+	// the pod, and observe it in k8stream_pod_scheduling_latency_seconds.
+	EmitSchedulingLatency bool `json:"emit_scheduling_latency"`
+
+	Topology TopologyConfig `json:"topology"`
+
+	Correlation CorrelationConfig `json:"correlation"`
+
+	// Emit k8stream's own metrics as a K8streamMetrics event every
+	// interval, for where nothing scrapes metrics_addr.
+	EmitSelfMetrics     bool `json:"emit_self_metrics"`
+	SelfMetricsInterval int  `json:"self_metrics_interval"`
+
+	// Summarize the evictions of a node, rather than emit each of them.
+	Storm  StormConfig `json:"storm"`
+	storms *stormCoalescer
+
+	// Seconds the last processed resourceVersion of a service is kept, to
+	// drop updates that arrive after a newer one.
+	ServiceVersionRetention int `json:"service_version_retention"`
+
+	// Seconds between prunings of dead pods from the pod -> service index.
+	// 0 never prunes.
+	PodIndexReconcileInterval int `json:"pod_index_reconcile_interval"`
+}
+
+// SetDefaults fills in the options left unset, and is called before a
+// config is used.
+func SetDefaults(c *L9K8streamConfig) {
+	if c.ResyncInterval == nil {
+		resync := DEFAULT_RESYNC_INTERVAL
+		c.ResyncInterval = &resync
+	}
+
+	if c.SelfNamespace == "" {
+		c.SelfNamespace = os.Getenv("POD_NAMESPACE")
+	}
+
+	if c.SelfPod == "" {
+		c.SelfPod = os.Getenv("POD_NAME")
+	}
+
+	c.producer = producerFromEnv()
+
+	if c.ServiceVersionRetention == 0 {
+		c.ServiceVersionRetention = objectCacheExpiry
+	}
+
+	if c.HighPrioritySeverities == nil {
+		c.HighPrioritySeverities = []string{severityCritical}
+	}
+
+	if c.EventDeletes == "" {
+		c.EventDeletes = eventDeletesIgnore
+	}
+
+	if c.Dedup.Scope == "" {
+		c.Dedup.Scope = dedupInstance
+	}
+
+	if c.Dedup.KeyPrefix == "" {
+		c.Dedup.KeyPrefix = defaultDedupKeyPrefix
+	}
+
+	if c.Cache.Type == "" {
+		c.Cache.Type = cacheMemory
+	}
+
+	if c.Cache.KeyPrefix == "" {
+		c.Cache.KeyPrefix = defaultCacheKeyPrefix
+	}
+
+	if c.Dedup.ClaimTTL == 0 {
+		c.Dedup.ClaimTTL = defaultDedupClaimTTL
+	}
+
+	if c.Output.Format == "" {
+		c.Output.Format = formatJSON
+	}
+
+	if c.NodeResolve.CacheSeconds == 0 {
+		c.NodeResolve.CacheSeconds = objectCacheExpiry
+	}
+
+	if c.Watch.Kinds == nil {
+		c.Watch.Kinds = defaultWatchKinds
+	}
+
+	if c.Enrich.InvalidReferences == "" {
+		c.Enrich.InvalidReferences = invalidReferencesEmit
+	}
+
+	if c.Enrich.RetryAttempts > 0 && c.Enrich.RetryDelayMs == 0 {
+		c.Enrich.RetryDelayMs = defaultEnrichRetryDelay
+	}
+
+	if c.Output.SchemaVersion == "" {
+		c.Output.SchemaVersion = SchemaVersion
+	}
+	c.Config.SchemaVersion = c.Output.SchemaVersion
+	c.Config.Timezone = c.Output.Timezone
+
+	if c.Diff.MaxChanges == 0 {
+		c.Diff.MaxChanges = defaultDiffMaxChanges
+	}
+
+	if c.Diff.MaxValueBytes == 0 {
+		c.Diff.MaxValueBytes = defaultDiffMaxValueBytes
+	}
+
+	if c.Diff.Redact == nil {
+		c.Diff.Redact = defaultDiffRedact
+	}
+
+	if c.SelfMetricsInterval == 0 {
+		c.SelfMetricsInterval = defaultSelfMetricsInterval
+	}
+
+	if c.Correlation.WindowSeconds == 0 {
+		c.Correlation.WindowSeconds = defaultCorrelationWindow
+	}
+
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if c.OversizePolicy == "" {
+		c.OversizePolicy = oversizeTruncate
+	}
+
+	if c.ChannelBufferSize == 0 {
+		c.ChannelBufferSize = c.BatchSize
+	}
+
+	if c.ChannelFullPolicy == "" {
+		c.ChannelFullPolicy = channelBlock
+	}
+}
+
+type DedupConfig struct {
+	Scope string `json:"scope"`
+
+	// Redis server that replicas claim events in with the shared scope.
+	// Replicas share the dedup state under the same key prefix.
+	RedisAddress string `json:"redis_address"`
+	KeyPrefix    string `json:"key_prefix"`
+
+	// Seconds an event stays claimed until it is flushed. A flushed event
+	// stays claimed for as long as the event cache keeps it.
+	ClaimTTL int `json:"claim_ttl"`
+
+	// Reasons whose recurrence is the signal. Every occurrence of such an
+	// event is emitted, rather than only the first, with the count it is
+	// at suffixed to its ID.
+	BypassReasons []string `json:"bypass_reasons"`
+}
+
+type ServiceEnrichmentConfig struct {
+	// Cap on the pods listed for a service. 0 lists all of them.
+	MaxPods int `json:"max_pods"`
+}
+
+type CacheConfig struct {
+	// Keep no cache at all, for sinks that are idempotent: events are not
+	// deduped, and the pod to service index is not kept.
+	Disabled bool `json:"disabled"`
+
+	// Choices "memory", "tiered" (memory in front of the Redis server at
+	// redis_address, shared by the replicas under key_prefix).
+	Type         string `json:"type"`
+	RedisAddress string `json:"redis_address"`
+	KeyPrefix    string `json:"key_prefix"`
+
+	// Entries the memory tier of the tiered cache holds before the least
+	// recently used are evicted. 100000 when 0.
+	FrontEntries int `json:"front_entries"`
+
+	// File that the cache is kept in, for the dedup state to survive
+	// restarts. In memory when empty.
+	Path string `json:"path"`
+
+	// Write denormalized objects in the background rather than inline.
+	AsyncWrites bool `json:"async_writes"`
+	// Writes queued before Set blocks.
+	AsyncBuffer int `json:"async_buffer"`
+
+	// Seconds after which service and pod entries that were not written
+	// again are swept. 0 keeps them until their objects are deleted.
+	ServiceTTL int `json:"service_ttl_seconds"`
+}
+
+// NamespacesConfig is which namespaces are watched. Include, when set, is
+// all that is watched, and Exclude is not watched even if included. When
+// neither is set, kube-system, kubernetes and kubernetes-dashboard are
+// excluded. It can be set as a list, which is the include list.
+type NamespacesConfig struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+func (c *NamespacesConfig) UnmarshalJSON(b []byte) error {
+	var include []string
+	if err := json.Unmarshal(b, &include); err == nil {
+		*c = NamespacesConfig{Include: include}
+		return nil
+	}
+
+	type plain NamespacesConfig
+	return json.Unmarshal(b, (*plain)(c))
+}
+
+// watches reports whether objects of namespace ns are watched, defaults
+// being excluded when the lists are not set.
+func (c *NamespacesConfig) watches(ns string, defaults []string) bool {
+	exclude := c.Exclude
+	if len(c.Include) == 0 && len(c.Exclude) == 0 {
+		exclude = defaults
+	}
+
+	if contains(ns, exclude) {
+		return false
+	}
+	return len(c.Include) == 0 || contains(ns, c.Include)
+}
+
+type WatchConfig struct {
+	// Kinds of objects that are reported, each by an informer of its own:
+	// events, services, pods, deployments and replicasets. Events and
+	// services unless set.
+	Kinds []string `json:"kinds"`
+
+	// Namespace creation, deletion and label changes.
+	Namespaces bool `json:"namespaces"`
+
+	// Quota usage crossing one of QuotaThresholds, in percent of the hard
+	// limit. The thresholds default to 80 and 100.
+	ResourceQuotas  bool  `json:"resourcequotas"`
+	QuotaThresholds []int `json:"quota_thresholds"`
+
+	// PodDisruptionBudgets turning below their desired healthy pods, or
+	// out of allowed disruptions, so that a drain would block.
+	PDB bool `json:"pdb"`
+
+	// Leader-election Leases changing holder, or not renewed for longer
+	// than LeaseStallSeconds, 60 unless set.
+	Leases            bool `json:"leases"`
+	LeaseStallSeconds int  `json:"lease_stall_seconds"`
+
+	// Certificates of kubernetes.io/tls Secrets expiring within
+	// CertExpiryWarningDays, 30 unless set.
+	Secrets               bool `json:"secrets"`
+	CertExpiryWarningDays int  `json:"cert_expiry_warning_days"`
+
+	// Fail at startup when one of the watched resources is not served,
+	// rather than watch the others.
+	RequireAllResources bool `json:"require_all_resources"`
+}
+
+// isSelf reports whether an object is k8stream's own pod, or one of the
+// controllers owning it, by the UIDs that resolveSelf found.
+// Without a known pod name the whole self namespace is treated as self.
+func (c *L9K8streamConfig) isSelf(namespace, uid string) bool {
+	if !c.ExcludeSelf || c.SelfNamespace == "" || namespace != c.SelfNamespace {
+		return false
+	}
+
+	if c.SelfPod == "" {
+		return true
+	}
+
+	return c.selfUIDs[uid]
+}
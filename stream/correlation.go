@@ -0,0 +1,59 @@
+package stream
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	correlationTable = "correlation-ids"
+
+	defaultCorrelationWindow = 300
+)
+
+// CorrelationConfig groups the events about an object, say the Scheduled,
+// Pulling, Pulled and Started of a pod, under one correlation_id.
+type CorrelationConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Seconds after the last event about an object that the next one
+	// still shares its correlation ID. Later events start a new one.
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// correlationID returns the correlation ID of the involved object uid, a
+// new one unless an event about it was seen within the window, and keeps
+// it for another window. Replicas that share a tiered cache share the IDs;
+// with the cache disabled, every event gets an ID of its own.
+func (c *CorrelationConfig) correlationID(db Cachier, uid string) (string, error) {
+	if !c.Enabled || uid == "" {
+		return "", nil
+	}
+
+	id, err := cachedCorrelationID(db, uid)
+	if err != nil || id != "" {
+		if id != "" {
+			err = db.ExpireSet(correlationTable, uid, id, c.WindowSeconds)
+		}
+		return id, err
+	}
+
+	// Of the events about a new object handled at once, the first to set
+	// its ID wins, and the others take it.
+	id = uuid.NewV4().String()
+	set, err := db.SetNX(correlationTable, uid, id, c.WindowSeconds)
+	if err != nil || set {
+		return id, err
+	}
+
+	return cachedCorrelationID(db, uid)
+}
+
+func cachedCorrelationID(db Cachier, uid string) (string, error) {
+	r, err := db.Get(correlationTable, uid)
+	if err != nil || !r.Exists() {
+		return "", err
+	}
+
+	var id string
+	return id, r.Unmarshal(&id)
+}
@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCorrelationID(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods := []*v1.Pod{}
+	for _, name := range []string{"web-1", "web-2"} {
+		pod := &v1.Pod{
+			TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "default", UID: types.UID(name + "-uid"),
+			},
+		}
+
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.ExpireSet(
+			objectCacheTable, string(pod.UID),
+			&unstructured.Unstructured{Object: obj}, objectCacheExpiry,
+		); err != nil {
+			t.Fatal(err)
+		}
+		pods = append(pods, pod)
+	}
+
+	conf := &L9K8streamConfig{Correlation: CorrelationConfig{Enabled: true}}
+	SetDefaults(conf)
+
+	ch := make(chan interface{}, 10)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+
+	correlationOf := func(pod *v1.Pod, reason string) string {
+		h.OnAdd(&v1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				UID: types.UID(pod.Name + "-" + reason), Namespace: "default",
+			},
+			InvolvedObject: v1.ObjectReference{
+				Kind: "Pod", APIVersion: "v1", UID: pod.UID,
+				Name: pod.Name, Namespace: pod.Namespace,
+			},
+			Reason:         reason,
+			FirstTimestamp: metav1.NewTime(time.Now()),
+		})
+
+		assert.Equal(t, len(ch), 1)
+		return (<-ch).(*L9Event).CorrelationID
+	}
+
+	startup := []string{}
+	for _, reason := range []string{"Scheduled", "Pulling", "Pulled", "Created", "Started"} {
+		startup = append(startup, correlationOf(pods[0], reason))
+	}
+
+	assert.NotEqual(t, startup[0], "")
+	for _, id := range startup {
+		assert.Equal(t, id, startup[0])
+	}
+
+	other := correlationOf(pods[1], "Scheduled")
+	assert.NotEqual(t, other, "")
+	assert.NotEqual(t, other, startup[0])
+
+	t.Run("A new window starts a new correlation", func(t *testing.T) {
+		c := &CorrelationConfig{Enabled: true, WindowSeconds: 1}
+		first, err := c.correlationID(db, "short-lived")
+		assert.Equal(t, err, nil)
+
+		time.Sleep(1100 * time.Millisecond)
+		next, err := c.correlationID(db, "short-lived")
+		assert.Equal(t, err, nil)
+		assert.NotEqual(t, next, first)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		id, err := (&CorrelationConfig{}).correlationID(db, string(pods[0].UID))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, id, "")
+	})
+}
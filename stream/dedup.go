@@ -0,0 +1,197 @@
+package stream
+
+import (
+	"bufio"
+	fmt "fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultDedupKeyPrefix = "k8stream:"
+
+	// Seconds a claim is held for before the event is flushed. A replica
+	// that dies holding a claim gives the event up to the others after it.
+	defaultDedupClaimTTL = 300
+
+	redisTimeout = 5 * time.Second
+)
+
+// claimStore is where replicas sharing a dedup scope claim events, so that
+// of all the replicas watching an event only one emits it.
+type claimStore interface {
+	// Claim sets id for ttl seconds, only if it is not set yet, and reports
+	// whether it did.
+	Claim(id string, ttl int) (bool, error)
+
+	// Done keeps id claimed for ttl seconds, once its event was flushed.
+	Done(id string, ttl int) error
+
+	// Release drops the claim on id, for the event to be processed again.
+	Release(id string) error
+}
+
+// redisClaims keeps claims in Redis, as keys under a prefix. Replicas that
+// use the same server and prefix share their dedup state.
+type redisClaims struct {
+	prefix string
+	redisConn
+}
+
+func newRedisClaims(addr, prefix string) *redisClaims {
+	return &redisClaims{prefix: prefix, redisConn: redisConn{addr: addr}}
+}
+
+func (c *redisClaims) Claim(id string, ttl int) (bool, error) {
+	reply, err := c.do("SET", c.prefix+id, "1", "NX", "EX", strconv.Itoa(ttl))
+	if err != nil {
+		return false, err
+	}
+
+	// A nil reply means the key was set already.
+	return reply != nil, nil
+}
+
+func (c *redisClaims) Done(id string, ttl int) error {
+	_, err := c.do("SET", c.prefix+id, "1", "EX", strconv.Itoa(ttl))
+	return err
+}
+
+func (c *redisClaims) Release(id string) error {
+	_, err := c.do("DEL", c.prefix+id)
+	return err
+}
+
+// redisConn is a connection to a Redis server, that commands are sent on
+// one at a time.
+type redisConn struct {
+	addr string
+
+	sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and reads its reply, connecting first if need be.
+// The connection is dropped on any error, to be made again on the next
+// command.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		c.conn = conn
+		c.r = bufio.NewReader(conn)
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return reply, err
+}
+
+func (c *redisConn) roundTrip(args []string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+
+	return readRESP(c.r)
+}
+
+// readRESP reads one reply of the Redis protocol. Only the replies that
+// SET, GET and DEL answer with are understood.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n+2)
+		for read := 0; read < len(b); {
+			m, err := r.Read(b[read:])
+			if err != nil {
+				return nil, err
+			}
+			read += m
+		}
+		return string(b[:n]), nil
+	}
+
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
+
+// markClaimsDone keeps the claims on a flushed batch, for as long as the
+// event cache keeps its events, the way markProcessed records them.
+func markClaimsDone(cfg *L9K8streamConfig, batch []interface{}) {
+	if cfg.claims == nil {
+		return
+	}
+
+	for _, v := range batch {
+		if err := cfg.claims.Done(v.(*L9Event).ID, objectCacheExpiry); err != nil {
+			log.Println("Keeping the claim on", v.(*L9Event).ID, err)
+		}
+	}
+}
+
+// releaseClaims gives up the claims on a batch that could not be flushed,
+// so that a replica can emit its events again.
+func releaseClaims(cfg *L9K8streamConfig, batch []interface{}) {
+	if cfg.claims == nil {
+		return
+	}
+
+	for _, v := range batch {
+		if err := cfg.claims.Release(v.(*L9Event).ID); err != nil {
+			log.Println("Releasing the claim on", v.(*L9Event).ID, err)
+		}
+	}
+}
+
+// idOf returns the ID that an event is deduped, and emitted, by.
+func (d *DedupConfig) idOf(e *v1.Event) string {
+	if contains(e.Reason, d.BypassReasons) {
+		return fmt.Sprintf("%s-%d", e.UID, e.Count)
+	}
+	return string(e.UID)
+}
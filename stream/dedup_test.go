@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeRedis understands just enough of the Redis protocol for claims and
+// the tiered cache.
+type fakeRedis struct {
+	net.Listener
+	sync.Mutex
+	keys map[string]string
+	gets int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{Listener: l, keys: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for ix := range args {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			args[ix] = strings.TrimSpace(arg)
+		}
+
+		conn.Write([]byte(f.exec(args)))
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		_, exists := f.keys[args[1]]
+		for _, a := range args[3:] {
+			if strings.ToUpper(a) == "NX" && exists {
+				return "$-1\r\n"
+			}
+		}
+		f.keys[args[1]] = args[2]
+		return "+OK\r\n"
+	case "GET":
+		f.gets++
+		v, exists := f.keys[args[1]]
+		if !exists {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		_, exists := f.keys[args[1]]
+		delete(f.keys, args[1])
+		if exists {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisClaims(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	a := newRedisClaims(server.Addr().String(), "k8stream:")
+	b := newRedisClaims(server.Addr().String(), "k8stream:")
+
+	claimed, err := a.Claim("event-uid", 60)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, true)
+
+	claimed, err = b.Claim("event-uid", 60)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, false)
+
+	t.Run("Released claims can be taken again", func(t *testing.T) {
+		assert.Equal(t, a.Release("event-uid"), nil)
+
+		claimed, err := b.Claim("event-uid", 60)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, true)
+	})
+
+	t.Run("Key prefixes keep dedup states apart", func(t *testing.T) {
+		other := newRedisClaims(server.Addr().String(), "staging:")
+		claimed, err := other.Claim("event-uid", 60)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claimed, true)
+	})
+}
+
+func TestReleaseClaimsOnFailedFlush(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	cfg := newTestConfig()
+	cfg.BatchSize = 1
+	cfg.claims = newRedisClaims(server.Addr().String(), cfg.Dedup.KeyPrefix)
+
+	for _, id := range []string{"failed", "flushed"} {
+		if _, err := cfg.claims.Claim(id, cfg.Dedup.ClaimTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failing := NewFuncFlusher(func([]*L9Event) error { return errors.New("sink down") })
+	ch := make(chan interface{}, 2)
+	ch <- &L9Event{ID: "failed"}
+	assert.NotEqual(t, doBatch(SingleSink(failing), nil, ch, nil, cfg), nil)
+
+	ch <- &L9Event{ID: "flushed"}
+	assert.Equal(t, doBatch(SingleSink(newMemSink()), nil, ch, nil, cfg), nil)
+
+	claimed, err := cfg.claims.Claim("failed", cfg.Dedup.ClaimTTL)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, true)
+
+	claimed, err = cfg.claims.Claim("flushed", cfg.Dedup.ClaimTTL)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, claimed, false)
+}
+
+func TestDedupBypassReasons(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{}
+	conf.Dedup.BypassReasons = []string{"BackOff"}
+	SetDefaults(conf)
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+
+	// The involved object is cached already, as no cluster is at hand.
+	if err := db.ExpireSet(
+		objectCacheTable, "pod-uid", &unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// occur hands the event over, and flushes what is emitted, returning
+	// its ID, or "" when nothing was emitted.
+	occur := func(uid, reason string, count int32) string {
+		h.OnUpdate(nil, &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "web", UID: "pod-uid"},
+			Reason:         reason,
+			Count:          count,
+		})
+
+		select {
+		case v := <-ch:
+			markProcessed(db, []interface{}{v})
+			return v.(*L9Event).ID
+		default:
+			return ""
+		}
+	}
+
+	assert.Equal(t, occur("backoff", "BackOff", 1), "backoff-1")
+	assert.Equal(t, occur("backoff", "BackOff", 1), "")
+	assert.Equal(t, occur("backoff", "BackOff", 2), "backoff-2")
+
+	assert.Equal(t, occur("failed", "Failed", 1), "failed")
+	assert.Equal(t, occur("failed", "Failed", 2), "")
+}
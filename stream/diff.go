@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	defaultDiffMaxChanges    = 50
+	defaultDiffMaxValueBytes = 1024
+
+	redacted = "<redacted>"
+)
+
+// Fields of the path segments whose values are never put in a diff, unless
+// configured otherwise.
+var defaultDiffRedact = []string{"password", "secret", "token", "credential"}
+
+// Metadata that changes on every update, or repeats the object, and so is
+// left out of diffs.
+var diffIgnoredPaths = map[string]bool{
+	"/metadata/resourceVersion": true,
+	"/metadata/managedFields":   true,
+	"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration": true,
+}
+
+type DiffConfig struct {
+	// Attach what changed to the events of updated services and namespaces.
+	Include bool `json:"include"`
+
+	// Changes listed, after which the diff is marked truncated.
+	MaxChanges int `json:"max_changes"`
+
+	// Size of the JSON of a value, past which it is left out.
+	MaxValueBytes int `json:"max_value_bytes"`
+
+	// Values under a field whose name contains one of these, ignoring case,
+	// are redacted.
+	Redact []string `json:"redact"`
+}
+
+// Change is one field of an object that an update changed, by its JSON
+// pointer. Old is unset when the field was added, and New when removed.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// attachDiff sets the diff of an update from old to obj on e. A diff that
+// cannot be made leaves the event without one.
+func (c *DiffConfig) attachDiff(e *L9Event, old, obj runtime.Object) {
+	if !c.Include {
+		return
+	}
+
+	changes, truncated, err := c.diff(old, obj)
+	if err != nil {
+		log.Println("Diffing", e.ID, err)
+		return
+	}
+
+	e.Diff = changes
+	e.DiffTruncated = truncated
+}
+
+// diff lists the changes from old to obj, up to MaxChanges of them, and
+// whether there were more.
+func (c *DiffConfig) diff(old, obj runtime.Object) ([]Change, bool, error) {
+	a, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return nil, false, err
+	}
+
+	b, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	d := &differ{conf: c}
+	d.walk("", a, b)
+	return d.changes, d.truncated, nil
+}
+
+type differ struct {
+	conf      *DiffConfig
+	changes   []Change
+	truncated bool
+}
+
+func (d *differ) walk(path string, a, b interface{}) {
+	if diffIgnoredPaths[path] || d.truncated {
+		return
+	}
+
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		keys := make([]string, 0, len(am)+len(bm))
+		for k := range am {
+			keys = append(keys, k)
+		}
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			d.walk(path+"/"+escapePointer(k), am[k], bm[k])
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	if len(d.changes) == d.conf.MaxChanges {
+		d.truncated = true
+		return
+	}
+
+	d.changes = append(d.changes, Change{
+		Path: path,
+		Old:  d.value(path, a),
+		New:  d.value(path, b),
+	})
+}
+
+// value is v as it goes into a change at path: redacted, left out when
+// too large, or as is.
+func (d *differ) value(path string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	lower := strings.ToLower(path)
+	for _, r := range d.conf.Redact {
+		if strings.Contains(lower, strings.ToLower(r)) {
+			return redacted
+		}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil || len(b) > d.conf.MaxValueBytes {
+		return fmt.Sprintf("<%d bytes>", len(b))
+	}
+
+	return v
+}
+
+// escapePointer escapes a key as a JSON pointer (RFC 6901) segment, since
+// label and annotation keys often contain a "/".
+func escapePointer(k string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+}
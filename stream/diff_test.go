@@ -0,0 +1,58 @@
+package stream
+
+import (
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServiceDiff(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{Diff: DiffConfig{Include: true}}
+	SetDefaults(conf)
+
+	ch := make(chan interface{}, 2)
+	h := &Handler{&KubernetesClient{Clientset: fake.NewSimpleClientset()}, ch, mCache, conf}
+
+	old := testService("1", map[string]string{"app": "a"})
+	old.Labels = map[string]string{"app.kubernetes.io/version": "1.0"}
+
+	updated := old.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Labels["app.kubernetes.io/version"] = "1.1"
+
+	h.OnUpdate(old, updated)
+	assert.Equal(t, len(ch), 1)
+
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Diff, []Change{{
+		Path: "/metadata/labels/app.kubernetes.io~1version",
+		Old:  "1.0",
+		New:  "1.1",
+	}})
+	assert.Equal(t, e.DiffTruncated, false)
+
+	t.Run("Sensitive and large values are left out", func(t *testing.T) {
+		d := &DiffConfig{MaxChanges: 2, MaxValueBytes: 8, Redact: defaultDiffRedact}
+
+		a := testService("1", nil)
+		a.Annotations = map[string]string{"example.com/owner": "team"}
+		b := a.DeepCopy()
+		b.Annotations["example.com/api-token"] = "hunter2"
+		b.Annotations["example.com/note"] = "a rather long note"
+		b.Annotations["example.com/owner"] = "platform"
+
+		changes, truncated, err := d.diff(a, b)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, truncated, true)
+		assert.Equal(t, changes, []Change{
+			{Path: "/metadata/annotations/example.com~1api-token", New: redacted},
+			{Path: "/metadata/annotations/example.com~1note", New: "<20 bytes>"},
+		})
+	})
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"net"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const defaultEnrichRetryDelay = 100
+
+// Choices of invalid_references, for events whose involved object cannot
+// be looked up.
+const (
+	invalidReferencesEmit = "emit"
+	invalidReferencesDrop = "drop"
+)
+
+// Why an involved object cannot be looked up, as counted by
+// k8stream_invalid_references_total.
+const (
+	referenceEmpty      = "empty"
+	referenceNoKind     = "no_kind"
+	referenceNoName     = "no_name"
+	referenceBadVersion = "bad_api_version"
+)
+
+type EnrichConfig struct {
+	// Retries of a lookup of the involved object or node that failed for a
+	// transient reason, after which the event is emitted without what the
+	// lookup would have added, and with enrichment_error set. 0 drops an
+	// event whose lookups fail, as before. Lookups that fail otherwise, such
+	// as for NotFound, always drop the event.
+	RetryAttempts int `json:"retry_attempts"`
+	RetryDelayMs  int `json:"retry_delay_ms"`
+
+	// What to do with an event whose involved object is empty or cannot be
+	// looked up: "emit" it without the object's details, or "drop" it.
+	InvalidReferences string `json:"invalid_references"`
+
+	// Metrics of the pod or node of an event, from Prometheus.
+	Prometheus *PrometheusConfig `json:"prometheus"`
+
+	// Datacenter and rack of the node of an event.
+	Datacenters *DatacenterConfig `json:"datacenters"`
+
+	// Labels of the namespace of an event.
+	NamespaceLabels *NamespaceLabelsConfig `json:"namespace_labels"`
+
+	// Vulnerabilities that image scanners annotated the pod of an event
+	// with.
+	ImageScan *ImageScanConfig `json:"image_scan"`
+}
+
+// invalidReference is why ref cannot be looked up, and "" when it can.
+// Cluster scoped objects have no namespace, which is fine.
+func invalidReference(ref *v1.ObjectReference) string {
+	switch {
+	case *ref == (v1.ObjectReference{}):
+		return referenceEmpty
+	case ref.Kind == "":
+		return referenceNoKind
+	case ref.Name == "":
+		return referenceNoName
+	}
+
+	if _, err := schema.ParseGroupVersion(ref.APIVersion); err != nil {
+		return referenceBadVersion
+	}
+
+	return ""
+}
+
+// retry calls fn until it succeeds, fails for a reason that a retry will not
+// fix, or runs out of attempts.
+func (c *EnrichConfig) retry(fn func() error) error {
+	err := fn()
+	for attempt := 1; err != nil && attempt <= c.RetryAttempts && transient(err); attempt++ {
+		time.Sleep(time.Duration(c.RetryDelayMs) * time.Millisecond)
+		err = fn()
+	}
+
+	return err
+}
+
+// partial reports whether an event whose lookup failed with err, after
+// retry, is still emitted: only once transient failures ran out of retries.
+func (c *EnrichConfig) partial(err error) bool {
+	return c.RetryAttempts > 0 && transient(err)
+}
+
+// transient reports whether an API call may well succeed if tried again.
+// NotFound and Forbidden, for one, will not.
+func transient(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+
+	return apierrors.IsTimeout(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err)
+}
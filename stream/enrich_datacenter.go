@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"encoding/json"
+	fmt "fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// DatacenterConfig enriches events with the datacenter and rack of their
+// node, from a static mapping file of node names, e.g.
+//
+//	{"node-a": {"datacenter": "dc1", "rack": "r1"}}
+//
+// The file is read again on SIGHUP.
+type DatacenterConfig struct {
+	MappingFile string `json:"mapping_file"`
+
+	mu    sync.RWMutex
+	nodes map[string]NodeLocation
+}
+
+// NodeLocation is where a node is, as the mapping file tells it.
+type NodeLocation struct {
+	Datacenter string `json:"datacenter"`
+	Rack       string `json:"rack"`
+}
+
+// load reads the mapping file. The mapping in use is kept if it fails.
+func (d *DatacenterConfig) load() error {
+	if d == nil {
+		return nil
+	}
+
+	if d.MappingFile == "" {
+		return fmt.Errorf("enrich.datacenters needs a mapping_file")
+	}
+
+	b, err := ioutil.ReadFile(d.MappingFile)
+	if err != nil {
+		return fmt.Errorf("reading the datacenter mapping: %w", err)
+	}
+
+	nodes := map[string]NodeLocation{}
+	if err := json.Unmarshal(b, &nodes); err != nil {
+		return fmt.Errorf("parsing the datacenter mapping %v: %w", d.MappingFile, err)
+	}
+
+	d.mu.Lock()
+	d.nodes = nodes
+	d.mu.Unlock()
+	return nil
+}
+
+// locationOf is where node is, and empty for a node that is not mapped.
+func (d *DatacenterConfig) locationOf(node string) NodeLocation {
+	if d == nil || node == "" {
+		return NodeLocation{}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nodes[node]
+}
+
+// ReloadDatacenters reads the datacenter mapping file again, for a SIGHUP.
+// Events of the nodes it maps from then on carry the new locations.
+func (p *Pipeline) ReloadDatacenters() error {
+	return p.Handler.conf.Enrich.Datacenters.load()
+}
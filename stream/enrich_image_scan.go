@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Annotations of the vulnerability counts, unless configured otherwise.
+const (
+	defaultScanCriticalKey = "scan.io/critical-count"
+	defaultScanHighKey     = "scan.io/high-count"
+	defaultScanMediumKey   = "scan.io/medium-count"
+	defaultScanLowKey      = "scan.io/low-count"
+)
+
+// ImageScanConfig surfaces the results that an image scanning admission
+// controller annotates pods with as the security of their events, e.g.
+//
+//	scan.io/critical-count: "3"
+//
+// Events of pods with enough critical vulnerabilities are critical, for the
+// severity routes to send them to security alerting.
+type ImageScanConfig struct {
+	// Annotations of the counts of vulnerabilities, by severity.
+	CriticalKey string `json:"critical_key"`
+	HighKey     string `json:"high_key"`
+	MediumKey   string `json:"medium_key"`
+	LowKey      string `json:"low_key"`
+
+	// Annotation of when the images were scanned, passed on as it is.
+	ScannedAtKey string `json:"scanned_at_key"`
+
+	// Critical vulnerabilities from which the events of a pod are critical.
+	// 0 leaves their severity to the severity rules.
+	CriticalThreshold int `json:"critical_threshold"`
+}
+
+// ImageScan is what the scanner found in the images of the pod of an
+// event.
+type ImageScan struct {
+	Critical  int    `json:"critical"`
+	High      int    `json:"high"`
+	Medium    int    `json:"medium"`
+	Low       int    `json:"low"`
+	ScannedAt string `json:"scanned_at,omitempty"`
+}
+
+// compile sets the defaults.
+func (s *ImageScanConfig) compile() error {
+	if s == nil {
+		return nil
+	}
+
+	for _, k := range []struct {
+		key *string
+		def string
+	}{
+		{&s.CriticalKey, defaultScanCriticalKey},
+		{&s.HighKey, defaultScanHighKey},
+		{&s.MediumKey, defaultScanMediumKey},
+		{&s.LowKey, defaultScanLowKey},
+	} {
+		if *k.key == "" {
+			*k.key = k.def
+		}
+	}
+	return nil
+}
+
+// scanOf reads the scan annotations of p. It is nil for a pod without any,
+// that was not scanned. Counts that do not parse are logged, and left 0.
+func (s *ImageScanConfig) scanOf(p *v1.Pod, log func(string, ...interface{})) *ImageScan {
+	if s == nil || p == nil {
+		return nil
+	}
+
+	a := p.GetAnnotations()
+	scan := &ImageScan{}
+	found := false
+	for _, c := range []struct {
+		key   string
+		count *int
+	}{
+		{s.CriticalKey, &scan.Critical},
+		{s.HighKey, &scan.High},
+		{s.MediumKey, &scan.Medium},
+		{s.LowKey, &scan.Low},
+	} {
+		v, ok := a[c.key]
+		if !ok {
+			continue
+		}
+
+		found = true
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log("Annotation %v of pod %v/%v is not a count: %q", c.key, p.GetNamespace(), p.GetName(), v)
+			continue
+		}
+		*c.count = n
+	}
+
+	if !found {
+		return nil
+	}
+
+	if s.ScannedAtKey != "" {
+		scan.ScannedAt = a[s.ScannedAtKey]
+	}
+	return scan
+}
+
+// critical reports whether scan has enough critical vulnerabilities for
+// its event to be critical.
+func (s *ImageScanConfig) critical(scan *ImageScan) bool {
+	return s != nil && scan != nil && s.CriticalThreshold > 0 && scan.Critical >= s.CriticalThreshold
+}
@@ -0,0 +1,100 @@
+package stream
+
+import (
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	namespaceLabelsTable = "namespace-labels"
+
+	defaultNamespaceLabelsCache = 300
+)
+
+// NamespaceLabelsConfig enriches events with the labels of their namespace,
+// as namespace_labels, for the team, cost-center or environment that is
+// kept on namespaces rather than on every object.
+type NamespaceLabelsConfig struct {
+	// Keys of the labels to attach. Every label when empty.
+	Keys []string `json:"keys"`
+
+	// Merge the annotations of the namespace too. A label wins over an
+	// annotation of the same key.
+	Annotations bool `json:"annotations"`
+
+	// Seconds the labels of a namespace are reused for.
+	CacheSeconds int `json:"cache_seconds"`
+}
+
+// compile sets the defaults.
+func (n *NamespaceLabelsConfig) compile() error {
+	if n == nil {
+		return nil
+	}
+
+	if n.CacheSeconds == 0 {
+		n.CacheSeconds = defaultNamespaceLabelsCache
+	}
+	return nil
+}
+
+// labelsOf returns the labels of namespace to attach to its events, from
+// the cache while they are fresh. A namespace that cannot be looked up is
+// logged, and its events go without.
+func (n *NamespaceLabelsConfig) labelsOf(
+	db Cachier, c *KubernetesClient, namespace string, log func(string, ...interface{}),
+) map[string]string {
+	if n == nil || namespace == "" || c == nil || c.Clientset == nil {
+		return nil
+	}
+
+	labels := map[string]string{}
+	if r, err := db.Get(namespaceLabelsTable, namespace); err == nil && r.Exists() {
+		if err := r.Unmarshal(&labels); err == nil {
+			return nonEmpty(labels)
+		}
+	}
+
+	ns, err := c.Clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		ns = &v1.Namespace{}
+	case err != nil:
+		log("Looking the labels of namespace %v up: %v", namespace, err)
+		return nil
+	}
+
+	if n.Annotations {
+		for k, v := range ns.GetAnnotations() {
+			labels[k] = v
+		}
+	}
+	for k, v := range ns.GetLabels() {
+		labels[k] = v
+	}
+
+	if len(n.Keys) > 0 {
+		kept := map[string]string{}
+		for _, k := range n.Keys {
+			if v, ok := labels[k]; ok {
+				kept[k] = v
+			}
+		}
+		labels = kept
+	}
+
+	// Namespaces without labels, or gone already, are cached too, for them
+	// not to be looked up on every event.
+	if err := db.ExpireSet(namespaceLabelsTable, namespace, labels, n.CacheSeconds); err != nil {
+		log("Caching the labels of namespace %v: %v", namespace, err)
+	}
+	return nonEmpty(labels)
+}
+
+func nonEmpty(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
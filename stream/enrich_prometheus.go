@@ -0,0 +1,206 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	fmt "fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	prometheusMetricsTable = "prometheus-metrics"
+
+	defaultPrometheusTimeout = 2000
+	defaultPrometheusCache   = 30
+)
+
+// PrometheusConfig enriches the events of pods and nodes with what the
+// queries tell of them at emit time, as metrics. Queries are PromQL, as
+// templates of the involved object's .Kind, .Namespace and .Name, and of
+// the .Node it runs on, e.g.
+//
+//	sum(rate(container_cpu_usage_seconds_total{namespace="{{.Namespace}}", pod="{{.Name}}"}[5m]))
+type PrometheusConfig struct {
+	URL     string            `json:"url"`
+	Queries map[string]string `json:"queries"`
+
+	// Milliseconds that all the queries of an event may take together.
+	// Queries that did not answer by then are left out.
+	TimeoutMs int `json:"timeout_ms"`
+
+	// Seconds the metrics of an object are reused for.
+	CacheSeconds int `json:"cache_seconds"`
+
+	names     []string
+	templates map[string]*template.Template
+	client    *http.Client
+}
+
+// compile checks and compiles the queries.
+func (p *PrometheusConfig) compile() error {
+	if p == nil {
+		return nil
+	}
+
+	if p.URL == "" {
+		return fmt.Errorf("enrich.prometheus needs a url")
+	}
+
+	if p.TimeoutMs == 0 {
+		p.TimeoutMs = defaultPrometheusTimeout
+	}
+
+	if p.CacheSeconds == 0 {
+		p.CacheSeconds = defaultPrometheusCache
+	}
+
+	p.templates = map[string]*template.Template{}
+	for name, q := range p.Queries {
+		t, err := template.New(name).Option("missingkey=error").Parse(q)
+		if err != nil {
+			return fmt.Errorf("enrich.prometheus query %v: %w", name, err)
+		}
+		p.templates[name] = t
+		p.names = append(p.names, name)
+	}
+	sort.Strings(p.names)
+
+	p.client = &http.Client{}
+	return nil
+}
+
+type promQuerySubject struct {
+	Kind, Namespace, Name, Node string
+}
+
+// metricsOf returns the metrics of the pod or node that e is about, from
+// the cache while they are fresh. It is nil for the events of any other
+// kind, and leaves out the queries that failed.
+func (p *PrometheusConfig) metricsOf(db Cachier, e *L9Event, log func(string, ...interface{})) map[string]float64 {
+	if p == nil || len(p.names) == 0 || e.ReferenceKind != "Pod" && e.ReferenceKind != "Node" {
+		return nil
+	}
+
+	key := strings.Join([]string{e.ReferenceKind, e.ReferenceNamespace, e.ReferenceName}, "/")
+	if r, err := db.Get(prometheusMetricsTable, key); err == nil && r.Exists() {
+		cached := map[string]float64{}
+		if err := r.Unmarshal(&cached); err == nil {
+			return cached
+		}
+	}
+
+	s := promQuerySubject{
+		Kind: e.ReferenceKind, Namespace: e.ReferenceNamespace,
+		Name: e.ReferenceName, Node: e.Host,
+	}
+	if s.Kind == "Node" {
+		s.Node = s.Name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	metrics := map[string]float64{}
+	for _, name := range p.names {
+		var q bytes.Buffer
+		if err := p.templates[name].Execute(&q, s); err != nil {
+			log("Prometheus query %v of %v: %v", name, key, err)
+			continue
+		}
+
+		v, err := p.query(ctx, q.String())
+		if err != nil {
+			log("Prometheus query %v of %v: %v", name, key, err)
+			continue
+		}
+		metrics[name] = v
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err := db.ExpireSet(prometheusMetricsTable, key, metrics, p.CacheSeconds); err != nil {
+		log("Caching the metrics of %v: %v", key, err)
+	}
+
+	return metrics
+}
+
+// query runs an instant query, and returns the value of the first sample
+// of a vector, or of a scalar.
+func (p *PrometheusConfig) query(ctx context.Context, q string) (float64, error) {
+	u := strings.TrimSuffix(p.URL, "/") + "/api/v1/query?" + url.Values{"query": {q}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+
+	if body.Status != "success" {
+		return 0, fmt.Errorf("%s: %s", resp.Status, body.Error)
+	}
+
+	// A sample is a pair of the time and the value, as a string.
+	var sample []interface{}
+	switch body.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		if len(vector) == 0 {
+			return 0, fmt.Errorf("no samples")
+		}
+		sample = vector[0].Value
+	case "scalar":
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unexpected result type %q", body.Data.ResultType)
+	}
+
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("malformed sample %v", sample)
+	}
+
+	s, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample %v", sample)
+	}
+
+	// Events are JSON, which has neither NaN nor infinities.
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, fmt.Errorf("sample %v is not a number", s)
+	}
+	return v, err
+}
@@ -0,0 +1,430 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// enrichClient serves the pod web, after failing the first fails lookups
+// of it with err.
+func enrichClient(fails int, err error) *KubernetesClient {
+	pod := &unstructured.Unstructured{}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace("default")
+	pod.SetName("web")
+	pod.SetUID("pod-uid")
+	pod.SetLabels(map[string]string{"app": "web"})
+
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), pod)
+	dc.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if fails > 0 {
+			fails--
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+
+	return &KubernetesClient{Interface: dc, RESTMapper: mapper}
+}
+
+func enrichEvent() *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.1", Namespace: "default", UID: "event-uid"},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", Name: "web", Namespace: "default", UID: "pod-uid", APIVersion: "v1",
+		},
+	}
+}
+
+func TestEnrichRetry(t *testing.T) {
+	enrich := &EnrichConfig{RetryAttempts: 2}
+	unavailable := apierrors.NewInternalError(errors.New("etcd is down"))
+
+	t.Run("Transient failures are retried", func(t *testing.T) {
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := makeL9Event(db, enrichClient(2, unavailable), enrichEvent(), enrich)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, e.Labels, map[string]string{"app": "web"})
+		assert.Equal(t, e.EnrichmentError, "")
+	})
+
+	t.Run("A partial event is emitted past the retries", func(t *testing.T) {
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := makeL9Event(db, enrichClient(3, unavailable), enrichEvent(), enrich)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(e.Labels), 0)
+		assert.NotEqual(t, e.EnrichmentError, "")
+	})
+
+	t.Run("NotFound is not retried, and drops the event", func(t *testing.T) {
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A retry would have found the pod.
+		gone := apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "web")
+		_, err = makeL9Event(db, enrichClient(1, gone), enrichEvent(), enrich)
+		assert.Equal(t, apierrors.IsNotFound(err), true)
+	})
+
+	t.Run("Without retries the event is dropped", func(t *testing.T) {
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = makeL9Event(db, enrichClient(1, unavailable), enrichEvent(), &EnrichConfig{})
+		assert.NotEqual(t, err, nil)
+	})
+}
+
+func TestInvalidReferences(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+
+	// A nil client panics on any lookup the handler should not make.
+	ch := make(chan interface{}, 1)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+
+	event := func(uid string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+			Reason:     "Rebooted",
+		}
+	}
+
+	before := testutil.ToFloat64(invalidReferences.WithLabelValues(referenceEmpty))
+
+	t.Run("Emitted without enrichment", func(t *testing.T) {
+		h.OnAdd(event("empty-ref-uid"))
+		assert.Equal(t, len(ch), 1)
+
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, "Rebooted")
+		assert.Equal(t, e.EnrichmentError, "")
+		assert.Equal(t, logged.String(), "")
+		assert.Equal(t, testutil.ToFloat64(invalidReferences.WithLabelValues(referenceEmpty)), before+1)
+	})
+
+	t.Run("Dropped when asked to", func(t *testing.T) {
+		conf.Enrich.InvalidReferences = invalidReferencesDrop
+		h.OnAdd(event("dropped-ref-uid"))
+		assert.Equal(t, len(ch), 0)
+		assert.Equal(t, testutil.ToFloat64(invalidReferences.WithLabelValues(referenceEmpty)), before+2)
+	})
+
+	t.Run("Categorized", func(t *testing.T) {
+		assert.Equal(t, invalidReference(&v1.ObjectReference{Name: "web"}), referenceNoKind)
+		assert.Equal(t, invalidReference(&v1.ObjectReference{Kind: "Pod"}), referenceNoName)
+		assert.Equal(t, invalidReference(&v1.ObjectReference{Kind: "Pod", Name: "web", APIVersion: "a/b/c"}), referenceBadVersion)
+		assert.Equal(t, invalidReference(&v1.ObjectReference{Kind: "Node", Name: "node-1", APIVersion: "v1"}), "")
+	})
+}
+
+func TestPrometheusEnrichment(t *testing.T) {
+	var queries []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("query")
+		mu.Lock()
+		queries = append(queries, q)
+		mu.Unlock()
+
+		if strings.Contains(q, "slow") {
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		w.Write([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+			{"metric": {}, "value": [1586340721.5, "0.25"]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &PrometheusConfig{
+		URL: server.URL,
+		Queries: map[string]string{
+			"cpu_cores": `sum(rate(container_cpu_usage_seconds_total{namespace="{{.Namespace}}", pod="{{.Name}}"}[5m]))`,
+		},
+	}
+	assert.Equal(t, p.compile(), nil)
+
+	e := &L9Event{ReferenceKind: "Pod", ReferenceNamespace: "default", ReferenceName: "web"}
+	assert.Equal(t, p.metricsOf(db, e, t.Logf), map[string]float64{"cpu_cores": 0.25})
+	assert.Equal(t, queries, []string{
+		`sum(rate(container_cpu_usage_seconds_total{namespace="default", pod="web"}[5m]))`,
+	})
+
+	t.Run("Metrics are reused while fresh", func(t *testing.T) {
+		assert.Equal(t, p.metricsOf(db, e, t.Logf), map[string]float64{"cpu_cores": 0.25})
+		assert.Equal(t, len(queries), 1)
+	})
+
+	t.Run("Other kinds are not queried", func(t *testing.T) {
+		assert.Equal(t, p.metricsOf(db, &L9Event{ReferenceKind: "Service"}, t.Logf) == nil, true)
+	})
+
+	t.Run("Queries that time out are left out", func(t *testing.T) {
+		slow := &PrometheusConfig{
+			URL:       server.URL,
+			Queries:   map[string]string{"slow": `slow{node="{{.Node}}"}`},
+			TimeoutMs: 50,
+		}
+		assert.Equal(t, slow.compile(), nil)
+
+		started := time.Now()
+		m := slow.metricsOf(db, &L9Event{ReferenceKind: "Node", ReferenceName: "node-1"}, t.Logf)
+		assert.Equal(t, m == nil, true)
+		assert.Equal(t, time.Since(started) < 400*time.Millisecond, true)
+	})
+}
+
+func TestDatacenterEnrichment(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "datacenters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapping := filepath.Join(dir, "datacenters.json")
+	write := func(s string) {
+		if err := ioutil.WriteFile(mapping, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"node-a": {"datacenter": "dc1", "rack": "r1"}}`)
+
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+	conf.Enrich.Datacenters = &DatacenterConfig{MappingFile: mapping}
+	assert.Equal(t, conf.Enrich.Datacenters.load(), nil)
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+
+	event := func(uid, node string) *L9Event {
+		// Addresses of the nodes are cached, for no lookup to be made.
+		assert.Equal(t, db.Set("node", node, []string{"10.0.0.1"}), nil)
+		h.OnAdd(&v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+			Reason:     "Rebooted",
+			Source:     v1.EventSource{Host: node},
+		})
+		assert.Equal(t, len(ch), 1)
+		return (<-ch).(*L9Event)
+	}
+
+	e := event("mapped-uid", "node-a")
+	assert.Equal(t, e.Datacenter, "dc1")
+	assert.Equal(t, e.Rack, "r1")
+
+	t.Run("Unmapped nodes are left empty", func(t *testing.T) {
+		e := event("unmapped-uid", "node-b")
+		assert.Equal(t, e.Datacenter, "")
+		assert.Equal(t, e.Rack, "")
+	})
+
+	t.Run("Reloaded", func(t *testing.T) {
+		write(`{"node-b": {"datacenter": "dc2", "rack": "r7"}}`)
+		assert.Equal(t, conf.Enrich.Datacenters.load(), nil)
+
+		e := event("reloaded-uid", "node-b")
+		assert.Equal(t, e.Datacenter, "dc2")
+		assert.Equal(t, e.Rack, "r7")
+	})
+
+	t.Run("A bad mapping keeps the one in use", func(t *testing.T) {
+		write(`{"node-b": `)
+		assert.NotEqual(t, conf.Enrich.Datacenters.load(), nil)
+		assert.Equal(t, conf.Enrich.Datacenters.locationOf("node-b").Datacenter, "dc2")
+	})
+}
+
+func TestNamespaceLabelsEnrichment(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "payments",
+			Labels:      map[string]string{"team": "payments", "cost-center": "cc-42", "env": "prod"},
+			Annotations: map[string]string{"owner": "payments@example.com", "team": "billing"},
+		}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "scratch"}},
+	)
+	lookups := 0
+	clientset.PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lookups++
+		return false, nil, nil
+	})
+
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+	conf.Enrich.NamespaceLabels = &NamespaceLabelsConfig{}
+	assert.Equal(t, conf.Enrich.NamespaceLabels.compile(), nil)
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{&KubernetesClient{Clientset: clientset}, ch, db, conf}
+
+	event := func(uid, namespace string) *L9Event {
+		h.OnAdd(&v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: namespace, UID: types.UID(uid)},
+			Reason:     "Rebooted",
+		})
+		assert.Equal(t, len(ch), 1)
+		return (<-ch).(*L9Event)
+	}
+
+	e := event("labeled-uid", "payments")
+	assert.Equal(t, e.NamespaceLabels, map[string]string{
+		"team": "payments", "cost-center": "cc-42", "env": "prod",
+	})
+
+	t.Run("Namespaces are cached", func(t *testing.T) {
+		e := event("cached-uid", "payments")
+		assert.Equal(t, e.NamespaceLabels["team"], "payments")
+		assert.Equal(t, lookups, 1)
+	})
+
+	t.Run("Namespaces without labels are left empty", func(t *testing.T) {
+		e := event("unlabeled-uid", "scratch")
+		assert.Equal(t, e.NamespaceLabels == nil, true)
+	})
+
+	t.Run("Missing namespaces are left empty", func(t *testing.T) {
+		e := event("missing-uid", "gone")
+		assert.Equal(t, e.NamespaceLabels == nil, true)
+
+		event("missing-again-uid", "gone")
+		assert.Equal(t, lookups, 3)
+	})
+
+	t.Run("Keys and annotations", func(t *testing.T) {
+		n := &NamespaceLabelsConfig{Keys: []string{"team", "owner"}, Annotations: true}
+		assert.Equal(t, n.compile(), nil)
+
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, n.labelsOf(db, h.client, "payments", t.Logf), map[string]string{
+			"team": "payments", "owner": "payments@example.com",
+		})
+	})
+}
+
+func TestImageScanEnrichment(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+	conf.Enrich.ImageScan = &ImageScanConfig{
+		HighKey:           "trivy.io/high",
+		ScannedAtKey:      "scan.io/scanned-at",
+		CriticalThreshold: 2,
+	}
+	assert.Equal(t, conf.Enrich.ImageScan.compile(), nil)
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+
+	pod := func(uid string, annotations map[string]string) *L9Event {
+		p := testPod(uid, uid, nil)
+		p.ResourceVersion = "1"
+		p.Annotations = annotations
+		h.OnAdd(p)
+		assert.Equal(t, len(ch), 1)
+		return (<-ch).(*L9Event)
+	}
+
+	e := pod("scanned", map[string]string{
+		"scan.io/critical-count": "3",
+		"trivy.io/high":          "12",
+		"scan.io/low-count":      "40",
+		"scan.io/scanned-at":     "2020-04-08T10:12:01Z",
+	})
+	assert.Equal(t, e.Security, &ImageScan{Critical: 3, High: 12, Low: 40, ScannedAt: "2020-04-08T10:12:01Z"})
+	assert.Equal(t, e.Severity, severityCritical)
+
+	t.Run("Below the threshold the severity rules apply", func(t *testing.T) {
+		e := pod("few-criticals", map[string]string{"scan.io/critical-count": "1"})
+		assert.Equal(t, e.Security, &ImageScan{Critical: 1})
+		assert.Equal(t, e.Severity, severityInfo)
+	})
+
+	t.Run("Unscanned pods have no security", func(t *testing.T) {
+		e := pod("unscanned", map[string]string{"scan.io/high-count": "5"})
+		assert.Equal(t, e.Security == nil, true)
+	})
+
+	t.Run("Counts that do not parse are left 0", func(t *testing.T) {
+		e := pod("garbled", map[string]string{"scan.io/critical-count": "many", "trivy.io/high": "2"})
+		assert.Equal(t, e.Security, &ImageScan{High: 2})
+	})
+}
@@ -0,0 +1,244 @@
+package stream
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+type L9Event struct {
+	ID                  string                 `json:"id"`
+	Timestamp           int64                  `json:"timestamp"`
+	Time                string                 `json:"time,omitempty"`
+	Component           string                 `json:"component"`
+	Host                string                 `json:"host"`
+	Message             string                 `json:"message"`
+	Namespace           string                 `json:"namespace"`
+	Reason              string                 `json:"reason"`
+	Type                string                 `json:"type"`
+	Severity            string                 `json:"severity"`
+	Priority            string                 `json:"priority"`
+	Count               int32                  `json:"count"`
+	FirstTimestamp      int64                  `json:"first_timestamp"`
+	LastTimestamp       int64                  `json:"last_timestamp"`
+	ReferenceUID        string                 `json:"reference_uid"`
+	ReferenceNamespace  string                 `json:"reference_namespace"`
+	ReferenceName       string                 `json:"reference_name"`
+	ReferenceKind       string                 `json:"reference_kind"`
+	ReferenceVersion    string                 `json:"reference_version"`
+	ReferenceAPIVersion string                 `json:"reference_api_version,omitempty"`
+	ObjectUid           string                 `json:"object_uid"`
+	Labels              map[string]string      `json:"labels"`
+	Annotations         map[string]string      `json:"annotations"`
+	Address             []string               `json:"address"`
+	Pod                 map[string]interface{} `json:"pod"`
+	Version             string                 `json:"version"`
+	Container           map[string]interface{} `json:"container,omitempty"`
+	TotalPods           int                    `json:"total_pods,omitempty"`
+	PodsTruncated       bool                   `json:"pods_truncated,omitempty"`
+	ProducerVersion     string                 `json:"producer_version,omitempty"`
+	SchemaVersion       string                 `json:"schema_version,omitempty"`
+	EnrichmentError     string                 `json:"enrichment_error,omitempty"`
+	Producer            *Producer              `json:"producer,omitempty"`
+	OriginalMessage     string                 `json:"original_message,omitempty"`
+	WorkloadKind        string                 `json:"workload_kind,omitempty"`
+	WorkloadName        string                 `json:"workload_name,omitempty"`
+	Edge                *Edge                  `json:"edge,omitempty"`
+	Quota               *QuotaUsage            `json:"quota,omitempty"`
+	Image               string                 `json:"image,omitempty"`
+	PullDurationMs      int64                  `json:"pull_duration_ms,omitempty"`
+	Diff                []Change               `json:"diff,omitempty"`
+	DiffTruncated       bool                   `json:"diff_truncated,omitempty"`
+	SchedulingLatencyMs int64                  `json:"scheduling_latency_ms,omitempty"`
+	Metrics             map[string]float64     `json:"metrics,omitempty"`
+	StormCount          int                    `json:"storm_count,omitempty"`
+	AffectedPods        []string               `json:"affected_pods,omitempty"`
+	CorrelationID       string                 `json:"correlation_id,omitempty"`
+	PDB                 *PDBStatus             `json:"pdb,omitempty"`
+	SelfMetrics         *SelfMetrics           `json:"self_metrics,omitempty"`
+	Datacenter          string                 `json:"datacenter,omitempty"`
+	Rack                string                 `json:"rack,omitempty"`
+	NamespaceLabels     map[string]string      `json:"namespace_labels,omitempty"`
+	Security            *ImageScan             `json:"security,omitempty"`
+	PodUID              string                 `json:"pod_uid,omitempty"`
+	PodName             string                 `json:"pod_name,omitempty"`
+	PodIP               string                 `json:"pod_ip,omitempty"`
+	PodHostIP           string                 `json:"pod_host_ip,omitempty"`
+	PodStartTime        int64                  `json:"pod_start_time,omitempty"`
+	Lease               *LeaseStatus           `json:"lease,omitempty"`
+	Certificate         *CertificateStatus     `json:"certificate,omitempty"`
+	Sequence            int64                  `json:"sequence,omitempty"`
+	Epoch               int64                  `json:"epoch,omitempty"`
+
+	// resourceVersion of the watched object this event was made from, to
+	// resume after. Not to be confused with ReferenceVersion, which is the
+	// resourceVersion of the involved object.
+	ResourceVersion string `json:"resource_version,omitempty"`
+
+	// AckToken is what the Acker is told of the event by. Its ID unless set.
+	AckToken string `json:"-"`
+
+	// pod is the decoded involved object, kept around for handlers that
+	// derive further events from the enriched Pod. Never serialized.
+	pod *v1.Pod
+
+	// raw is the object this event was made from, written instead of the
+	// event with the raw output format.
+	raw interface{}
+
+	// serviceState is the state of the service this event reports a
+	// transition of, recorded when the event is flushed.
+	serviceState *serviceState
+}
+
+func makeL9Event(
+	db Cachier, c *KubernetesClient, e *v1.Event, enrich *EnrichConfig,
+) (*L9Event, error) {
+	var enrichErrs []string
+
+	// An involved object that cannot be looked up is left out, as onEvent
+	// counted it already.
+	var u *unstructured.Unstructured
+	if invalidReference(&e.InvolvedObject) == "" {
+		err := enrich.retry(func() (err error) {
+			u, err = c.getObject(db, &e.InvolvedObject)
+			return err
+		})
+		if err != nil {
+			if !enrich.partial(err) {
+				return nil, err
+			}
+			enrichErrs = append(enrichErrs, err.Error())
+		}
+	}
+
+	var address []string
+	err := enrich.retry(func() (err error) {
+		address, err = c.getNodeAddress(db, e.Source.Host)
+		return err
+	})
+	if err != nil {
+		if !enrich.partial(err) {
+			return nil, err
+		}
+		enrichErrs = append(enrichErrs, err.Error())
+	}
+
+	ne, err := makeL9EventDetails(db, e, u, address)
+	if err != nil {
+		return nil, err
+	}
+
+	ne.EnrichmentError = strings.Join(enrichErrs, "; ")
+	return ne, nil
+}
+
+// Producer is the k8stream instance that emitted an event, as the downward
+// API tells it.
+type Producer struct {
+	Node      string `json:"node,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// producerFromEnv reads the downward API variables, and returns nil outside
+// a cluster, where none are set.
+func producerFromEnv() *Producer {
+	p := &Producer{
+		Node:      os.Getenv("NODE_NAME"),
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+	}
+
+	if *p == (Producer{}) {
+		return nil
+	}
+	return p
+}
+
+func makeL9EventDetails(db Cachier, e *v1.Event, u *unstructured.Unstructured, address []string) (*L9Event, error) {
+	ne := &L9Event{
+		raw:                 e,
+		ID:                  string(e.UID),
+		Timestamp:           e.CreationTimestamp.Time.Unix(),
+		Component:           e.Source.Component,
+		Host:                e.Source.Host,
+		Message:             e.Message,
+		Namespace:           e.Namespace,
+		Reason:              e.Reason,
+		Type:                e.Type,
+		Count:               e.Count,
+		FirstTimestamp:      unixTime(e.FirstTimestamp),
+		LastTimestamp:       unixTime(e.LastTimestamp),
+		ReferenceUID:        string(e.InvolvedObject.UID),
+		ReferenceName:       e.InvolvedObject.Name,
+		ReferenceVersion:    e.InvolvedObject.ResourceVersion,
+		ReferenceAPIVersion: e.InvolvedObject.APIVersion,
+		ReferenceNamespace:  e.InvolvedObject.Namespace,
+		ReferenceKind:       e.InvolvedObject.Kind,
+		ObjectUid:           string(e.InvolvedObject.UID),
+		ResourceVersion:     e.ResourceVersion,
+		Address:             address,
+		Version:             VERSION,
+	}
+
+	if u != nil {
+		ne.Labels = u.GetLabels()
+		ne.Annotations = u.GetAnnotations()
+		if err := addPodDetails(db, ne, u); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return ne, nil
+}
+
+// unixTime is 0 for an unset timestamp, rather than year 1.
+func unixTime(t metav1.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func addPodDetails(db Cachier, ne *L9Event, u *unstructured.Unstructured) error {
+	p, err := unstructuredToPod(u)
+	if err != nil {
+		return err
+	}
+
+	ne.Pod = miniPodInfo(*p)
+	ne.pod = p
+	return err
+}
+
+func miniPodInfo(p v1.Pod) map[string]interface{} {
+	ne := map[string]interface{}{}
+	ne["uid"] = p.GetUID()
+	ne["name"] = p.GetName()
+	ne["namespace"] = p.GetNamespace()
+	ne["start_time"] = p.Status.StartTime
+	ne["ip"] = p.Status.PodIP
+	ne["host_ip"] = p.Status.HostIP
+	return ne
+}
+
+func unstructuredToPod(obj *unstructured.Unstructured) (*v1.Pod, error) {
+	json, err := runtime.Encode(unstructured.UnstructuredJSONScheme, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := new(v1.Pod)
+	err = runtime.DecodeInto(clientscheme.Codecs.LegacyCodec(v1.SchemeGroupVersion), json, pod)
+	pod.Kind = ""
+	pod.APIVersion = ""
+	return pod, err
+}
@@ -0,0 +1,137 @@
+package stream
+
+import (
+	fmt "fmt"
+	"time"
+
+	coordination "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Reasons of Lease events.
+const (
+	// Another holder took the lease over, as on a leader failover.
+	leaseFailoverReason = "LeaseHolderChanged"
+
+	// The holder did not renew the lease for longer than the stall
+	// threshold.
+	leaseStalledReason = "LeaseRenewalStalled"
+)
+
+const defaultLeaseStallSeconds = 60
+
+// LeaseStatus is the lease of a Lease event.
+type LeaseStatus struct {
+	Holder           string `json:"holder"`
+	PreviousHolder   string `json:"previous_holder,omitempty"`
+	RenewTime        int64  `json:"renew_time,omitempty"`
+	DurationSeconds  int32  `json:"duration_seconds,omitempty"`
+	LeaseTransitions int32  `json:"lease_transitions,omitempty"`
+}
+
+func leaseHolder(l *coordination.Lease) string {
+	if l.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *l.Spec.HolderIdentity
+}
+
+// leaseStalled reports whether the lease was last renewed more than stall
+// ago. A lease that nobody holds is not renewed by anyone.
+func leaseStalled(l *coordination.Lease, stall time.Duration, now time.Time) bool {
+	if leaseHolder(l) == "" || l.Spec.RenewTime == nil {
+		return false
+	}
+	return now.Sub(l.Spec.RenewTime.Time) > stall
+}
+
+// onLease reports a leader-election Lease changing hands, or not being
+// renewed for longer than watch.lease_stall_seconds. A stalled lease is not
+// updated, so the stall is noticed on a resync of the informer. Unlike the
+// other watched objects, leases of kube-system are reported unless it is
+// excluded, as that is where the control plane keeps its own.
+func (h *Handler) onLease(old, l *coordination.Lease) error {
+	if old == nil {
+		return nil
+	}
+
+	if !h.conf.Namespaces.watches(l.GetNamespace(), nil) {
+		return nil
+	}
+
+	stall := time.Duration(h.conf.Watch.LeaseStallSeconds) * time.Second
+	if stall == 0 {
+		stall = defaultLeaseStallSeconds * time.Second
+	}
+
+	var reason string
+	switch {
+	case leaseHolder(old) != "" && leaseHolder(l) != leaseHolder(old):
+		reason = leaseFailoverReason
+	case leaseStalled(l, stall, time.Now()):
+		reason = leaseStalledReason
+	default:
+		return nil
+	}
+
+	eventId := fmt.Sprintf("%s-%s", l.GetUID(), l.GetResourceVersion())
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("Lease %v was processed already", eventId)
+		return nil
+	}
+
+	h.emit(makeL9LeaseEvent(eventId, old, l, reason))
+	return nil
+}
+
+func makeL9LeaseEvent(eventID string, old, l *coordination.Lease, reason string) *L9Event {
+	status := &LeaseStatus{Holder: leaseHolder(l)}
+	if l.Spec.RenewTime != nil {
+		status.RenewTime = l.Spec.RenewTime.Unix()
+	}
+	if l.Spec.LeaseDurationSeconds != nil {
+		status.DurationSeconds = *l.Spec.LeaseDurationSeconds
+	}
+	if l.Spec.LeaseTransitions != nil {
+		status.LeaseTransitions = *l.Spec.LeaseTransitions
+	}
+
+	message := fmt.Sprintf(
+		"Lease %s held by %s was not renewed since %s",
+		l.GetName(), status.Holder, time.Unix(status.RenewTime, 0).UTC().Format(time.RFC3339),
+	)
+	if reason == leaseFailoverReason {
+		status.PreviousHolder = leaseHolder(old)
+		message = fmt.Sprintf(
+			"Lease %s moved from %s to %s",
+			l.GetName(), status.PreviousHolder, status.Holder,
+		)
+	}
+
+	return &L9Event{
+		raw:                l,
+		ID:                 eventID,
+		Timestamp:          time.Now().Unix(),
+		Component:          l.GetName(),
+		Message:            message,
+		Namespace:          l.GetNamespace(),
+		Reason:             reason,
+		Type:               v1.EventTypeWarning,
+		ReferenceUID:       string(l.GetUID()),
+		ReferenceNamespace: l.GetNamespace(),
+		ReferenceName:      l.GetName(),
+		ReferenceKind:      "Lease",
+		ReferenceVersion:   l.GetResourceVersion(),
+		ResourceVersion:    l.GetResourceVersion(),
+		ObjectUid:          string(l.GetUID()),
+		Labels:             l.GetLabels(),
+		Annotations:        l.GetAnnotations(),
+		Version:            VERSION,
+		Lease:              status,
+	}
+}
@@ -0,0 +1,89 @@
+package stream
+
+import (
+	fmt "fmt"
+	"reflect"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Reasons of namespace lifecycle events.
+const (
+	namespaceCreated       = "NamespaceCreated"
+	namespaceDeleted       = "NamespaceDeleted"
+	namespaceLabelsChanged = "LabelsChanged"
+)
+
+// namespaceReason is the reason to report a namespace update for; updates
+// that leave its labels and annotations alone, like status changes, are
+// not reported.
+func namespaceReason(old, ns *v1.Namespace) string {
+	if old == nil {
+		return ""
+	}
+
+	if !reflect.DeepEqual(old.GetLabels(), ns.GetLabels()) ||
+		!reflect.DeepEqual(old.GetAnnotations(), ns.GetAnnotations()) {
+		return namespaceLabelsChanged
+	}
+
+	return ""
+}
+
+// onNamespace reports the creation and deletion of a namespace, and the
+// changes of its labels and annotations, for a governance audit trail.
+// Namespaces are cluster scoped and so are not subject to the namespaces
+// the config restricts events to. old is the previous version of an
+// updated namespace, and nil otherwise.
+func (h *Handler) onNamespace(old, ns *v1.Namespace, reason string) error {
+	if reason == "" {
+		return nil
+	}
+
+	eventId := fmt.Sprintf("%s-%s", ns.GetUID(), ns.GetResourceVersion())
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("Namespace %v was processed already", ns.GetUID())
+		return nil
+	}
+
+	event := makeL9NamespaceEvent(eventId, ns, reason)
+	if old != nil {
+		h.conf.Diff.attachDiff(event, old, ns)
+	}
+
+	h.emit(event)
+	return nil
+}
+
+func makeL9NamespaceEvent(eventID string, ns *v1.Namespace, reason string) *L9Event {
+	ts := time.Now().Unix()
+	if reason == namespaceCreated {
+		ts = ns.GetCreationTimestamp().Unix()
+	}
+
+	return &L9Event{
+		raw:              ns,
+		ID:               eventID,
+		Timestamp:        ts,
+		Component:        ns.GetName(),
+		Message:          fmt.Sprintf("Namespace %s: %s", ns.GetName(), reason),
+		Namespace:        ns.GetName(),
+		Reason:           reason,
+		Type:             v1.EventTypeNormal,
+		ReferenceUID:     string(ns.GetUID()),
+		ReferenceName:    ns.GetName(),
+		ReferenceKind:    "Namespace",
+		ReferenceVersion: ns.GetResourceVersion(),
+		ResourceVersion:  ns.GetResourceVersion(),
+		ObjectUid:        string(ns.GetUID()),
+		Labels:           ns.GetLabels(),
+		Annotations:      ns.GetAnnotations(),
+		Version:          VERSION,
+	}
+}
@@ -0,0 +1,87 @@
+package stream
+
+import (
+	fmt "fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Verbs of the reasons of object events, e.g. updatedDeployment, as with
+// services.
+const (
+	objectAdded   = "added"
+	objectUpdated = "updated"
+	objectDeleted = "deleted"
+)
+
+// onObject reports a pod, deployment or replica set of watch.kinds being
+// added, updated or deleted. old is the previous version of an updated
+// object, and nil otherwise.
+func (h *Handler) onObject(old, obj runtime.Object, kind, verb string) error {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	// Resyncs of an object that did not change.
+	if o, err := meta.Accessor(old); err == nil && o.GetResourceVersion() == m.GetResourceVersion() {
+		return nil
+	}
+
+	ns := m.GetNamespace()
+	if !h.conf.Namespaces.watches(ns, skipNamespaces) {
+		return nil
+	}
+
+	eventId := fmt.Sprintf("%s-%s", m.GetUID(), m.GetResourceVersion())
+	if verb == objectDeleted {
+		eventId += "-deleted"
+	}
+
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("%v %v was processed already", kind, eventId)
+		return nil
+	}
+
+	reason := verb + kind
+	event := &L9Event{
+		raw:                obj,
+		ID:                 eventId,
+		Timestamp:          time.Now().Unix(),
+		Component:          m.GetName(),
+		Message:            fmt.Sprintf("%s %s: %s", kind, m.GetName(), reason),
+		Namespace:          ns,
+		Reason:             reason,
+		Type:               v1.EventTypeNormal,
+		ReferenceUID:       string(m.GetUID()),
+		ReferenceNamespace: ns,
+		ReferenceName:      m.GetName(),
+		ReferenceKind:      kind,
+		ReferenceVersion:   m.GetResourceVersion(),
+		ResourceVersion:    m.GetResourceVersion(),
+		ObjectUid:          string(m.GetUID()),
+		Labels:             m.GetLabels(),
+		Annotations:        m.GetAnnotations(),
+		Version:            VERSION,
+	}
+
+	if p, ok := obj.(*v1.Pod); ok {
+		event.Pod = miniPodInfo(*p)
+		event.pod = p
+	}
+
+	if old != nil {
+		h.conf.Diff.attachDiff(event, old, obj)
+	}
+
+	h.emit(event)
+	return nil
+}
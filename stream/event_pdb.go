@@ -0,0 +1,130 @@
+package stream
+
+import (
+	fmt "fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of PodDisruptionBudget events.
+const (
+	// Fewer pods are healthy than the budget asks for.
+	pdbUnhealthyReason = "PDBBelowDesiredHealthy"
+
+	// No pod may be evicted, so a drain of one of their nodes would block.
+	pdbBlockingReason = "PDBDisruptionsExhausted"
+)
+
+// PDBStatus is the budget and status of the PodDisruptionBudget of an event.
+type PDBStatus struct {
+	Selector           string `json:"selector"`
+	MinAvailable       string `json:"min_available,omitempty"`
+	MaxUnavailable     string `json:"max_unavailable,omitempty"`
+	DisruptionsAllowed int32  `json:"disruptions_allowed"`
+	CurrentHealthy     int32  `json:"current_healthy"`
+	DesiredHealthy     int32  `json:"desired_healthy"`
+	ExpectedPods       int32  `json:"expected_pods"`
+}
+
+// pdbViolation is the reason a budget in status s is violated, or empty.
+// Being below the desired health is reported over the exhausted
+// disruptions that it causes. A budget selecting no pods blocks nothing.
+func pdbViolation(s policy.PodDisruptionBudgetStatus) string {
+	switch {
+	case s.ExpectedPods == 0:
+		return ""
+	case s.CurrentHealthy < s.DesiredHealthy:
+		return pdbUnhealthyReason
+	case s.PodDisruptionsAllowed == 0:
+		return pdbBlockingReason
+	}
+	return ""
+}
+
+// onPDB reports a PodDisruptionBudget turning violated, or violated in
+// another way, since its previous version. Like quotas, budgets are only
+// compared across updates, so one violated when k8stream starts is not
+// reported until it changes.
+func (h *Handler) onPDB(old, pdb *policy.PodDisruptionBudget) error {
+	if old == nil {
+		return nil
+	}
+
+	ns := pdb.GetNamespace()
+	if !h.conf.Namespaces.watches(ns, skipNamespaces) {
+		return nil
+	}
+
+	reason := pdbViolation(pdb.Status)
+	if reason == "" || reason == pdbViolation(old.Status) {
+		return nil
+	}
+
+	eventId := fmt.Sprintf("%s-%s", pdb.GetUID(), pdb.GetResourceVersion())
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("PodDisruptionBudget %v was processed already", eventId)
+		return nil
+	}
+
+	h.emit(makeL9PDBEvent(eventId, pdb, reason))
+	return nil
+}
+
+func makeL9PDBEvent(eventID string, pdb *policy.PodDisruptionBudget, reason string) *L9Event {
+	s := pdb.Status
+
+	status := &PDBStatus{
+		Selector:           metav1.FormatLabelSelector(pdb.Spec.Selector),
+		DisruptionsAllowed: s.PodDisruptionsAllowed,
+		CurrentHealthy:     s.CurrentHealthy,
+		DesiredHealthy:     s.DesiredHealthy,
+		ExpectedPods:       s.ExpectedPods,
+	}
+	if pdb.Spec.MinAvailable != nil {
+		status.MinAvailable = pdb.Spec.MinAvailable.String()
+	}
+	if pdb.Spec.MaxUnavailable != nil {
+		status.MaxUnavailable = pdb.Spec.MaxUnavailable.String()
+	}
+
+	message := fmt.Sprintf(
+		"PodDisruptionBudget %s allows no disruptions, evictions of %s would block",
+		pdb.GetName(), status.Selector,
+	)
+	if reason == pdbUnhealthyReason {
+		message = fmt.Sprintf(
+			"PodDisruptionBudget %s has %d of %d desired healthy pods",
+			pdb.GetName(), s.CurrentHealthy, s.DesiredHealthy,
+		)
+	}
+
+	return &L9Event{
+		raw:                pdb,
+		ID:                 eventID,
+		Timestamp:          time.Now().Unix(),
+		Component:          pdb.GetName(),
+		Message:            message,
+		Namespace:          pdb.GetNamespace(),
+		Reason:             reason,
+		Type:               v1.EventTypeWarning,
+		ReferenceUID:       string(pdb.GetUID()),
+		ReferenceNamespace: pdb.GetNamespace(),
+		ReferenceName:      pdb.GetName(),
+		ReferenceKind:      "PodDisruptionBudget",
+		ReferenceVersion:   pdb.GetResourceVersion(),
+		ResourceVersion:    pdb.GetResourceVersion(),
+		ObjectUid:          string(pdb.GetUID()),
+		Labels:             pdb.GetLabels(),
+		Annotations:        pdb.GetAnnotations(),
+		Version:            VERSION,
+		PDB:                status,
+	}
+}
@@ -0,0 +1,139 @@
+package stream
+
+import (
+	fmt "fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const quotaThresholdReason = "QuotaThresholdCrossed"
+
+// Percentages of a hard quota limit reported on, unless configured.
+var defaultQuotaThresholds = []int{80, 100}
+
+// QuotaUsage is the usage of one resource of a QuotaThresholdCrossed event.
+type QuotaUsage struct {
+	Resource  string  `json:"resource"`
+	Used      string  `json:"used"`
+	Hard      string  `json:"hard"`
+	Percent   float64 `json:"percent"`
+	Threshold int     `json:"threshold"`
+}
+
+// quotaPercent is the percentage of the hard limit of a resource that is
+// used, and false for a resource without a hard limit.
+func quotaPercent(s v1.ResourceQuotaStatus, r v1.ResourceName) (float64, bool) {
+	hard, ok := s.Hard[r]
+	if !ok || hard.IsZero() {
+		return 0, false
+	}
+
+	used := s.Used[r]
+	return float64(used.MilliValue()) * 100 / float64(hard.MilliValue()), true
+}
+
+// crossedThreshold is the highest of the thresholds that usage rose to
+// from old to now, and 0 when it crossed none.
+func crossedThreshold(thresholds []int, old, now float64) int {
+	crossed := 0
+	for _, t := range thresholds {
+		if old < float64(t) && now >= float64(t) && t > crossed {
+			crossed = t
+		}
+	}
+	return crossed
+}
+
+// onResourceQuota reports the resources of a quota whose usage rose past
+// one of the configured thresholds since the previous version. Quotas are
+// only compared across updates, so one already past a threshold when
+// k8stream starts is not reported until it crosses the next.
+func (h *Handler) onResourceQuota(old, q *v1.ResourceQuota) error {
+	if old == nil {
+		return nil
+	}
+
+	ns := q.GetNamespace()
+	if !h.conf.Namespaces.watches(ns, skipNamespaces) {
+		return nil
+	}
+
+	thresholds := h.conf.Watch.QuotaThresholds
+	if len(thresholds) == 0 {
+		thresholds = defaultQuotaThresholds
+	}
+
+	resources := make([]string, 0, len(q.Status.Hard))
+	for r := range q.Status.Hard {
+		resources = append(resources, string(r))
+	}
+	sort.Strings(resources)
+
+	for _, r := range resources {
+		now, ok := quotaPercent(q.Status, v1.ResourceName(r))
+		if !ok {
+			continue
+		}
+
+		was, _ := quotaPercent(old.Status, v1.ResourceName(r))
+		t := crossedThreshold(thresholds, was, now)
+		if t == 0 {
+			continue
+		}
+
+		eventId := fmt.Sprintf("%s-%s-%s", q.GetUID(), q.GetResourceVersion(), r)
+		processed, err := h.processed(eventId)
+		if err != nil {
+			return err
+		}
+
+		if processed {
+			h.conf.Log("ResourceQuota %v was processed already", eventId)
+			continue
+		}
+
+		h.emit(makeL9QuotaEvent(eventId, q, r, now, t))
+	}
+
+	return nil
+}
+
+func makeL9QuotaEvent(
+	eventID string, q *v1.ResourceQuota, r string, percent float64, threshold int,
+) *L9Event {
+	used := q.Status.Used[v1.ResourceName(r)]
+	hard := q.Status.Hard[v1.ResourceName(r)]
+
+	return &L9Event{
+		raw:       q,
+		ID:        eventID,
+		Timestamp: time.Now().Unix(),
+		Component: q.GetName(),
+		Message: fmt.Sprintf(
+			"ResourceQuota %s: %s is at %.0f%% of its hard limit (%s of %s)",
+			q.GetName(), r, percent, used.String(), hard.String(),
+		),
+		Namespace:          q.GetNamespace(),
+		Reason:             quotaThresholdReason,
+		Type:               v1.EventTypeWarning,
+		ReferenceUID:       string(q.GetUID()),
+		ReferenceNamespace: q.GetNamespace(),
+		ReferenceName:      q.GetName(),
+		ReferenceKind:      "ResourceQuota",
+		ReferenceVersion:   q.GetResourceVersion(),
+		ResourceVersion:    q.GetResourceVersion(),
+		ObjectUid:          string(q.GetUID()),
+		Labels:             q.GetLabels(),
+		Annotations:        q.GetAnnotations(),
+		Version:            VERSION,
+		Quota: &QuotaUsage{
+			Resource:  r,
+			Used:      used.String(),
+			Hard:      hard.String(),
+			Percent:   percent,
+			Threshold: threshold,
+		},
+	}
+}
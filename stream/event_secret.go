@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	fmt "fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// The certificate of a TLS Secret expires within the warning days.
+const certExpiringReason = "CertificateExpiring"
+
+const defaultCertExpiryWarningDays = 30
+
+// CertificateStatus is the certificate of a CertificateExpiring event. It
+// describes the certificate and never carries the certificate or the key.
+type CertificateStatus struct {
+	Secret        string   `json:"secret"`
+	Subject       string   `json:"subject"`
+	SANs          []string `json:"sans,omitempty"`
+	NotAfter      int64    `json:"not_after"`
+	DaysRemaining int      `json:"days_remaining"`
+}
+
+// leafCertificate is the first certificate of the tls.crt of a Secret, the
+// one that a chain starts with.
+func leafCertificate(s *v1.Secret) (*x509.Certificate, error) {
+	rest := s.Data[v1.TLSCertKey]
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate in %v", v1.TLSCertKey)
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+func certificateSANs(c *x509.Certificate) []string {
+	sans := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// onSecret reports the certificate of a kubernetes.io/tls Secret expiring
+// within watch.cert_expiry_warning_days. Secrets are looked at again on
+// each resync of the informer, which is what notices a certificate coming
+// closer to expiry while its Secret does not change. It is reported once
+// per day remaining.
+func (h *Handler) onSecret(s *v1.Secret) error {
+	if s.Type != v1.SecretTypeTLS {
+		return nil
+	}
+
+	if !h.conf.Namespaces.watches(s.GetNamespace(), skipNamespaces) {
+		return nil
+	}
+
+	cert, err := leafCertificate(s)
+	if err != nil {
+		h.conf.Log("Secret %v/%v: %v", s.GetNamespace(), s.GetName(), err)
+		return nil
+	}
+
+	days := h.conf.Watch.CertExpiryWarningDays
+	if days == 0 {
+		days = defaultCertExpiryWarningDays
+	}
+
+	left := time.Until(cert.NotAfter)
+	if left > time.Duration(days)*24*time.Hour {
+		return nil
+	}
+
+	remaining := int(left / (24 * time.Hour))
+	eventId := fmt.Sprintf("%s-%d-expiring-%d", s.GetUID(), cert.NotAfter.Unix(), remaining)
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("Secret %v was processed already", eventId)
+		return nil
+	}
+
+	h.emit(makeL9CertificateEvent(eventId, s, cert, remaining))
+	return nil
+}
+
+// makeL9CertificateEvent leaves the raw object and the annotations out, so
+// that neither output.format raw nor a last-applied-configuration writes
+// the Secret, key and all.
+func makeL9CertificateEvent(eventID string, s *v1.Secret, cert *x509.Certificate, remaining int) *L9Event {
+	status := &CertificateStatus{
+		Secret:        s.GetName(),
+		Subject:       cert.Subject.String(),
+		SANs:          certificateSANs(cert),
+		NotAfter:      cert.NotAfter.Unix(),
+		DaysRemaining: remaining,
+	}
+
+	message := fmt.Sprintf(
+		"Certificate of Secret %s for %s expires in %d days, on %s",
+		s.GetName(), strings.Join(status.SANs, ", "), remaining,
+		cert.NotAfter.UTC().Format(time.RFC3339),
+	)
+	if cert.NotAfter.Before(time.Now()) {
+		message = fmt.Sprintf(
+			"Certificate of Secret %s for %s expired on %s",
+			s.GetName(), strings.Join(status.SANs, ", "),
+			cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+
+	return &L9Event{
+		ID:                 eventID,
+		Timestamp:          time.Now().Unix(),
+		Component:          s.GetName(),
+		Message:            message,
+		Namespace:          s.GetNamespace(),
+		Reason:             certExpiringReason,
+		Type:               v1.EventTypeWarning,
+		ReferenceUID:       string(s.GetUID()),
+		ReferenceNamespace: s.GetNamespace(),
+		ReferenceName:      s.GetName(),
+		ReferenceKind:      "Secret",
+		ReferenceVersion:   s.GetResourceVersion(),
+		ResourceVersion:    s.GetResourceVersion(),
+		ObjectUid:          string(s.GetUID()),
+		Labels:             s.GetLabels(),
+		Version:            VERSION,
+		Certificate:        status,
+	}
+}
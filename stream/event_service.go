@@ -0,0 +1,231 @@
+package stream
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// getServicePods returns at most maxPods of the pods behind a service, along
+// with the total number of pods found. A maxPods of 0 returns all of them.
+func getServicePods(c *KubernetesClient, db Cachier, s *v1.Service, maxPods int) ([]v1.Pod, int, error) {
+	suid := string(s.GetUID())
+
+	// Find all PODS for this service so that a rerverse lookup is possible.
+	podIndexLock.Lock()
+	pods, err := c.getPods(db, s)
+	podIndexLock.Unlock()
+	if err != nil {
+		return pods, len(pods), err
+	}
+
+	// A service behind a huge Deployment would otherwise bloat both the
+	// cache and the event. Sort so the same pods are kept every time.
+	kept := pods
+	if maxPods > 0 && len(pods) > maxPods {
+		sort.Slice(pods, func(i, j int) bool {
+			return pods[i].GetName() < pods[j].GetName()
+		})
+		kept = pods[:maxPods]
+	}
+
+	// Save service -> pods
+	if err := db.Set(servicePodsTable, suid, kept); err != nil {
+		return kept, len(pods), err
+	}
+
+	// Also save pod -> service denormalized for reverse Index lookup, for
+	// the same pods that the service event carries.
+	for _, p := range kept {
+		// A pod may be behind multiple services. Each service is a key of
+		// its own in the table of the pod rather than an entry of a list
+		// read and set again, so services of the same pod handled at once
+		// cannot overwrite one another.
+		if err := db.Set(
+			makeKey(podServicesTable, string(p.GetUID())), suid, true,
+		); err != nil {
+			return kept, len(pods), err
+		}
+	}
+
+	return kept, len(pods), nil
+}
+
+/*
+func getServiceApps(c *KubernetesClient, db Cachier, s *v1.Service) ([]appsv1.Deployment, error) {
+	suid := string(s.GetUID())
+
+	// Find all Replication Controllers for this service
+	// so that a rerverse lookup is possible.
+	apps, err := c.getApps(db, s)
+	if err != nil {
+		return apps, err
+	}
+
+	// Save service -> ReplicationControllers
+	if err := db.Set(serviceAppsTable, suid, apps); err != nil {
+		return apps, err
+	}
+
+	// Also save rc -> service denormalized for reverse Index lookup
+	for _, r := range apps {
+		// A pod may be behind multiple services.
+		// Get the existing array. append the new serviceID and set again
+		// Calls for race condition probably. So will need some mutex here.
+		if err := db.Set(
+			makeKey(appServicesTable, string(r.GetUID())), suid, true,
+		); err != nil {
+			return apps, err
+		}
+	}
+
+	return apps, nil
+}
+*/
+
+// eventID
+func makeL9ServiceEvent(db Cachier, eventID string, s *v1.Service, pods []v1.Pod, eventType string) (*L9Event, error) {
+	suid := string(s.GetUID())
+
+	// Save service to database
+	// Maybe use s.SelfLink since UID is literally not exposed elsewhere for
+	// a service other than the service itself being aware of it.
+	// And a change in the service will change the UID afterall.
+	if err := db.Set(serviceTable, suid, s); err != nil {
+		return nil, err
+	}
+
+	podMap := map[string]interface{}{}
+	for _, p := range pods {
+		b, err := json.Marshal(miniPodInfo(p))
+		if err != nil {
+			podMap[p.GetName()] = err.Error()
+		} else {
+			podMap[p.GetName()] = string(b)
+		}
+	}
+
+	return &L9Event{
+		raw:              s,
+		ID:               eventID,
+		Timestamp:        time.Now().Unix(),
+		Component:        s.GetName(),
+		Message:          eventType,
+		Namespace:        s.GetNamespace(),
+		Reason:           eventType,
+		ReferenceVersion: s.GetResourceVersion(),
+		ResourceVersion:  s.GetResourceVersion(),
+		ObjectUid:        string(s.GetUID()),
+		Labels:           s.GetLabels(),
+		Annotations:      s.GetAnnotations(),
+		Pod:              podMap,
+		Version:          VERSION,
+	}, nil
+}
+
+// Reasons set on a service event when only transitions are emitted.
+const (
+	serviceSelectorChanged = "SelectorChanged"
+	servicePortsChanged    = "PortsChanged"
+	servicePodsChanged     = "PodsChanged"
+)
+
+// serviceState is what a service looks like to its consumers. Each part is
+// stored hashed so that a change can be attributed to one of them.
+type serviceState struct {
+	Selector string `json:"selector"`
+	Ports    string `json:"ports"`
+	Pods     string `json:"pods"`
+}
+
+func hashOf(obj interface{}) string {
+	b, _ := json.Marshal(obj)
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func makeServiceState(s *v1.Service, pods []v1.Pod) serviceState {
+	uids := make([]string, 0, len(pods))
+	for _, p := range pods {
+		uids = append(uids, string(p.GetUID()))
+	}
+	sort.Strings(uids)
+
+	return serviceState{
+		Selector: hashOf(s.Spec.Selector),
+		Ports:    hashOf(s.Spec.Ports),
+		Pods:     hashOf(uids),
+	}
+}
+
+// serviceTransition compares the service against the state that was last
+// recorded for it and returns the reason for emitting an event, with the
+// state to record once the event is flushed. An empty reason means nothing
+// that matters has changed. A service without a recorded state keeps the
+// eventType it was observed with.
+// The state is not recorded here, so that an event that fails to flush is
+// reported again on the next update.
+func serviceTransition(db Cachier, s *v1.Service, pods []v1.Pod, eventType string) (string, *serviceState, error) {
+	suid := string(s.GetUID())
+	state := makeServiceState(s, pods)
+
+	r, err := db.Get(serviceStateTable, suid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !r.Exists() {
+		return eventType, &state, nil
+	}
+
+	var last serviceState
+	if err := r.Unmarshal(&last); err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case last.Selector != state.Selector:
+		return serviceSelectorChanged, &state, nil
+	case last.Ports != state.Ports:
+		return servicePortsChanged, &state, nil
+	case last.Pods != state.Pods:
+		return servicePodsChanged, &state, nil
+	}
+
+	return "", nil, nil
+}
+
+// staleService reports whether a newer resourceVersion of the service was
+// processed already, so that an update delivered out of order is dropped.
+// Otherwise it records this one, for retention seconds. resourceVersions
+// are compared as integers; ones that do not parse are never stale.
+func staleService(db Cachier, s *v1.Service, retention int) (bool, error) {
+	suid := string(s.GetUID())
+	rv, err := strconv.ParseUint(s.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	r, err := db.Get(serviceVersionTable, suid)
+	if err != nil {
+		return false, err
+	}
+
+	if r.Exists() {
+		var last uint64
+		if err := r.Unmarshal(&last); err != nil {
+			return false, err
+		}
+
+		if rv < last {
+			return true, nil
+		}
+	}
+
+	return false, db.ExpireSet(serviceVersionTable, suid, rv, retention)
+}
@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"math"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+)
+
+// Choices of preset.
+const presetCritical = "critical"
+
+// presets are the reasons of the events that each preset emits, curated so
+// that a new install reports what needs attention and little else.
+var presets = map[string][]string{
+	presetCritical: {
+		// Pods that cannot run.
+		"FailedScheduling", "FailedCreate", "FailedCreatePodSandBox",
+		"FailedMount", "FailedAttachVolume", "Failed", "BackOff",
+		"Unhealthy", "OOMKilled", "OOMKilling", "Evicted", "FailedKillPod",
+
+		// Nodes in trouble.
+		"NodeNotReady", "NodeHasDiskPressure", "NodeHasInsufficientMemory",
+		"NodeHasInsufficientPID", "SystemOOM", "Rebooted",
+		"EvictionThresholdMet", "ContainerGCFailed", "ImageGCFailed",
+	},
+}
+
+// presetReasons are the reasons of preset, and nil for no preset, which
+// emits every reason.
+func presetReasons(preset string) ([]string, error) {
+	if preset == "" {
+		return nil, nil
+	}
+
+	reasons, ok := presets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q, the choice is %v", preset, presetCritical)
+	}
+	return reasons, nil
+}
+
+// eventFilter is the compiled filter_expression. A nil filter keeps every
+// event.
+type eventFilter struct {
+	prg cel.Program
+}
+
+// compileFilter compiles a CEL expression over the event, as it is
+// serialized, e.g.
+//
+//	event.type == "Warning" && event.namespace.startsWith("prod-")
+//
+// It has to evaluate to a bool.
+func compileFilter(expr string) (*eventFilter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewIdent("event", decls.NewMapType(decls.String, decls.Dyn), nil),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("filter_expression: %w", iss.Err())
+	}
+
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return nil, fmt.Errorf("filter_expression: %q is not a bool", expr)
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("filter_expression: %w", err)
+	}
+
+	return &eventFilter{prg}, nil
+}
+
+// keep reports whether the expression holds for e. An event that it cannot
+// be evaluated for, say for a field that the event lacks, is kept.
+func (f *eventFilter) keep(e *L9Event) bool {
+	if f == nil {
+		return true
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Println("filter_expression:", err)
+		return true
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		log.Println("filter_expression:", err)
+		return true
+	}
+
+	out, _, err := f.prg.Eval(map[string]interface{}{"event": integers(fields)})
+	if err != nil {
+		log.Println("filter_expression:", e.ID, err)
+		return true
+	}
+
+	return out != types.False
+}
+
+// integers turns the whole numbers that JSON decoded as floats back into
+// ints, so that event.count > 5 compares an int with an int.
+func integers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, x := range t {
+			t[k] = integers(x)
+		}
+	case []interface{}:
+		for i, x := range t {
+			t[i] = integers(x)
+		}
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return int64(t)
+		}
+	}
+
+	return v
+}
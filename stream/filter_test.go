@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilterExpression(t *testing.T) {
+	f, err := compileFilter(`event.type == "Warning" && event.namespace.startsWith("prod-") && event.count > 1`)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, f.keep(&L9Event{Type: "Warning", Namespace: "prod-payments", Count: 3}), true)
+	assert.Equal(t, f.keep(&L9Event{Type: "Normal", Namespace: "prod-payments", Count: 3}), false)
+	assert.Equal(t, f.keep(&L9Event{Type: "Warning", Namespace: "staging", Count: 3}), false)
+	assert.Equal(t, f.keep(&L9Event{Type: "Warning", Namespace: "prod-payments", Count: 1}), false)
+
+	t.Run("Handler drops what the filter rejects", func(t *testing.T) {
+		ch := make(chan interface{}, 2)
+		h := &Handler{&KubernetesClient{}, ch, nil, &L9K8streamConfig{filter: f}}
+		h.emit(&L9Event{ID: "kept", Type: "Warning", Namespace: "prod-a", Count: 2})
+		h.emit(&L9Event{ID: "dropped", Type: "Normal", Namespace: "prod-a", Count: 2})
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, (<-ch).(*L9Event).ID, "kept")
+	})
+
+	t.Run("No expression keeps everything", func(t *testing.T) {
+		f, err := compileFilter("")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, f.keep(&L9Event{}), true)
+	})
+
+	t.Run("Invalid expressions fail at startup", func(t *testing.T) {
+		conf := newTestConfig()
+		conf.FilterExpression = `event.type ==`
+		_, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+		assert.NotEqual(t, err, nil)
+
+		_, err = compileFilter(`event.count + 1`)
+		assert.NotEqual(t, err, nil)
+	})
+}
+
+func TestCriticalPreset(t *testing.T) {
+	conf := newTestConfig()
+	conf.Preset = presetCritical
+	conf.FilterExpression = `event.namespace != "staging"`
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.Handler
+
+	event := func(reason, namespace string) *v1.Event {
+		return &v1.Event{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}, Reason: reason}
+	}
+
+	for _, reason := range []string{"FailedScheduling", "OOMKilled", "BackOff", "Unhealthy", "FailedMount", "NodeNotReady"} {
+		assert.Equal(t, h.isEligible(event(reason, "default")), true)
+	}
+
+	for _, reason := range []string{"Pulled", "Created", "Started", "Scheduled"} {
+		assert.Equal(t, h.isEligible(event(reason, "default")), false)
+	}
+
+	t.Run("Composes with the user's filters", func(t *testing.T) {
+		conf.Events = []string{"OOMKilled"}
+		defer func() { conf.Events = nil }()
+		assert.Equal(t, h.isEligible(event("OOMKilled", "default")), true)
+		assert.Equal(t, h.isEligible(event("BackOff", "default")), false)
+
+		assert.Equal(t, conf.filter.keep(&L9Event{Reason: "OOMKilled", Namespace: "staging"}), false)
+	})
+
+	t.Run("Unknown presets fail at startup", func(t *testing.T) {
+		conf := newTestConfig()
+		conf.Preset = "everything"
+		_, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+		assert.NotEqual(t, err, nil)
+	})
+}
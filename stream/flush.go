@@ -0,0 +1,385 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/last9/k8stream/io"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	lineBreak = "\n"
+
+	// Appended to a message that was cut short to fit MaxEventBytes.
+	truncatedMarker = "...(truncated)"
+)
+
+// Ingester returns a send-only message chan. It also spins a goroutine that runs
+// a forever loop of listening to messages and flush them to disk till the buffer
+// overflows the batchSize or the lease if past the batchInterval. While a batch
+// is being flushed, the channels stop listening.
+// Events that are too large for the sink are sent to the dead-letter sink dl
+// when the oversize policy asks for it.
+// With more than one flush worker, batches are flushed concurrently. Events
+// about one object can then be flushed out of order, unless ordering by
+// object is asked for: events are sharded by their ReferenceUID, so that
+// one worker flushes, and retries, all of the events of an object in order.
+// With batch_by_key, each worker fills a batch for each value of the key.
+// Once the channel is closed, the workers flush what they hold and stop,
+// and then the returned done channel is closed.
+func startIngester(
+	sinks *SinkSet, dl io.Flusher, cfg *L9K8streamConfig, db Cachier,
+) (chan<- interface{}, <-chan struct{}) {
+	msgChan := make(chan interface{}, cfg.BatchSize)
+
+	var wg sync.WaitGroup
+	worker := func(ch <-chan interface{}) {
+		defer wg.Done()
+
+		if cfg.BatchByKey != "" {
+			keyedBatcher(sinks, dl, ch, db, cfg)
+			return
+		}
+
+		for {
+			err := doBatch(sinks, dl, ch, db, cfg)
+			if err == errIngesterClosed {
+				return
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	defer func() {
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+	}()
+
+	workers := cfg.FlushWorkers
+	if workers <= 1 || !cfg.OrderByObject {
+		for i := 0; i < workers || i == 0; i++ {
+			wg.Add(1)
+			go worker(msgChan)
+		}
+		return msgChan, done
+	}
+
+	shards := make([]chan interface{}, workers)
+	for i := range shards {
+		shards[i] = make(chan interface{}, cfg.BatchSize)
+		wg.Add(1)
+		go worker(shards[i])
+	}
+
+	go func() {
+		for v := range msgChan {
+			shards[shardOf(v, workers)] <- v
+		}
+
+		for _, s := range shards {
+			close(s)
+		}
+	}()
+
+	return msgChan, done
+}
+
+func shardOf(v interface{}, n int) int {
+	e, ok := v.(*L9Event)
+	if !ok {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.ReferenceUID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// urgent events do not wait for the batch to fill up.
+func urgent(v interface{}) bool {
+	e, ok := v.(*L9Event)
+	return ok && e.Priority == priorityHigh
+}
+
+func doBatch(
+	sinks *SinkSet, dl io.Flusher, msgChan <-chan interface{},
+	db Cachier, cfg *L9K8streamConfig,
+) error {
+	batch, batchIdent, open := io.NextBatch(msgChan, &cfg.Config, urgent)
+	cfg.Log("Flushing %v: %v", batchIdent, len(batch))
+
+	var err error
+	if len(batch) > 0 {
+		err = shipBatch(sinks, dl, batch, batchIdent, db, cfg)
+	}
+
+	if err == nil && !open {
+		return errIngesterClosed
+	}
+	return err
+}
+
+// shipBatch counts a batch, and flushes it unless only metrics are asked
+// for.
+func shipBatch(
+	sinks *SinkSet, dl io.Flusher, batch []interface{}, batchIdent string,
+	db Cachier, cfg *L9K8streamConfig,
+) error {
+	bufferedEvents.Sub(float64(len(batch)))
+
+	if cfg.Output.countsEvents() {
+		for _, v := range batch {
+			countEvent(v.(*L9Event))
+		}
+	}
+
+	if cfg.Output.Format == formatMetricsOnly {
+		markProcessed(db, batch)
+		markClaimsDone(cfg, batch)
+		ackBatch(cfg, batch, nil, nil)
+		return nil
+	}
+
+	return flushBatch(sinks, dl, batch, batchIdent, db, cfg)
+}
+
+// flushBatch encodes a batch and flushes it to the sinks that its events
+// are routed to, and then acks it. Each sink succeeds or fails on its own:
+// the events routed to a sink that failed are released to be emitted
+// again, while those the other sinks took are done with.
+func flushBatch(
+	sinks *SinkSet, dl io.Flusher, batch []interface{}, batchIdent string,
+	db Cachier, cfg *L9K8streamConfig,
+) error {
+	var dead bytes.Buffer
+
+	var latest map[string]int
+	if cfg.BatchCollapseDuplicates {
+		latest = latestOf(batch)
+	}
+
+	// Records, and the events they stand for, by the name of the sink they
+	// are routed to.
+	routed := map[string][][]byte{}
+	members := map[string][]interface{}{}
+	sinkOf := map[int]string{}
+
+	// Oversized events that were not sent to a sink, and why.
+	var skipped []interface{}
+	reasons := map[int]error{}
+	reasonOf := map[int]error{}
+	skip := func(v interface{}, reason error) {
+		reasons[len(skipped)] = reason
+		skipped = append(skipped, v)
+	}
+
+	var collapsed []int
+	for ix, v := range batch {
+		// Acked, and marked processed, along with the one kept.
+		if latest != nil && latest[v.(*L9Event).ID] != ix {
+			collapsedEvents.Inc()
+			collapsed = append(collapsed, ix)
+			continue
+		}
+
+		// Encoded in the format of the sink it is routed to.
+		name, _ := sinks.route(v.(*L9Event))
+		output := cfg.outputFor(name)
+		bytes, err := encodeEvent(v.(*L9Event), output)
+		if err != nil {
+			ackBatch(cfg, batch, err, nil)
+			return err
+		}
+
+		eventBytes.Observe(float64(len(bytes)))
+		if cfg.MaxEventBytes > 0 && len(bytes) > cfg.MaxEventBytes {
+			oversizedEvents.WithLabelValues(cfg.OversizePolicy).Inc()
+			bytes, err = applyOversizePolicy(v.(*L9Event), bytes, output, cfg, &dead)
+			if err != nil {
+				ackBatch(cfg, batch, err, nil)
+				return err
+			}
+		}
+
+		if bytes == nil {
+			reasonOf[ix] = ErrDropped
+			if cfg.OversizePolicy == oversizeDeadLetter {
+				reasonOf[ix] = ErrDeadLettered
+			}
+			skip(v, reasonOf[ix])
+			continue
+		}
+
+		sinkOf[ix] = name
+		routed[name] = append(routed[name], bytes)
+		members[name] = append(members[name], v)
+	}
+
+	for _, ix := range collapsed {
+		kept := latest[batch[ix].(*L9Event).ID]
+		if reason, ok := reasonOf[kept]; ok {
+			skip(batch[ix], reason)
+			continue
+		}
+		members[sinkOf[kept]] = append(members[sinkOf[kept]], batch[ix])
+	}
+
+	if dead.Len() > 0 {
+		if err := dl.Flush(cfg.UID, batchIdent, dead.Bytes()); err != nil {
+			flushErrors.Inc()
+			releaseClaims(cfg, batch)
+			ackBatch(cfg, batch, err, nil)
+			return err
+		}
+	}
+
+	markProcessed(db, skipped)
+	markClaimsDone(cfg, skipped)
+	ackBatch(cfg, skipped, nil, reasons)
+
+	var flushErr error
+	for name, records := range routed {
+		f := sinks.primary
+		if name != "" {
+			f = sinks.named[name]
+		}
+
+		started := time.Now()
+		err := io.FlushRecords(f, cfg.UID, batchIdent, records)
+		flushLatencies.Observe(time.Since(started).Seconds())
+		if err := settle(cfg, db, members[name], err); err != nil {
+			log.Printf("Flushing %v to sink %q: %v", batchIdent, name, err)
+			flushErr = err
+		}
+	}
+	return flushErr
+}
+
+// settle records, and acks, what became of the events flushed to a sink.
+// They are done with once the sink took them, or the dead-letter sink did,
+// and are released to be emitted again otherwise, with the error returned.
+// Events that the spill queue holds are settled once it is done with them.
+func settle(cfg *L9K8streamConfig, db Cachier, events []interface{}, err error) error {
+	var spilled *io.ErrSpilled
+	var dead *io.ErrDeadLettered
+	switch {
+	case errors.As(err, &spilled):
+		if cfg.spilled != nil {
+			cfg.spilled.Add(1)
+		}
+		go func() {
+			settle(cfg, db, events, <-spilled.Done)
+			if cfg.spilled != nil {
+				cfg.spilled.Done()
+			}
+		}()
+		return nil
+	case errors.As(err, &dead):
+		flushErrors.Inc()
+		markProcessed(db, events)
+		markClaimsDone(cfg, events)
+		ackBatch(cfg, events, err, nil)
+		return nil
+	case err != nil:
+		flushErrors.Inc()
+		releaseClaims(cfg, events)
+	default:
+		markProcessed(db, events)
+		markClaimsDone(cfg, events)
+	}
+
+	ackBatch(cfg, events, err, nil)
+	return err
+}
+
+// latestOf is the index of the last event of each id in the batch, that
+// the events of the id before it are collapsed into.
+func latestOf(batch []interface{}) map[string]int {
+	latest := map[string]int{}
+	for ix, v := range batch {
+		latest[v.(*L9Event).ID] = ix
+	}
+	return latest
+}
+
+// markProcessed records the batch in the event cache so that the handler
+// does not emit these events again, and the states of the services whose
+// transitions it reports, for the next update to be compared against.
+func markProcessed(db Cachier, batch []interface{}) {
+	processedEvents.Add(float64(len(batch)))
+	if db == nil {
+		return
+	}
+
+	for _, v := range batch {
+		e := v.(*L9Event)
+		db.ExpireSet(eventCacheTable, e.ID, e, objectCacheExpiry)
+		if e.serviceState != nil {
+			db.Set(serviceStateTable, e.ObjectUid, e.serviceState)
+		}
+	}
+}
+
+// applyOversizePolicy returns what should be written to the sink in place of
+// an event whose serialization b, in the output o of its sink, is larger
+// than MaxEventBytes. A nil slice means the event is not sent to the sink at
+// all.
+func applyOversizePolicy(
+	e *L9Event, b []byte, o *OutputConfig, cfg *L9K8streamConfig, dead *bytes.Buffer,
+) ([]byte, error) {
+	switch cfg.OversizePolicy {
+	case oversizeDrop:
+		log.Println("Dropping oversized event", e.ID, len(b))
+		return nil, nil
+	case oversizeDeadLetter:
+		dead.Write(b)
+		dead.Write([]byte(lineBreak))
+		return nil, nil
+	}
+
+	// Written in the raw format, it is the message of the object that is
+	// cut, for the objects that have one.
+	t := *e
+	message := &t.Message
+	if o.Format == formatRaw && e.raw != nil {
+		message = nil
+		if ev, ok := e.raw.(*v1.Event); ok {
+			r := *ev
+			t.raw = &r
+			message = &r.Message
+		}
+	}
+
+	over := len(b) - cfg.MaxEventBytes + len(truncatedMarker)
+	if message == nil || over > len(*message) {
+		log.Println("Dropping oversized event", e.ID, "truncation isn't enough")
+		return nil, nil
+	}
+
+	*message = truncateString(*message, len(*message)-over) + truncatedMarker
+	return encodeEvent(&t, o)
+}
+
+// truncateString cuts s to at most n bytes without splitting a rune.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
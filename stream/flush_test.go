@@ -0,0 +1,386 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestConfig() *L9K8streamConfig {
+	c := &L9K8streamConfig{}
+	c.UID = "mock-uuid"
+	c.BatchSize = 2
+	c.BatchInterval = 1
+	c.Sink = "memory"
+	SetDefaults(c)
+	return c
+}
+
+func newMemSink() *io.MemSink {
+	return &io.MemSink{Records: map[string][]byte{}, OnFetch: func(string) {}}
+}
+
+func sinkLines(m *io.MemSink) []string {
+	lines := []string{}
+	for _, r := range m.Records {
+		for _, l := range strings.Split(strings.TrimSpace(string(r)), lineBreak) {
+			if l != "" {
+				lines = append(lines, l)
+			}
+		}
+	}
+	return lines
+}
+
+func sampleCount(t *testing.T) uint64 {
+	m := &dto.Metric{}
+	if err := eventBytes.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestOversizedEvents(t *testing.T) {
+	small := &L9Event{ID: "small", Message: "ok"}
+	big := &L9Event{ID: "big", Message: strings.Repeat("x", 2048)}
+
+	run := func(t *testing.T, policy string) (*io.MemSink, *io.MemSink) {
+		cfg := newTestConfig()
+		cfg.MaxEventBytes = 512
+		cfg.OversizePolicy = policy
+
+		f, dl := newMemSink(), newMemSink()
+		ch := make(chan interface{}, 2)
+		ch <- small
+		ch <- big
+
+		before := sampleCount(t)
+		if err := doBatch(SingleSink(f), dl, ch, nil, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, sampleCount(t)-before, uint64(2))
+		return f, dl
+	}
+
+	t.Run("truncate", func(t *testing.T) {
+		before := testutil.ToFloat64(oversizedEvents.WithLabelValues(oversizeTruncate))
+		f, dl := run(t, oversizeTruncate)
+		assert.Equal(t, testutil.ToFloat64(oversizedEvents.WithLabelValues(oversizeTruncate))-before, float64(1))
+
+		lines := sinkLines(f)
+		assert.Equal(t, len(lines), 2)
+		assert.Equal(t, len(sinkLines(dl)), 0)
+
+		var e L9Event
+		if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, e.ID, "big")
+		assert.Equal(t, strings.HasSuffix(e.Message, truncatedMarker), true)
+		assert.Equal(t, len(lines[1]) <= 512, true)
+	})
+
+	t.Run("truncate raw", func(t *testing.T) {
+		cfg := newTestConfig()
+		cfg.MaxEventBytes = 512
+		cfg.OversizePolicy = oversizeTruncate
+		cfg.Output.Format = formatRaw
+
+		event := &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "web.1"},
+			Message:    strings.Repeat("x", 2048),
+		}
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Annotations: map[string]string{"note": strings.Repeat("x", 2048)},
+		}}
+
+		f := newMemSink()
+		batch := []interface{}{
+			&L9Event{ID: "event", raw: event},
+			&L9Event{ID: "namespace", raw: namespace},
+		}
+		if err := flushBatch(SingleSink(f), nil, batch, "1", nil, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		// Objects without a message to cut are dropped.
+		lines := sinkLines(f)
+		assert.Equal(t, len(lines), 1)
+		assert.Equal(t, len(lines[0]) <= 512, true)
+
+		var e v1.Event
+		if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, e.Name, "web.1")
+		assert.Equal(t, strings.HasSuffix(e.Message, truncatedMarker), true)
+		assert.Equal(t, len(event.Message), 2048)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		f, dl := run(t, oversizeDrop)
+		assert.Equal(t, len(sinkLines(f)), 1)
+		assert.Equal(t, len(sinkLines(dl)), 0)
+	})
+
+	t.Run("dead-letter", func(t *testing.T) {
+		f, dl := run(t, oversizeDeadLetter)
+		assert.Equal(t, len(sinkLines(f)), 1)
+
+		dead := sinkLines(dl)
+		assert.Equal(t, len(dead), 1)
+		assert.Equal(t, strings.Contains(dead[0], `"id":"big"`), true)
+	})
+}
+
+// flakySink fails its first fails flushes, and then passes them on.
+type flakySink struct {
+	*io.MemSink
+	calls, fails int
+}
+
+func (f *flakySink) Flush(uuid, ident string, d []byte) error {
+	f.calls++
+	if f.calls <= f.fails {
+		return &io.ErrRetryable{Err: errors.New("sink unavailable")}
+	}
+	return f.MemSink.Flush(uuid, ident, d)
+}
+
+func TestFlushRetries(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.RetryAttempts = 3
+	cfg.RetryInitial = 1
+	cfg.RetryMax = 2
+
+	mem := newMemSink()
+	flaky := &flakySink{MemSink: mem, fails: 2}
+
+	ch := make(chan interface{}, 2)
+	ch <- &L9Event{ID: "a", Message: "one"}
+	ch <- &L9Event{ID: "b", Message: "two"}
+
+	f := io.WithRetry(flaky, nil, &cfg.Config, io.NewRetryBudget(0))
+	if err := doBatch(SingleSink(f), nil, ch, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, flaky.calls, 3)
+	assert.Equal(t, len(mem.Records), 1)
+
+	lines := sinkLines(mem)
+	assert.Equal(t, len(lines), 2)
+	assert.Equal(t, strings.Contains(lines[0], `"id":"a"`), true)
+	assert.Equal(t, strings.Contains(lines[1], `"id":"b"`), true)
+}
+
+func TestMetricsOnlyOutput(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 3
+	cfg.Output.Format = formatMetricsOnly
+
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warning := k8sEvents.WithLabelValues("default", "BackOff", "Warning", "Pod")
+	normal := k8sEvents.WithLabelValues("default", "Scheduled", "Normal", "Pod")
+	wBefore, nBefore := testutil.ToFloat64(warning), testutil.ToFloat64(normal)
+
+	ch := make(chan interface{}, 3)
+	for ix, r := range []string{"BackOff", "BackOff", "Scheduled"} {
+		typ := "Warning"
+		if r == "Scheduled" {
+			typ = "Normal"
+		}
+
+		ch <- &L9Event{
+			ID: strconv.Itoa(ix), Namespace: "default", Reason: r,
+			Type: typ, ReferenceKind: "Pod",
+		}
+	}
+
+	f := newMemSink()
+	if err := doBatch(SingleSink(f), nil, ch, db, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, testutil.ToFloat64(warning)-wBefore, float64(2))
+	assert.Equal(t, testutil.ToFloat64(normal)-nBefore, float64(1))
+	assert.Equal(t, len(f.Records), 0)
+
+	t.Run("Counted events are still marked processed", func(t *testing.T) {
+		r, err := db.Get(eventCacheTable, "0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, r.Exists(), true)
+	})
+}
+
+func TestOrderByObject(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 1
+	cfg.FlushWorkers = 4
+	cfg.OrderByObject = true
+
+	var mu sync.Mutex
+	acked := []string{}
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		// Hold the first event back, so that an unordered worker would
+		// get the second one out first.
+		if events[0].ID == "first" {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range events {
+			acked = append(acked, e.ID)
+		}
+		return nil
+	})
+
+	ch, _ := startIngester(SingleSink(f), nil, cfg, nil)
+	ch <- &L9Event{ID: "first", ReferenceUID: "pod-uid"}
+	ch <- &L9Event{ID: "second", ReferenceUID: "pod-uid"}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(acked)
+		mu.Unlock()
+
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Events were never flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, acked, []string{"first", "second"})
+}
+
+// Run with -race: the workers flush to one sink at once.
+func TestFlushWorkersShareSink(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 1
+	cfg.FlushWorkers = 4
+
+	f := newMemSink()
+	ch, _ := startIngester(SingleSink(f), nil, cfg, nil)
+
+	const n = 40
+	for i := 0; i < n; i++ {
+		ch <- &L9Event{ID: strconv.Itoa(i)}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for f.Len() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v of %v batches were flushed", f.Len(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Batches cut at once by different workers do not share an ident.
+	assert.Equal(t, len(sinkLines(f)), n)
+}
+
+func TestBatchByKey(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 2
+	cfg.BatchInterval = 1
+	cfg.BatchByKey = "reference_kind"
+
+	got := make(chan []*L9Event, 4)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	ch, _ := startIngester(SingleSink(f), nil, cfg, nil)
+	start := time.Now()
+	for ix, kind := range []string{"Pod", "Service", "Pod"} {
+		ch <- &L9Event{ID: strconv.Itoa(ix), ReferenceKind: kind}
+	}
+
+	next := func() []*L9Event {
+		select {
+		case b := <-got:
+			return b
+		case <-time.After(3 * time.Second):
+			t.Fatal("No batch was flushed")
+			return nil
+		}
+	}
+
+	// The Pod batch is full before the Service one, which is not cut
+	// short by it.
+	pods := next()
+	assert.Equal(t, []string{pods[0].ID, pods[1].ID}, []string{"0", "2"})
+	assert.Equal(t, time.Since(start) < time.Second, true)
+
+	services := next()
+	assert.Equal(t, len(services), 1)
+	assert.Equal(t, services[0].ReferenceKind, "Service")
+	assert.Equal(t, time.Since(start) >= 900*time.Millisecond, true)
+}
+
+func TestBatchCollapseDuplicates(t *testing.T) {
+	batch := []interface{}{
+		&L9Event{ID: "backoff", Message: "Back-off restarting failed container", Count: 1},
+		&L9Event{ID: "pulled", Message: "Pulled"},
+		&L9Event{ID: "backoff", Message: "Back-off restarting failed container", Count: 2},
+		&L9Event{ID: "backoff", Message: "Back-off restarting failed container", Count: 3},
+	}
+
+	flushed := func(t *testing.T, collapse bool) []L9Event {
+		cfg := newTestConfig()
+		cfg.BatchCollapseDuplicates = collapse
+
+		f := newMemSink()
+		if err := flushBatch(SingleSink(f), nil, batch, "1", nil, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		events := []L9Event{}
+		for _, l := range sinkLines(f) {
+			var e L9Event
+			if err := json.Unmarshal([]byte(l), &e); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+
+	before := testutil.ToFloat64(collapsedEvents)
+	events := flushed(t, true)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].ID, "pulled")
+	assert.Equal(t, events[1].ID, "backoff")
+	assert.Equal(t, events[1].Count, int32(3))
+	assert.Equal(t, testutil.ToFloat64(collapsedEvents)-before, float64(2))
+
+	t.Run("Kept apart unless asked for", func(t *testing.T) {
+		assert.Equal(t, len(flushed(t, false)), 4)
+	})
+}
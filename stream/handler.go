@@ -0,0 +1,480 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordination "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const (
+	serviceTable        = "service"
+	eventCacheTable     = "events"
+	servicePodsTable    = "service-pods"
+	podServicesTable    = "pod-service"
+	serviceAppsTable    = "service-apps"
+	serviceStateTable   = "service-state"
+	serviceVersionTable = "service-version"
+	appServicesTable    = "apps-service"
+)
+
+type Handler struct {
+	client *KubernetesClient
+	ch     chan<- interface{}
+	db     Cachier
+	conf   *L9K8streamConfig
+}
+
+// recoverPanic keeps a panic in handling one object, say a malformed one,
+// from taking the whole stream down.
+func recoverPanic(obj interface{}) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	handlerPanics.Inc()
+
+	var uid interface{} = "unknown"
+	if m, err := meta.Accessor(obj); err == nil {
+		uid = m.GetUID()
+	}
+	log.Printf("Recovered handling %v: %v\n%s", uid, r, debug.Stack())
+}
+
+func (h *Handler) OnAdd(obj interface{}) {
+	h.dispatch(obj, func() error {
+		switch obj.(type) {
+		case *v1.Event:
+			return h.onEvent(obj.(*v1.Event))
+		case *v1.Service:
+			return h.onService(nil, obj.(*v1.Service), "addedService")
+		case *v1.Namespace:
+			return h.onNamespace(nil, obj.(*v1.Namespace), namespaceCreated)
+		case *v1.Secret:
+			return h.onSecret(obj.(*v1.Secret))
+		case *v1.Pod:
+			return h.onObject(nil, obj.(*v1.Pod), "Pod", objectAdded)
+		case *appsv1.Deployment:
+			return h.onObject(nil, obj.(*appsv1.Deployment), "Deployment", objectAdded)
+		case *appsv1.ReplicaSet:
+			return h.onObject(nil, obj.(*appsv1.ReplicaSet), "ReplicaSet", objectAdded)
+		}
+		return nil
+	})
+}
+
+func (h *Handler) OnUpdate(oldObj, newObj interface{}) {
+	h.dispatch(newObj, func() error {
+		switch newObj.(type) {
+		case *v1.Event:
+			return h.onEvent(newObj.(*v1.Event))
+		case *v1.Service:
+			old, _ := oldObj.(*v1.Service)
+			return h.onService(old, newObj.(*v1.Service), "updatedService")
+		case *v1.Namespace:
+			old, _ := oldObj.(*v1.Namespace)
+			ns := newObj.(*v1.Namespace)
+			return h.onNamespace(old, ns, namespaceReason(old, ns))
+		case *v1.ResourceQuota:
+			old, _ := oldObj.(*v1.ResourceQuota)
+			return h.onResourceQuota(old, newObj.(*v1.ResourceQuota))
+		case *policy.PodDisruptionBudget:
+			old, _ := oldObj.(*policy.PodDisruptionBudget)
+			return h.onPDB(old, newObj.(*policy.PodDisruptionBudget))
+		case *coordination.Lease:
+			old, _ := oldObj.(*coordination.Lease)
+			return h.onLease(old, newObj.(*coordination.Lease))
+		case *v1.Secret:
+			return h.onSecret(newObj.(*v1.Secret))
+		case *v1.Pod:
+			old, _ := oldObj.(*v1.Pod)
+			return h.onObject(old, newObj.(*v1.Pod), "Pod", objectUpdated)
+		case *appsv1.Deployment:
+			old, _ := oldObj.(*appsv1.Deployment)
+			return h.onObject(old, newObj.(*appsv1.Deployment), "Deployment", objectUpdated)
+		case *appsv1.ReplicaSet:
+			old, _ := oldObj.(*appsv1.ReplicaSet)
+			return h.onObject(old, newObj.(*appsv1.ReplicaSet), "ReplicaSet", objectUpdated)
+		}
+		return nil
+	})
+}
+
+func (h *Handler) OnDelete(obj interface{}) {
+	h.dispatch(obj, func() error {
+		switch obj.(type) {
+		case *v1.Event:
+			return h.onEventDelete(obj.(*v1.Event))
+		case *v1.Service:
+			return h.onService(nil, obj.(*v1.Service), "deletedService")
+		case *v1.Namespace:
+			return h.onNamespace(nil, obj.(*v1.Namespace), namespaceDeleted)
+		case *v1.Pod:
+			return h.onObject(nil, obj.(*v1.Pod), "Pod", objectDeleted)
+		case *appsv1.Deployment:
+			return h.onObject(nil, obj.(*appsv1.Deployment), "Deployment", objectDeleted)
+		case *appsv1.ReplicaSet:
+			return h.onObject(nil, obj.(*appsv1.ReplicaSet), "ReplicaSet", objectDeleted)
+		}
+		return nil
+	})
+}
+
+// dispatch handles obj with fn on a goroutine of its own when the handler
+// may run more than one, or else on the informer's goroutine. Once
+// HandlerMaxGoroutines are running, the informer waits for one of them to
+// finish, so that a slow cache or API server does not pile goroutines up.
+// Objects arriving once shutdown began are dropped.
+func (h *Handler) dispatch(obj interface{}, fn func() error) {
+	receivedObjects.WithLabelValues(kindOf(obj)).Inc()
+
+	if !h.conf.gate.enter() {
+		h.conf.Log("Dropped %T during shutdown", obj)
+		return
+	}
+
+	if h.conf.handlerSlots == nil {
+		defer h.conf.gate.leave()
+		h.handle(obj, fn)
+		return
+	}
+
+	h.conf.handlerSlots <- struct{}{}
+	go func() {
+		defer h.conf.gate.leave()
+		defer func() { <-h.conf.handlerSlots }()
+		h.handle(obj, fn)
+	}()
+}
+
+func (h *Handler) handle(obj interface{}, fn func() error) {
+	defer recoverPanic(obj)
+
+	if err := fn(); err != nil {
+		h.conf.Log("Obj: %+v\nError: %+v", obj, err)
+	}
+}
+
+func contains(v string, a []string) bool {
+	for _, i := range a {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespaces excluded unless the namespaces lists are set.
+var skipNamespaces = []string{"kube-system", "kubernetes", "kubernetes-dashboard"}
+
+// watchesService reports whether events about the service are emitted.
+func (h *Handler) watchesService(s *v1.Service) bool {
+	// Do not watch the default kubernetes services
+	if !h.conf.Namespaces.watches(s.GetNamespace(), skipNamespaces) {
+		return false
+	}
+	return s.GetName() != "kubernetes"
+}
+
+// onService reports a service with its pods. old is the previous version
+// of an updated service, and nil otherwise.
+func (h *Handler) onService(old, s *v1.Service, eventType string) error {
+	if !h.watchesService(s) {
+		return nil
+	}
+
+	suid := string(s.GetUID())
+	eventId := fmt.Sprintf("%s-%s", suid, s.GetResourceVersion())
+
+	stale, err := staleService(h.db, s, h.conf.ServiceVersionRetention)
+	if err != nil {
+		return err
+	}
+
+	if stale {
+		h.conf.Log("Service %v at %v is stale", suid, s.GetResourceVersion())
+		return nil
+	}
+
+	// Service has been processed already.
+	processed, err := h.processed(eventId)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		log.Println("Service", suid, "already processed")
+		return nil
+	}
+
+	pods, total, err := getServicePods(
+		h.client, h.db, s, h.conf.ServiceEnrichment.MaxPods,
+	)
+	if err != nil {
+		h.release(eventId)
+		return err
+	}
+
+	// A delete is always worth reporting, and leaves no state to compare to.
+	var state *serviceState
+	switch {
+	case eventType == "deletedService":
+		if err := h.db.Delete(serviceStateTable, suid); err != nil {
+			h.release(eventId)
+			return err
+		}
+	case h.conf.ServiceTransitionsOnly:
+		reason, next, err := serviceTransition(h.db, s, pods, eventType)
+		if err != nil {
+			h.release(eventId)
+			return err
+		}
+
+		if reason == "" {
+			h.conf.Log("Service %v has not changed", suid)
+			return nil
+		}
+
+		eventType, state = reason, next
+	}
+
+	event, err := makeL9ServiceEvent(h.db, eventId, s, pods, eventType)
+	if err != nil {
+		h.release(eventId)
+		return err
+	}
+
+	event.TotalPods = total
+	event.PodsTruncated = total > len(pods)
+	event.serviceState = state
+	if old != nil {
+		h.conf.Diff.attachDiff(event, old, s)
+	}
+
+	h.emit(event)
+
+	if h.conf.Topology.EmitEdges {
+		edges, err := makeServiceEdges(h.db, eventId, s, pods)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range edges {
+			h.emit(e)
+		}
+	}
+
+	return nil
+}
+
+// Check Event eligibility based on:
+// Namespace should be one amongst the reserved namespaces.
+// Its namespace should be watched, by the namespaces lists.
+// If events whitelist is provided, this event should be in it.
+// If a preset is set, this event should be one of its reasons.
+// Events about k8stream itself are dropped when asked to.
+func (h *Handler) isEligible(obj *v1.Event) bool {
+	if !h.conf.Namespaces.watches(obj.Namespace, skipNamespaces) {
+		return false
+	}
+	if h.conf.isSelf(obj.InvolvedObject.Namespace, string(obj.InvolvedObject.UID)) {
+		return false
+	}
+	if h.conf.presetReasons != nil && !contains(obj.Reason, h.conf.presetReasons) {
+		return false
+	}
+	return len(h.conf.Events) == 0 || contains(obj.Reason, h.conf.Events)
+}
+
+func (h *Handler) onEvent(e *v1.Event) error {
+	if !h.isEligible(e) {
+		return nil
+	}
+
+	// Handled once the informers have synced.
+	if h.conf.quiet != nil && h.conf.quiet.hold(e) {
+		h.conf.Log("%v is held during the quiet period", e.GetUID())
+		return nil
+	}
+
+	if problem := invalidReference(&e.InvolvedObject); problem != "" {
+		invalidReferences.WithLabelValues(problem).Inc()
+		if h.conf.Enrich.InvalidReferences == invalidReferencesDrop {
+			h.conf.Log("%v has an invalid involved object: %v", e.GetUID(), problem)
+			return nil
+		}
+	}
+
+	// Event has been processed already.
+	id := h.conf.Dedup.idOf(e)
+	processed, err := h.processed(id)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("%v was processed already", id)
+		return nil
+	}
+
+	event, err := makeL9Event(h.db, h.client, e, &h.conf.Enrich)
+	if err != nil {
+		h.release(id)
+		return err
+	}
+
+	event.ID = id
+
+	event.CorrelationID, err = h.conf.Correlation.correlationID(h.db, string(e.InvolvedObject.UID))
+	if err != nil {
+		h.release(id)
+		return err
+	}
+
+	if h.conf.ResolveWorkloads && event.pod != nil {
+		event.WorkloadKind, event.WorkloadName = resolveWorkload(h.db, h.client, event.pod)
+	}
+
+	if h.conf.EmitSchedulingLatency {
+		addSchedulingLatency(event, e)
+	}
+
+	event.Metrics = h.conf.Enrich.Prometheus.metricsOf(h.db, event, h.conf.Log)
+
+	loc := h.conf.Enrich.Datacenters.locationOf(e.Source.Host)
+	event.Datacenter, event.Rack = loc.Datacenter, loc.Rack
+
+	// Emitted, or summarized, once the storm window closes.
+	if !h.conf.storms.hold(event) {
+		h.emit(event)
+	}
+
+	if h.conf.EmitOOMEvents && event.pod != nil {
+		oomEvents, err := makeOOMEvents(h.processed, e, event.pod)
+		if err != nil {
+			return err
+		}
+
+		for _, oe := range oomEvents {
+			oe.CorrelationID = event.CorrelationID
+			h.emit(oe)
+		}
+	}
+
+	return nil
+}
+
+// onEventDelete handles an Event being garbage collected at the end of its
+// TTL. Unless asked for an EventExpired marker, this is not reported, as the
+// event itself was reported when it was added.
+func (h *Handler) onEventDelete(e *v1.Event) error {
+	if h.conf.EventDeletes != eventDeletesExpired || !h.isEligible(e) {
+		return nil
+	}
+
+	id := string(e.UID) + "-expired"
+	processed, err := h.processed(id)
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		h.conf.Log("%v was processed already", id)
+		return nil
+	}
+
+	// The involved object may well be gone by now, so it is not looked up.
+	event, err := makeL9EventDetails(h.db, e, nil, nil)
+	if err != nil {
+		h.release(id)
+		return err
+	}
+
+	event.ID = id
+	event.Timestamp = time.Now().Unix()
+	event.Message = fmt.Sprintf("%s expired: %s", e.Reason, e.Message)
+	event.Reason = eventExpiredReason
+	event.Type = v1.EventTypeNormal
+
+	h.emit(event)
+	return nil
+}
+
+// processed reports whether the event with this id was processed already.
+// With a dedup scope shared between replicas, the check also claims the id,
+// atomically, so that only one of the replicas processes it.
+func (h *Handler) processed(id string) (bool, error) {
+	if h.conf.claims != nil {
+		claimed, err := h.conf.claims.Claim(id, h.conf.Dedup.ClaimTTL)
+		if err == nil && !claimed {
+			dedupedEvents.Inc()
+		}
+		return !claimed, err
+	}
+
+	r, err := h.db.Get(eventCacheTable, id)
+	if err != nil {
+		return false, err
+	}
+
+	if r.Exists() {
+		dedupedEvents.Inc()
+	}
+	return r.Exists(), nil
+}
+
+// release gives up the claim that processed took on id, when the event is
+// not emitted after all, so that a replica can process it again.
+func (h *Handler) release(id string) {
+	if h.conf.claims == nil {
+		return
+	}
+
+	if err := h.conf.claims.Release(id); err != nil {
+		log.Println("Releasing the claim on", id, err)
+	}
+}
+
+// emit hands a processed event over to the batcher, unless the filter
+// expression drops it.
+func (h *Handler) emit(e *L9Event) {
+	h.finish(e)
+	if !h.conf.filter.keep(e) {
+		h.conf.Log("%v is filtered out", e.ID)
+		return
+	}
+
+	h.conf.sequence.stamp(e)
+	h.send(e)
+}
+
+// finish applies the output settings to an event that is about to be
+// emitted.
+func (h *Handler) finish(e *L9Event) {
+	h.conf.Message.parseImagePull(e)
+	h.conf.Message.normalize(e)
+	e.Security = h.conf.Enrich.ImageScan.scanOf(e.pod, h.conf.Log)
+	e.Severity = h.conf.severityOf(e)
+	e.Priority = h.conf.priorityOf(e.Severity)
+	if h.conf.Output.IncludeProducerVersion {
+		e.ProducerVersion = VERSION
+	}
+	e.SchemaVersion = h.conf.Output.SchemaVersion
+	if e.AckToken == "" {
+		e.AckToken = e.ID
+	}
+	e.Time = h.conf.Output.localTime(e)
+	h.conf.Output.promotePod(e)
+	e.Producer = h.conf.producer
+	e.NamespaceLabels = h.conf.Enrich.NamespaceLabels.labelsOf(h.db, h.client, e.Namespace, h.conf.Log)
+	if h.conf.Output.LegacyReferenceVersion && e.ReferenceAPIVersion != "" {
+		e.ReferenceVersion = e.ReferenceAPIVersion
+	}
+}
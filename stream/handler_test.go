@@ -0,0 +1,1303 @@
+package stream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/go-playground/assert.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	coordination "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type events struct {
+	Items []*v1.Event `json:"items"`
+}
+
+func TestMakeL9Event(t *testing.T) {
+	var wg sync.WaitGroup
+	e := &events{}
+	ch := make(chan interface{})
+
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "814a4994-e977-4e07-be69-6a464b2169c9"
+
+	t.Run("Wait till you get the key", func(t *testing.T) {
+		if err := mCache.ExpireSet(
+			objectCacheTable, key,
+			&unstructured.Unstructured{}, objectCacheExpiry,
+		); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Make Last9 Event", func(t *testing.T) {
+		b, err := ioutil.ReadFile("testdata/events.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(b, e); err != nil {
+			t.Fatal(err)
+		}
+
+		c, _ := newCache()
+		ev, err := makeL9EventDetails(
+			c, e.Items[0], nil, []string{"127.0.0.1"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, ev.ID, "19b4506f-95f4-4dd0-8d2d-bf7647997877")
+		assert.Equal(t, ev.Address[0], "127.0.0.1")
+
+		t.Run("Version value in event matches binary version", func(t *testing.T) {
+			assert.Equal(t, ev.Version, VERSION)
+		})
+	})
+
+	t.Run("Receive event over Handler", func(t *testing.T) {
+		go func() {
+			defer wg.Done()
+			msg := <-ch
+			x := msg.(*L9Event)
+			assert.Equal(t, "Scheduled", x.Reason)
+		}()
+
+		wg.Add(1)
+		h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+		h.OnAdd(e.Items[0])
+		wg.Wait()
+	})
+}
+
+func TestOOMKilledEvents(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pyserve-oom", Namespace: "default", UID: "oom-pod-uid",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "pyserve",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+				},
+			}},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:         "pyserve",
+				RestartCount: 3,
+				LastTerminationState: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{
+						Reason: "OOMKilled", ExitCode: 137,
+					},
+				},
+			}},
+		},
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mCache.ExpireSet(
+		objectCacheTable, string(pod.UID),
+		&unstructured.Unstructured{Object: obj}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{UID: "oom-event-uid", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", APIVersion: "v1", UID: pod.UID,
+			Name: pod.Name, Namespace: pod.Namespace,
+		},
+		Reason: "BackOff",
+	}
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{
+		&KubernetesClient{}, ch, mCache,
+		&L9K8streamConfig{EmitOOMEvents: true},
+	}
+	h.OnAdd(e)
+
+	assert.Equal(t, len(ch), 2)
+	assert.Equal(t, (<-ch).(*L9Event).Reason, "BackOff")
+
+	oom := (<-ch).(*L9Event)
+	assert.Equal(t, oom.Reason, "OOMKilled")
+	assert.Equal(t, oom.ID, "oom-pod-uid-pyserve-3")
+	assert.Equal(t, oom.Container["name"], "pyserve")
+	assert.Equal(t, oom.Container["exit_code"], int32(137))
+	assert.Equal(t, oom.Container["memory_limit"], "128Mi")
+
+	t.Run("Restart already reported is not emitted again", func(t *testing.T) {
+		if err := mCache.ExpireSet(
+			eventCacheTable, oom.ID, oom, objectCacheExpiry,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		oomEvents, err := makeOOMEvents(h.processed, e, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(oomEvents), 0)
+	})
+}
+
+func testPod(name, uid string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: name, Namespace: "default", UID: types.UID(uid), Labels: labels,
+	}}
+}
+
+func testService(rv string, selector map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pyserve", Namespace: "default", UID: "svc-uid",
+			ResourceVersion: rv,
+		},
+		Spec: v1.ServiceSpec{
+			Selector: selector,
+			Ports:    []v1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+}
+
+func TestServiceTransitions(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset(
+		testPod("a-1", "pod-a-1", map[string]string{"app": "a"}),
+		testPod("b-1", "pod-b-1", map[string]string{"app": "b"}),
+	)
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{
+		&KubernetesClient{Clientset: clientset}, ch, mCache,
+		&L9K8streamConfig{ServiceTransitionsOnly: true},
+	}
+
+	// flushed takes the next event as the flush would, once it is delivered.
+	flushed := func() *L9Event {
+		e := (<-ch).(*L9Event)
+		markProcessed(mCache, []interface{}{e})
+		return e
+	}
+
+	h.OnAdd(testService("1", map[string]string{"app": "a"}))
+	assert.Equal(t, len(ch), 1)
+	assert.Equal(t, flushed().Reason, "addedService")
+
+	t.Run("Unchanged update is not emitted", func(t *testing.T) {
+		h.OnUpdate(nil, testService("2", map[string]string{"app": "a"}))
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Selector change is emitted with its reason", func(t *testing.T) {
+		h.OnUpdate(nil, testService("3", map[string]string{"app": "b"}))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, flushed().Reason, serviceSelectorChanged)
+	})
+
+	t.Run("Pod set change is emitted with its reason", func(t *testing.T) {
+		if _, err := clientset.CoreV1().Pods("default").Create(
+			testPod("b-2", "pod-b-2", map[string]string{"app": "b"}),
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		h.OnUpdate(nil, testService("4", map[string]string{"app": "b"}))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, flushed().Reason, servicePodsChanged)
+	})
+
+	t.Run("A change that failed to flush is emitted again", func(t *testing.T) {
+		h.OnUpdate(nil, testService("5", map[string]string{"app": "a"}))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, (<-ch).(*L9Event).Reason, serviceSelectorChanged)
+
+		h.OnUpdate(nil, testService("6", map[string]string{"app": "a"}))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, flushed().Reason, serviceSelectorChanged)
+	})
+
+	t.Run("A delete forgets the state", func(t *testing.T) {
+		h.OnDelete(testService("7", map[string]string{"app": "a"}))
+		assert.Equal(t, flushed().Reason, "deletedService")
+
+		r, err := mCache.Get(serviceStateTable, "svc-uid")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, r.Exists(), false)
+	})
+}
+
+func TestExcludeSelf(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &unstructured.Unstructured{}
+	rs.SetAPIVersion("apps/v1")
+	rs.SetKind("ReplicaSet")
+	rs.SetName("k8stream-5d8f7b9c4")
+	rs.SetUID("self-rs-uid")
+	rs.SetOwnerReferences([]metav1.OwnerReference{
+		controllerRef("apps/v1", "Deployment", "k8stream", "self-deploy-uid"),
+	})
+	if err := db.ExpireSet(objectCacheTable, "self-rs-uid", rs, objectCacheExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	deploy := &unstructured.Unstructured{}
+	deploy.SetName("k8stream")
+	deploy.SetUID("self-deploy-uid")
+	if err := db.ExpireSet(objectCacheTable, "self-deploy-uid", deploy, objectCacheExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	kc := &KubernetesClient{Clientset: fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "k8stream-5d8f7b9c4-x2x7q", Namespace: "last9", UID: "self-pod-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				controllerRef("apps/v1", "ReplicaSet", "k8stream-5d8f7b9c4", "self-rs-uid"),
+			},
+		},
+	})}
+
+	conf := &L9K8streamConfig{
+		ExcludeSelf: true, SelfNamespace: "last9", SelfPod: "k8stream-5d8f7b9c4-x2x7q",
+	}
+	conf.selfUIDs, err = resolveSelf(db, kc, conf.SelfNamespace, conf.SelfPod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{conf: conf}
+
+	event := func(kind, name, uid string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "last9"},
+			InvolvedObject: v1.ObjectReference{
+				Kind: kind, Name: name, Namespace: "last9", UID: types.UID(uid),
+			},
+			Reason: "Pulled",
+		}
+	}
+
+	assert.Equal(t, h.isEligible(event("Pod", "k8stream-5d8f7b9c4-x2x7q", "self-pod-uid")), false)
+	assert.Equal(t, h.isEligible(event("ReplicaSet", "k8stream-5d8f7b9c4", "self-rs-uid")), false)
+	assert.Equal(t, h.isEligible(event("Deployment", "k8stream", "self-deploy-uid")), false)
+	assert.Equal(t, h.isEligible(event("Pod", "another-app-6c9f", "other-pod-uid")), true)
+
+	t.Run("Objects named like k8stream are kept", func(t *testing.T) {
+		assert.Equal(t, h.isEligible(event("ConfigMap", "k8stream", "configmap-uid")), true)
+	})
+
+	t.Run("Kept when excludeSelf is off", func(t *testing.T) {
+		conf.ExcludeSelf = false
+		assert.Equal(t, h.isEligible(event("Pod", "k8stream-5d8f7b9c4-x2x7q", "self-pod-uid")), true)
+	})
+}
+
+func TestNamespaceLists(t *testing.T) {
+	eligible := func(t *testing.T, raw string, ns string) bool {
+		conf := &L9K8streamConfig{}
+		if raw != "" {
+			assert.Equal(t, json.Unmarshal([]byte(raw), conf), nil)
+		}
+
+		h := &Handler{conf: conf}
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: ns}}
+		ok := h.isEligible(&v1.Event{ObjectMeta: metav1.ObjectMeta{Namespace: ns}})
+		assert.Equal(t, h.watchesService(service), ok)
+		return ok
+	}
+
+	t.Run("System namespaces are skipped by default", func(t *testing.T) {
+		assert.Equal(t, eligible(t, "", "kube-system"), false)
+		assert.Equal(t, eligible(t, "", "default"), true)
+	})
+
+	t.Run("Include", func(t *testing.T) {
+		raw := `{"namespaces": {"include": ["kube-system", "payments"]}}`
+		assert.Equal(t, eligible(t, raw, "kube-system"), true)
+		assert.Equal(t, eligible(t, raw, "payments"), true)
+		assert.Equal(t, eligible(t, raw, "default"), false)
+	})
+
+	t.Run("Exclude wins over include", func(t *testing.T) {
+		raw := `{"namespaces": {"include": ["payments", "payments-canary"], "exclude": ["payments-canary"]}}`
+		assert.Equal(t, eligible(t, raw, "payments"), true)
+		assert.Equal(t, eligible(t, raw, "payments-canary"), false)
+	})
+
+	t.Run("Exclude alone replaces the defaults", func(t *testing.T) {
+		raw := `{"namespaces": {"exclude": ["sandbox"]}}`
+		assert.Equal(t, eligible(t, raw, "kube-system"), true)
+		assert.Equal(t, eligible(t, raw, "sandbox"), false)
+	})
+
+	t.Run("A plain list is the include list", func(t *testing.T) {
+		raw := `{"namespaces": ["default"]}`
+		assert.Equal(t, eligible(t, raw, "default"), true)
+		assert.Equal(t, eligible(t, raw, "payments"), false)
+	})
+}
+
+func TestServiceMaxPods(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"app": "a"}
+	clientset := fake.NewSimpleClientset(
+		testPod("a-1", "pod-a-1", labels),
+		testPod("a-2", "pod-a-2", labels),
+		testPod("a-3", "pod-a-3", labels),
+	)
+
+	ch := make(chan interface{}, 1)
+	conf := &L9K8streamConfig{}
+	conf.ServiceEnrichment.MaxPods = 2
+	h := &Handler{&KubernetesClient{Clientset: clientset}, ch, mCache, conf}
+
+	h.OnAdd(testService("1", labels))
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, len(e.Pod), 2)
+	assert.Equal(t, e.TotalPods, 3)
+	assert.Equal(t, e.PodsTruncated, true)
+
+	t.Run("service-pods mapping is capped too", func(t *testing.T) {
+		var pods []v1.Pod
+		r, err := mCache.Get(servicePodsTable, "svc-uid")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Unmarshal(&pods); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(pods), 2)
+	})
+
+	t.Run("pod-service index only has the kept pods", func(t *testing.T) {
+		tables, err := mCache.Tables(makeKey(podServicesTable, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(tables), 2)
+	})
+}
+
+func TestSharedDedup(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	conf := &L9K8streamConfig{}
+	conf.Dedup.Scope = dedupShared
+	SetDefaults(conf)
+
+	// Replicas have caches of their own, and share only the claims.
+	ch := make(chan interface{}, 2)
+	replicas := []*Handler{}
+	for ix := 0; ix < 2; ix++ {
+		mCache, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mCache.ExpireSet(
+			objectCacheTable, "shared-pod-uid",
+			&unstructured.Unstructured{}, objectCacheExpiry,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		c := *conf
+		c.claims = newRedisClaims(server.Addr().String(), conf.Dedup.KeyPrefix)
+		replicas = append(replicas, &Handler{&KubernetesClient{}, ch, mCache, &c})
+	}
+
+	e := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "shared-event-uid", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{UID: "shared-pod-uid", Namespace: "default"},
+		Reason:         "Scheduled",
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range replicas {
+		wg.Add(1)
+		go func(h *Handler) {
+			defer wg.Done()
+			h.OnAdd(e)
+		}(h)
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(ch), 1)
+}
+
+func TestEventSeries(t *testing.T) {
+	first := time.Date(2020, 2, 20, 8, 39, 3, 0, time.UTC)
+	last := first.Add(10 * time.Minute)
+
+	e := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "series-uid", Namespace: "default"},
+		Reason:         "BackOff",
+		Count:          5,
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+
+	ev, err := makeL9EventDetails(nil, e, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ev.Count, int32(5))
+	assert.Equal(t, ev.FirstTimestamp, first.Unix())
+	assert.Equal(t, ev.LastTimestamp, last.Unix())
+
+	t.Run("Unset timestamps are zero", func(t *testing.T) {
+		ev, err := makeL9EventDetails(nil, &v1.Event{}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, ev.FirstTimestamp, int64(0))
+		assert.Equal(t, ev.LastTimestamp, int64(0))
+	})
+}
+
+func TestNamespaceEvents(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 2)
+	h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "payments", UID: "ns-payments", ResourceVersion: "1",
+		Labels: map[string]string{"team": "billing"},
+	}}
+
+	h.OnAdd(ns)
+	assert.Equal(t, len(ch), 1)
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Reason, namespaceCreated)
+	assert.Equal(t, e.ReferenceKind, "Namespace")
+	assert.Equal(t, e.Labels["team"], "billing")
+
+	t.Run("Status only updates are not emitted", func(t *testing.T) {
+		updated := ns.DeepCopy()
+		updated.ResourceVersion = "2"
+		updated.Status.Phase = v1.NamespaceTerminating
+		h.OnUpdate(ns, updated)
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Label change is emitted", func(t *testing.T) {
+		updated := ns.DeepCopy()
+		updated.ResourceVersion = "3"
+		updated.Labels["team"] = "platform"
+		h.OnUpdate(ns, updated)
+		assert.Equal(t, len(ch), 1)
+
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, namespaceLabelsChanged)
+		assert.Equal(t, e.Labels["team"], "platform")
+		assert.Equal(t, e.ID, "ns-payments-3")
+	})
+}
+
+func TestResourceQuotaThresholds(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+
+	quota := func(rv, pods, cpu string) *v1.ResourceQuota {
+		return &v1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "compute", Namespace: "default", UID: "quota-uid", ResourceVersion: rv,
+			},
+			Status: v1.ResourceQuotaStatus{
+				Hard: v1.ResourceList{
+					v1.ResourcePods:        resource.MustParse("10"),
+					v1.ResourceRequestsCPU: resource.MustParse("2"),
+				},
+				Used: v1.ResourceList{
+					v1.ResourcePods:        resource.MustParse(pods),
+					v1.ResourceRequestsCPU: resource.MustParse(cpu),
+				},
+			},
+		}
+	}
+
+	old := quota("1", "7", "500m")
+	h.OnAdd(old)
+	assert.Equal(t, len(ch), 0)
+
+	crossed := quota("2", "8", "600m")
+	h.OnUpdate(old, crossed)
+	assert.Equal(t, len(ch), 1)
+
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Reason, quotaThresholdReason)
+	assert.Equal(t, e.ReferenceKind, "ResourceQuota")
+	assert.Equal(t, e.Quota.Resource, "pods")
+	assert.Equal(t, e.Quota.Threshold, 80)
+	assert.Equal(t, e.Quota.Percent, float64(80))
+
+	t.Run("Updates past the threshold are not emitted again", func(t *testing.T) {
+		h.OnUpdate(crossed, quota("3", "9", "700m"))
+		assert.Equal(t, len(ch), 0)
+	})
+}
+
+func TestPDBViolations(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+
+	minAvailable := intstr.FromInt(2)
+	pdb := func(rv string, allowed, healthy int32) *policy.PodDisruptionBudget {
+		return &policy.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "web", Namespace: "default", UID: "pdb-uid", ResourceVersion: rv,
+			},
+			Spec: policy.PodDisruptionBudgetSpec{
+				MinAvailable: &minAvailable,
+				Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+			Status: policy.PodDisruptionBudgetStatus{
+				PodDisruptionsAllowed: allowed,
+				CurrentHealthy:        healthy,
+				DesiredHealthy:        2,
+				ExpectedPods:          3,
+			},
+		}
+	}
+
+	old := pdb("1", 1, 3)
+	h.OnAdd(old)
+	assert.Equal(t, len(ch), 0)
+
+	blocking := pdb("2", 0, 2)
+	h.OnUpdate(old, blocking)
+	assert.Equal(t, len(ch), 1)
+
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Reason, pdbBlockingReason)
+	assert.Equal(t, e.ReferenceKind, "PodDisruptionBudget")
+	assert.Equal(t, e.Type, v1.EventTypeWarning)
+	assert.Equal(t, *e.PDB, PDBStatus{
+		Selector: "app=web", MinAvailable: "2",
+		DisruptionsAllowed: 0, CurrentHealthy: 2, DesiredHealthy: 2, ExpectedPods: 3,
+	})
+
+	t.Run("Resyncs of a violated budget are not emitted again", func(t *testing.T) {
+		h.OnUpdate(blocking, pdb("3", 0, 2))
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Falling below the desired healthy pods is a transition", func(t *testing.T) {
+		h.OnUpdate(pdb("3", 0, 2), pdb("4", 0, 1))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, (<-ch).(*L9Event).Reason, pdbUnhealthyReason)
+	})
+
+	t.Run("Recoveries are not emitted", func(t *testing.T) {
+		h.OnUpdate(pdb("4", 0, 1), pdb("5", 1, 3))
+		assert.Equal(t, len(ch), 0)
+	})
+}
+
+func TestLeaseFailover(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+
+	lease := func(rv, holder string, renewed time.Time) *coordination.Lease {
+		duration, transitions := int32(15), int32(1)
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "kube-controller-manager", Namespace: "kube-system",
+				UID: "lease-uid", ResourceVersion: rv,
+			},
+			Spec: coordination.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &renewTime,
+				LeaseTransitions:     &transitions,
+			},
+		}
+	}
+
+	now := time.Now()
+	old := lease("1", "master-1", now)
+	h.OnAdd(old)
+	h.OnUpdate(old, lease("2", "master-1", now))
+	assert.Equal(t, len(ch), 0)
+
+	failedOver := lease("3", "master-2", now)
+	h.OnUpdate(lease("2", "master-1", now), failedOver)
+	assert.Equal(t, len(ch), 1)
+
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Reason, leaseFailoverReason)
+	assert.Equal(t, e.ReferenceKind, "Lease")
+	assert.Equal(t, e.ReferenceName, "kube-controller-manager")
+	assert.Equal(t, e.Namespace, "kube-system")
+	assert.Equal(t, e.Lease.Holder, "master-2")
+	assert.Equal(t, e.Lease.PreviousHolder, "master-1")
+	assert.Equal(t, e.Message, "Lease kube-controller-manager moved from master-1 to master-2")
+
+	t.Run("Deduped by resourceVersion once flushed", func(t *testing.T) {
+		markProcessed(mCache, []interface{}{e})
+		h.OnUpdate(lease("2", "master-1", now), failedOver)
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Stalled renewals", func(t *testing.T) {
+		stalled := lease("4", "master-2", now.Add(-2*time.Minute))
+		h.OnUpdate(stalled, stalled)
+		assert.Equal(t, len(ch), 1)
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, leaseStalledReason)
+		assert.Equal(t, e.Lease.Holder, "master-2")
+
+		// Resyncs of the same stall.
+		markProcessed(mCache, []interface{}{e})
+		h.OnUpdate(stalled, stalled)
+		assert.Equal(t, len(ch), 0)
+	})
+}
+
+func TestWatchedObjects(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{&KubernetesClient{}, ch, mCache, &L9K8streamConfig{}}
+
+	deployment := func(rv string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name: "web", Namespace: "default", UID: "deploy-uid", ResourceVersion: rv,
+		}}
+	}
+
+	h.OnAdd(deployment("1"))
+	h.OnUpdate(deployment("1"), deployment("2"))
+	h.OnDelete(deployment("2"))
+	assert.Equal(t, len(ch), 3)
+
+	for _, reason := range []string{"addedDeployment", "updatedDeployment", "deletedDeployment"} {
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, reason)
+		assert.Equal(t, e.ReferenceKind, "Deployment")
+		assert.Equal(t, e.ReferenceName, "web")
+	}
+
+	t.Run("Resyncs are not emitted", func(t *testing.T) {
+		h.OnUpdate(deployment("2"), deployment("2"))
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Pods carry their details", func(t *testing.T) {
+		h.OnAdd(testPod("web-1", "pod-web-1", map[string]string{"app": "web"}))
+		assert.Equal(t, len(ch), 1)
+
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, "addedPod")
+		assert.Equal(t, e.Pod["name"], "web-1")
+	})
+}
+
+// tlsSecret is a kubernetes.io/tls Secret of a self-signed certificate for
+// dnsNames, valid until notAfter.
+func tlsSecret(t *testing.T, dnsNames []string, notAfter time.Time) *v1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-tls", Namespace: "default", UID: "secret-uid", ResourceVersion: "1",
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			v1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	conf := &L9K8streamConfig{}
+	conf.Watch.CertExpiryWarningDays = 14
+	h := &Handler{&KubernetesClient{}, ch, mCache, conf}
+
+	t.Run("Far from expiry", func(t *testing.T) {
+		h.OnAdd(tlsSecret(t, []string{"web.example.com"}, time.Now().Add(60*24*time.Hour)))
+		assert.Equal(t, len(ch), 0)
+	})
+
+	t.Run("Not a TLS secret", func(t *testing.T) {
+		s := tlsSecret(t, []string{"web.example.com"}, time.Now().Add(time.Hour))
+		s.Type = v1.SecretTypeOpaque
+		h.OnAdd(s)
+		assert.Equal(t, len(ch), 0)
+	})
+
+	secret := tlsSecret(t, []string{"web.example.com", "www.example.com"}, time.Now().Add(10*24*time.Hour+time.Hour))
+	h.OnAdd(secret)
+	assert.Equal(t, len(ch), 1)
+
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.Reason, certExpiringReason)
+	assert.Equal(t, e.ReferenceKind, "Secret")
+	assert.Equal(t, e.ReferenceName, "web-tls")
+	assert.Equal(t, e.Certificate.Secret, "web-tls")
+	assert.Equal(t, e.Certificate.Subject, "CN=web.example.com")
+	assert.Equal(t, e.Certificate.SANs, []string{"web.example.com", "www.example.com"})
+	assert.Equal(t, e.Certificate.DaysRemaining, 10)
+
+	t.Run("No key material", func(t *testing.T) {
+		for _, o := range []*OutputConfig{{}, {Format: formatRaw}} {
+			b, err := encodeEvent(e, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, strings.Contains(string(b), "PRIVATE KEY"), false)
+			assert.Equal(t, strings.Contains(string(b), "CERTIFICATE"), false)
+		}
+	})
+
+	t.Run("Once per day remaining", func(t *testing.T) {
+		markProcessed(mCache, []interface{}{e})
+		h.OnUpdate(secret, secret)
+		assert.Equal(t, len(ch), 0)
+	})
+}
+
+func TestStaleServiceUpdates(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientset := fake.NewSimpleClientset(
+		testPod("a-1", "pod-a-1", map[string]string{"app": "a"}),
+	)
+
+	ch := make(chan interface{}, 4)
+	h := &Handler{
+		&KubernetesClient{Clientset: clientset}, ch, mCache,
+		&L9K8streamConfig{},
+	}
+
+	selector := map[string]string{"app": "a"}
+	for _, rv := range []string{"9", "10", "2"} {
+		h.OnUpdate(nil, testService(rv, selector))
+	}
+
+	// "10" sorts before "9" as a string, but is the newer of the two.
+	assert.Equal(t, len(ch), 2)
+	assert.Equal(t, (<-ch).(*L9Event).ReferenceVersion, "9")
+	assert.Equal(t, (<-ch).(*L9Event).ReferenceVersion, "10")
+}
+
+func TestHandlerPanics(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a client, looking up the involved object panics.
+	h := &Handler{&KubernetesClient{}, make(chan interface{}, 1), mCache, &L9K8streamConfig{}}
+
+	e := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "broken", Namespace: "default", UID: "event-uid"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "web", UID: "pod-uid"},
+	}
+
+	before := testutil.ToFloat64(handlerPanics)
+	h.OnAdd(e)
+	h.OnUpdate(nil, e)
+	assert.Equal(t, testutil.ToFloat64(handlerPanics)-before, float64(2))
+}
+
+func TestHandlerMaxGoroutines(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mCache.ExpireSet(
+		objectCacheTable, "bounded-pod-uid",
+		&unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{HandlerMaxGoroutines: 2}
+	conf.handlerSlots = make(chan struct{}, conf.HandlerMaxGoroutines)
+
+	// Nothing reads the events yet, so every handler goroutine is stuck.
+	ch := make(chan interface{})
+	h := &Handler{&KubernetesClient{}, ch, mCache, conf}
+
+	dispatched := make(chan struct{})
+	go func() {
+		for ix := 0; ix < 5; ix++ {
+			h.OnAdd(&v1.Event{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: types.UID("bounded-" + strconv.Itoa(ix)), Namespace: "default",
+				},
+				InvolvedObject: v1.ObjectReference{UID: "bounded-pod-uid", Namespace: "default"},
+			})
+		}
+		close(dispatched)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, len(conf.handlerSlots), 2)
+	select {
+	case <-dispatched:
+		t.Fatal("More objects were handled at once than allowed")
+	default:
+	}
+
+	for ix := 0; ix < 5; ix++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Event was never emitted")
+		}
+	}
+	<-dispatched
+}
+
+func TestEventDeletes(t *testing.T) {
+	e := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "web.1", Namespace: "default", UID: "event-uid"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "web", UID: "pod-uid"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+	}
+
+	run := func(t *testing.T, policy string) chan interface{} {
+		mCache, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf := &L9K8streamConfig{EventDeletes: policy}
+		SetDefaults(conf)
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{&KubernetesClient{}, ch, mCache, conf}
+		h.OnDelete(e)
+		return ch
+	}
+
+	t.Run("ignore", func(t *testing.T) {
+		assert.Equal(t, len(run(t, "")), 0)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		ch := run(t, eventDeletesExpired)
+		assert.Equal(t, len(ch), 1)
+
+		ev := (<-ch).(*L9Event)
+		assert.Equal(t, ev.ID, "event-uid-expired")
+		assert.Equal(t, ev.Reason, eventExpiredReason)
+		assert.Equal(t, ev.Message, "BackOff expired: Back-off restarting failed container")
+		assert.Equal(t, ev.ReferenceName, "web")
+	})
+}
+
+func TestProducerVersion(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		conf := &L9K8streamConfig{}
+		conf.Output.IncludeProducerVersion = enabled
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: conf, ch: ch}
+		h.emit(&L9Event{ID: "id"})
+
+		b, err := encodeEvent((<-ch).(*L9Event), &conf.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, strings.Contains(string(b), `"producer_version":"`+VERSION+`"`), enabled)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	for _, configured := range []string{"", "2"} {
+		conf := &L9K8streamConfig{}
+		conf.Output.SchemaVersion = configured
+		SetDefaults(conf)
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: conf, ch: ch}
+		h.emit(&L9Event{ID: "id"})
+
+		want := configured
+		if want == "" {
+			want = SchemaVersion
+		}
+		assert.Equal(t, (<-ch).(*L9Event).SchemaVersion, want)
+		assert.Equal(t, conf.Config.SchemaVersion, want)
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	// 2020-04-08T10:12:01Z
+	const ts = 1586340721
+
+	t.Run("Events carry the time in the timezone", func(t *testing.T) {
+		conf := &L9K8streamConfig{}
+		conf.Output.Timezone = "Asia/Kolkata"
+		SetDefaults(conf)
+		assert.Equal(t, conf.Output.loadTimezone(), nil)
+		assert.Equal(t, conf.Config.Timezone, "Asia/Kolkata")
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: conf, ch: ch}
+		h.emit(&L9Event{ID: "id", Timestamp: ts})
+
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Time, "2020-04-08T15:42:01+05:30")
+		assert.Equal(t, e.Timestamp, int64(ts))
+	})
+
+	t.Run("Events carry no time unless a timezone is set", func(t *testing.T) {
+		conf := &L9K8streamConfig{}
+		assert.Equal(t, conf.Output.loadTimezone(), nil)
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: conf, ch: ch}
+		h.emit(&L9Event{ID: "id", Timestamp: ts})
+		assert.Equal(t, (<-ch).(*L9Event).Time, "")
+	})
+
+	t.Run("Unknown timezones are rejected", func(t *testing.T) {
+		o := &OutputConfig{Timezone: "Mars/Olympus_Mons"}
+		assert.NotEqual(t, o.loadTimezone(), nil)
+	})
+}
+
+func TestProducer(t *testing.T) {
+	env := map[string]string{
+		"NODE_NAME": "node-1", "POD_NAME": "k8stream-0", "POD_NAMESPACE": "last9",
+	}
+	for k, v := range env {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{conf: conf, ch: ch}
+	h.emit(&L9Event{ID: "id"})
+
+	assert.Equal(t, (<-ch).(*L9Event).Producer, &Producer{
+		Node: "node-1", Pod: "k8stream-0", Namespace: "last9",
+	})
+
+	t.Run("Outside a cluster there is none", func(t *testing.T) {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		assert.Equal(t, producerFromEnv() == nil, true)
+	})
+}
+
+func TestStartupQuietPeriod(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{StartupQuietPeriod: 60}
+	SetDefaults(conf)
+	conf.quiet = newQuietPeriod()
+
+	ch := make(chan interface{}, 3)
+	h := &Handler{&KubernetesClient{}, ch, mCache, conf}
+	p := &Pipeline{Handler: h}
+
+	// The involved object is cached already, as no cluster is at hand.
+	if err := mCache.ExpireSet(
+		objectCacheTable, "pod-uid", &unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(uid, reason string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "web", UID: "pod-uid"},
+			Reason:         reason,
+		}
+	}
+
+	// Shipped by a previous run.
+	if err := mCache.ExpireSet(eventCacheTable, "known", true, objectCacheExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	h.OnAdd(event("known", "BackOff"))
+	h.OnAdd(event("unshipped", "BackOff"))
+	h.OnUpdate(nil, event("unshipped", "Failed"))
+	assert.Equal(t, len(ch), 0)
+
+	p.MarkSynced()
+	assert.Equal(t, len(ch), 1)
+
+	got := (<-ch).(*L9Event)
+	assert.Equal(t, got.ID, "unshipped")
+	assert.Equal(t, got.Reason, "Failed")
+
+	t.Run("Events are handled as they come once synced", func(t *testing.T) {
+		p.MarkSynced()
+		assert.Equal(t, len(ch), 0)
+
+		h.OnAdd(event("new", "BackOff"))
+		assert.Equal(t, len(ch), 1)
+		assert.Equal(t, (<-ch).(*L9Event).ID, "new")
+	})
+}
+
+func TestResourceVersion(t *testing.T) {
+	e := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web.1", Namespace: "default", UID: "event-uid", ResourceVersion: "4711",
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", Name: "web", UID: "pod-uid", APIVersion: "v1", ResourceVersion: "42",
+		},
+	}
+
+	ev, err := makeL9EventDetails(nil, e, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ev.ResourceVersion, "4711")
+	assert.Equal(t, ev.ReferenceVersion, "42")
+	assert.Equal(t, ev.ReferenceAPIVersion, "v1")
+
+	t.Run("Legacy reference version", func(t *testing.T) {
+		conf := &L9K8streamConfig{}
+		conf.Output.LegacyReferenceVersion = true
+
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: conf, ch: ch}
+		h.emit(ev)
+
+		legacy := (<-ch).(*L9Event)
+		assert.Equal(t, legacy.ReferenceVersion, "v1")
+		assert.Equal(t, legacy.ReferenceAPIVersion, "v1")
+	})
+}
+
+func TestServiceEdges(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"app": "a"}
+	clientset := fake.NewSimpleClientset(
+		testPod("a-1", "pod-a-1", labels),
+		testPod("a-2", "pod-a-2", labels),
+	)
+
+	// Another service is already in front of one of the pods.
+	other := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "admin", UID: "other-uid"}}
+	if err := mCache.Set(serviceTable, "other-uid", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := mCache.Set(makeKey(podServicesTable, "pod-a-1"), "other-uid", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 4)
+	conf := &L9K8streamConfig{}
+	conf.Topology.EmitEdges = true
+	h := &Handler{&KubernetesClient{Clientset: clientset}, ch, mCache, conf}
+
+	h.OnAdd(testService("1", labels))
+	assert.Equal(t, len(ch), 4)
+	assert.Equal(t, (<-ch).(*L9Event).Reason, "addedService")
+
+	want := []Edge{
+		{"Service", "svc-uid", "pyserve", "Pod", "pod-a-1", "a-1"},
+		{"Service", "svc-uid", "pyserve", "Pod", "pod-a-2", "a-2"},
+		{"Service", "svc-uid", "pyserve", "Service", "other-uid", "admin"},
+	}
+	for _, w := range want {
+		e := (<-ch).(*L9Event)
+		assert.Equal(t, e.Reason, serviceEdgeReason)
+		assert.Equal(t, *e.Edge, w)
+	}
+}
+
+func TestRawOutput(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mCache.ExpireSet(
+		objectCacheTable, "raw-pod-uid",
+		&unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 1)
+	conf := &L9K8streamConfig{}
+	conf.Output.Format = formatRaw
+	h := &Handler{
+		&KubernetesClient{Clientset: fake.NewSimpleClientset(
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		)}, ch, mCache, conf,
+	}
+
+	seen := metav1.NewTime(time.Date(2020, 2, 20, 8, 39, 3, 0, time.Local))
+	e := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web.15f4c", Namespace: "default", UID: "raw-event-uid",
+			ResourceVersion: "42", CreationTimestamp: seen,
+			Annotations: map[string]string{"note": "kept"},
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", Name: "web", Namespace: "default", UID: "raw-pod-uid",
+			APIVersion: "v1", ResourceVersion: "7", FieldPath: "spec.containers{web}",
+		},
+		Reason:              "BackOff",
+		Message:             "Back-off restarting failed container",
+		Source:              v1.EventSource{Component: "kubelet", Host: "node-1"},
+		FirstTimestamp:      seen,
+		LastTimestamp:       seen,
+		Count:               3,
+		Type:                v1.EventTypeWarning,
+		ReportingController: "kubelet",
+		ReportingInstance:   "node-1",
+	}
+
+	h.OnAdd(e)
+
+	var emitted *L9Event
+	select {
+	case v := <-ch:
+		emitted = v.(*L9Event)
+	case <-time.After(time.Second):
+		t.Fatal("Event was never emitted")
+	}
+
+	b, err := encodeEvent(emitted, &conf.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got v1.Event
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &got, e)
+}
@@ -0,0 +1,63 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const initialReason = "Initial"
+
+// EmitInitialState emits an Initial event for each object in the synced
+// informer stores, so that a new consumer sees the current state before
+// the changes that follow. Initial events are deduped by the object's UID
+// and resource version, so that a restart does not emit them again for
+// objects that have not changed.
+func (p *Pipeline) EmitInitialState(stores []cache.Store) {
+	h := p.Handler
+	if !h.conf.gate.enter() {
+		return
+	}
+	defer h.conf.gate.leave()
+
+	taken := time.Now()
+	n := 0
+	for _, s := range stores {
+		for _, obj := range s.List() {
+			m, err := meta.Accessor(obj)
+			if err != nil {
+				log.Println("initial state:", err)
+				continue
+			}
+
+			id := fmt.Sprintf("%s-initial-%s", m.GetUID(), m.GetResourceVersion())
+			processed, err := h.processed(id)
+			if err != nil {
+				log.Println("initial state:", err)
+				continue
+			}
+
+			if processed {
+				h.conf.Log("Initial state of %v was emitted already", m.GetUID())
+				continue
+			}
+
+			e, err := h.makeStateEvent(obj, id, initialReason, taken)
+			if err != nil || e == nil {
+				if err != nil {
+					log.Println("initial state:", err)
+				}
+				h.release(id)
+				continue
+			}
+
+			h.emit(e)
+			n++
+		}
+	}
+
+	log.Println("Initial state of", n, "objects")
+}
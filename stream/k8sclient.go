@@ -0,0 +1,253 @@
+package stream
+
+import (
+	fmt "fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	objectCacheTable  = "object"
+	objectCacheExpiry = 3600
+)
+
+// KubernetesClient is the clients the Handler looks objects up with.
+type KubernetesClient struct {
+	dynamic.Interface
+	meta.RESTMapper
+	Clientset kubernetes.Interface
+
+	// Pods are looked up in this informer store, when there is one.
+	pods cache.Store
+
+	// Addresses of nodes, cached and looked up once at a time.
+	nodes *nodeResolver
+}
+
+// Overrides applied on top of the kubeconfig, so that one kubeconfig with
+// many contexts can serve multiple k8stream deployments.
+type KubeOptions struct {
+	Context string `json:"context"`
+	Cluster string `json:"cluster"`
+	User    string `json:"user"`
+}
+
+// kubeconfigRules loads the kubeconfig from a file or, when kubeconfig is a
+// directory such as a mounted secret, merges every file within it.
+func kubeconfigRules(kubeconfig string) (*clientcmd.ClientConfigLoadingRules, error) {
+	fi, err := os.Stat(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}, nil
+	}
+
+	files, err := ioutil.ReadDir(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{}
+	for _, f := range files {
+		// Skip the ..data style symlinks and dirs of a secret mount.
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		rules.Precedence = append(rules.Precedence, filepath.Join(kubeconfig, f.Name()))
+	}
+
+	return rules, nil
+}
+
+// buildKubernetesConfig reads the kubeconfig when one is given, and the
+// config of the service account of the pod when it is not, for the same
+// binary to run locally and as a Deployment.
+func buildKubernetesConfig(kubeconfig string, o KubeOptions) (config *rest.Config, err error) {
+	if kubeconfig != "" {
+		rules, err := kubeconfigRules(kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %v: %w", kubeconfig, err)
+		}
+
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+		overrides.Context.Cluster = o.Cluster
+		overrides.Context.AuthInfo = o.User
+
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			rules, overrides,
+		).ClientConfig()
+	}
+
+	config, err = rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("no kubeconfig is set, and the in-cluster config is not available: %w", err)
+	}
+	return config, nil
+}
+
+func NewK8sClient(kubeconf string, o KubeOptions) (*KubernetesClient, error) {
+	config, err := buildKubernetesConfig(kubeconf, o)
+	if err != nil {
+		return nil, err
+	}
+
+	intf, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset := kubernetes.NewForConfigOrDie(config)
+
+	groupResources, err := restmapper.GetAPIGroupResources(clientset.Discovery())
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubernetesClient{
+		Clientset:  clientset,
+		Interface:  intf,
+		RESTMapper: restmapper.NewDiscoveryRESTMapper(groupResources),
+	}, nil
+}
+
+func (kc *KubernetesClient) getApps(db Cachier, s *v1.Service) ([]appsv1.Deployment, error) {
+	namespace := s.GetNamespace()
+
+	q := labels.Set(s.Spec.Selector)
+	apps, err := kc.Clientset.AppsV1().Deployments(namespace).List(
+		metav1.ListOptions{LabelSelector: q.String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return apps.Items, nil
+}
+
+func (kc *KubernetesClient) getPods(db Cachier, s *v1.Service) ([]v1.Pod, error) {
+	namespace := s.GetNamespace()
+	q := labels.Set(s.Spec.Selector)
+
+	if kc.pods != nil {
+		selector := q.AsSelector()
+		pods := []v1.Pod{}
+		for _, obj := range kc.pods.List() {
+			p, ok := obj.(*v1.Pod)
+			if ok && p.GetNamespace() == namespace && selector.Matches(labels.Set(p.GetLabels())) {
+				pods = append(pods, *p)
+			}
+		}
+		return pods, nil
+	}
+
+	pods, err := kc.Clientset.CoreV1().Pods(namespace).List(
+		metav1.ListOptions{LabelSelector: q.String()},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pods.Items, nil
+
+}
+
+func (kc *KubernetesClient) getService(namespace, name string) (*v1.Service, error) {
+	return kc.Clientset.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+}
+
+func (kc *KubernetesClient) getNodeAddress(db Cachier, node string) ([]string, error) {
+	if node == "" {
+		return []string{}, nil
+	}
+
+	return kc.nodes.resolve(node, func() ([]string, error) {
+		return kc.lookupNodeAddress(db, node)
+	})
+}
+
+func (kc *KubernetesClient) lookupNodeAddress(db Cachier, node string) ([]string, error) {
+	addr := []string{}
+
+	res, err := db.Get("node", node)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Exists() {
+		return addr, res.Unmarshal(&addr)
+	}
+
+	n, err := kc.Clientset.CoreV1().Nodes().Get(node, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range n.Status.Addresses {
+		addr = append(addr, i.Address)
+	}
+
+	ttl := objectCacheExpiry
+	if kc.nodes != nil {
+		ttl = int(kc.nodes.ttl / time.Second)
+	}
+
+	defer db.ExpireSet("node", node, addr, ttl)
+	return addr, nil
+}
+
+func (kc *KubernetesClient) getObject(db Cachier, ref *v1.ObjectReference) (*unstructured.Unstructured, error) {
+	uid := string(ref.UID)
+
+	var cached *unstructured.Unstructured
+	result, err := db.Get(objectCacheTable, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Exists() {
+		return cached, result.Unmarshal(&cached)
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	gk := schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+	mapping, err := kc.RESTMapper.RESTMapping(gk, gv.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := kc.Interface.Resource(mapping.Resource).Namespace(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.ExpireSet(objectCacheTable, uid, item, objectCacheExpiry)
+	if ref.UID != item.GetUID() {
+		defer db.ExpireSet(objectCacheTable, string(item.GetUID()), item, objectCacheExpiry)
+	}
+
+	return item, nil
+}
@@ -0,0 +1,179 @@
+package stream
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestBuildKubernetesConfig(t *testing.T) {
+	t.Run("Current context by default", func(t *testing.T) {
+		c, err := buildKubernetesConfig("testdata/kube/config", KubeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Host, "https://production.example.com:6443")
+		assert.Equal(t, c.BearerToken, "production-token")
+	})
+
+	t.Run("Selected context", func(t *testing.T) {
+		c, err := buildKubernetesConfig(
+			"testdata/kube/config", KubeOptions{Context: "staging"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Host, "https://staging.example.com:6443")
+		assert.Equal(t, c.BearerToken, "staging-token")
+	})
+
+	t.Run("Cluster and user overrides", func(t *testing.T) {
+		c, err := buildKubernetesConfig(
+			"testdata/kube/config",
+			KubeOptions{Cluster: "staging", User: "production-admin"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Host, "https://staging.example.com:6443")
+		assert.Equal(t, c.BearerToken, "production-token")
+	})
+
+	t.Run("Secret mounted directory", func(t *testing.T) {
+		c, err := buildKubernetesConfig("testdata/kube", KubeOptions{Context: "staging"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, c.Host, "https://staging.example.com:6443")
+	})
+
+	t.Run("Missing kubeconfig is named", func(t *testing.T) {
+		_, err := buildKubernetesConfig("testdata/kube/missing", KubeOptions{})
+		assert.NotEqual(t, err, nil)
+		assert.Equal(t, strings.Contains(err.Error(), "testdata/kube/missing"), true)
+	})
+
+	t.Run("In-cluster config without a kubeconfig", func(t *testing.T) {
+		host, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+		os.Unsetenv("KUBERNETES_SERVICE_HOST")
+		if ok {
+			defer os.Setenv("KUBERNETES_SERVICE_HOST", host)
+		}
+
+		_, err := buildKubernetesConfig("", KubeOptions{})
+		assert.NotEqual(t, err, nil)
+		assert.Equal(t, strings.Contains(err.Error(), "no kubeconfig"), true)
+		assert.Equal(t, strings.Contains(err.Error(), "in-cluster"), true)
+	})
+}
+
+func TestAvailableResources(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{Name: "namespaces"}, {Name: "resourcequotas"}},
+		},
+	}
+	kc := &KubernetesClient{Clientset: clientset}
+
+	// An old cluster, without the policy group.
+	w := &WatchConfig{Namespaces: true, ResourceQuotas: true, PDB: true}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	available, err := kc.AvailableResources(w.Resources(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, available, map[WatchedResource]bool{
+		NamespacesResource: true, ResourceQuotasResource: true,
+	})
+	assert.Equal(t, strings.Contains(logged.String(), "Not watching policy/v1beta1/poddisruptionbudgets"), true)
+
+	t.Run("Required", func(t *testing.T) {
+		_, err := kc.AvailableResources(w.Resources(), true)
+		assert.NotEqual(t, err, nil)
+	})
+}
+
+func TestWatchKinds(t *testing.T) {
+	conf := newTestConfig()
+	assert.Equal(t, conf.Watch.Kinds, []string{KindEvents, KindServices})
+
+	conf.Watch.Kinds = []string{KindEvents, KindDeployments}
+	assert.Equal(t, conf.Watch.Resources(), []WatchedResource{
+		{"v1", "events"}, {"apps/v1", "deployments"},
+	})
+
+	t.Run("Unknown kinds fail startup", func(t *testing.T) {
+		conf.Watch.Kinds = []string{KindEvents, "statefulsets"}
+		_, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+		assert.NotEqual(t, err, nil)
+		assert.Equal(t, strings.Contains(err.Error(), `unknown kind "statefulsets" in watch.kinds`), true)
+	})
+}
+
+func TestNodeAddressResolution(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Address: "10.0.0.1"}}},
+	})
+
+	var lookups int32
+	clientset.PrependReactor("get", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&lookups, 1)
+		time.Sleep(50 * time.Millisecond)
+		return false, nil, nil
+	})
+
+	kc := &KubernetesClient{Clientset: clientset, nodes: newNodeResolver(time.Minute)}
+
+	// Nothing is cached in between, as during a storm with the cache off.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := kc.getNodeAddress(noopCache{}, "node-1")
+			assert.Equal(t, err, nil)
+			assert.Equal(t, addr, []string{"10.0.0.1"})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, atomic.LoadInt32(&lookups), int32(1))
+
+	t.Run("Reused until they expire", func(t *testing.T) {
+		kc.getNodeAddress(noopCache{}, "node-1")
+		assert.Equal(t, atomic.LoadInt32(&lookups), int32(1))
+
+		kc.nodes.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		kc.getNodeAddress(noopCache{}, "node-1")
+		assert.Equal(t, atomic.LoadInt32(&lookups), int32(2))
+	})
+
+	t.Run("Failures are not cached", func(t *testing.T) {
+		_, err := kc.getNodeAddress(noopCache{}, "node-2")
+		assert.NotEqual(t, err, nil)
+		_, err = kc.getNodeAddress(noopCache{}, "node-2")
+		assert.NotEqual(t, err, nil)
+		assert.Equal(t, atomic.LoadInt32(&lookups), int32(4))
+	})
+}
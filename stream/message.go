@@ -0,0 +1,106 @@
+package stream
+
+import (
+	fmt "fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const pulledReason = "Pulled"
+
+// A regex substitution applied to event messages.
+type MessageRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+type MessageConfig struct {
+	// Rewrite messages with the rules, keeping the original message in
+	// original_message, so that they group cleanly in the sink.
+	Normalize bool          `json:"normalize"`
+	Rules     []MessageRule `json:"rules"`
+
+	// Set image and pull_duration_ms of Pulled events from their message.
+	ParseImagePulls bool `json:"parse_image_pulls"`
+}
+
+// The kubelet has worded Pulled messages differently across releases, e.g.
+//
+//	Successfully pulled image "nginx:1.19" in 3.456789012s
+//	Successfully pulled image "nginx:1.19" in 3.4s (3.4s including waiting)
+//	Container image "nginx:1.19" already present on machine
+//
+// so the image and the duration are matched apart.
+var (
+	pulledImageRe    = regexp.MustCompile(`(?i)image "([^"]+)"`)
+	pulledDurationRe = regexp.MustCompile(`" in ((?:[0-9.]+(?:ns|us|µs|ms|s|m|h))+)`)
+)
+
+// parseImagePull sets the image of a Pulled event, and how long the pull
+// took, as far as its message tells. Fields it cannot find are left empty.
+func (m *MessageConfig) parseImagePull(e *L9Event) {
+	if !m.ParseImagePulls || e.Reason != pulledReason {
+		return
+	}
+
+	if match := pulledImageRe.FindStringSubmatch(e.Message); match != nil {
+		e.Image = match[1]
+	}
+
+	if match := pulledDurationRe.FindStringSubmatch(e.Message); match != nil {
+		if d, err := time.ParseDuration(match[1]); err == nil {
+			e.PullDurationMs = d.Milliseconds()
+		}
+	}
+}
+
+// Rules used when normalization is asked for without any: UIDs and
+// timestamps are masked, then whitespace is collapsed.
+var defaultMessageRules = []MessageRule{
+	{Pattern: `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`, Replace: "<uid>"},
+	{Pattern: `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`, Replace: "<time>"},
+	{Pattern: `\s+`, Replace: " "},
+}
+
+// compile checks and compiles the rules.
+func (m *MessageConfig) compile() error {
+	if !m.Normalize {
+		return nil
+	}
+
+	if len(m.Rules) == 0 {
+		m.Rules = append([]MessageRule{}, defaultMessageRules...)
+	}
+
+	for i := range m.Rules {
+		re, err := regexp.Compile(m.Rules[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("message rule %q: %w", m.Rules[i].Pattern, err)
+		}
+		m.Rules[i].re = re
+	}
+
+	return nil
+}
+
+func (m *MessageConfig) normalize(e *L9Event) {
+	if !m.Normalize {
+		return
+	}
+
+	msg := e.Message
+	for _, r := range m.Rules {
+		if r.re != nil {
+			msg = r.re.ReplaceAllString(msg, r.Replace)
+		}
+	}
+
+	msg = strings.TrimSpace(msg)
+	if msg != e.Message {
+		e.OriginalMessage = e.Message
+		e.Message = msg
+	}
+}
@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestNormalizeMessage(t *testing.T) {
+	original := "Failed to pull   image for pod 19b4506f-95f4-4dd0-8d2d-bf7647997877\nat 2020-04-08T10:12:01Z"
+
+	t.Run("Default rules", func(t *testing.T) {
+		m := &MessageConfig{Normalize: true}
+		assert.Equal(t, m.compile(), nil)
+
+		e := &L9Event{Message: original}
+		m.normalize(e)
+		assert.Equal(t, e.Message, "Failed to pull image for pod <uid> at <time>")
+		assert.Equal(t, e.OriginalMessage, original)
+	})
+
+	t.Run("Configured rules", func(t *testing.T) {
+		m := &MessageConfig{Normalize: true, Rules: []MessageRule{
+			{Pattern: `pod \S+`, Replace: "pod <pod>"},
+		}}
+		assert.Equal(t, m.compile(), nil)
+
+		e := &L9Event{Message: "Killing pod web-5d8f7b9c4-x2x7q"}
+		m.normalize(e)
+		assert.Equal(t, e.Message, "Killing pod <pod>")
+		assert.Equal(t, e.OriginalMessage, "Killing pod web-5d8f7b9c4-x2x7q")
+	})
+
+	t.Run("Unchanged messages carry no original", func(t *testing.T) {
+		m := &MessageConfig{Normalize: true}
+		assert.Equal(t, m.compile(), nil)
+
+		e := &L9Event{Message: "Started container"}
+		m.normalize(e)
+		assert.Equal(t, e.OriginalMessage, "")
+	})
+
+	t.Run("Invalid patterns are rejected", func(t *testing.T) {
+		m := &MessageConfig{Normalize: true, Rules: []MessageRule{{Pattern: "("}}}
+		assert.NotEqual(t, m.compile(), nil)
+	})
+}
+
+func TestParseImagePull(t *testing.T) {
+	m := &MessageConfig{ParseImagePulls: true}
+
+	t.Run("Duration and image", func(t *testing.T) {
+		e := &L9Event{
+			Reason:  pulledReason,
+			Message: `Successfully pulled image "nginx:1.19" in 3.456789012s (3.456789012s including waiting)`,
+		}
+		m.parseImagePull(e)
+		assert.Equal(t, e.Image, "nginx:1.19")
+		assert.Equal(t, e.PullDurationMs, int64(3456))
+	})
+
+	t.Run("Image already present", func(t *testing.T) {
+		e := &L9Event{
+			Reason:  pulledReason,
+			Message: `Container image "registry.k8s.io/pause:3.9" already present on machine`,
+		}
+		m.parseImagePull(e)
+		assert.Equal(t, e.Image, "registry.k8s.io/pause:3.9")
+		assert.Equal(t, e.PullDurationMs, int64(0))
+	})
+
+	t.Run("Unparseable messages are left alone", func(t *testing.T) {
+		e := &L9Event{Reason: pulledReason, Message: "Pulled"}
+		m.parseImagePull(e)
+		assert.Equal(t, e.Image, "")
+		assert.Equal(t, e.PullDurationMs, int64(0))
+	})
+}
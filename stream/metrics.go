@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "k8stream"
+
+var (
+	eventBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_bytes",
+		Help:      "Serialized size of each event handed to the sink.",
+		Buckets:   prometheus.ExponentialBuckets(256, 2, 12),
+	})
+
+	oversizedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "oversized_events_total",
+		Help:      "Events larger than max_event_bytes, by the policy applied.",
+	}, []string{"policy"})
+
+	handlerPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "handler_panics_total",
+		Help:      "Panics recovered while handling an object.",
+	})
+
+	podIndexEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_index_evictions_total",
+		Help:      "Dead pods dropped from the pod to service reverse index.",
+	})
+
+	schedulingLatencies = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_scheduling_latency_seconds",
+		Help:      "Time from the creation of a pod to its Scheduled event.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	})
+
+	invalidReferences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "invalid_references_total",
+		Help:      "Events whose involved object could not be looked up, by why.",
+	}, []string{"problem"})
+
+	processedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_processed_total",
+		Help:      "Events flushed to the sinks, or otherwise done with.",
+	})
+
+	flushErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "flush_errors_total",
+		Help:      "Batches that could not be flushed to the sinks.",
+	})
+
+	channelDroppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "channel_dropped_events_total",
+		Help:      "Events dropped as the channel to the batchers was full, with channel_full_policy drop.",
+	})
+
+	sinkRouterFallbacks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_router_fallbacks_total",
+		Help:      "Sink lookups that failed, and fell back to the routes of severity_routes.",
+	})
+
+	receivedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_received_total",
+		Help:      "Objects the informers handed to the Handler, by kind.",
+	}, []string{"kind"})
+
+	dedupedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_deduplicated_total",
+		Help:      "Events not emitted again, as they were processed already.",
+	})
+
+	bufferedEvents = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_buffered",
+		Help:      "Events on their way to the batchers, or in a batch being filled.",
+	})
+
+	collapsedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "batch_collapsed_events_total",
+		Help:      "Events left out of a batch, for a later event of the same id in it.",
+	})
+
+	flushLatencies = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "flush_duration_seconds",
+		Help:      "Time a batch took to flush to a sink, retries included.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	})
+
+	// Kept without the k8stream namespace so that dashboards read naturally
+	// as a count of Kubernetes events.
+	k8sEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_events_total",
+		Help: "Kubernetes events observed, when output metrics are enabled.",
+	}, []string{"namespace", "reason", "type", "kind"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		eventBytes, oversizedEvents, handlerPanics, podIndexEvictions,
+		invalidReferences, schedulingLatencies, processedEvents, flushErrors,
+		channelDroppedEvents, sinkRouterFallbacks, receivedObjects,
+		dedupedEvents, bufferedEvents, collapsedEvents, flushLatencies, k8sEvents,
+	)
+}
+
+func countEvent(e *L9Event) {
+	k8sEvents.WithLabelValues(e.Namespace, e.Reason, e.Type, e.ReferenceKind).Inc()
+}
+
+// kindOf is the kind of an object handed to the Handler, by its type, as
+// the informers leave the TypeMeta of objects empty.
+func kindOf(obj interface{}) string {
+	t := reflect.TypeOf(obj)
+	if t == nil {
+		return "unknown"
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// StartMetricsServer exposes the default Prometheus registry on addr,
+// along with the readiness of k8stream on /readyz. The server is for the
+// program to Shutdown once it stops.
+func StartMetricsServer(addr string, ready http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/readyz", ready)
+
+	s := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Println("Serving metrics on", addr)
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("metrics server:", err)
+		}
+	}()
+	return s
+}
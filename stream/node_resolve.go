@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+type NodeResolveConfig struct {
+	// Seconds the addresses of a node are reused for.
+	CacheSeconds int `json:"cache_seconds"`
+}
+
+// nodeResolver caches the addresses of nodes for ttl, and coalesces the
+// concurrent lookups of a node into one, so that a storm of events from
+// one node looks it up once.
+type nodeResolver struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	cached   map[string]resolvedNode
+	inflight map[string]*nodeLookup
+}
+
+type resolvedNode struct {
+	addr    []string
+	expires time.Time
+}
+
+// nodeLookup is a lookup in flight, that the resolutions of the same node
+// wait for.
+type nodeLookup struct {
+	done chan struct{}
+	addr []string
+	err  error
+}
+
+func newNodeResolver(ttl time.Duration) *nodeResolver {
+	return &nodeResolver{
+		ttl:      ttl,
+		now:      time.Now,
+		cached:   map[string]resolvedNode{},
+		inflight: map[string]*nodeLookup{},
+	}
+}
+
+// resolve returns the addresses of node, looked up with lookup unless they
+// are cached or being looked up already. Failures are not cached. A nil
+// resolver looks every node up.
+func (r *nodeResolver) resolve(node string, lookup func() ([]string, error)) ([]string, error) {
+	if r == nil {
+		return lookup()
+	}
+
+	r.mu.Lock()
+	if c, ok := r.cached[node]; ok && r.now().Before(c.expires) {
+		r.mu.Unlock()
+		return c.addr, nil
+	}
+
+	if l, ok := r.inflight[node]; ok {
+		r.mu.Unlock()
+		<-l.done
+		return l.addr, l.err
+	}
+
+	l := &nodeLookup{done: make(chan struct{})}
+	r.inflight[node] = l
+	r.mu.Unlock()
+
+	l.addr, l.err = lookup()
+
+	r.mu.Lock()
+	delete(r.inflight, node)
+	if l.err == nil {
+		r.cached[node] = resolvedNode{l.addr, r.now().Add(r.ttl)}
+	}
+	r.mu.Unlock()
+
+	close(l.done)
+	return l.addr, l.err
+}
@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const oomKilledReason = "OOMKilled"
+
+// makeOOMEvents looks for containers whose last termination was an OOMKill
+// and synthesizes an L9Event for each one of them. The kubelet does not
+// always emit a distinct event for an OOMKill, so this correlates the
+// container status with the event that triggered the pod lookup.
+// Events are deduped per container restart count.
+func makeOOMEvents(
+	processed func(id string) (bool, error), e *v1.Event, p *v1.Pod,
+) ([]*L9Event, error) {
+	limits := map[string]string{}
+	for _, c := range p.Spec.Containers {
+		if m, ok := c.Resources.Limits[v1.ResourceMemory]; ok {
+			limits[c.Name] = m.String()
+		}
+	}
+
+	events := []*L9Event{}
+	for _, cs := range p.Status.ContainerStatuses {
+		t := cs.LastTerminationState.Terminated
+		if t == nil || t.Reason != oomKilledReason {
+			continue
+		}
+
+		id := fmt.Sprintf("%s-%s-%d", p.GetUID(), cs.Name, cs.RestartCount)
+		// This restart has been reported already.
+		done, err := processed(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if done {
+			continue
+		}
+
+		events = append(events, &L9Event{
+			ID:        id,
+			Timestamp: t.FinishedAt.Time.Unix(),
+			Component: e.Source.Component,
+			Host:      e.Source.Host,
+			Message: fmt.Sprintf(
+				"Container %s was OOMKilled (exit code %d, memory limit %s)",
+				cs.Name, t.ExitCode, limits[cs.Name],
+			),
+			Namespace:           p.GetNamespace(),
+			Reason:              oomKilledReason,
+			Type:                v1.EventTypeWarning,
+			ReferenceUID:        string(p.GetUID()),
+			ReferenceNamespace:  p.GetNamespace(),
+			ReferenceName:       p.GetName(),
+			ReferenceKind:       "Pod",
+			ReferenceVersion:    p.GetResourceVersion(),
+			ReferenceAPIVersion: e.InvolvedObject.APIVersion,
+			ObjectUid:           string(p.GetUID()),
+			ResourceVersion:     p.GetResourceVersion(),
+			Labels:              p.GetLabels(),
+			Annotations:         p.GetAnnotations(),
+			Pod:                 miniPodInfo(*p),
+			Version:             VERSION,
+			Container: map[string]interface{}{
+				"name":          cs.Name,
+				"exit_code":     t.ExitCode,
+				"memory_limit":  limits[cs.Name],
+				"restart_count": cs.RestartCount,
+			},
+		})
+	}
+
+	return events, nil
+}
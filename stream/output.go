@@ -0,0 +1,226 @@
+package stream
+
+import (
+	"encoding/json"
+	fmt "fmt"
+	"regexp"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	labelPrefix      = "label_"
+	annotationPrefix = "annotation_"
+)
+
+// Output formats
+const (
+	formatJSON = "json"
+
+	// Count events as Prometheus metrics instead of writing them to the sink.
+	formatMetricsOnly = "metrics-only"
+
+	// Write the Kubernetes object an event was made from as is. Events that
+	// k8stream derives itself, like OOMKilled, are still written as events.
+	formatRaw = "raw"
+)
+
+// Options controlling how events are serialized for the sink.
+type OutputConfig struct {
+	Format             string `json:"format"`
+	EventMetrics       bool   `json:"event_metrics"`
+	FlattenLabels      bool   `json:"flatten_labels"`
+	FlattenAnnotations bool   `json:"flatten_annotations"`
+
+	// Write the pod of an event as top-level pod_ fields instead of the
+	// nested pod map.
+	PodAsColumns bool `json:"pod_as_columns"`
+
+	// Stamp the k8stream build on every event as producer_version.
+	IncludeProducerVersion bool `json:"include_producer_version"`
+
+	// Put the API version of the involved object in reference_version, as
+	// it used to be, rather than its resourceVersion.
+	LegacyReferenceVersion bool `json:"legacy_reference_version"`
+
+	// Version of the event schema stamped on every event, and sent by the
+	// HTTP sinks as a header. SchemaVersion unless set.
+	SchemaVersion string `json:"schema_version"`
+
+	// Name of the tz database location that dates are written in, by
+	// the time of events and the date patterns of sink paths. UTC unless
+	// set; events only carry a time when it is set.
+	Timezone string `json:"timezone"`
+	location *time.Location
+
+	// Number the emitted events in sequence, in the epoch of the start of
+	// the instance. Persisted in the cache, the sequence carries on across
+	// restarts, when the cache does.
+	Sequence        bool `json:"sequence"`
+	PersistSequence bool `json:"persist_sequence"`
+}
+
+// SchemaVersion is the version of the shape of L9Event. Bump it when fields
+// are added, removed or change meaning, so that consumers can tell.
+const SchemaVersion = "1"
+
+// sinkFormats returns the formats that named sinks write in, by the
+// "format" key of their block. Sinks without one write in output.format,
+// which the type of each sink has to take too.
+func sinkFormats(sinks map[string]json.RawMessage, output string) (map[string]string, error) {
+	formats := map[string]string{}
+	for name, raw := range sinks {
+		var c struct {
+			Sink   string `json:"sink"`
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("sink %v: %w", name, err)
+		}
+
+		format := c.Format
+		switch c.Format {
+		case "":
+			format = output
+		case formatJSON, formatRaw:
+			formats[name] = c.Format
+		default:
+			return nil, fmt.Errorf("sink %v: format %q cannot be written to a sink", name, c.Format)
+		}
+
+		if err := checkSinkFormat(c.Sink, format); err != nil {
+			return nil, fmt.Errorf("sink %v: %w", name, err)
+		}
+	}
+
+	return formats, nil
+}
+
+// checkSinkFormat fails a format that sinks of the type cannot take.
+func checkSinkFormat(sink, format string) error {
+	if format == formatRaw && !io.TakesObjects(sink) {
+		return fmt.Errorf("a %v sink takes only events, not format %q", sink, format)
+	}
+	return nil
+}
+
+// outputFor returns the output settings of the sink by that name, "" being
+// the primary sink.
+func (c *L9K8streamConfig) outputFor(sink string) *OutputConfig {
+	format, ok := c.sinkFormats[sink]
+	if !ok {
+		return &c.Output
+	}
+
+	o := c.Output
+	o.Format = format
+	return &o
+}
+
+// countsEvents reports whether events are turned into labeled counters.
+func (o *OutputConfig) countsEvents() bool {
+	return o.EventMetrics || o.Format == formatMetricsOnly
+}
+
+var invalidFieldChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// sanitizeFieldName turns an arbitrary label key like app.kubernetes.io/name
+// into something every flat-schema sink accepts as a column name.
+func sanitizeFieldName(k string) string {
+	return invalidFieldChars.ReplaceAllString(k, "_")
+}
+
+// encodeEvent serializes an event as it should be written to the sink.
+func encodeEvent(e *L9Event, o *OutputConfig) ([]byte, error) {
+	if o.Format == formatRaw && e.raw != nil {
+		return json.Marshal(e.raw)
+	}
+
+	if !o.FlattenLabels && !o.FlattenAnnotations && !o.PodAsColumns {
+		return json.Marshal(e)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	if o.FlattenLabels {
+		flatten(m, "labels", labelPrefix, e.Labels)
+	}
+
+	if o.FlattenAnnotations {
+		flatten(m, "annotations", annotationPrefix, e.Annotations)
+	}
+
+	if o.PodAsColumns && e.Pod == nil {
+		delete(m, "pod")
+	}
+
+	return json.Marshal(m)
+}
+
+// flatten replaces the nested map under key with top-level prefixed fields.
+// Flat-schema sinks (ClickHouse, Elasticsearch) otherwise create a mapping
+// for every distinct nested key they come across.
+func flatten(m map[string]interface{}, key, prefix string, values map[string]string) {
+	delete(m, key)
+	for k, v := range values {
+		m[prefix+sanitizeFieldName(k)] = v
+	}
+}
+
+// promotePod moves the pod of e, as miniPodInfo has it, to the top-level
+// pod_ fields. The pods of a service event are left in the map.
+func (o *OutputConfig) promotePod(e *L9Event) {
+	if !o.PodAsColumns {
+		return
+	}
+
+	uid, ok := e.Pod["uid"].(types.UID)
+	if !ok {
+		return
+	}
+
+	e.PodUID = string(uid)
+	e.PodName, _ = e.Pod["name"].(string)
+	e.PodIP, _ = e.Pod["ip"].(string)
+	e.PodHostIP, _ = e.Pod["host_ip"].(string)
+	if t, ok := e.Pod["start_time"].(*metav1.Time); ok && t != nil {
+		e.PodStartTime = t.Unix()
+	}
+	e.Pod = nil
+}
+
+// loadTimezone checks output.timezone against the tz database.
+func (o *OutputConfig) loadTimezone() error {
+	if o.Timezone == "" {
+		return nil
+	}
+
+	loc, err := time.LoadLocation(o.Timezone)
+	if err != nil {
+		return fmt.Errorf("output.timezone %q: %w", o.Timezone, err)
+	}
+
+	o.location = loc
+	return nil
+}
+
+// localTime is the Timestamp of e in output.timezone, with its offset, if
+// one is set.
+func (o *OutputConfig) localTime(e *L9Event) string {
+	if o.location == nil || e.Timestamp == 0 {
+		return ""
+	}
+
+	return time.Unix(e.Timestamp, 0).In(o.location).Format(time.RFC3339)
+}
@@ -0,0 +1,262 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncodeEvent(t *testing.T) {
+	e := &L9Event{
+		ID:          "uid",
+		Labels:      map[string]string{"app": "pyserve", "app.kubernetes.io/name": "py"},
+		Annotations: map[string]string{"owner": "sre", "last9.io/team-id": "9"},
+	}
+
+	decode := func(t *testing.T, o *OutputConfig) map[string]interface{} {
+		b, err := encodeEvent(e, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	t.Run("Nested by default", func(t *testing.T) {
+		m := decode(t, &OutputConfig{})
+		assert.Equal(t, m["labels"].(map[string]interface{})["app"], "pyserve")
+		assert.Equal(t, m["label_app"], nil)
+	})
+
+	t.Run("Flatten labels and annotations", func(t *testing.T) {
+		m := decode(t, &OutputConfig{FlattenLabels: true, FlattenAnnotations: true})
+
+		_, ok := m["labels"]
+		assert.Equal(t, ok, false)
+		_, ok = m["annotations"]
+		assert.Equal(t, ok, false)
+
+		assert.Equal(t, m["label_app"], "pyserve")
+		assert.Equal(t, m["label_app_kubernetes_io_name"], "py")
+		assert.Equal(t, m["annotation_owner"], "sre")
+		assert.Equal(t, m["annotation_last9_io_team_id"], "9")
+		assert.Equal(t, m["id"], "uid")
+	})
+}
+
+func TestPodAsColumns(t *testing.T) {
+	started := metav1.Unix(1586340721, 0)
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "pod-uid", Name: "web", Namespace: "default"},
+		Status:     v1.PodStatus{PodIP: "10.0.0.7", HostIP: "192.168.1.4", StartTime: &started},
+	}
+
+	encoded := func(t *testing.T, o OutputConfig) map[string]interface{} {
+		ch := make(chan interface{}, 1)
+		h := &Handler{conf: &L9K8streamConfig{Output: o}, ch: ch}
+		h.emit(&L9Event{ID: "uid", Pod: miniPodInfo(pod)})
+
+		b, err := encodeEvent((<-ch).(*L9Event), &o)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := encoded(t, OutputConfig{PodAsColumns: true})
+	_, ok := m["pod"]
+	assert.Equal(t, ok, false)
+	assert.Equal(t, m["pod_uid"], "pod-uid")
+	assert.Equal(t, m["pod_name"], "web")
+	assert.Equal(t, m["pod_ip"], "10.0.0.7")
+	assert.Equal(t, m["pod_host_ip"], "192.168.1.4")
+	assert.Equal(t, m["pod_start_time"], float64(1586340721))
+
+	t.Run("Nested by default", func(t *testing.T) {
+		m := encoded(t, OutputConfig{})
+		assert.Equal(t, m["pod"].(map[string]interface{})["name"], "web")
+		assert.Equal(t, m["pod_name"], nil)
+	})
+
+	t.Run("The pods of a service stay nested", func(t *testing.T) {
+		o := OutputConfig{PodAsColumns: true}
+		e := &L9Event{ID: "svc", Pod: map[string]interface{}{"web": `{"name":"web"}`}}
+		o.promotePod(e)
+		assert.Equal(t, len(e.Pod), 1)
+		assert.Equal(t, e.PodName, "")
+	})
+}
+
+func TestSequence(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2020, 4, 8, 10, 0, 0, 0, time.UTC)
+	run := func(now time.Time, persist bool, n int) []*L9Event {
+		conf := &L9K8streamConfig{}
+		conf.sequence, err = newSequencer(db, persist, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan interface{}, n)
+		h := &Handler{&KubernetesClient{}, ch, db, conf}
+		for ix := 0; ix < n; ix++ {
+			h.emit(&L9Event{ID: fmt.Sprintf("e%d", ix)})
+		}
+		close(ch)
+
+		events := []*L9Event{}
+		for e := range ch {
+			events = append(events, e.(*L9Event))
+		}
+		return events
+	}
+
+	sequence := func(events []*L9Event) []int64 {
+		seqs := []int64{}
+		for _, e := range events {
+			seqs = append(seqs, e.Sequence)
+		}
+		return seqs
+	}
+
+	first := run(started, true, 3)
+	assert.Equal(t, sequence(first), []int64{1, 2, 3})
+	for _, e := range first {
+		assert.Equal(t, e.Epoch, started.Unix())
+	}
+
+	t.Run("Carries on across a restart when persisted", func(t *testing.T) {
+		restarted := started.Add(time.Hour)
+		events := run(restarted, true, 2)
+		assert.Equal(t, sequence(events), []int64{4, 5})
+		assert.Equal(t, events[0].Epoch, restarted.Unix())
+	})
+
+	t.Run("Starts over otherwise", func(t *testing.T) {
+		restarted := started.Add(2 * time.Hour)
+		events := run(restarted, false, 2)
+		assert.Equal(t, sequence(events), []int64{1, 2})
+		assert.Equal(t, events[1].Epoch, restarted.Unix())
+	})
+
+	t.Run("Filtered out events take no number", func(t *testing.T) {
+		filter, err := compileFilter(`event.reason != "Pulled"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf := &L9K8streamConfig{filter: filter}
+		conf.sequence, _ = newSequencer(db, false, started)
+		ch := make(chan interface{}, 3)
+		h := &Handler{&KubernetesClient{}, ch, db, conf}
+		for _, r := range []string{"BackOff", "Pulled", "Killing"} {
+			h.emit(&L9Event{ID: r, Reason: r})
+		}
+
+		assert.Equal(t, (<-ch).(*L9Event).Sequence, int64(1))
+		assert.Equal(t, (<-ch).(*L9Event).Sequence, int64(2))
+	})
+}
+
+func TestSinkFormats(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Sinks = map[string]json.RawMessage{
+		"alert": json.RawMessage(`{"sink": "memory", "format": "raw"}`),
+	}
+
+	formats, err := sinkFormats(cfg.Sinks, cfg.Output.Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.sinkFormats = formats
+
+	primary, alert := newMemSink(), newMemSink()
+	sinks, err := NewSinkSet(primary, map[string]io.Flusher{"alert": alert}, map[string]string{
+		severityCritical: "alert",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "web.1"}}
+	batch := []interface{}{
+		&L9Event{raw: obj, ID: "normal", Severity: severityInfo},
+		&L9Event{raw: obj, ID: "critical", Severity: severityCritical},
+	}
+
+	if err := flushBatch(sinks, nil, batch, "1", nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary sink writes output.format, the alert sink its own.
+	assert.Equal(t, strings.Contains(sinkLines(primary)[0], `"id":"normal"`), true)
+	assert.Equal(t, strings.Contains(sinkLines(alert)[0], `"name":"web.1"`), true)
+	assert.Equal(t, strings.Contains(sinkLines(alert)[0], `"id"`), false)
+
+	t.Run("Unknown formats are rejected", func(t *testing.T) {
+		_, err := sinkFormats(map[string]json.RawMessage{
+			"kafka": json.RawMessage(`{"format": "protobuf"}`),
+		}, formatJSON)
+		assert.NotEqual(t, err, nil)
+	})
+
+	t.Run("Formats the sink type cannot take are rejected", func(t *testing.T) {
+		_, err := sinkFormats(map[string]json.RawMessage{
+			"flight": json.RawMessage(`{"sink": "arrow-flight", "format": "raw"}`),
+		}, formatJSON)
+		assert.NotEqual(t, err, nil)
+
+		_, err = sinkFormats(map[string]json.RawMessage{
+			"otlp": json.RawMessage(`{"sink": "otlp-logs"}`),
+		}, formatRaw)
+		assert.NotEqual(t, err, nil)
+
+		_, err = sinkFormats(map[string]json.RawMessage{
+			"otlp": json.RawMessage(`{"sink": "otlp-logs", "format": "json"}`),
+		}, formatRaw)
+		assert.Equal(t, err, nil)
+
+		assert.NotEqual(t, checkSinkFormat("arrow-flight", formatRaw), nil)
+		assert.Equal(t, checkSinkFormat("s3", formatRaw), nil)
+	})
+}
+
+func TestFlightSchemaCoversEvent(t *testing.T) {
+	fields := map[string]bool{}
+	for _, f := range io.FlightSchema.Fields() {
+		fields[f.Name] = true
+	}
+
+	typ := reflect.TypeOf(L9Event{})
+	for ix := 0; ix < typ.NumField(); ix++ {
+		tag := strings.Split(typ.Field(ix).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		if !fields[tag] {
+			t.Errorf("%v is not a column of the arrow-flight schema", tag)
+		}
+	}
+}
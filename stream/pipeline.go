@@ -0,0 +1,317 @@
+// Package stream is the k8stream pipeline, for programs that embed it
+// rather than run the k8stream binary: the Handler to attach to informers,
+// the enrichment and dedup of events, and the batching to sinks.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/last9/k8stream/io"
+)
+
+// Pipeline is the path an object takes from the informers to the sinks:
+// the Handler enriches and dedups it, and the batcher flushes it. Attach
+// the Handler to informers to feed it.
+type Pipeline struct {
+	Handler   *Handler
+	tap       chan *L9Event
+	snapshots chan<- interface{}
+
+	// Closed once the batchers flushed their last batch.
+	ingested, snapshotted <-chan struct{}
+
+	sinks *SinkSet
+
+	// Closed once the process outlived max_process_lifetime_seconds.
+	expired chan struct{}
+}
+
+// Events the tap holds before further ones are dropped.
+const tapBuffer = 1024
+
+// NewPipeline starts the batchers that flush to sinks, and returns the
+// Pipeline with a Handler to attach to informers. Events that the sinks
+// cannot take go to the dead-letter sink dl, which can be nil.
+func NewPipeline(
+	conf *L9K8streamConfig, kc *KubernetesClient, sinks *SinkSet, dl io.Flusher,
+) (*Pipeline, error) {
+	if err := conf.Message.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Enrich.Prometheus.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Enrich.NamespaceLabels.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Enrich.ImageScan.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Watch.checkKinds(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.SinkRouter.compile(); err != nil {
+		return nil, err
+	}
+	if sinks != nil {
+		sinks.router = conf.SinkRouter
+	}
+
+	if err := conf.Enrich.Datacenters.load(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Output.loadTimezone(); err != nil {
+		return nil, err
+	}
+
+	reasons, err := presetReasons(conf.Preset)
+	if err != nil {
+		return nil, err
+	}
+	conf.presetReasons = reasons
+
+	filter, err := compileFilter(conf.FilterExpression)
+	if err != nil {
+		return nil, err
+	}
+	conf.filter = filter
+
+	if _, ok := eventField(conf.BatchByKey); conf.BatchByKey != "" && !ok {
+		return nil, fmt.Errorf("batch_by_key %q is not a field of the event", conf.BatchByKey)
+	}
+
+	if err := checkSinkFormat(conf.Sink, conf.Output.Format); err != nil {
+		return nil, err
+	}
+
+	formats, err := sinkFormats(conf.Sinks, conf.Output.Format)
+	if err != nil {
+		return nil, err
+	}
+	conf.sinkFormats = formats
+
+	if dl == nil && conf.OversizePolicy == oversizeDeadLetter {
+		return nil, fmt.Errorf("oversize_policy %v needs a dead-letter sink", oversizeDeadLetter)
+	}
+
+	if conf.Cache.Disabled && conf.Dedup.Scope == dedupShared {
+		return nil, fmt.Errorf("cache.disabled cannot dedup with scope %v", dedupShared)
+	}
+
+	switch conf.ChannelFullPolicy {
+	case "", channelBlock:
+	case channelDrop:
+		conf.channelDrops = &dropLog{}
+		conf.channelDrops.start(dropLogInterval)
+	default:
+		return nil, fmt.Errorf("unknown channel_full_policy %q", conf.ChannelFullPolicy)
+	}
+
+	if conf.Cache.Disabled && conf.Output.PersistSequence {
+		return nil, fmt.Errorf("cache.disabled cannot persist the sequence")
+	}
+
+	switch conf.Cache.Type {
+	case "", cacheMemory:
+	case cacheTiered:
+		if conf.Cache.RedisAddress == "" {
+			return nil, fmt.Errorf("cache type %v needs a cache.redis_address", cacheTiered)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", conf.Cache.Type)
+	}
+
+	// Create a LRU Cache
+	var db Cachier = noopCache{}
+	if !conf.Cache.Disabled {
+		db, err = openCache(conf.Cache.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.Cache.Type == cacheTiered {
+			db = newTieredCache(db, conf.Cache.RedisAddress, conf.Cache.KeyPrefix, conf.Cache.FrontEntries)
+		}
+
+		if conf.Cache.AsyncWrites {
+			db = withAsyncWrites(db, conf.Cache.AsyncBuffer)
+		}
+
+		if conf.Cache.ServiceTTL > 0 {
+			startCacheSweeper(db, time.Duration(conf.Cache.ServiceTTL)*time.Second)
+		}
+	}
+
+	if conf.Storm.Protection {
+		if len(conf.Storm.Reasons) == 0 {
+			conf.Storm.Reasons = defaultStormReasons
+		}
+		if conf.Storm.Threshold <= 0 {
+			conf.Storm.Threshold = defaultStormThreshold
+		}
+		if conf.Storm.WindowSeconds <= 0 {
+			conf.Storm.WindowSeconds = defaultStormWindow
+		}
+	}
+
+	if conf.Output.Sequence {
+		conf.sequence, err = newSequencer(db, conf.Output.PersistSequence, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conf.gate = &gate{}
+	conf.spilled = &sync.WaitGroup{}
+
+	if kc != nil {
+		kc.nodes = newNodeResolver(time.Duration(conf.NodeResolve.CacheSeconds) * time.Second)
+	}
+
+	if conf.HandlerMaxGoroutines > 1 {
+		conf.handlerSlots = make(chan struct{}, conf.HandlerMaxGoroutines)
+	}
+
+	if conf.Dedup.Scope == dedupShared {
+		if conf.Dedup.RedisAddress == "" {
+			return nil, fmt.Errorf("dedup scope %v needs a dedup.redis_address", dedupShared)
+		}
+		conf.claims = newRedisClaims(conf.Dedup.RedisAddress, conf.Dedup.KeyPrefix)
+	}
+
+	if conf.ExcludeSelf && conf.SelfPod != "" {
+		conf.selfUIDs, err = resolveSelf(db, kc, conf.SelfNamespace, conf.SelfPod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Start a batcher, returns a channel.
+	out, ingested := startIngester(sinks, dl, conf, db)
+	snapshots, snapshotted := startIngester(sinks, dl, conf, db)
+
+	p := &Pipeline{
+		tap:         make(chan *L9Event, tapBuffer),
+		snapshots:   snapshots,
+		ingested:    ingested,
+		snapshotted: snapshotted,
+		sinks:       sinks,
+		expired:     make(chan struct{}),
+	}
+	buffer := conf.ChannelBufferSize
+	if buffer == 0 {
+		buffer = conf.BatchSize
+	}
+	ch := make(chan interface{}, buffer)
+	go p.tee(ch, out)
+
+	p.Handler = &Handler{kc, ch, db, conf}
+
+	if conf.Storm.Protection {
+		conf.storms = newStormCoalescer(conf.Storm, p.Handler)
+	}
+
+	if conf.EmitSelfMetrics {
+		p.Handler.startSelfMetrics(time.Duration(conf.SelfMetricsInterval) * time.Second)
+	}
+
+	if conf.MaxProcessLifetime > 0 {
+		time.AfterFunc(time.Duration(conf.MaxProcessLifetime)*time.Second, func() {
+			log.Println("Reached max_process_lifetime_seconds, shutting down")
+			close(p.expired)
+		})
+	}
+
+	if conf.StartupQuietPeriod > 0 {
+		conf.quiet = newQuietPeriod()
+		time.AfterFunc(time.Duration(conf.StartupQuietPeriod)*time.Second, p.MarkSynced)
+	}
+
+	return p, nil
+}
+
+// MarkSynced ends the startup quiet period, once the informers have
+// synced, and handles the events held during it.
+func (p *Pipeline) MarkSynced() {
+	if p.Handler.conf.quiet == nil {
+		return
+	}
+
+	for _, e := range p.Handler.conf.quiet.close() {
+		e := e
+		p.Handler.dispatch(e, func() error { return p.Handler.onEvent(e) })
+	}
+}
+
+// TapChannel receives a copy of every event the Handler emits. Nothing
+// waits on the tap: once its buffer is full, events are dropped from it.
+// The tap is closed on Shutdown.
+func (p *Pipeline) TapChannel() <-chan *L9Event {
+	return p.tap
+}
+
+func (p *Pipeline) tee(in <-chan interface{}, out chan<- interface{}) {
+	for v := range in {
+		if e, ok := v.(*L9Event); ok {
+			c := *e
+			select {
+			case p.tap <- &c:
+			default:
+			}
+		}
+
+		out <- v
+	}
+
+	close(out)
+	close(p.tap)
+}
+
+// FuncFlusher hands each batch over to a function, as events, for programs
+// that embed the pipeline rather than write to a sink.
+type FuncFlusher struct {
+	fn func([]*L9Event) error
+}
+
+func NewFuncFlusher(fn func([]*L9Event) error) *FuncFlusher {
+	return &FuncFlusher{fn}
+}
+
+func (f *FuncFlusher) LoadConfig(_ json.RawMessage) error {
+	return nil
+}
+
+func (f *FuncFlusher) Flush(uuid, ident string, d []byte) error {
+	events := []*L9Event{}
+	s := bufio.NewScanner(bytes.NewReader(d))
+	s.Buffer(nil, len(d)+1)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		e := &L9Event{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return &io.ErrPermanent{Err: err}
+		}
+		events = append(events, e)
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return f.fn(events)
+}
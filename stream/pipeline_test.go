@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/go-playground/assert.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPipeline(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/events.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &events{}
+	if err := json.Unmarshal(b, e); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan []*L9Event, 1)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.BatchSize = 1
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The involved object is cached already, as no cluster is at hand.
+	if err := p.Handler.db.ExpireSet(
+		objectCacheTable, string(e.Items[0].InvolvedObject.UID),
+		&unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Handler.OnAdd(e.Items[0])
+
+	select {
+	case events := <-got:
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].ID, string(e.Items[0].UID))
+		assert.Equal(t, events[0].Reason, "Scheduled")
+	case <-time.After(2 * time.Second):
+		t.Fatal("No batch reached the callback")
+	}
+}
+
+func TestTapChannel(t *testing.T) {
+	got := make(chan []*L9Event, 1)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.BatchSize = tapBuffer + 2
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the tap until all of them are emitted.
+	for i := 0; i < conf.BatchSize; i++ {
+		p.Handler.emit(&L9Event{ID: strconv.Itoa(i)})
+	}
+
+	select {
+	case events := <-got:
+		assert.Equal(t, len(events), conf.BatchSize)
+	case <-time.After(2 * time.Second):
+		t.Fatal("A full tap stalled the pipeline")
+	}
+
+	tap := p.TapChannel()
+	assert.Equal(t, len(tap), tapBuffer)
+	assert.Equal(t, (<-tap).ID, "0")
+}
+
+func TestChannelFullPolicy(t *testing.T) {
+	t.Run("Drop", func(t *testing.T) {
+		before := testutil.ToFloat64(channelDroppedEvents)
+
+		ch := make(chan interface{}, 2)
+		conf := &L9K8streamConfig{channelDrops: &dropLog{}}
+		h := &Handler{&KubernetesClient{}, ch, nil, conf}
+		for ix := 0; ix < 5; ix++ {
+			h.emit(&L9Event{ID: strconv.Itoa(ix)})
+		}
+
+		assert.Equal(t, len(ch), 2)
+		assert.Equal(t, (<-ch).(*L9Event).ID, "0")
+		assert.Equal(t, conf.channelDrops.take(), 3)
+		assert.Equal(t, conf.channelDrops.take(), 0)
+		assert.Equal(t, testutil.ToFloat64(channelDroppedEvents)-before, float64(3))
+	})
+
+	t.Run("Buffer size", func(t *testing.T) {
+		conf := newTestConfig()
+		conf.ChannelBufferSize = 7
+		p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, cap(p.Handler.ch), 7)
+	})
+
+	t.Run("Unknown policies are rejected", func(t *testing.T) {
+		conf := newTestConfig()
+		conf.ChannelFullPolicy = "spill"
+		_, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(newMemSink()), nil)
+		assert.NotEqual(t, err, nil)
+	})
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/events.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &events{}
+	if err := json.Unmarshal(b, e); err != nil {
+		t.Fatal(err)
+	}
+
+	flushes := func() uint64 {
+		m := &dto.Metric{}
+		if err := flushLatencies.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	received := testutil.ToFloat64(receivedObjects.WithLabelValues("Event"))
+	deduped := testutil.ToFloat64(dedupedEvents)
+	buffered := testutil.ToFloat64(bufferedEvents)
+	flushed := flushes()
+
+	got := make(chan []*L9Event, 1)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		// Taken off the buffer by the time the batch is flushed.
+		assert.Equal(t, testutil.ToFloat64(bufferedEvents), buffered)
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.BatchSize = 1
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Handler.db.ExpireSet(
+		objectCacheTable, string(e.Items[0].InvolvedObject.UID),
+		&unstructured.Unstructured{}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Handler.OnAdd(e.Items[0])
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No batch reached the callback")
+	}
+
+	assert.Equal(t, testutil.ToFloat64(receivedObjects.WithLabelValues("Event"))-received, float64(1))
+	assert.Equal(t, testutil.ToFloat64(bufferedEvents), buffered)
+
+	// Marked processed once the flush returns.
+	id := string(e.Items[0].UID)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		r, err := p.Handler.db.Get(eventCacheTable, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Exists() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not marked processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, flushes()-flushed, uint64(1))
+
+	p.Handler.OnAdd(e.Items[0])
+	assert.Equal(t, testutil.ToFloat64(dedupedEvents)-deduped, float64(1))
+	assert.Equal(t, testutil.ToFloat64(receivedObjects.WithLabelValues("Event"))-received, float64(2))
+}
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, kindOf(&unstructured.Unstructured{}), "Unstructured")
+	assert.Equal(t, kindOf(nil), "unknown")
+}
@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podIndexLock is held while the pods of a service are looked up, and while
+// the index is reconciled, so that a pod found after the live pods were
+// taken stock of is not pruned along with dead ones. The pods found are
+// indexed once it is released, for writes that wait on a full async queue
+// not to hold up the reconciler and every other service. A pod that died in
+// between is pruned by the next reconcile.
+var podIndexLock sync.Mutex
+
+// reconcilePodServices drops the pod -> service reverse index of every pod
+// that is not live anymore, and returns how many were dropped. Pods are
+// added to the index as services are processed but nothing removes them
+// when they die, which on a cluster with a lot of pod churn adds up.
+func reconcilePodServices(db Cachier, live map[string]bool) (int, error) {
+	prefix := makeKey(podServicesTable, "")
+	tables, err := db.Tables(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	evicted := 0
+	for _, table := range tables {
+		if live[strings.TrimPrefix(table, prefix)] {
+			continue
+		}
+
+		if err := db.DropTable(table); err != nil {
+			return evicted, err
+		}
+
+		evicted++
+		podIndexEvictions.Inc()
+	}
+
+	return evicted, nil
+}
+
+// storeUIDs returns the UIDs of the pods in an informer store.
+func storeUIDs(pods cache.Store) map[string]bool {
+	uids := map[string]bool{}
+	for _, obj := range pods.List() {
+		if p, ok := obj.(*v1.Pod); ok {
+			uids[string(p.GetUID())] = true
+		}
+	}
+	return uids
+}
+
+// StartPodIndexReconciler reconciles the reverse index against the pods of
+// a pod informer every interval. The pods of services are then looked up in
+// the same store, rather than listed from the API server, so that the
+// index never has a pod that the store does not know of yet. It is to be
+// started before the Handler is attached to informers.
+func (p *Pipeline) StartPodIndexReconciler(pods cache.Store, interval time.Duration) {
+	p.Handler.client.pods = pods
+
+	go func() {
+		for range time.Tick(interval) {
+			podIndexLock.Lock()
+			n, err := reconcilePodServices(p.Handler.db, storeUIDs(pods))
+			podIndexLock.Unlock()
+
+			if err != nil {
+				log.Println("reconciling the reverse index:", err)
+			}
+
+			if n > 0 {
+				log.Println("Evicted", n, "dead pods from the reverse index")
+			}
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/go-playground/assert.v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReconcilePodServices(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pod informer's store.
+	pods := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, p := range []string{"a-1", "a-2"} {
+		if err := pods.Add(testPod(p, "pod-"+p, map[string]string{"app": "a"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kc := &KubernetesClient{pods: pods}
+
+	if _, _, err := getServicePods(
+		kc, db, testService("1", map[string]string{"app": "a"}), 0,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := db.Tables(makeKey(podServicesTable, ""))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(tables), 2)
+
+	if err := pods.Delete(testPod("a-2", "pod-a-2", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(podIndexEvictions)
+	n, err := reconcilePodServices(db, storeUIDs(pods))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 1)
+	assert.Equal(t, testutil.ToFloat64(podIndexEvictions)-before, float64(1))
+
+	tables, err = db.Tables(makeKey(podServicesTable, ""))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tables, []string{makeKey(podServicesTable, "pod-a-1")})
+
+	services, err := db.List(makeKey(podServicesTable, "pod-a-1"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, services, []string{"svc-uid"})
+
+	t.Run("Pods of other namespaces or labels are not the service's", func(t *testing.T) {
+		other := testPod("b-1", "pod-b-1", map[string]string{"app": "b"})
+		elsewhere := testPod("a-3", "pod-a-3", map[string]string{"app": "a"})
+		elsewhere.Namespace = "staging"
+		pods.Add(other)
+		pods.Add(elsewhere)
+
+		found, _, err := getServicePods(
+			kc, db, testService("2", map[string]string{"app": "a"}), 0,
+		)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(found), 1)
+		assert.Equal(t, found[0].GetName(), "a-1")
+	})
+}
+
+func TestPodServicesConcurrent(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	if err := pods.Add(testPod("a-1", "pod-a-1", map[string]string{"app": "a"})); err != nil {
+		t.Fatal(err)
+	}
+
+	const services = 16
+	conf := &L9K8streamConfig{}
+	SetDefaults(conf)
+	h := &Handler{&KubernetesClient{pods: pods}, make(chan interface{}, services), db, conf}
+
+	var want []string
+	var wg sync.WaitGroup
+	for i := 0; i < services; i++ {
+		s := testService("1", map[string]string{"app": "a"})
+		s.Name = fmt.Sprintf("svc-%02d", i)
+		s.UID = types.UID(s.Name)
+		want = append(want, s.Name)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.onService(nil, s, "addedService"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := db.List(makeKey(podServicesTable, "pod-a-1"))
+	assert.Equal(t, err, nil)
+	sort.Strings(got)
+	assert.Equal(t, got, want)
+}
+
+func TestPodIndexWritesOutsideTheLock(t *testing.T) {
+	c, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &blockingCache{Cachier: c, release: make(chan struct{})}
+	db := withAsyncWrites(slow, 1).(*asyncCache)
+
+	pods := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, p := range []string{"a-1", "a-2"} {
+		if err := pods.Add(testPod(p, "pod-"+p, map[string]string{"app": "a"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Three writes: one held by the writer, one queued, and one waiting
+	// for room in the queue.
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := getServicePods(
+			&KubernetesClient{pods: pods}, db, testService("1", map[string]string{"app": "a"}), 0,
+		)
+		done <- err
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(db.writes) < cap(db.writes) {
+		if time.Now().After(deadline) {
+			t.Fatal("Writes were never queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		podIndexLock.Lock()
+		podIndexLock.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("The pod index lock was held while writes were queued")
+	}
+
+	close(slow.release)
+	assert.Equal(t, <-done, nil)
+}
@@ -0,0 +1,59 @@
+package stream
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// quietPeriod holds back the events handled while the informers sync
+// after startup. They are handed back once the informers have synced, and
+// only then checked against dedup, with the cache warm. An event that the
+// relist delivers more than once is handed back once, at its latest.
+type quietPeriod struct {
+	sync.Mutex
+	closed bool
+	order  []string
+	held   map[string]*v1.Event
+}
+
+func newQuietPeriod() *quietPeriod {
+	return &quietPeriod{held: map[string]*v1.Event{}}
+}
+
+// hold keeps the event back and reports whether it did, which it does
+// not once the period is over.
+func (q *quietPeriod) hold(e *v1.Event) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	uid := string(e.GetUID())
+	if _, ok := q.held[uid]; !ok {
+		q.order = append(q.order, uid)
+	}
+	q.held[uid] = e
+	return true
+}
+
+// close ends the period, and returns the events held in the order they
+// were first seen. Only the first close returns any.
+func (q *quietPeriod) close() []*v1.Event {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+
+	events := make([]*v1.Event, 0, len(q.order))
+	for _, uid := range q.order {
+		events = append(events, q.held[uid])
+	}
+	q.order, q.held = nil, nil
+	return events
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness backs /readyz: k8stream is ready once the informers have
+// synced and, when a sink probe is configured, the sink was reachable on
+// its last probe.
+type Readiness struct {
+	synced int32
+	probe  *sinkProbe
+}
+
+// ProbeSink keeps k8stream unready while ping, checked every interval,
+// fails.
+func (r *Readiness) ProbeSink(ping func() error, interval time.Duration) {
+	r.probe = newSinkProbe(ping, interval)
+}
+
+func (r *Readiness) MarkSynced() {
+	atomic.StoreInt32(&r.synced, 1)
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&r.synced) == 0 {
+		http.Error(w, "informers have not synced", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.probe != nil {
+		if err := r.probe.result(); err != nil {
+			http.Error(w, "sink unreachable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// sinkProbe checks the sink every interval and keeps the outcome, so that
+// readiness probes do not each hit the sink.
+type sinkProbe struct {
+	ping func() error
+
+	sync.Mutex
+	err error
+}
+
+func newSinkProbe(ping func() error, interval time.Duration) *sinkProbe {
+	p := &sinkProbe{ping: ping}
+	p.check()
+
+	go func() {
+		for range time.Tick(interval) {
+			p.check()
+		}
+	}()
+
+	return p
+}
+
+func (p *sinkProbe) check() {
+	err := p.ping()
+	if err != nil {
+		log.Println("sink probe failed:", err)
+	}
+
+	p.Lock()
+	p.err = err
+	p.Unlock()
+}
+
+func (p *sinkProbe) result() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.err
+}
@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestReadiness(t *testing.T) {
+	reachable := true
+	ping := func() error {
+		if !reachable {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	r := &Readiness{probe: newSinkProbe(ping, time.Hour)}
+	status := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, status(), http.StatusServiceUnavailable)
+
+	r.MarkSynced()
+	assert.Equal(t, status(), http.StatusOK)
+
+	t.Run("Follows the sink", func(t *testing.T) {
+		reachable = false
+		// Until the next probe, the last result holds.
+		assert.Equal(t, status(), http.StatusOK)
+
+		r.probe.check()
+		assert.Equal(t, status(), http.StatusServiceUnavailable)
+
+		reachable = true
+		r.probe.check()
+		assert.Equal(t, status(), http.StatusOK)
+	})
+}
@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const scheduledReason = "Scheduled"
+
+// schedulingLatency is how long a pod waited from its creation to being
+// scheduled, as its Scheduled event tells, and false when either time is
+// unknown. The pod is the one enrichment found, in the object cache by its
+// UID or from the API server.
+func schedulingLatency(e *v1.Event, p *v1.Pod) (time.Duration, bool) {
+	created := p.GetCreationTimestamp()
+	if created.IsZero() {
+		return 0, false
+	}
+
+	var scheduled time.Time
+	switch {
+	case !e.EventTime.IsZero():
+		scheduled = e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		scheduled = e.FirstTimestamp.Time
+	default:
+		scheduled = e.GetCreationTimestamp().Time
+	}
+
+	if scheduled.IsZero() || scheduled.Before(created.Time) {
+		return 0, false
+	}
+
+	return scheduled.Sub(created.Time), true
+}
+
+// addSchedulingLatency sets the scheduling latency of a Scheduled event, and
+// observes it.
+func addSchedulingLatency(ne *L9Event, e *v1.Event) {
+	if e.Reason != scheduledReason || ne.pod == nil {
+		return
+	}
+
+	d, ok := schedulingLatency(e, ne.pod)
+	if !ok {
+		return
+	}
+
+	ne.SchedulingLatencyMs = d.Milliseconds()
+	schedulingLatencies.Observe(d.Seconds())
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSchedulingLatency(t *testing.T) {
+	mCache, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2020, 4, 8, 10, 12, 0, 0, time.UTC)
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pyserve-1", Namespace: "default", UID: "sched-pod-uid",
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+
+	// The pod was cached as it was created.
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mCache.ExpireSet(
+		objectCacheTable, string(pod.UID),
+		&unstructured.Unstructured{Object: obj}, objectCacheExpiry,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	observed := func() uint64 {
+		m := &dto.Metric{}
+		if err := schedulingLatencies.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := observed()
+
+	ch := make(chan interface{}, 1)
+	h := &Handler{
+		&KubernetesClient{}, ch, mCache,
+		&L9K8streamConfig{EmitSchedulingLatency: true},
+	}
+
+	h.OnAdd(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{UID: "sched-event-uid", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", APIVersion: "v1", UID: pod.UID,
+			Name: pod.Name, Namespace: pod.Namespace,
+		},
+		Reason:         scheduledReason,
+		FirstTimestamp: metav1.NewTime(created.Add(1500 * time.Millisecond)),
+	})
+
+	assert.Equal(t, len(ch), 1)
+	e := (<-ch).(*L9Event)
+	assert.Equal(t, e.SchedulingLatencyMs, int64(1500))
+	assert.Equal(t, observed(), before+1)
+
+	t.Run("Unknown creation time", func(t *testing.T) {
+		_, ok := schedulingLatency(&v1.Event{Reason: scheduledReason}, &v1.Pod{})
+		assert.Equal(t, ok, false)
+	})
+}
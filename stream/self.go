@@ -0,0 +1,22 @@
+package stream
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// resolveSelf returns the UIDs of k8stream's own pod and of the controllers
+// owning it, its ReplicaSet and Deployment say, for isSelf to match events
+// about any of them.
+func resolveSelf(db Cachier, c *KubernetesClient, namespace, name string) (map[string]bool, error) {
+	p, err := c.Clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	uids := map[string]bool{string(p.GetUID()): true}
+	for _, ref := range controllerChain(db, c, p) {
+		uids[string(ref.UID)] = true
+	}
+
+	return uids, nil
+}
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	selfMetricsReason = "K8streamMetrics"
+
+	defaultSelfMetricsInterval = 60
+)
+
+// SelfMetrics are the operational metrics of k8stream, for where there is
+// no Prometheus to scrape them. Counts are since startup.
+type SelfMetrics struct {
+	EventsProcessed int64 `json:"events_processed"`
+	FlushErrors     int64 `json:"flush_errors"`
+	BufferDepth     int   `json:"buffer_depth"`
+	CacheEntries    int   `json:"cache_entries"`
+	UptimeSeconds   int64 `json:"uptime_seconds"`
+}
+
+func counterValue(c prometheus.Counter) int64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		return 0
+	}
+	return int64(m.GetCounter().GetValue())
+}
+
+// startSelfMetrics emits a K8streamMetrics event every interval, until
+// shutdown.
+func (h *Handler) startSelfMetrics(interval time.Duration) {
+	started := time.Now()
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for now := range t.C {
+			if !h.conf.gate.enter() {
+				return
+			}
+
+			h.emit(h.selfMetricsEvent(now, started))
+			h.conf.gate.leave()
+		}
+	}()
+}
+
+func (h *Handler) selfMetricsEvent(now, started time.Time) *L9Event {
+	entries, err := h.db.Len()
+	if err != nil {
+		log.Println("Counting the cache entries:", err)
+	}
+
+	m := &SelfMetrics{
+		EventsProcessed: counterValue(processedEvents),
+		FlushErrors:     counterValue(flushErrors),
+		BufferDepth:     len(h.ch),
+		CacheEntries:    entries,
+		UptimeSeconds:   int64(now.Sub(started).Seconds()),
+	}
+
+	return &L9Event{
+		ID:        fmt.Sprintf("%s-metrics-%d", h.conf.UID, now.Unix()),
+		Timestamp: now.Unix(),
+		Component: "k8stream",
+		Message: fmt.Sprintf(
+			"k8stream processed %d events, with %d flush errors, up %ds",
+			m.EventsProcessed, m.FlushErrors, m.UptimeSeconds,
+		),
+		Namespace:   h.conf.SelfNamespace,
+		Reason:      selfMetricsReason,
+		Type:        v1.EventTypeNormal,
+		Version:     VERSION,
+		SelfMetrics: m,
+	}
+}
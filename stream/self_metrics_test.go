@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestSelfMetrics(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Set(serviceTable, "svc-uid", "svc"), nil)
+
+	conf := newTestConfig()
+	conf.EmitSelfMetrics = true
+	conf.gate = &gate{}
+
+	ch := make(chan interface{}, 4)
+
+	processed := counterValue(processedEvents)
+	markProcessed(nil, []interface{}{&L9Event{ID: "a"}, &L9Event{ID: "b"}})
+
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+	h.startSelfMetrics(50 * time.Millisecond)
+
+	var e *L9Event
+	select {
+	case v := <-ch:
+		e = v.(*L9Event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no metrics event was emitted")
+	}
+
+	assert.Equal(t, e.Reason, selfMetricsReason)
+	assert.Equal(t, e.Component, "k8stream")
+	assert.NotEqual(t, e.SelfMetrics, nil)
+	// Other tests' pipelines may flush meanwhile.
+	assert.Equal(t, e.SelfMetrics.EventsProcessed >= processed+2, true)
+	assert.Equal(t, e.SelfMetrics.CacheEntries, 1)
+	assert.Equal(t, e.SelfMetrics.BufferDepth, 0)
+	assert.Equal(t, e.SelfMetrics.FlushErrors <= counterValue(flushErrors), true)
+
+	t.Run("On every interval", func(t *testing.T) {
+		select {
+		case v := <-ch:
+			assert.Equal(t, v.(*L9Event).Reason, selfMetricsReason)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no second metrics event was emitted")
+		}
+	})
+
+	t.Run("Stops on shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		assert.Equal(t, conf.gate.close(ctx), nil)
+		time.Sleep(150 * time.Millisecond)
+		for len(ch) > 0 {
+			<-ch
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		assert.Equal(t, len(ch), 0)
+	})
+}
@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// Table of the last sequence number, with output.persist_sequence.
+const sequenceTable = "sequence"
+
+// sequencer numbers the emitted events of an instance, for consumers to
+// tell when events went missing between them and the sink. The epoch is
+// the start of the instance, so that a restart, which starts the sequence
+// over, is told apart from a gap. Persisted, the sequence carries on from
+// where the previous instance stopped, under the new epoch. Events handled
+// by more than one goroutine, or snapshots, can reach the sink out of order,
+// but each number is given once.
+type sequencer struct {
+	epoch int64
+
+	mu   sync.Mutex
+	last int64
+	db   Cachier
+}
+
+// newSequencer returns a sequencer starting in the epoch of now, after the
+// last number kept in db, when it is persisted there.
+func newSequencer(db Cachier, persist bool, now time.Time) (*sequencer, error) {
+	s := &sequencer{epoch: now.Unix()}
+	if !persist {
+		return s, nil
+	}
+
+	s.db = db
+	r, err := db.Get(sequenceTable, "last")
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Exists() {
+		if err := r.Unmarshal(&s.last); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// stamp gives e the next number of the sequence. A nil sequencer leaves e
+// as it is.
+func (s *sequencer) stamp(e *L9Event) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last++
+	e.Sequence, e.Epoch = s.last, s.epoch
+	if s.db != nil {
+		s.db.Set(sequenceTable, "last", s.last)
+	}
+}
@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"context"
+	fmt "fmt"
+	"log"
+
+	"github.com/last9/k8stream/io"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// Priorities of events. A high priority event is flushed right away,
+// along with the batch buffered so far.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+
+	// Snapshots, that go through a lane of their own.
+	priorityLow = "low"
+)
+
+// priorityOf is high for the severities configured to be.
+func (c *L9K8streamConfig) priorityOf(severity string) string {
+	if contains(severity, c.HighPrioritySeverities) {
+		return priorityHigh
+	}
+
+	return priorityNormal
+}
+
+// severityOf looks the reason up in the severity rules. Reasons without a
+// rule are a warning for Warning events and info otherwise. Events of pods
+// with enough critical vulnerabilities are critical, whatever the reason.
+func (c *L9K8streamConfig) severityOf(e *L9Event) string {
+	if c.Enrich.ImageScan.critical(e.Security) {
+		return severityCritical
+	}
+
+	if s, ok := c.SeverityRules[e.Reason]; ok {
+		return s
+	}
+
+	if e.Type == v1.EventTypeWarning {
+		return severityWarning
+	}
+
+	return severityInfo
+}
+
+// SinkSet is where a batch is flushed to: the primary sink, and the named
+// sinks that events of a severity, or the sink router, route to.
+type SinkSet struct {
+	primary io.Flusher
+	named   map[string]io.Flusher
+	routes  map[string]string
+	router  *SinkRouterConfig
+}
+
+// SingleSink sends every event to f.
+func SingleSink(f io.Flusher) *SinkSet {
+	return &SinkSet{primary: f}
+}
+
+func NewSinkSet(primary io.Flusher, named map[string]io.Flusher, routes map[string]string) (*SinkSet, error) {
+	for severity, name := range routes {
+		if _, ok := named[name]; !ok {
+			return nil, fmt.Errorf("severity %v is routed to an unknown sink %v", severity, name)
+		}
+	}
+
+	return &SinkSet{primary: primary, named: named, routes: routes}, nil
+}
+
+// all is the primary sink and the named ones.
+func (s *SinkSet) all() []io.Flusher {
+	if s == nil {
+		return nil
+	}
+
+	sinks := []io.Flusher{s.primary}
+	for _, f := range s.named {
+		sinks = append(sinks, f)
+	}
+	return sinks
+}
+
+// drain waits for the sinks that hold batches to flush later, like the
+// spill queue, to flush them, until ctx is done.
+func (s *SinkSet) drain(ctx context.Context) error {
+	var err error
+	for _, f := range s.all() {
+		if d, ok := f.(io.Drainer); ok {
+			if e := d.Drain(ctx); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// close closes the sinks that run something of their own, like a
+// keep-alive.
+func (s *SinkSet) close() {
+	for _, f := range s.all() {
+		if c, ok := f.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Println("Closing a sink:", err)
+			}
+		}
+	}
+}
+
+// route returns the name of the sink, and the sink, an event goes to.
+// The sink router has the first say. Events of a severity without a route
+// go to the primary sink, named "".
+func (s *SinkSet) route(e *L9Event) (string, io.Flusher) {
+	if s.router != nil {
+		name := s.router.sinkOf(e)
+		if f, ok := s.named[name]; ok {
+			return name, f
+		}
+
+		if name != "" {
+			log.Printf("sink_router named an unknown sink %v for %v", name, e.ID)
+			sinkRouterFallbacks.Inc()
+		}
+	}
+
+	if name, ok := s.routes[e.Severity]; ok {
+		return name, s.named[name]
+	}
+
+	return "", s.primary
+}
@@ -0,0 +1,223 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSeverityRouting(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SeverityRules = map[string]string{"OOMKilled": severityCritical}
+
+	critical := &L9Event{ID: "oom", Reason: "OOMKilled", Type: v1.EventTypeWarning}
+	info := &L9Event{ID: "pulled", Reason: "Pulled", Type: v1.EventTypeNormal}
+	for _, e := range []*L9Event{critical, info} {
+		e.Severity = cfg.severityOf(e)
+	}
+
+	assert.Equal(t, critical.Severity, severityCritical)
+	assert.Equal(t, info.Severity, severityInfo)
+	assert.Equal(t, cfg.severityOf(&L9Event{Type: v1.EventTypeWarning}), severityWarning)
+
+	alert, archive := newMemSink(), newMemSink()
+	sinks, err := NewSinkSet(archive, map[string]io.Flusher{"alert": alert}, map[string]string{
+		severityCritical: "alert",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}, 2)
+	ch <- critical
+	ch <- info
+	if err := doBatch(sinks, nil, ch, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	id := func(lines []string) []string {
+		ids := []string{}
+		for _, l := range lines {
+			var e L9Event
+			if err := json.Unmarshal([]byte(l), &e); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, id(sinkLines(alert)), []string{"oom"})
+	assert.Equal(t, id(sinkLines(archive)), []string{"pulled"})
+
+	t.Run("A failing sink fails only the events routed to it", func(t *testing.T) {
+		cfg := newTestConfig()
+		acker := &recordingAcker{failed: map[string]error{}}
+		cfg.acker = acker
+
+		down := errors.New("pager down")
+		failing := NewFuncFlusher(func([]*L9Event) error { return down })
+		archive := newMemSink()
+		sinks, err := NewSinkSet(archive, map[string]io.Flusher{"alert": failing}, map[string]string{
+			severityCritical: "alert",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := newCache()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan interface{}, 2)
+		ch <- &L9Event{ID: "oom", AckToken: "oom", Severity: severityCritical}
+		ch <- &L9Event{ID: "pulled", AckToken: "pulled", Severity: severityInfo}
+		assert.Equal(t, errors.Is(doBatch(sinks, nil, ch, db, cfg), down), true)
+
+		assert.Equal(t, acker.delivered, []string{"pulled"})
+		assert.Equal(t, acker.failed["oom"], down)
+		assert.Equal(t, len(sinkLines(archive)), 1)
+
+		ids, err := db.List(eventCacheTable)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, ids, []string{"pulled"})
+	})
+
+	t.Run("Routes to unknown sinks are rejected", func(t *testing.T) {
+		_, err := NewSinkSet(archive, nil, map[string]string{severityCritical: "pager"})
+		assert.NotEqual(t, err, nil)
+	})
+}
+
+func TestSinkRouter(t *testing.T) {
+	var mu sync.Mutex
+	lookups := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key sinkRouteKey
+		if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		lookups[key.Namespace]++
+		mu.Unlock()
+
+		switch key.Namespace {
+		case "web":
+			w.Write([]byte(`{"sink": "alert"}`))
+		case "ops":
+			w.Write([]byte(`{"sink": "pager"}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"sink": ""}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig()
+	cfg.SinkRouter = &SinkRouterConfig{URL: server.URL}
+	assert.Equal(t, cfg.SinkRouter.compile(), nil)
+
+	alert, archive := newMemSink(), newMemSink()
+	sinks, err := NewSinkSet(archive, map[string]io.Flusher{"alert": alert}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks.router = cfg.SinkRouter
+
+	flush := func(events ...*L9Event) {
+		ch := make(chan interface{}, len(events))
+		for _, e := range events {
+			ch <- e
+		}
+		close(ch)
+
+		for {
+			err := doBatch(sinks, nil, ch, nil, cfg)
+			if err == errIngesterClosed {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	flush(
+		&L9Event{ID: "web-1", Namespace: "web", Reason: "BackOff"},
+		&L9Event{ID: "web-2", Namespace: "web", Reason: "BackOff"},
+		&L9Event{ID: "db", Namespace: "db", Reason: "BackOff"},
+		&L9Event{ID: "ops", Namespace: "ops", Reason: "BackOff"},
+		&L9Event{ID: "broken", Namespace: "broken", Reason: "BackOff"},
+	)
+
+	ids := func(m *io.MemSink) []string {
+		ids := []string{}
+		for _, l := range sinkLines(m) {
+			var e L9Event
+			if err := json.Unmarshal([]byte(l), &e); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, e.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	assert.Equal(t, ids(alert), []string{"web-1", "web-2"})
+	assert.Equal(t, ids(archive), []string{"broken", "db", "ops"})
+
+	t.Run("Lookups are cached", func(t *testing.T) {
+		assert.Equal(t, lookups["web"], 1)
+		assert.Equal(t, lookups["broken"], 1)
+	})
+
+	t.Run("Failed lookups are retried later", func(t *testing.T) {
+		later := time.Now().Add(sinkRouterRetry*time.Second + time.Second)
+		cfg.SinkRouter.now = func() time.Time { return later }
+
+		cfg.SinkRouter.sinkOf(&L9Event{Namespace: "broken", Reason: "BackOff"})
+		cfg.SinkRouter.sinkOf(&L9Event{Namespace: "web", Reason: "BackOff"})
+		assert.Equal(t, lookups["broken"], 2)
+		assert.Equal(t, lookups["web"], 1)
+	})
+
+	t.Run("A router needs a url", func(t *testing.T) {
+		assert.NotEqual(t, (&SinkRouterConfig{}).compile(), nil)
+	})
+}
+
+func TestHighPriorityFlush(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 10
+	cfg.BatchInterval = 5
+	cfg.SeverityRules = map[string]string{"OOMKilled": severityCritical}
+
+	ch := make(chan interface{}, 10)
+	h := &Handler{conf: cfg, ch: ch}
+	h.emit(&L9Event{ID: "pulled", Reason: "Pulled", Type: v1.EventTypeNormal})
+	h.emit(&L9Event{ID: "oom", Reason: "OOMKilled", Type: v1.EventTypeWarning})
+
+	f := newMemSink()
+	start := time.Now()
+	if err := doBatch(SingleSink(f), nil, ch, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) >= time.Second {
+		t.Fatal("High priority event waited for the batch interval")
+	}
+
+	assert.Equal(t, len(sinkLines(f)), 2)
+}
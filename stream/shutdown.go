@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const defaultShutdownTimeout = 30
+
+// errIngesterClosed ends a batcher once its channel is closed and the last
+// batch is flushed.
+var errIngesterClosed = errors.New("ingester closed")
+
+// gate lets objects into the Handler until it is closed, and keeps track
+// of the ones still being handled. A nil gate never closes.
+type gate struct {
+	mu       sync.RWMutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+// enter reports whether an object may be handled. A true enter has to be
+// followed by a leave once it is handled.
+func (g *gate) enter() bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.closed {
+		return false
+	}
+
+	g.inFlight.Add(1)
+	return true
+}
+
+func (g *gate) leave() {
+	if g == nil {
+		return
+	}
+
+	g.inFlight.Done()
+}
+
+// close turns further objects away, and waits for the ones let in to be
+// handled, or for ctx to be done.
+func (g *gate) close(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	return wait(ctx, func() { g.inFlight.Wait() })
+}
+
+// wait runs fn until it returns or ctx is done.
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Expired is closed once the process has run for
+// max_process_lifetime_seconds, for the program to Shutdown and exit. It is
+// never closed without a lifetime.
+func (p *Pipeline) Expired() <-chan struct{} {
+	return p.expired
+}
+
+// Shutdown stops the pipeline without losing the events it holds. Once
+// the informers are stopped, so that no further objects arrive:
+//
+//  1. the events held by the startup quiet period are handled,
+//  2. new objects are turned away, and the ones being enriched finish,
+//  3. the events held in storm windows are emitted,
+//  4. the batchers flush what they buffered, and stop,
+//  5. the batches held by spill queues are replayed to their sinks, and the
+//     sinks are closed.
+//
+// Events still in the pipeline when ctx is done are lost, but for the
+// batches still spilled, which are dead-lettered. Shutdown is called once.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.MarkSynced()
+
+	if err := p.Handler.conf.gate.close(ctx); err != nil {
+		return err
+	}
+
+	p.Handler.conf.storms.flush()
+
+	close(p.Handler.ch)
+	close(p.snapshots)
+
+	if err := wait(ctx, func() {
+		<-p.ingested
+		<-p.snapshotted
+	}); err != nil {
+		return err
+	}
+
+	// Once drained, every spilled batch was replayed or dead-lettered, and
+	// only the settling of their events is left.
+	err := p.sinks.drain(ctx)
+	p.Handler.conf.spilled.Wait()
+	p.sinks.close()
+	return err
+}
@@ -0,0 +1,175 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/last9/k8stream/io"
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestShutdownFlushesInFlightEvents(t *testing.T) {
+	// Node lookups are slow, so that events are still being enriched when
+	// shutdown begins.
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "nodes", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(20 * time.Millisecond)
+		name := a.(k8stesting.GetAction).GetName()
+		return true, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	})
+
+	f := newMemSink()
+	conf := newTestConfig()
+	conf.BatchSize = 1000
+	conf.BatchInterval = 60
+	conf.HandlerMaxGoroutines = 8
+
+	p, err := NewPipeline(conf, &KubernetesClient{Clientset: clientset}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(ix int) *v1.Event {
+		uid := "burst-" + strconv.Itoa(ix)
+		return &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+			Reason:     "Rebooted",
+			Source:     v1.EventSource{Host: "node-" + strconv.Itoa(ix)},
+		}
+	}
+
+	const burst = 64
+	for ix := 0; ix < burst; ix++ {
+		p.Handler.OnAdd(event(ix))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, p.Shutdown(ctx), nil)
+	assert.Equal(t, len(sinkLines(f)), burst)
+
+	t.Run("Objects after shutdown are dropped", func(t *testing.T) {
+		p.Handler.OnAdd(event(burst))
+		assert.Equal(t, len(sinkLines(f)), burst)
+	})
+}
+
+func TestMaxProcessLifetime(t *testing.T) {
+	f := newMemSink()
+	conf := newTestConfig()
+	conf.BatchSize = 1000
+	conf.BatchInterval = 60
+	conf.MaxProcessLifetime = 1
+
+	start := time.Now()
+	p, err := NewPipeline(conf, &KubernetesClient{Clientset: fake.NewSimpleClientset()}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Handler.OnAdd(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulled", Namespace: "default", UID: "pulled"},
+		Reason:     "Pulled",
+	})
+
+	select {
+	case <-p.Expired():
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline did not expire")
+	}
+	assert.Equal(t, time.Since(start) >= time.Second, true)
+
+	// The buffered batch is flushed on the way out, rather than lost.
+	assert.Equal(t, len(sinkLines(f)), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, p.Shutdown(ctx), nil)
+	assert.Equal(t, len(sinkLines(f)), 1)
+
+	t.Run("Pipelines without a lifetime do not expire", func(t *testing.T) {
+		p, err := NewPipeline(newTestConfig(), nil, SingleSink(newMemSink()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-p.Expired():
+			t.Fatal("pipeline expired")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestShutdownDrainsSpilledBatches(t *testing.T) {
+	spilling := func(t *testing.T, up func(attempt int) bool) (*Pipeline, *recordingAcker, *io.MemSink, func() int) {
+		conf := newTestConfig()
+		conf.BatchSize = 2
+		conf.Recovery = io.RecoveryConfig{QueueBatches: 10, ProbeInterval: 1}
+		acker := &recordingAcker{failed: map[string]error{}}
+
+		var mu sync.Mutex
+		attempts, delivered := 0, 0
+		dl := newMemSink()
+		f := io.WithRecovery(NewFuncFlusher(func(events []*L9Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts++; !up(attempts) {
+				return errors.New("sink down")
+			}
+			delivered += len(events)
+			return nil
+		}), dl, &conf.Config, nil)
+
+		p, err := NewPipeline(conf, &KubernetesClient{Clientset: fake.NewSimpleClientset()}, SingleSink(f), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetAcker(acker)
+
+		for _, uid := range []string{"pulled", "started"} {
+			p.Handler.OnAdd(&v1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+				Reason:     "Pulled",
+			})
+		}
+
+		return p, acker, dl, func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return delivered
+		}
+	}
+
+	t.Run("Replayed before shutdown returns", func(t *testing.T) {
+		p, acker, dl, delivered := spilling(t, func(attempt int) bool { return attempt > 1 })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.Equal(t, p.Shutdown(ctx), nil)
+		assert.Equal(t, delivered(), 2)
+		assert.Equal(t, len(acker.delivered), 2)
+		assert.Equal(t, len(sinkLines(dl)), 0)
+	})
+
+	t.Run("Dead-lettered once shutdown times out", func(t *testing.T) {
+		p, acker, dl, delivered := spilling(t, func(int) bool { return false })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, p.Shutdown(ctx), context.DeadlineExceeded)
+		assert.Equal(t, delivered(), 0)
+		assert.Equal(t, len(sinkLines(dl)), 2)
+		assert.Equal(t, len(acker.delivered), 0)
+		assert.Equal(t, len(acker.failed), 2)
+	})
+}
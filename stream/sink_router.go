@@ -0,0 +1,135 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	fmt "fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSinkRouterTimeout = 1000
+	defaultSinkRouterCache   = 300
+
+	// Seconds the fallback is used for after a failed lookup, so that a
+	// router that is down does not hold every event up by the timeout.
+	sinkRouterRetry = 10
+)
+
+// SinkRouterConfig looks the sink of an event up in an external routing
+// service. The attributes of the event are POSTed to URL,
+//
+//	{"namespace": "web", "kind": "Pod", "reason": "BackOff", "type": "Warning", "severity": "warning"}
+//
+// and the service answers with the name of a sink of the sinks block,
+// {"sink": "alerts"}. An empty name, an unknown one, or a failed lookup
+// leaves the event to severity_routes and the primary sink.
+type SinkRouterConfig struct {
+	URL string `json:"url"`
+
+	// Milliseconds a lookup may take.
+	TimeoutMs int `json:"timeout_ms"`
+
+	// Seconds the sink of the same attributes is reused for.
+	CacheSeconds int `json:"cache_seconds"`
+
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	cached map[sinkRouteKey]cachedSinkRoute
+}
+
+// sinkRouteKey is what the sink of an event is looked up by.
+type sinkRouteKey struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Reason    string `json:"reason"`
+	Type      string `json:"type"`
+	Severity  string `json:"severity"`
+}
+
+type cachedSinkRoute struct {
+	sink    string
+	expires time.Time
+}
+
+// compile checks the router and sets its defaults.
+func (r *SinkRouterConfig) compile() error {
+	if r == nil {
+		return nil
+	}
+
+	if r.URL == "" {
+		return fmt.Errorf("sink_router needs a url")
+	}
+
+	if r.TimeoutMs == 0 {
+		r.TimeoutMs = defaultSinkRouterTimeout
+	}
+
+	if r.CacheSeconds == 0 {
+		r.CacheSeconds = defaultSinkRouterCache
+	}
+
+	r.client = &http.Client{Timeout: time.Duration(r.TimeoutMs) * time.Millisecond}
+	r.now = time.Now
+	r.cached = map[sinkRouteKey]cachedSinkRoute{}
+	return nil
+}
+
+// sinkOf is the name of the sink that the router names for e, from the
+// cache while it is fresh. A failed lookup is logged, and is an empty name
+// for sinkRouterRetry seconds.
+func (r *SinkRouterConfig) sinkOf(e *L9Event) string {
+	key := sinkRouteKey{e.Namespace, e.ReferenceKind, e.Reason, e.Type, e.Severity}
+	now := r.now()
+
+	r.mu.Lock()
+	c, ok := r.cached[key]
+	r.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.sink
+	}
+
+	sink, err := r.lookup(key)
+	ttl := time.Duration(r.CacheSeconds) * time.Second
+	if err != nil {
+		log.Printf("Looking the sink of %v up: %v", key, err)
+		sinkRouterFallbacks.Inc()
+		sink, ttl = "", sinkRouterRetry*time.Second
+	}
+
+	r.mu.Lock()
+	r.cached[key] = cachedSinkRoute{sink, now.Add(ttl)}
+	r.mu.Unlock()
+	return sink
+}
+
+func (r *SinkRouterConfig) lookup(key sinkRouteKey) (string, error) {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := r.client.Post(r.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("router answered %v", resp.Status)
+	}
+
+	var body struct {
+		Sink string `json:"sink"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Sink, nil
+}
@@ -0,0 +1,151 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+	"reflect"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const snapshotReason = "Snapshot"
+
+type SnapshotConfig struct {
+	// Seconds between snapshots of every watched object. 0 disables them.
+	Interval int `json:"interval_seconds"`
+}
+
+// StartSnapshots emits a snapshot of every object in the informer stores
+// each interval, so that consumers can reconcile what they missed, until
+// stopCh is closed.
+func (p *Pipeline) StartSnapshots(stores []cache.Store, interval time.Duration, stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.Snapshot(stores)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Snapshot emits a Snapshot event for each object in the stores. These go
+// through a batcher of their own, so that a large snapshot never holds up
+// the events about what is happening.
+// Objects are filtered, and their events built, as they are for the events
+// about their changes.
+func (p *Pipeline) Snapshot(stores []cache.Store) {
+	if !p.Handler.conf.gate.enter() {
+		return
+	}
+	defer p.Handler.conf.gate.leave()
+
+	taken := time.Now()
+	n := 0
+	for _, s := range stores {
+		for _, obj := range s.List() {
+			m, err := meta.Accessor(obj)
+			if err != nil {
+				log.Println("snapshot:", err)
+				continue
+			}
+
+			id := fmt.Sprintf("%s-snapshot-%d", m.GetUID(), taken.Unix())
+			e, err := p.Handler.makeStateEvent(obj, id, snapshotReason, taken)
+			if err != nil {
+				log.Println("snapshot:", err)
+				continue
+			}
+
+			if e == nil {
+				continue
+			}
+
+			p.Handler.finish(e)
+			e.Priority = priorityLow
+			if !p.Handler.conf.filter.keep(e) {
+				continue
+			}
+			p.Handler.conf.sequence.stamp(e)
+			p.snapshots <- e
+			bufferedEvents.Inc()
+			n++
+		}
+	}
+
+	log.Println("Snapshot of", n, "objects")
+}
+
+// makeStateEvent returns an event with the state of obj at the time taken,
+// or nil when events about it are not emitted.
+func (h *Handler) makeStateEvent(obj interface{}, id, reason string, taken time.Time) (*L9Event, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects out of an informer have no TypeMeta to tell their kind.
+	kind := reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+
+	var e *L9Event
+	switch o := obj.(type) {
+	case *v1.Service:
+		if !h.watchesService(o) {
+			return nil, nil
+		}
+
+		pods, total, err := getServicePods(
+			h.client, h.db, o, h.conf.ServiceEnrichment.MaxPods,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err = makeL9ServiceEvent(h.db, id, o, pods, reason)
+		if err != nil {
+			return nil, err
+		}
+
+		e.TotalPods = total
+		e.PodsTruncated = total > len(pods)
+	case *v1.Namespace:
+		e = makeL9NamespaceEvent(id, o, reason)
+	default:
+		if !h.conf.Namespaces.watches(m.GetNamespace(), skipNamespaces) ||
+			h.conf.isSelf(m.GetNamespace(), string(m.GetUID())) {
+			return nil, nil
+		}
+
+		e = &L9Event{
+			raw:              obj,
+			ID:               id,
+			Component:        m.GetName(),
+			Message:          fmt.Sprintf("%s %s", kind, m.GetName()),
+			Namespace:        m.GetNamespace(),
+			Reason:           reason,
+			Type:             v1.EventTypeNormal,
+			ReferenceVersion: m.GetResourceVersion(),
+			ResourceVersion:  m.GetResourceVersion(),
+			ObjectUid:        string(m.GetUID()),
+			Labels:           m.GetLabels(),
+			Annotations:      m.GetAnnotations(),
+			Version:          VERSION,
+		}
+	}
+
+	// The event is about what the object is at the time it was taken.
+	e.Timestamp = taken.Unix()
+	e.ReferenceUID = string(m.GetUID())
+	e.ReferenceNamespace = m.GetNamespace()
+	e.ReferenceName = m.GetName()
+	e.ReferenceKind = kind
+	return e, nil
+}
@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSnapshot(t *testing.T) {
+	got := make(chan []*L9Event, 1)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.Output.IncludeProducerVersion = true
+
+	pods := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	pods.Add(testPod("a-1", "pod-a-1", map[string]string{"app": "a"}))
+
+	p, err := NewPipeline(conf, &KubernetesClient{pods: pods}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	services.Add(testService("7", map[string]string{"app": "a"}))
+
+	// Filtered out, as its events would be.
+	system := testService("8", nil)
+	system.Namespace, system.UID = "kube-system", "svc-system"
+	services.Add(system)
+
+	namespaces := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	namespaces.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "payments", UID: "ns-payments", ResourceVersion: "3",
+	}})
+
+	p.Snapshot([]cache.Store{services, namespaces})
+
+	select {
+	case events := <-got:
+		assert.Equal(t, len(events), 2)
+
+		kinds := []string{}
+		for _, e := range events {
+			assert.Equal(t, e.Reason, snapshotReason)
+			assert.Equal(t, e.Priority, priorityLow)
+			assert.Equal(t, e.ProducerVersion, VERSION)
+			kinds = append(kinds, e.ReferenceKind)
+
+			if e.ReferenceKind == "Service" {
+				assert.Equal(t, e.ReferenceUID, "svc-uid")
+				assert.Equal(t, e.TotalPods, 1)
+				assert.Equal(t, len(e.Pod), 1)
+			}
+		}
+		sort.Strings(kinds)
+		assert.Equal(t, kinds, []string{"Namespace", "Service"})
+	case <-time.After(3 * time.Second):
+		t.Fatal("No snapshot was flushed")
+	}
+}
+
+func TestStartSnapshotsStops(t *testing.T) {
+	got := make(chan []*L9Event, 8)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.BatchSize = 1
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	namespaces.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", UID: "ns-payments"}})
+
+	stopCh := make(chan struct{})
+	p.StartSnapshots([]cache.Store{namespaces}, 20*time.Millisecond, stopCh)
+
+	select {
+	case <-got:
+	case <-time.After(3 * time.Second):
+		t.Fatal("No snapshot was flushed")
+	}
+
+	close(stopCh)
+	time.Sleep(50 * time.Millisecond)
+	for len(got) > 0 {
+		<-got
+	}
+
+	// A tick already under way may still land.
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, len(got) <= 1, true)
+}
+
+func TestEmitInitialState(t *testing.T) {
+	got := make(chan []*L9Event, 4)
+	f := NewFuncFlusher(func(events []*L9Event) error {
+		got <- events
+		return nil
+	})
+
+	conf := newTestConfig()
+	conf.BatchSize = 1
+	p, err := NewPipeline(conf, &KubernetesClient{}, SingleSink(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	namespaces.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "payments", UID: "ns-payments", ResourceVersion: "3",
+	}})
+
+	p.EmitInitialState([]cache.Store{namespaces})
+
+	select {
+	case events := <-got:
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Reason, initialReason)
+		assert.Equal(t, events[0].ReferenceKind, "Namespace")
+	case <-time.After(3 * time.Second):
+		t.Fatal("No initial state was flushed")
+	}
+
+	t.Run("A second sync does not emit it again", func(t *testing.T) {
+		// Marked processed once flushed.
+		time.Sleep(50 * time.Millisecond)
+		p.EmitInitialState([]cache.Store{namespaces})
+
+		select {
+		case events := <-got:
+			t.Fatalf("Initial state was emitted again: %v", events[0].ID)
+		case <-time.After(1500 * time.Millisecond):
+		}
+	})
+}
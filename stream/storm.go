@@ -0,0 +1,197 @@
+package stream
+
+import (
+	fmt "fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultStormThreshold = 10
+	defaultStormWindow    = 10
+
+	// Pods named in a summary, of however many it counts.
+	stormMaxPods = 100
+)
+
+// Reasons coalesced, unless configured otherwise.
+var defaultStormReasons = []string{"Evicted", "Preempting"}
+
+type StormConfig struct {
+	// Coalesce the events of a node and reason, in a window, into one
+	// summary event once there are Threshold of them.
+	Protection    bool     `json:"protection"`
+	Reasons       []string `json:"reasons"`
+	Threshold     int      `json:"threshold"`
+	WindowSeconds int      `json:"window_seconds"`
+}
+
+type stormKey struct {
+	node, reason string
+}
+
+// stormWindow is the events of one node and reason held since start.
+type stormWindow struct {
+	start  time.Time
+	events []*L9Event
+	timer  *time.Timer
+}
+
+// stormCoalescer holds the events of the storm reasons back for a window
+// each node and reason, and then emits them one by one, or a summary of
+// them when there were Threshold of them or more. This delays the events
+// of those reasons by the window.
+type stormCoalescer struct {
+	conf StormConfig
+	h    *Handler
+
+	mu      sync.Mutex
+	windows map[stormKey]*stormWindow
+}
+
+func newStormCoalescer(conf StormConfig, h *Handler) *stormCoalescer {
+	return &stormCoalescer{conf: conf, h: h, windows: map[stormKey]*stormWindow{}}
+}
+
+// nodeOf is the node an event happened on, as its source or its pod tells.
+func nodeOf(e *L9Event) string {
+	if e.Host == "" && e.pod != nil {
+		return e.pod.Spec.NodeName
+	}
+	return e.Host
+}
+
+// hold keeps e back in the window of its node and reason, and reports
+// whether it did. Events of other reasons are not held.
+func (s *stormCoalescer) hold(e *L9Event) bool {
+	if s == nil || !contains(e.Reason, s.conf.Reasons) {
+		return false
+	}
+
+	key := stormKey{nodeOf(e), e.Reason}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[key]
+	if !ok {
+		w = &stormWindow{start: time.Now()}
+		w.timer = time.AfterFunc(time.Duration(s.conf.WindowSeconds)*time.Second, func() {
+			s.close(key, w)
+		})
+		s.windows[key] = w
+	}
+	w.events = append(w.events, e)
+	return true
+}
+
+// close emits the events of a window that is due, unless it was flushed.
+// Once the gate is closed, the window is left to flush.
+func (s *stormCoalescer) close(key stormKey, w *stormWindow) {
+	if !s.h.conf.gate.enter() {
+		return
+	}
+	defer s.h.conf.gate.leave()
+
+	s.mu.Lock()
+	if s.windows[key] != w {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.windows, key)
+	s.mu.Unlock()
+
+	s.emit(key, w)
+}
+
+// flush emits the events of every window right away, as on shutdown.
+func (s *stormCoalescer) flush() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	windows := s.windows
+	s.windows = map[stormKey]*stormWindow{}
+	s.mu.Unlock()
+
+	for key, w := range windows {
+		w.timer.Stop()
+		s.emit(key, w)
+	}
+}
+
+func (s *stormCoalescer) emit(key stormKey, w *stormWindow) {
+	if len(w.events) < s.conf.Threshold {
+		for _, e := range w.events {
+			s.h.emit(e)
+		}
+		return
+	}
+
+	summary := makeStormEvent(key, w)
+	s.h.conf.Log("Coalesced %v %v events of %v into %v", len(w.events), key.reason, key.node, summary.ID)
+
+	// The coalesced events are as good as emitted.
+	batch := make([]interface{}, len(w.events))
+	for i, e := range w.events {
+		batch[i] = e
+	}
+	markProcessed(s.h.db, batch)
+	markClaimsDone(s.h.conf, batch)
+
+	s.h.emit(summary)
+}
+
+// makeStormEvent summarizes the events of a window, about the node, with
+// the pods they were about.
+func makeStormEvent(key stormKey, w *stormWindow) *L9Event {
+	pods := []string{}
+	seen := map[string]bool{}
+	namespaces := map[string]bool{}
+	for _, e := range w.events {
+		namespaces[e.Namespace] = true
+
+		pod := e.ReferenceNamespace + "/" + e.ReferenceName
+		if !seen[pod] {
+			seen[pod] = true
+			pods = append(pods, pod)
+		}
+	}
+	sort.Strings(pods)
+
+	total := len(pods)
+	if len(pods) > stormMaxPods {
+		pods = pods[:stormMaxPods]
+	}
+
+	node := key.node
+	if node == "" {
+		node = "unknown node"
+	}
+
+	e := &L9Event{
+		ID:            fmt.Sprintf("%s-%s-storm-%d", key.node, key.reason, w.start.Unix()),
+		Timestamp:     time.Now().Unix(),
+		Component:     w.events[0].Component,
+		Host:          key.node,
+		Message:       fmt.Sprintf("%s %d pods on %s", key.reason, total, node),
+		Reason:        key.reason,
+		Type:          v1.EventTypeWarning,
+		Count:         int32(len(w.events)),
+		ReferenceName: key.node,
+		ReferenceKind: "Node",
+		StormCount:    total,
+		AffectedPods:  pods,
+		Version:       VERSION,
+	}
+
+	if len(namespaces) == 1 {
+		e.Namespace = w.events[0].Namespace
+	}
+
+	return e
+}
@@ -0,0 +1,84 @@
+package stream
+
+import (
+	fmt "fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestEvictionStorm(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &L9K8streamConfig{Storm: StormConfig{
+		Protection: true, Reasons: defaultStormReasons, Threshold: 10, WindowSeconds: 1,
+	}}
+	ch := make(chan interface{}, 100)
+	h := &Handler{&KubernetesClient{}, ch, db, conf}
+	conf.storms = newStormCoalescer(conf.Storm, h)
+
+	evicted := func(id, node, pod string) *L9Event {
+		return &L9Event{
+			ID: id, Reason: "Evicted", Host: node, Namespace: "default",
+			ReferenceKind: "Pod", ReferenceNamespace: "default", ReferenceName: pod,
+		}
+	}
+
+	for i := 0; i < 25; i++ {
+		assert.Equal(t, conf.storms.hold(evicted(fmt.Sprint("storm-", i), "node-1", fmt.Sprint("web-", i))), true)
+	}
+
+	// Below the threshold, the events of a node are emitted as they are.
+	assert.Equal(t, conf.storms.hold(evicted("calm-0", "node-2", "api-0")), true)
+	assert.Equal(t, conf.storms.hold(evicted("calm-1", "node-2", "api-1")), true)
+
+	// Other reasons are not held.
+	assert.Equal(t, conf.storms.hold(&L9Event{ID: "pulled", Reason: "Pulled", Host: "node-1"}), false)
+
+	emitted := map[string]*L9Event{}
+	for len(emitted) < 3 {
+		select {
+		case v := <-ch:
+			e := v.(*L9Event)
+			emitted[e.ID] = e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("emitted %v events, want 3", len(emitted))
+		}
+	}
+
+	var summary *L9Event
+	ids := []string{}
+	for id, e := range emitted {
+		ids = append(ids, id)
+		if e.StormCount > 0 {
+			summary = e
+		}
+	}
+	sort.Strings(ids)
+
+	assert.Equal(t, ids[:2], []string{"calm-0", "calm-1"})
+	assert.NotEqual(t, summary, nil)
+	assert.Equal(t, summary.StormCount, 25)
+	assert.Equal(t, len(summary.AffectedPods), 25)
+	assert.Equal(t, summary.ReferenceKind, "Node")
+	assert.Equal(t, summary.ReferenceName, "node-1")
+	assert.Equal(t, summary.Reason, "Evicted")
+	assert.Equal(t, summary.Namespace, "default")
+	assert.Equal(t, summary.Message, "Evicted 25 pods on node-1")
+
+	// The coalesced events are not emitted again.
+	r, err := db.Get(eventCacheTable, "storm-7")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Exists(), true)
+
+	t.Run("Flushed on shutdown", func(t *testing.T) {
+		conf.storms.hold(evicted("late", "node-3", "db-0"))
+		conf.storms.flush()
+		assert.Equal(t, (<-ch).(*L9Event).ID, "late")
+	})
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	fmt "fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const serviceEdgeReason = "ServiceEdge"
+
+type TopologyConfig struct {
+	// Emit a ServiceEdge event for each service -> pod relationship, and
+	// service -> service through a shared pod, on every service event.
+	EmitEdges bool `json:"emit_edges"`
+}
+
+// Edge is one relationship of a ServiceEdge event.
+type Edge struct {
+	SourceKind string `json:"source_kind"`
+	SourceUID  string `json:"source_uid"`
+	SourceName string `json:"source_name"`
+	TargetKind string `json:"target_kind"`
+	TargetUID  string `json:"target_uid"`
+	TargetName string `json:"target_name,omitempty"`
+}
+
+// makeServiceEdges returns the edges of a service to its pods, and to the
+// other services in front of any of the pods, as found in the reverse index.
+func makeServiceEdges(db Cachier, eventID string, s *v1.Service, pods []v1.Pod) ([]*L9Event, error) {
+	suid := string(s.GetUID())
+	edges := []*Edge{}
+	peers := map[string]bool{}
+
+	for _, p := range pods {
+		edges = append(edges, &Edge{
+			SourceKind: "Service", SourceUID: suid, SourceName: s.GetName(),
+			TargetKind: "Pod", TargetUID: string(p.GetUID()), TargetName: p.GetName(),
+		})
+
+		services, err := db.List(makeKey(podServicesTable, string(p.GetUID())))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, peer := range services {
+			if peer != suid {
+				peers[peer] = true
+			}
+		}
+	}
+
+	uids := make([]string, 0, len(peers))
+	for uid := range peers {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	for _, uid := range uids {
+		e := &Edge{
+			SourceKind: "Service", SourceUID: suid, SourceName: s.GetName(),
+			TargetKind: "Service", TargetUID: uid,
+		}
+
+		r, err := db.Get(serviceTable, uid)
+		if err != nil {
+			return nil, err
+		}
+
+		var peer v1.Service
+		if r.Exists() && r.Unmarshal(&peer) == nil {
+			e.TargetName = peer.GetName()
+		}
+		edges = append(edges, e)
+	}
+
+	events := make([]*L9Event, 0, len(edges))
+	for _, e := range edges {
+		events = append(events, &L9Event{
+			ID:                 fmt.Sprintf("%s-%s", eventID, e.TargetUID),
+			Timestamp:          time.Now().Unix(),
+			Component:          s.GetName(),
+			Message:            fmt.Sprintf("%s %s -> %s %s", e.SourceKind, e.SourceName, e.TargetKind, e.TargetName),
+			Namespace:          s.GetNamespace(),
+			Reason:             serviceEdgeReason,
+			Type:               v1.EventTypeNormal,
+			ReferenceUID:       suid,
+			ReferenceNamespace: s.GetNamespace(),
+			ReferenceName:      s.GetName(),
+			ReferenceKind:      "Service",
+			ReferenceVersion:   s.GetResourceVersion(),
+			ResourceVersion:    s.GetResourceVersion(),
+			ObjectUid:          suid,
+			Version:            VERSION,
+			Edge:               e,
+		})
+	}
+
+	return events, nil
+}
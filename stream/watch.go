@@ -0,0 +1,138 @@
+package stream
+
+import (
+	fmt "fmt"
+	"log"
+)
+
+// WatchedResource is a kind of object that an informer may watch, by the
+// API group version and the resource name that discovery serves it by.
+type WatchedResource struct {
+	GroupVersion string
+	Resource     string
+}
+
+func (r WatchedResource) String() string {
+	return r.GroupVersion + "/" + r.Resource
+}
+
+// The resources of the watch settings, which an API server may not serve.
+var (
+	NamespacesResource     = WatchedResource{"v1", "namespaces"}
+	ResourceQuotasResource = WatchedResource{"v1", "resourcequotas"}
+	PDBResource            = WatchedResource{"policy/v1beta1", "poddisruptionbudgets"}
+	LeasesResource         = WatchedResource{"coordination.k8s.io/v1", "leases"}
+	SecretsResource        = WatchedResource{"v1", "secrets"}
+)
+
+// Kinds of watch.kinds, that each get an informer of their own.
+const (
+	KindEvents      = "events"
+	KindServices    = "services"
+	KindPods        = "pods"
+	KindDeployments = "deployments"
+	KindReplicaSets = "replicasets"
+)
+
+var defaultWatchKinds = []string{KindEvents, KindServices}
+
+// kindResources are the resources of the kinds that watch.kinds may list.
+var kindResources = map[string]WatchedResource{
+	KindEvents:      {"v1", "events"},
+	KindServices:    {"v1", "services"},
+	KindPods:        {"v1", "pods"},
+	KindDeployments: {"apps/v1", "deployments"},
+	KindReplicaSets: {"apps/v1", "replicasets"},
+}
+
+// KindResource is the resource of a kind of watch.kinds.
+func KindResource(kind string) WatchedResource {
+	return kindResources[kind]
+}
+
+// checkKinds fails on a kind of watch.kinds that cannot be watched.
+func (w *WatchConfig) checkKinds() error {
+	for _, k := range w.Kinds {
+		if _, ok := kindResources[k]; !ok {
+			return fmt.Errorf(
+				"unknown kind %q in watch.kinds, choices are %v, %v, %v, %v and %v",
+				k, KindEvents, KindServices, KindPods, KindDeployments, KindReplicaSets,
+			)
+		}
+	}
+	return nil
+}
+
+// Resources are the resources watched by w.
+func (w *WatchConfig) Resources() []WatchedResource {
+	rs := []WatchedResource{}
+	for _, k := range w.Kinds {
+		rs = append(rs, kindResources[k])
+	}
+	if w.Namespaces {
+		rs = append(rs, NamespacesResource)
+	}
+	if w.ResourceQuotas {
+		rs = append(rs, ResourceQuotasResource)
+	}
+	if w.PDB {
+		rs = append(rs, PDBResource)
+	}
+	if w.Leases {
+		rs = append(rs, LeasesResource)
+	}
+	if w.Secrets {
+		rs = append(rs, SecretsResource)
+	}
+	return rs
+}
+
+// AvailableResources returns the resources of rs that the API server
+// serves. The informers of the others would never sync, and hold startup
+// up for good, so they are logged and left out, unless requireAll, which
+// makes any of them an error.
+func (c *KubernetesClient) AvailableResources(
+	rs []WatchedResource, requireAll bool,
+) (map[WatchedResource]bool, error) {
+	groups, err := c.Clientset.Discovery().ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("discovering the API groups: %w", err)
+	}
+
+	served := map[string]bool{}
+	for _, g := range groups.Groups {
+		for _, v := range g.Versions {
+			served[v.GroupVersion] = true
+		}
+	}
+
+	// Resources of each served group version, as they are looked up.
+	resources := map[string]map[string]bool{}
+
+	available := map[WatchedResource]bool{}
+	for _, r := range rs {
+		if served[r.GroupVersion] && resources[r.GroupVersion] == nil {
+			list, err := c.Clientset.Discovery().ServerResourcesForGroupVersion(r.GroupVersion)
+			if err != nil {
+				return nil, fmt.Errorf("discovering the resources of %v: %w", r.GroupVersion, err)
+			}
+
+			resources[r.GroupVersion] = map[string]bool{}
+			for _, res := range list.APIResources {
+				resources[r.GroupVersion][res.Name] = true
+			}
+		}
+
+		if resources[r.GroupVersion][r.Resource] {
+			available[r] = true
+			continue
+		}
+
+		if requireAll {
+			return nil, fmt.Errorf("%v is not served by the API server", r)
+		}
+		log.Printf("Not watching %v, the API server does not serve it", r)
+	}
+
+	return available, nil
+}
@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"log"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Owners walked up from a pod before giving up on finding its workload.
+const maxOwnerDepth = 5
+
+// resolveWorkload walks up the controllers of obj, say a Pod owned by a
+// ReplicaSet owned by a Deployment, to the one at the top and returns its
+// kind and name. Owners are looked up through the object cache, so the
+// ReplicaSets of a Deployment are fetched once for all of its pods. A bare
+// object, without a controller, has no workload.
+func resolveWorkload(db Cachier, c *KubernetesClient, obj metav1.Object) (string, string) {
+	owners := controllerChain(db, c, obj)
+	if len(owners) == 0 {
+		return "", ""
+	}
+
+	top := owners[len(owners)-1]
+	return top.Kind, top.Name
+}
+
+// controllerChain returns the controllers of obj, nearest first, as far up
+// as they can be looked up.
+func controllerChain(db Cachier, c *KubernetesClient, obj metav1.Object) []*metav1.OwnerReference {
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil {
+		return nil
+	}
+
+	chain := []*metav1.OwnerReference{ref}
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		owner, err := c.getObject(db, &v1.ObjectReference{
+			APIVersion: ref.APIVersion,
+			Kind:       ref.Kind,
+			Name:       ref.Name,
+			Namespace:  obj.GetNamespace(),
+			UID:        ref.UID,
+		})
+		if err != nil {
+			// The best known so far; the owner may be gone already.
+			log.Printf("Resolving the owners of %v: %v", obj.GetName(), err)
+			break
+		}
+
+		next := metav1.GetControllerOf(owner)
+		if next == nil {
+			break
+		}
+		ref = next
+		chain = append(chain, ref)
+	}
+
+	return chain
+}
@@ -0,0 +1,65 @@
+package stream
+
+import (
+	"testing"
+
+	"gopkg.in/go-playground/assert.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func controllerRef(apiVersion, kind, name, uid string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: apiVersion, Kind: kind, Name: name,
+		UID: types.UID(uid), Controller: &controller,
+	}
+}
+
+func TestResolveWorkload(t *testing.T) {
+	db, err := newCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owners are cached already, as no cluster is at hand.
+	rs := &unstructured.Unstructured{}
+	rs.SetAPIVersion("apps/v1")
+	rs.SetKind("ReplicaSet")
+	rs.SetName("web-5d8f7b9c4")
+	rs.SetUID("rs-uid")
+	rs.SetOwnerReferences([]metav1.OwnerReference{
+		controllerRef("apps/v1", "Deployment", "web", "deploy-uid"),
+	})
+
+	deploy := &unstructured.Unstructured{}
+	deploy.SetAPIVersion("apps/v1")
+	deploy.SetKind("Deployment")
+	deploy.SetName("web")
+	deploy.SetUID("deploy-uid")
+
+	for _, u := range []*unstructured.Unstructured{rs, deploy} {
+		if err := db.ExpireSet(objectCacheTable, string(u.GetUID()), u, objectCacheExpiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-5d8f7b9c4-x2x7q", Namespace: "default",
+		OwnerReferences: []metav1.OwnerReference{
+			controllerRef("apps/v1", "ReplicaSet", "web-5d8f7b9c4", "rs-uid"),
+		},
+	}}
+
+	kind, name := resolveWorkload(db, &KubernetesClient{}, pod)
+	assert.Equal(t, kind, "Deployment")
+	assert.Equal(t, name, "web")
+
+	t.Run("Bare pods have no workload", func(t *testing.T) {
+		kind, name := resolveWorkload(db, &KubernetesClient{}, &v1.Pod{})
+		assert.Equal(t, kind, "")
+		assert.Equal(t, name, "")
+	})
+}